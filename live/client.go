@@ -1,101 +1,501 @@
 package live
 
 import (
+	"errors"
+	"math/rand"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 )
 
+// The defaults of the client options.
+const (
+	DefaultMinBackoff        = 500 * time.Millisecond
+	DefaultMaxBackoff        = 30 * time.Second
+	DefaultHeartbeatInterval = 5 * time.Second
+	DefaultMaxMissedPongs    = 2
+)
+
+var (
+	// ErrNotConnected is returned by Send while the client is reconnecting or closed.
+	ErrNotConnected = errors.New("live: the client is not connected")
+
+	// ErrGoodbye is reported when the server ends the connection with a goodbye message.
+	ErrGoodbye = errors.New("live: the server said goodbye")
+
+	// ErrReconnectFailed is reported when the client gives up reconnecting.
+	ErrReconnectFailed = errors.New("live: could not reconnect to the server")
+)
+
+// ConnectionState is the state of the connection of a client.
+type ConnectionState int
+
+// The states of a client.  A client starts Connected, moves to Reconnecting when the
+// connection drops and back to Connected when it is re-established.  Closed is final.
+const (
+	StateConnected ConnectionState = iota
+	StateReconnecting
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Dialer opens a new transport to the server.
+type Dialer func() (Transport, error)
+
+// TCPDialer returns a dialer for a TCP server.
+func TCPDialer(addr string) Dialer {
+	return func() (Transport, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return NewTCPTransport(conn), nil
+	}
+}
+
+// ClientOptions configure a client.
+type ClientOptions struct {
+	ID   string // The client ID sent in the hello message
+	Host string // The host name sent in the hello message
+
+	MinBackoff        time.Duration // optional: The delay before the first reconnect attempt
+	MaxBackoff        time.Duration // optional: The maximum delay between reconnect attempts
+	MaxAttempts       int           // optional: The number of failed attempts before the client closes, 0 for no limit
+	HeartbeatInterval time.Duration // optional: The interval between pings
+	PongTimeout       time.Duration // optional: The time to wait for a pong, at most and by default the HeartbeatInterval
+
+	// optional: The number of consecutive pings without a pong after which the
+	// connection is considered dead, and dropped.  The default is
+	// DefaultMaxMissedPongs, a negative value disables the check.
+	MaxMissedPongs int
+
+	// optional: Send the ID of the last acknowledged message in the hello message
+	// when reconnecting, so the server can resume after it.  See Client.Ack.  The
+	// ID is only sent when the previous connection negotiated FeatureResume.  It is
+	// a hint for servers that keep messages, the Server of this package does not
+	// resume and does not negotiate the feature.
+	Resume bool
+
+	// optional: The protocol features the client supports.  FeatureTopics is always
+	// added, and FeatureResume is added when Resume is set.
+	Features []string
+
+	// optional: The number of received messages buffered for Incoming, and what to
+	// do when the buffer is full.  The default is DefaultQueueSize and OverflowBlock.
+	QueueSize int
+	Overflow  OverflowPolicy
+
+	// optional: The bearer token sent in the hello message.  Web socket transports
+	// send the token in the handshake instead, see WebSocketOptions.
+	Token string
+
+	// optional: Called when the state of the connection changes.  It is called from
+	// the goroutines of the client and must not block.
+	OnStateChange func(from, to ConnectionState)
+}
+
+// Client is a connection to a live server.  A client created with a dialer
+// reconnects with exponential backoff when the connection drops, and sends its
+// hello message again on every new connection.
 type Client struct {
+	dial    Dialer
+	options ClientOptions
+
+	mu        sync.RWMutex
 	transport Transport
+	state     ConnectionState
+	lastAck   string
+	protocol  Hello // The hello message of the server
+
+	negotiated     chan struct{} // Closed when the server first replies with its hello message
+	negotiatedOnce sync.Once
+
+	topicsMu sync.RWMutex
+	handlers []*Subscription
+	patterns map[string]int // The number of handlers of each pattern
+
+	writeMu   sync.Mutex
 	ticker    *time.Ticker
 	incoming  chan Message
 	errors    chan error
+	counters  clientCounters
+	liveness  liveness
 	closing   chan struct{}
+	closeOnce sync.Once
+	routines  sync.WaitGroup // The read and heartbeat goroutines
 }
 
+// NewTCPClient connects to a TCP server and reconnects when the connection drops.
 func NewTCPClient(addr, id, host string) (*Client, error) {
+	return NewClient(TCPDialer(addr), ClientOptions{ID: id, Host: host})
+}
+
+// NewClient connects to a server using the dialer.  The first connection is made
+// before NewClient returns, and an error is returned if it fails.
+func NewClient(dial Dialer, options ClientOptions) (*Client, error) {
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = DefaultMinBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultMaxBackoff
+	}
+	if options.MaxBackoff < options.MinBackoff {
+		options.MaxBackoff = options.MinBackoff
+	}
 
-	conn, err := net.Dial("tcp", addr)
+	transport, err := dial()
 	if err != nil {
-		return &Client{}, err
+		return nil, err
 	}
 
-	transport := NewTCPTransport(conn)
-	cli, err := NewClientWithTransport(transport, id, host)
-	return cli, err
+	return newClient(transport, dial, options)
 }
 
+// NewClientWithTransport creates a client for a connected transport.  The client
+// cannot reconnect, it is closed when the connection drops.
 func NewClientWithTransport(transport Transport, id, host string) (*Client, error) {
+	return newClient(transport, nil, ClientOptions{ID: id, Host: host})
+}
+
+func newClient(transport Transport, dial Dialer, options ClientOptions) (*Client, error) {
+	if options.HeartbeatInterval <= 0 {
+		options.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if options.PongTimeout <= 0 || options.PongTimeout > options.HeartbeatInterval {
+		options.PongTimeout = options.HeartbeatInterval
+	}
+	if options.MaxMissedPongs == 0 {
+		options.MaxMissedPongs = DefaultMaxMissedPongs
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	options.Features = append([]string{FeatureTopics}, options.Features...)
+	if options.Resume {
+		options.Features = append([]string{FeatureResume}, options.Features...)
+	}
 
 	cli := &Client{
+		dial:      dial,
+		options:   options,
 		transport: transport,
-		ticker:    time.NewTicker(5 * time.Second),
-		incoming:  make(chan Message),
-		errors:    make(chan error),
+		state:     StateConnected,
+		protocol:  Hello{Version: 1},
+		patterns:  map[string]int{},
+		ticker:    time.NewTicker(options.HeartbeatInterval),
+		incoming:  make(chan Message, options.QueueSize),
+		errors:    make(chan error, 10),
 		closing:   make(chan struct{}),
+
+		negotiated: make(chan struct{}),
 	}
 
-	helloMsg := NewHelloMessage(id, host)
-	err := transport.WriteMessage(helloMsg)
-	if err != nil {
+	if err := cli.hello(transport); err != nil {
+		cli.ticker.Stop()
+		transport.Close()
 		return cli, err
 	}
 
-	go withRecover(cli.read)
-	go withRecover(cli.heartbeat)
+	cli.routines.Add(2)
+	go cli.run(cli.read)
+	go cli.run(cli.heartbeat)
 
 	return cli, nil
-
 }
 
+// Incoming returns the messages received from the server.  The channel is closed
+// when the client is closed.  When the application does not keep up, the messages
+// are buffered up to the QueueSize option, then handled by the Overflow option.
 func (cli *Client) Incoming() <-chan Message {
 	return cli.incoming
 }
 
+// Errors returns the errors of the connection.  Errors are dropped when they
+// are not received, see Stats.
 func (cli *Client) Errors() <-chan error {
 	return cli.errors
 }
 
+// State returns the state of the connection.
+func (cli *Client) State() ConnectionState {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	return cli.state
+}
+
+// Protocol returns the hello message the server replied with, which holds the
+// negotiated protocol version and features.  Until the server replies, and for
+// servers that do not reply, it is version 1 without any features.
+func (cli *Client) Protocol() Hello {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	return cli.protocol
+}
+
+// Negotiated returns a channel that is closed when the server first replies with
+// its hello message.  Web socket servers do not reply, so the channel is never
+// closed for them.
+func (cli *Client) Negotiated() <-chan struct{} {
+	return cli.negotiated
+}
+
+// Ack records the ID of the last message the application has processed.  When
+// the Resume option is set and the server negotiated FeatureResume, the ID is
+// sent to the server when reconnecting.
+func (cli *Client) Ack(id string) {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	cli.lastAck = id
+}
+
+// Send writes a message to the server.  It returns ErrNotConnected while the
+// client is reconnecting.
+func (cli *Client) Send(msg Message) error {
+	cli.mu.RLock()
+	transport, state := cli.transport, cli.state
+	cli.mu.RUnlock()
+
+	if state != StateConnected {
+		return ErrNotConnected
+	}
+	return cli.write(transport, msg)
+}
+
+// Close says goodbye to the server, closes the connection and waits for the
+// goroutines of the client to return.  Close must not be called from a
+// subscription handler or from OnStateChange, which are called by those goroutines.
 func (cli *Client) Close() {
-	close(cli.closing)
-	cli.transport.Close()
+	cli.shutdown(true)
+	cli.routines.Wait()
+}
+
+func (cli *Client) shutdown(goodbye bool) {
+	cli.closeOnce.Do(func() {
+		close(cli.closing)
+		cli.ticker.Stop()
+
+		cli.mu.RLock()
+		transport, state := cli.transport, cli.state
+		cli.mu.RUnlock()
+
+		if goodbye && state == StateConnected {
+			cli.write(transport, NewGoodbyeMessage())
+		}
+		transport.Close()
+		cli.setState(StateClosed)
+	})
+}
+
+func (cli *Client) isClosing() bool {
+	select {
+	case <-cli.closing:
+		return true
+	default:
+		return false
+	}
 }
 
 func (cli *Client) read() {
+	defer close(cli.incoming)
+
 	for {
-		msg, err := cli.transport.ReadMessage()
+		cli.mu.RLock()
+		transport := cli.transport
+		cli.mu.RUnlock()
+
+		msg, err := transport.ReadMessage()
+		if err == nil && msg.Type == MessageTypeGoodbye {
+			err = ErrGoodbye
+		}
+
 		if err != nil {
-			cli.errors <- err
+			if cli.isClosing() {
+				return
+			}
+			cli.reportError(err)
+			if !cli.reconnect(transport) {
+				return
+			}
+			continue
 		}
 
 		switch msg.Type {
+		case MessageTypeHello:
+			var hello Hello
+			if err := msg.ReadJSON(&hello); err != nil {
+				cli.reportError(err)
+				continue
+			}
+			cli.mu.Lock()
+			cli.protocol = hello
+			cli.mu.Unlock()
+			cli.negotiatedOnce.Do(func() { close(cli.negotiated) })
 		case MessageTypePing:
 			logrus.Debug("PING")
 		case MessageTypePong:
 			logrus.Debug("PONG")
+			cli.pong()
 		default:
-			cli.incoming <- msg
+			atomic.AddInt64(&cli.counters.received, 1)
+			if msg.Topic != "" && cli.dispatch(msg) {
+				continue
+			}
+			if err := cli.deliver(msg); err != nil {
+				cli.reportError(err)
+				if !cli.reconnect(transport) {
+					return
+				}
+			}
 		}
 	}
 }
 
-func (cli *Client) heartbeat() {
-	for {
+// reconnect replaces the failed transport.  It returns false if the client
+// is closed instead.
+func (cli *Client) reconnect(failed Transport) bool {
+	failed.Close()
+
+	if cli.dial == nil {
+		cli.shutdown(false)
+		return false
+	}
+
+	cli.setState(StateReconnecting)
+
+	for attempt := 0; ; attempt++ {
+		if cli.options.MaxAttempts > 0 && attempt >= cli.options.MaxAttempts {
+			cli.reportError(ErrReconnectFailed)
+			cli.shutdown(false)
+			return false
+		}
+
 		select {
-		case <-cli.ticker.C:
-			cli.sendPing()
+		case <-time.After(cli.backoff(attempt)):
 		case <-cli.closing:
-			cli.ticker.Stop()
-			return
+			return false
+		}
+
+		transport, err := cli.dial()
+		if err != nil {
+			cli.reportError(err)
+			continue
+		}
+
+		if err := cli.hello(transport); err != nil {
+			transport.Close()
+			cli.reportError(err)
+			continue
+		}
+
+		cli.mu.Lock()
+		if cli.isClosing() {
+			cli.mu.Unlock()
+			transport.Close()
+			return false
 		}
+		cli.transport = transport
+		cli.mu.Unlock()
+
+		cli.setState(StateConnected)
+
+		// Subscribing after the state changed cannot miss a concurrent Subscribe, and
+		// the server ignores patterns that are subscribed twice.  Write errors are
+		// detected by the reader.
+		if err := cli.resubscribe(transport); err != nil {
+			cli.reportError(err)
+		}
+		return true
+	}
+}
+
+// hello sends the hello message, which includes the last acknowledged message
+// ID when the client resumes and the server negotiated FeatureResume.  The hello
+// message is sent before the server replies, so the protocol of the previous
+// connection is used.
+func (cli *Client) hello(transport Transport) error {
+	cli.mu.RLock()
+	resumeFrom := ""
+	if cli.options.Resume && cli.protocol.HasFeature(FeatureResume) {
+		resumeFrom = cli.lastAck
+	}
+	cli.mu.RUnlock()
+
+	hello := Hello{
+		ClientID:   cli.options.ID,
+		Host:       cli.options.Host,
+		ResumeFrom: resumeFrom,
+		Version:    ProtocolVersion,
+		Features:   cli.options.Features,
 	}
+	if transport.Name() != "ws" {
+		hello.Token = cli.options.Token
+	}
+
+	return cli.write(transport, newHelloMessage(hello))
+}
+
+// backoff returns the delay before the reconnect attempt with the given index.  The
+// delay doubles with every attempt up to the maximum, and is randomized between half
+// and all of it, so clients do not reconnect all at once after a server restart.
+func (cli *Client) backoff(attempt int) time.Duration {
+	d := cli.options.MinBackoff
+	for i := 0; i < attempt && d < cli.options.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > cli.options.MaxBackoff {
+		d = cli.options.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (cli *Client) setState(state ConnectionState) {
+	cli.mu.Lock()
+	from := cli.state
+	if from == StateClosed || from == state {
+		cli.mu.Unlock()
+		return
+	}
+	cli.state = state
+	cli.mu.Unlock()
+
+	logrus.Debugf("live: connection %s", state)
+	if cli.options.OnStateChange != nil {
+		cli.options.OnStateChange(from, state)
+	}
+}
+
+func (cli *Client) reportError(err error) {
+	select {
+	case cli.errors <- err:
+	default:
+		atomic.AddInt64(&cli.counters.droppedErrors, 1)
+	}
+}
+
+func (cli *Client) write(transport Transport, msg Message) error {
+	cli.writeMu.Lock()
+	defer cli.writeMu.Unlock()
+	return transport.WriteMessage(msg)
 }
 
-func (cli *Client) sendPing() {
-	pingMsg := NewPingMessage()
-	cli.transport.WriteMessage(pingMsg)
+// run runs one of the goroutines of the client.
+func (cli *Client) run(f func()) {
+	defer cli.routines.Done()
+	withRecover(f)
 }
 
 func withRecover(f func()) {
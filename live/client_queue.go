@@ -0,0 +1,119 @@
+package live
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// DefaultQueueSize is the number of received messages the client buffers for Incoming.
+const DefaultQueueSize = 64
+
+// ErrQueueFull is reported when the client drops the connection because Incoming
+// is full and the overflow policy is OverflowDisconnect.
+var ErrQueueFull = errors.New("live: the incoming queue is full")
+
+// OverflowPolicy decides what the client does with a received message when the
+// Incoming queue is full.
+type OverflowPolicy int
+
+// The overflow policies of a client.
+const (
+	// OverflowBlock waits until the application receives a message.  The client
+	// stops reading the connection while it waits, so a wait longer than the pong
+	// deadlines drops the connection, see ClientOptions.MaxMissedPongs.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest removes the oldest message of the queue to make room.
+	OverflowDropOldest
+
+	// OverflowDropNewest drops the received message.
+	OverflowDropNewest
+
+	// OverflowDisconnect drops the connection and reconnects.  The dropped
+	// messages are lost, unless the server negotiated FeatureResume and sends the
+	// messages after the last acknowledged message again, see ClientOptions.Resume.
+	// The Server of this package does not.
+	OverflowDisconnect
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDisconnect:
+		return "disconnect"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// ClientStats are the counters of a client.
+type ClientStats struct {
+	Received      int64 // The messages received from the server, not counting heartbeats
+	Dropped       int64 // The messages dropped because Incoming was full
+	DroppedErrors int64 // The errors dropped because Errors was full
+	QueueDepth    int   // The messages waiting in Incoming
+	QueueSize     int   // The capacity of Incoming
+}
+
+type clientCounters struct {
+	received, dropped, droppedErrors int64
+}
+
+// Stats returns the counters of the client.
+func (cli *Client) Stats() ClientStats {
+	return ClientStats{
+		Received:      atomic.LoadInt64(&cli.counters.received),
+		Dropped:       atomic.LoadInt64(&cli.counters.dropped),
+		DroppedErrors: atomic.LoadInt64(&cli.counters.droppedErrors),
+		QueueDepth:    len(cli.incoming),
+		QueueSize:     cap(cli.incoming),
+	}
+}
+
+// deliver queues a message for Incoming according to the overflow policy.  It
+// returns ErrQueueFull when the connection must be dropped.  A message that is
+// waiting when the client is closed is dropped.
+func (cli *Client) deliver(msg Message) error {
+	select {
+	case cli.incoming <- msg:
+		return nil
+	default:
+	}
+
+	switch cli.options.Overflow {
+	case OverflowDropNewest:
+		atomic.AddInt64(&cli.counters.dropped, 1)
+		return nil
+
+	case OverflowDropOldest:
+		// The reader is the only sender, so the queue cannot fill up again between
+		// removing a message and queueing this one.
+		select {
+		case <-cli.incoming:
+			atomic.AddInt64(&cli.counters.dropped, 1)
+		default:
+		}
+		select {
+		case cli.incoming <- msg:
+		default:
+			atomic.AddInt64(&cli.counters.dropped, 1)
+		}
+		return nil
+
+	case OverflowDisconnect:
+		atomic.AddInt64(&cli.counters.dropped, 1)
+		return ErrQueueFull
+	}
+
+	select {
+	case cli.incoming <- msg:
+		return nil
+	case <-cli.closing:
+		return nil
+	}
+}
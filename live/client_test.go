@@ -0,0 +1,405 @@
+package live
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testServer accepts TCP connections and records the hello messages of the clients.
+type testServer struct {
+	listener net.Listener
+	hellos   chan Hello
+	conns    chan Transport
+
+	mu         sync.Mutex
+	transports []Transport
+}
+
+func newTestServer(addr string) *testServer {
+	l, err := net.Listen("tcp", addr)
+	So(err, ShouldBeNil)
+
+	s := &testServer{
+		listener: l,
+		hellos:   make(chan Hello, 10),
+		conns:    make(chan Transport, 10),
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			t := NewTCPTransport(conn)
+			msg, err := t.ReadMessage()
+			if err != nil {
+				continue
+			}
+
+			var hello Hello
+			msg.ReadJSON(&hello)
+
+			s.mu.Lock()
+			s.transports = append(s.transports, t)
+			s.mu.Unlock()
+
+			s.hellos <- hello
+			s.conns <- t
+		}
+	}()
+
+	return s
+}
+
+// stop closes the listener and every connection.
+func (s *testServer) stop() {
+	s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.transports {
+		t.Close()
+	}
+}
+
+func receiveHello(s *testServer) Hello {
+	select {
+	case h := <-s.hellos:
+		return h
+	case <-time.After(2 * time.Second):
+		return Hello{}
+	}
+}
+
+func waitForState(states chan ConnectionState, state ConnectionState) bool {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case s := <-states:
+			if s == state {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+
+	Convey("Given a client connected to a server", t, func() {
+		server := newTestServer("127.0.0.1:0")
+		addr := server.listener.Addr().String()
+
+		states := make(chan ConnectionState, 10)
+		cli, err := NewClient(TCPDialer(addr), ClientOptions{
+			ID:            "agent-1",
+			Host:          "test",
+			MinBackoff:    10 * time.Millisecond,
+			MaxBackoff:    50 * time.Millisecond,
+			Resume:        true,
+			OnStateChange: func(from, to ConnectionState) { states <- to },
+		})
+		So(err, ShouldBeNil)
+
+		hello := receiveHello(server)
+		conn := <-server.conns
+
+		Convey("Should say hello", func() {
+			So(hello.ClientID, ShouldEqual, "agent-1")
+			So(hello.Host, ShouldEqual, "test")
+			So(hello.ResumeFrom, ShouldEqual, "")
+			So(hello.Version, ShouldEqual, ProtocolVersion)
+			So(hello.Features, ShouldResemble, []string{FeatureResume, FeatureTopics})
+			So(cli.State(), ShouldEqual, StateConnected)
+			So(cli.Protocol().Version, ShouldEqual, 1)
+		})
+
+		Convey("Should use the protocol the server replies with", func() {
+			reply, err := Negotiate(hello, Hello{ClientID: "server", Version: ProtocolVersion, Features: []string{FeatureResume}})
+			So(err, ShouldBeNil)
+			So(conn.WriteMessage(newHelloMessage(reply)), ShouldBeNil)
+			So(conn.WriteMessage(mustJSONMessage(t, "next")), ShouldBeNil)
+
+			received := <-cli.Incoming()
+			So(string(received.Content), ShouldEqual, `"next"`)
+			So(cli.Protocol().ClientID, ShouldEqual, "server")
+			So(cli.Protocol().HasFeature(FeatureResume), ShouldBeTrue)
+		})
+
+		Convey("Should receive messages", func() {
+			msg, _ := NewJSONMessage(map[string]string{"id": "1"})
+			So(conn.WriteMessage(msg), ShouldBeNil)
+
+			received := <-cli.Incoming()
+			So(string(received.Content), ShouldEqual, `{"id":"1"}`)
+		})
+
+		Convey("When the connection drops", func() {
+			cli.Ack("42")
+			conn.Close()
+
+			Convey("Should not resume when the server did not negotiate it", func() {
+				So(waitForState(states, StateReconnecting), ShouldBeTrue)
+				So(waitForState(states, StateConnected), ShouldBeTrue)
+
+				hello := receiveHello(server)
+				So(hello.ClientID, ShouldEqual, "agent-1")
+				So(hello.ResumeFrom, ShouldEqual, "")
+			})
+		})
+
+		Convey("When the connection to a server that resumes drops", func() {
+			reply, err := Negotiate(hello, Hello{ClientID: "server", Version: ProtocolVersion, Features: []string{FeatureResume}})
+			So(err, ShouldBeNil)
+			So(conn.WriteMessage(newHelloMessage(reply)), ShouldBeNil)
+			So(conn.WriteMessage(mustJSONMessage(t, "next")), ShouldBeNil)
+			<-cli.Incoming()
+
+			cli.Ack("42")
+			conn.Close()
+
+			Convey("Should reconnect and resume from the last acknowledged message", func() {
+				So(waitForState(states, StateReconnecting), ShouldBeTrue)
+				So(waitForState(states, StateConnected), ShouldBeTrue)
+
+				hello := receiveHello(server)
+				So(hello.ClientID, ShouldEqual, "agent-1")
+				So(hello.ResumeFrom, ShouldEqual, "42")
+
+				conn := <-server.conns
+				So(cli.Send(mustJSONMessage(t, "after")), ShouldBeNil)
+				msg, err := conn.ReadMessage()
+				So(err, ShouldBeNil)
+				So(string(msg.Content), ShouldEqual, `"after"`)
+			})
+		})
+
+		Convey("When the server restarts", func() {
+			server.stop()
+			So(waitForState(states, StateReconnecting), ShouldBeTrue)
+			So(cli.Send(NewPingMessage()), ShouldEqual, ErrNotConnected)
+
+			time.Sleep(50 * time.Millisecond)
+			server = newTestServer(addr)
+
+			Convey("Should reconnect when the server is back", func() {
+				So(waitForState(states, StateConnected), ShouldBeTrue)
+				So(receiveHello(server).ClientID, ShouldEqual, "agent-1")
+			})
+		})
+
+		Convey("When the client is closed", func() {
+			cli.Close()
+
+			Convey("Should say goodbye and close the incoming channel", func() {
+				msg, err := conn.ReadMessage()
+				So(err, ShouldBeNil)
+				So(msg.Type, ShouldEqual, MessageTypeGoodbye)
+
+				_, ok := <-cli.Incoming()
+				So(ok, ShouldBeFalse)
+				So(cli.State(), ShouldEqual, StateClosed)
+			})
+		})
+
+		Reset(func() {
+			cli.Close()
+			server.stop()
+		})
+	})
+
+	Convey("Given a client with a limited number of attempts", t, func() {
+		server := newTestServer("127.0.0.1:0")
+		cli, err := NewClient(TCPDialer(server.listener.Addr().String()), ClientOptions{
+			MinBackoff:  5 * time.Millisecond,
+			MaxAttempts: 2,
+		})
+		So(err, ShouldBeNil)
+		receiveHello(server)
+
+		Convey("Should close when the server does not come back", func() {
+			server.stop()
+
+			_, ok := <-cli.Incoming()
+			So(ok, ShouldBeFalse)
+			So(cli.State(), ShouldEqual, StateClosed)
+
+			var last error
+			for err := range drain(cli.Errors()) {
+				last = err
+			}
+			So(last, ShouldEqual, ErrReconnectFailed)
+		})
+	})
+
+	Convey("Given a client without a dialer", t, func() {
+		server := newTestServer("127.0.0.1:0")
+		conn, err := net.Dial("tcp", server.listener.Addr().String())
+		So(err, ShouldBeNil)
+
+		cli, err := NewClientWithTransport(NewTCPTransport(conn), "agent-2", "test")
+		So(err, ShouldBeNil)
+		So(receiveHello(server).ClientID, ShouldEqual, "agent-2")
+
+		Convey("Should close when the connection drops", func() {
+			server.stop()
+
+			_, ok := <-cli.Incoming()
+			So(ok, ShouldBeFalse)
+			So(cli.State(), ShouldEqual, StateClosed)
+		})
+	})
+}
+
+func TestClientBackoff(t *testing.T) {
+
+	Convey("Given a client with a backoff", t, func() {
+		cli := &Client{options: ClientOptions{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+
+		Convey("Should double the delay up to the maximum", func() {
+			for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+				d := cli.backoff(attempt)
+				So(d, ShouldBeGreaterThanOrEqualTo, max/2)
+				So(d, ShouldBeLessThanOrEqualTo, max)
+			}
+		})
+	})
+}
+
+func mustJSONMessage(t *testing.T, data interface{}) Message {
+	msg, err := NewJSONMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// drain returns the values that are buffered in the channel.
+func drain(errs <-chan error) chan error {
+	out := make(chan error, cap(errs))
+	for {
+		select {
+		case err := <-errs:
+			out <- err
+		default:
+			close(out)
+			return out
+		}
+	}
+}
+
+func TestClientOverflow(t *testing.T) {
+
+	Convey("Given a server and a client that is not receiving", t, func() {
+		server := newTestServer("127.0.0.1:0")
+		addr := server.listener.Addr().String()
+
+		states := make(chan ConnectionState, 10)
+		options := ClientOptions{
+			ID:            "agent-1",
+			MinBackoff:    10 * time.Millisecond,
+			QueueSize:     2,
+			OnStateChange: func(from, to ConnectionState) { states <- to },
+		}
+
+		var cli *Client
+		var conn Transport
+		connect := func(policy OverflowPolicy) {
+			options.Overflow = policy
+			var err error
+			cli, err = NewClient(TCPDialer(addr), options)
+			So(err, ShouldBeNil)
+			receiveHello(server)
+			conn = <-server.conns
+		}
+		send := func(texts ...string) {
+			for _, text := range texts {
+				So(conn.WriteMessage(mustJSONMessage(t, text)), ShouldBeNil)
+			}
+		}
+		received := func(n int64) bool {
+			return waitFor(func() bool { return cli.Stats().Received == n })
+		}
+		receive := func() string {
+			return string(receiveMessage(cli.Incoming()).Content)
+		}
+
+		Convey("Should drop the newest messages", func() {
+			connect(OverflowDropNewest)
+			send("1", "2", "3", "4")
+			So(received(4), ShouldBeTrue)
+
+			So(cli.Stats(), ShouldResemble, ClientStats{Received: 4, Dropped: 2, QueueDepth: 2, QueueSize: 2})
+			So(receive(), ShouldEqual, `"1"`)
+			So(receive(), ShouldEqual, `"2"`)
+		})
+
+		Convey("Should drop the oldest messages", func() {
+			connect(OverflowDropOldest)
+			send("1", "2", "3", "4")
+			So(received(4), ShouldBeTrue)
+
+			So(cli.Stats().Dropped, ShouldEqual, 2)
+			So(receive(), ShouldEqual, `"3"`)
+			So(receive(), ShouldEqual, `"4"`)
+		})
+
+		Convey("Should reconnect when the queue is full", func() {
+			connect(OverflowDisconnect)
+			send("1", "2", "3")
+
+			So(waitForState(states, StateReconnecting), ShouldBeTrue)
+			So(waitForState(states, StateConnected), ShouldBeTrue)
+			So(<-cli.Errors(), ShouldEqual, ErrQueueFull)
+			So(cli.Stats().Dropped, ShouldEqual, 1)
+		})
+
+		Convey("Should wait for the application by default", func() {
+			connect(OverflowBlock)
+			send("1", "2", "3", "4")
+			So(received(3), ShouldBeTrue)
+
+			So(receive(), ShouldEqual, `"1"`)
+			So(receive(), ShouldEqual, `"2"`)
+			So(receive(), ShouldEqual, `"3"`)
+			So(receive(), ShouldEqual, `"4"`)
+			So(cli.Stats().Dropped, ShouldEqual, 0)
+
+			Convey("Should stop waiting when the client is closed", func() {
+				send("5", "6", "7")
+				So(received(7), ShouldBeTrue)
+
+				closed := make(chan struct{})
+				go func() {
+					cli.Close()
+					close(closed)
+				}()
+				select {
+				case <-closed:
+				case <-time.After(2 * time.Second):
+					So("Close did not return", ShouldBeEmpty)
+				}
+
+				count := 0
+				for range cli.Incoming() {
+					count++
+				}
+				So(count, ShouldEqual, 2)
+			})
+		})
+
+		Reset(func() {
+			if cli != nil {
+				cli.Close()
+			}
+			server.stop()
+		})
+	})
+}
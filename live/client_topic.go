@@ -0,0 +1,143 @@
+package live
+
+// Subscription is a handler registered with Client.Subscribe.
+type Subscription struct {
+	cli     *Client
+	pattern string
+	handler func(Message)
+}
+
+// Pattern returns the pattern of the subscription.
+func (s *Subscription) Pattern() string {
+	return s.pattern
+}
+
+// Unsubscribe removes the handler.  The server is told to stop sending the topics
+// of the pattern when it was the last handler of the pattern.
+func (s *Subscription) Unsubscribe() error {
+	return s.cli.unsubscribe(s)
+}
+
+// Subscribe registers a handler for the messages whose topic matches the pattern,
+// and subscribes to the pattern on the server.  Several handlers can share a
+// connection, each message is passed to every matching handler.  Messages with a
+// topic no handler matches are sent to Incoming.
+//
+// Handlers are called from the goroutine that reads the connection, and must not
+// block.  Subscriptions are sent again when the client reconnects.
+//
+// ErrTopicsNotSupported is returned unless the server negotiated FeatureTopics, so
+// clients should wait for Negotiated before subscribing.
+func (cli *Client) Subscribe(pattern string, handler func(Message)) (*Subscription, error) {
+	if err := ValidatePattern(pattern); err != nil {
+		return nil, err
+	}
+	if !cli.Protocol().HasFeature(FeatureTopics) {
+		return nil, ErrTopicsNotSupported
+	}
+
+	sub := &Subscription{cli: cli, pattern: pattern, handler: handler}
+
+	cli.topicsMu.Lock()
+	cli.handlers = append(cli.handlers, sub)
+	cli.patterns[pattern]++
+	first := cli.patterns[pattern] == 1
+	cli.topicsMu.Unlock()
+
+	if first {
+		if err := cli.sendSubscription(NewSubscribeMessage(pattern)); err != nil {
+			cli.removeHandler(sub)
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// Publish sends a message with the topic to the server.  Like Subscribe, it returns
+// ErrTopicsNotSupported unless the server negotiated FeatureTopics.
+func (cli *Client) Publish(topic string, msg Message) error {
+	if err := ValidateTopic(topic); err != nil {
+		return err
+	}
+	if !cli.Protocol().HasFeature(FeatureTopics) {
+		return ErrTopicsNotSupported
+	}
+
+	msg.Topic = topic
+	return cli.Send(msg)
+}
+
+func (cli *Client) unsubscribe(sub *Subscription) error {
+	if cli.removeHandler(sub) {
+		return cli.sendSubscription(NewUnsubscribeMessage(sub.pattern))
+	}
+	return nil
+}
+
+// removeHandler removes the handler of the subscription, and returns true if it was
+// the last handler of its pattern.
+func (cli *Client) removeHandler(sub *Subscription) bool {
+	cli.topicsMu.Lock()
+	defer cli.topicsMu.Unlock()
+
+	found := false
+	for i, h := range cli.handlers {
+		if h == sub {
+			cli.handlers = append(cli.handlers[:i], cli.handlers[i+1:]...)
+			found = true
+			break
+		}
+	}
+	last := false
+	if found {
+		cli.patterns[sub.pattern]--
+		if cli.patterns[sub.pattern] == 0 {
+			delete(cli.patterns, sub.pattern)
+			last = true
+		}
+	}
+	return last
+}
+
+// sendSubscription sends a SUBSCRIBE or UNSUBSCRIBE message.  While the client is
+// reconnecting the message is not sent, as the subscriptions are sent again once
+// the client is connected.
+func (cli *Client) sendSubscription(msg Message) error {
+	if err := cli.Send(msg); err != nil && err != ErrNotConnected {
+		return err
+	}
+	return nil
+}
+
+// resubscribe sends the patterns of the handlers on a new connection.
+func (cli *Client) resubscribe(transport Transport) error {
+	cli.topicsMu.RLock()
+	patterns := make([]string, 0, len(cli.patterns))
+	for pattern := range cli.patterns {
+		patterns = append(patterns, pattern)
+	}
+	cli.topicsMu.RUnlock()
+
+	if len(patterns) == 0 {
+		return nil
+	}
+	return cli.write(transport, NewSubscribeMessage(patterns...))
+}
+
+// dispatch passes the message to the handlers whose pattern matches its topic.  It
+// returns false if no handler matched.
+func (cli *Client) dispatch(msg Message) bool {
+	cli.topicsMu.RLock()
+	var matched []*Subscription
+	for _, h := range cli.handlers {
+		if MatchTopic(h.pattern, msg.Topic) {
+			matched = append(matched, h)
+		}
+	}
+	cli.topicsMu.RUnlock()
+
+	for _, h := range matched {
+		h.handler(msg)
+	}
+	return len(matched) > 0
+}
@@ -1,16 +1,57 @@
 package live
 
 import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
 	"github.com/gorilla/websocket"
 )
 
-func NewWebSocketClient(addr, id, host string) (*Client, error) {
-	wsConn, _, err := websocket.DefaultDialer.Dial(addr, nil)
-	if err != nil {
-		return nil, err
+// WebSocketOptions configure the handshake of a web socket dialer.
+type WebSocketOptions struct {
+	TLSConfig        *tls.Config   // optional: The TLS configuration of wss:// addresses
+	Header           http.Header   // optional: The headers of the handshake request
+	Token            string        // optional: The bearer token sent in the Authorization header
+	HandshakeTimeout time.Duration // optional: The time allowed for the handshake
+
+	// optional: The content type of binary frames, see NewWebSocketTransportWithContentType.
+	BinaryContentType string
+}
+
+// WebSocketDialer returns a dialer for a web socket server.
+func WebSocketDialer(addr string) Dialer {
+	return WebSocketDialerWithOptions(addr, WebSocketOptions{})
+}
+
+// WebSocketDialerWithOptions returns a dialer for a web socket server that uses
+// the options for the handshake.
+func WebSocketDialerWithOptions(addr string, options WebSocketOptions) Dialer {
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		TLSClientConfig:  options.TLSConfig,
+		HandshakeTimeout: options.HandshakeTimeout,
 	}
 
-	transport := NewWebSocketTransport(wsConn)
-	cli, err := NewClientWithTransport(transport, id, host)
-	return cli, err
+	header := http.Header{}
+	for k, v := range options.Header {
+		header[k] = v
+	}
+	if options.Token != "" {
+		header.Set("Authorization", "Bearer "+options.Token)
+	}
+
+	return func() (Transport, error) {
+		wsConn, _, err := dialer.Dial(addr, header)
+		if err != nil {
+			return nil, err
+		}
+		return NewWebSocketTransportWithContentType(wsConn, options.BinaryContentType), nil
+	}
+}
+
+// NewWebSocketClient connects to a web socket server and reconnects when the
+// connection drops.
+func NewWebSocketClient(addr, id, host string) (*Client, error) {
+	return NewClient(WebSocketDialer(addr), ClientOptions{ID: id, Host: host})
 }
@@ -0,0 +1,232 @@
+package live
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack"
+)
+
+// The content types of the built in content codecs.  ContentTypeBinary is the
+// content type of the binary frames of web sockets, which has no codec.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgPack  = "application/msgpack"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeBinary   = "application/octet-stream"
+)
+
+// CompressionGzip is the compression of messages compressed with Message.Compress.
+// It is added to the content type as a parameter, as in
+// "application/msgpack; compression=gzip".
+const CompressionGzip = "gzip"
+
+// ContentCodec marshals the content of messages of a content type.
+type ContentCodec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	contentCodecsMu sync.RWMutex
+	contentCodecs   = map[string]ContentCodec{}
+)
+
+func init() {
+	RegisterContentCodec(jsonCodec{})
+	RegisterContentCodec(msgpackCodec{})
+	RegisterContentCodec(protobufCodec{})
+}
+
+// RegisterContentCodec makes a codec available to Message.Encode and Message.Decode
+// for its content type.  It panics if a codec is already registered for the
+// content type.
+func RegisterContentCodec(codec ContentCodec) {
+	contentCodecsMu.Lock()
+	defer contentCodecsMu.Unlock()
+
+	if codec == nil {
+		panic("live: RegisterContentCodec codec is nil")
+	}
+	contentType := codec.ContentType()
+	if _, dup := contentCodecs[contentType]; dup {
+		panic("live: RegisterContentCodec called twice for content type " + contentType)
+	}
+	contentCodecs[contentType] = codec
+}
+
+// ContentCodecFor returns the codec of the content type.  The parameters of the
+// content type are ignored.
+func ContentCodecFor(contentType string) (ContentCodec, error) {
+	mediaType, _, err := parseContentType(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	contentCodecsMu.RLock()
+	codec, ok := contentCodecs[mediaType]
+	contentCodecsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("live: no codec is registered for content type '%s'", mediaType)
+	}
+	return codec, nil
+}
+
+// NewMessage creates a message with the data encoded in the content type.
+func NewMessage(contentType string, data interface{}) (Message, error) {
+	msg := Message{Type: MessageTypeMessage}
+	err := msg.Encode(contentType, data)
+	return msg, err
+}
+
+// Encode replaces the content of the message with the data encoded in the
+// content type.
+func (msg *Message) Encode(contentType string, data interface{}) error {
+	codec, err := ContentCodecFor(contentType)
+	if err != nil {
+		return err
+	}
+
+	buf, err := codec.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	msg.ContentType = codec.ContentType()
+	msg.ContentLength = int32(len(buf))
+	msg.Content = buf
+	return nil
+}
+
+// Decode decodes the content of the message into the value pointed to by ptr,
+// using the codec of its content type.  Compressed content is decompressed first.
+func (msg Message) Decode(ptr interface{}) error {
+	mediaType, params, err := parseContentType(msg.ContentType)
+	if err != nil {
+		return err
+	}
+
+	codec, err := ContentCodecFor(mediaType)
+	if err != nil {
+		return err
+	}
+
+	content := msg.Content
+	switch compression := params["compression"]; compression {
+	case "":
+	case CompressionGzip:
+		if content, err = gunzip(content); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("live: unknown compression '%s'", compression)
+	}
+
+	return codec.Unmarshal(content, ptr)
+}
+
+// Compress compresses the content of the message with gzip, and adds the
+// compression to its content type.  Messages that are already compressed are
+// not changed.  Web sockets only send compressed messages when the content type of
+// their binary frames includes the compression.
+func (msg *Message) Compress() error {
+	mediaType, params, err := parseContentType(msg.ContentType)
+	if err != nil {
+		return err
+	}
+	if params["compression"] != "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(msg.Content); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	params["compression"] = CompressionGzip
+	msg.ContentType = mime.FormatMediaType(mediaType, params)
+	msg.ContentLength = int32(buf.Len())
+	msg.Content = buf.Bytes()
+	return nil
+}
+
+func parseContentType(contentType string) (string, map[string]string, error) {
+	if contentType == "" {
+		return "", nil, fmt.Errorf("live: the message has no content type")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, fmt.Errorf("live: invalid content type '%s': %v", contentType, err)
+	}
+	return mediaType, params, nil
+}
+
+// gunzip decompresses content, which cannot be larger than DefaultMaxFrameSize
+// once decompressed.
+func gunzip(content []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	buf, err := ioutil.ReadAll(io.LimitReader(r, DefaultMaxFrameSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > DefaultMaxFrameSize {
+		return nil, fmt.Errorf("live: the decompressed content is larger than %d bytes", DefaultMaxFrameSize)
+	}
+	return buf, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return ContentTypeJSON }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return ContentTypeMsgPack }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) { return msgpack.Marshal(v) }
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+// protobufCodec encodes values that implement proto.Message.
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	pb, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("live: %T is not a protocol buffer message", v)
+	}
+	return proto.Marshal(pb)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	pb, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("live: %T is not a protocol buffer message", v)
+	}
+	return proto.Unmarshal(data, pb)
+}
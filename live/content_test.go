@@ -0,0 +1,188 @@
+package live
+
+import (
+	"bytes"
+	"mime"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type contentTestData struct {
+	Name  string `json:"name" msgpack:"name"`
+	Count int    `json:"count" msgpack:"count"`
+}
+
+func TestContentCodecs(t *testing.T) {
+
+	Convey("Given the built in content codecs", t, func() {
+		data := contentTestData{Name: "users", Count: 42}
+
+		Convey("Should encode and decode JSON and MessagePack", func() {
+			for _, contentType := range []string{ContentTypeJSON, ContentTypeMsgPack} {
+				msg, err := NewMessage(contentType, data)
+				So(err, ShouldBeNil)
+				So(msg.Type, ShouldEqual, MessageTypeMessage)
+				So(msg.ContentType, ShouldEqual, contentType)
+				So(msg.ContentLength, ShouldEqual, len(msg.Content))
+
+				var decoded contentTestData
+				So(msg.Decode(&decoded), ShouldBeNil)
+				So(decoded, ShouldResemble, data)
+			}
+		})
+
+		Convey("Should encode and decode protocol buffers", func() {
+			msg, err := NewMessage(ContentTypeProtobuf, &wrappers.StringValue{Value: "users"})
+			So(err, ShouldBeNil)
+
+			var decoded wrappers.StringValue
+			So(msg.Decode(&decoded), ShouldBeNil)
+			So(decoded.Value, ShouldEqual, "users")
+
+			_, err = NewMessage(ContentTypeProtobuf, data)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should compress the content", func() {
+			msg, err := NewMessage(ContentTypeMsgPack, strings.Repeat("users ", 100))
+			So(err, ShouldBeNil)
+			size := len(msg.Content)
+
+			So(msg.Compress(), ShouldBeNil)
+			So(msg.ContentType, ShouldEqual, "application/msgpack; compression=gzip")
+			So(len(msg.Content), ShouldBeLessThan, size)
+			So(msg.ContentLength, ShouldEqual, len(msg.Content))
+
+			compressed := msg.Content
+			So(msg.Compress(), ShouldBeNil)
+			So(msg.Content, ShouldResemble, compressed)
+
+			var decoded string
+			So(msg.Decode(&decoded), ShouldBeNil)
+			So(decoded, ShouldEqual, strings.Repeat("users ", 100))
+		})
+
+		Convey("Should send compressed messages over TCP", func() {
+			msg, _ := NewMessage(ContentTypeJSON, data)
+			So(msg.Compress(), ShouldBeNil)
+
+			var buf bytes.Buffer
+			So(Codec{}.Encode(&buf, msg), ShouldBeNil)
+			received, err := Codec{}.Decode(&buf)
+			So(err, ShouldBeNil)
+
+			var decoded contentTestData
+			So(received.Decode(&decoded), ShouldBeNil)
+			So(decoded, ShouldResemble, data)
+		})
+
+		Convey("Should fail for unknown content types and compressions", func() {
+			var decoded contentTestData
+			So(Message{ContentType: ContentTypeBinary}.Decode(&decoded), ShouldNotBeNil)
+			So(Message{ContentType: "application/json; compression=zip"}.Decode(&decoded), ShouldNotBeNil)
+			So(Message{}.Decode(&decoded), ShouldNotBeNil)
+
+			_, err := NewMessage("text/csv", data)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should panic when a codec is registered twice", func() {
+			So(func() { RegisterContentCodec(jsonCodec{}) }, ShouldPanic)
+		})
+	})
+
+	Convey("Given a web socket server that reads binary frames as MessagePack", t, func() {
+		events, options := newServerEvents()
+		options.BinaryContentType = ContentTypeMsgPack
+		server := NewServer(options)
+		httpServer := httptest.NewServer(server)
+		addr := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+		cli, err := NewClient(WebSocketDialerWithOptions(addr, WebSocketOptions{BinaryContentType: ContentTypeMsgPack}), ClientOptions{ID: "browser"})
+		So(err, ShouldBeNil)
+		conn := receiveConn(events.connected)
+		So(conn != nil, ShouldBeTrue)
+
+		Convey("Should decode the binary frames", func() {
+			msg, err := NewMessage(ContentTypeMsgPack, contentTestData{Name: "orders", Count: 7})
+			So(err, ShouldBeNil)
+			So(cli.Send(msg), ShouldBeNil)
+
+			var decoded contentTestData
+			So(receiveMessage(events.messages).Decode(&decoded), ShouldBeNil)
+			So(decoded, ShouldResemble, contentTestData{Name: "orders", Count: 7})
+
+			So(conn.Send(msg), ShouldBeNil)
+			decoded = contentTestData{}
+			So(receiveMessage(cli.Incoming()).Decode(&decoded), ShouldBeNil)
+			So(decoded.Name, ShouldEqual, "orders")
+		})
+
+		Convey("Should refuse content types the binary frames do not carry", func() {
+			msg, err := NewMessage(ContentTypeMsgPack, contentTestData{Name: "orders", Count: 7})
+			So(err, ShouldBeNil)
+			So(msg.Compress(), ShouldBeNil)
+			So(cli.Send(msg), ShouldNotBeNil)
+
+			msg, err = NewMessage(ContentTypeJSON, contentTestData{Name: "orders", Count: 7})
+			So(err, ShouldBeNil)
+			So(msg.Compress(), ShouldBeNil)
+			So(cli.Send(msg), ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			cli.Close()
+			server.Close()
+			httpServer.Close()
+		})
+	})
+
+	Convey("Given a web socket server that reads binary frames as compressed MessagePack", t, func() {
+		compressed := mime.FormatMediaType(ContentTypeMsgPack, map[string]string{"compression": CompressionGzip})
+
+		events, options := newServerEvents()
+		options.BinaryContentType = compressed
+		server := NewServer(options)
+		httpServer := httptest.NewServer(server)
+		addr := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+		cli, err := NewClient(WebSocketDialerWithOptions(addr, WebSocketOptions{BinaryContentType: compressed}), ClientOptions{ID: "browser"})
+		So(err, ShouldBeNil)
+		conn := receiveConn(events.connected)
+		So(conn != nil, ShouldBeTrue)
+
+		Convey("Should round-trip compressed messages", func() {
+			msg, err := NewMessage(ContentTypeMsgPack, contentTestData{Name: "orders", Count: 7})
+			So(err, ShouldBeNil)
+			So(msg.Compress(), ShouldBeNil)
+			So(cli.Send(msg), ShouldBeNil)
+
+			received := receiveMessage(events.messages)
+			So(received.ContentType, ShouldEqual, compressed)
+			var decoded contentTestData
+			So(received.Decode(&decoded), ShouldBeNil)
+			So(decoded, ShouldResemble, contentTestData{Name: "orders", Count: 7})
+
+			So(conn.Send(msg), ShouldBeNil)
+			decoded = contentTestData{}
+			So(receiveMessage(cli.Incoming()).Decode(&decoded), ShouldBeNil)
+			So(decoded, ShouldResemble, contentTestData{Name: "orders", Count: 7})
+		})
+
+		Convey("Should refuse uncompressed binary messages", func() {
+			msg, err := NewMessage(ContentTypeMsgPack, contentTestData{Name: "orders", Count: 7})
+			So(err, ShouldBeNil)
+			So(cli.Send(msg), ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			cli.Close()
+			server.Close()
+			httpServer.Close()
+		})
+	})
+}
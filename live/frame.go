@@ -0,0 +1,193 @@
+package live
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxFrameSize is the maximum size of the content of a frame, unless the
+// codec is configured otherwise.
+const DefaultMaxFrameSize = 16 * 1024 * 1024
+
+// topicMessageCode is the code of a MESSAGE with a topic, which is written
+// after the code.
+const topicMessageCode = 11
+
+// The message type codes used on the wire.
+var (
+	messageTypeCodes = map[MessageType]uint16{
+		MessageTypePing:        0,
+		MessageTypePong:        1,
+		MessageTypeHello:       2,
+		MessageTypeGoodbye:     3,
+		MessageTypeSubscribe:   4,
+		MessageTypeUnsubscribe: 5,
+		MessageTypeMessage:     10,
+	}
+	messageTypes = map[uint16]MessageType{}
+)
+
+func init() {
+	for t, code := range messageTypeCodes {
+		messageTypes[code] = t
+	}
+}
+
+// ProtocolError is returned when a peer sends a frame that is not valid.  The
+// connection cannot be used after a protocol error.
+type ProtocolError struct {
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return "live: protocol error: " + e.Message
+}
+
+func protocolError(format string, args ...interface{}) error {
+	return &ProtocolError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Codec reads and writes the frames of the live protocol.  A frame is the message
+// type as a uint16, followed for messages with content by the length of the content
+// type as a uint16, the content type, the length of the content as an int32 and the
+// content.  A MESSAGE with a topic has its own type code, followed by the length of
+// the topic as a uint16 and the topic.  All integers are big endian.
+type Codec struct {
+	MaxFrameSize int // optional: The maximum size of the content, DefaultMaxFrameSize if 0
+}
+
+func (c Codec) maxFrameSize() int {
+	if c.MaxFrameSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return c.MaxFrameSize
+}
+
+// Encode writes the message as a frame.  The content length is taken from the
+// content, not from the ContentLength of the message.
+func (c Codec) Encode(w io.Writer, msg Message) error {
+	code, ok := messageTypeCodes[msg.Type]
+	if !ok {
+		return protocolError("unknown message type '%s'", msg.Type)
+	}
+
+	if !hasContent(msg.Type) {
+		var buf [2]byte
+		binary.BigEndian.PutUint16(buf[:], code)
+		_, err := w.Write(buf[:])
+		return err
+	}
+
+	if len(msg.ContentType) > 0xffff {
+		return fmt.Errorf("live: the content type is longer than %d bytes", 0xffff)
+	}
+	if len(msg.Topic) > 0xffff {
+		return fmt.Errorf("live: the topic is longer than %d bytes", 0xffff)
+	}
+	if len(msg.Content) > c.maxFrameSize() {
+		return fmt.Errorf("live: the content is larger than the maximum frame size of %d bytes", c.maxFrameSize())
+	}
+
+	topic := msg.Type == MessageTypeMessage && msg.Topic != ""
+
+	size := 2 + 2 + len(msg.ContentType) + 4 + len(msg.Content)
+	if topic {
+		size += 2 + len(msg.Topic)
+	}
+
+	buf := make([]byte, size)
+	n := 2
+	if topic {
+		binary.BigEndian.PutUint16(buf[0:], topicMessageCode)
+		binary.BigEndian.PutUint16(buf[2:], uint16(len(msg.Topic)))
+		n = 4 + copy(buf[4:], msg.Topic)
+	} else {
+		binary.BigEndian.PutUint16(buf[0:], code)
+	}
+	binary.BigEndian.PutUint16(buf[n:], uint16(len(msg.ContentType)))
+	n += 2 + copy(buf[n+2:], msg.ContentType)
+	binary.BigEndian.PutUint32(buf[n:], uint32(len(msg.Content)))
+	copy(buf[n+4:], msg.Content)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// Decode reads a frame.  It returns io.EOF if the reader ends before the frame
+// starts, io.ErrUnexpectedEOF if it ends within the frame and a *ProtocolError if
+// the frame is not valid or its content is larger than the maximum frame size.
+func (c Codec) Decode(r io.Reader) (Message, error) {
+	var msg Message
+
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return msg, err
+	}
+
+	code := binary.BigEndian.Uint16(header[:])
+	if code == topicMessageCode {
+		msg.Type = MessageTypeMessage
+
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return msg, unexpectedEOF(err)
+		}
+		topic := make([]byte, binary.BigEndian.Uint16(header[:]))
+		if len(topic) == 0 {
+			return msg, protocolError("empty topic")
+		}
+		if _, err := io.ReadFull(r, topic); err != nil {
+			return msg, unexpectedEOF(err)
+		}
+		msg.Topic = string(topic)
+	} else {
+		t, ok := messageTypes[code]
+		if !ok {
+			return msg, protocolError("unknown message type %d", code)
+		}
+		msg.Type = t
+	}
+
+	if !hasContent(msg.Type) {
+		return msg, nil
+	}
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return msg, unexpectedEOF(err)
+	}
+
+	contentType := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, contentType); err != nil {
+		return msg, unexpectedEOF(err)
+	}
+	msg.ContentType = string(contentType)
+
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return msg, unexpectedEOF(err)
+	}
+
+	size := int32(binary.BigEndian.Uint32(length[:]))
+	if size < 0 {
+		return msg, protocolError("negative content length %d", size)
+	}
+	if int(size) > c.maxFrameSize() {
+		return msg, protocolError("content length %d exceeds the maximum frame size of %d bytes", size, c.maxFrameSize())
+	}
+
+	msg.ContentLength = size
+	msg.Content = make([]byte, size)
+	if _, err := io.ReadFull(r, msg.Content); err != nil {
+		return msg, unexpectedEOF(err)
+	}
+
+	return msg, nil
+}
+
+// unexpectedEOF turns an EOF within a frame into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
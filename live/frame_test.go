@@ -0,0 +1,143 @@
+package live
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+	"testing/quick"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func encode(c Codec, msgs ...Message) []byte {
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		So(c.Encode(&buf, msg), ShouldBeNil)
+	}
+	return buf.Bytes()
+}
+
+func TestCodec(t *testing.T) {
+
+	Convey("Given a codec", t, func() {
+		codec := Codec{}
+		msg := mustJSONMessage(t, map[string]string{"id": "1"})
+
+		Convey("Should round trip every message type", func() {
+			topic, _ := NewTopicMessage("publishers.csv", "status")
+			msgs := []Message{NewPingMessage(), NewPongMessage(), NewHelloMessage("agent", "host"), NewGoodbyeMessage(), msg,
+				topic, NewSubscribeMessage("publishers.*"), NewUnsubscribeMessage("publishers.*")}
+			r := bytes.NewReader(encode(codec, msgs...))
+
+			for _, expected := range msgs {
+				actual, err := codec.Decode(r)
+				So(err, ShouldBeNil)
+				So(actual, ShouldResemble, expected)
+			}
+
+			_, err := codec.Decode(r)
+			So(err, ShouldEqual, io.EOF)
+		})
+
+		Convey("Should read frames that arrive one byte at a time", func() {
+			actual, err := codec.Decode(iotest.OneByteReader(bytes.NewReader(encode(codec, msg))))
+			So(err, ShouldBeNil)
+			So(actual, ShouldResemble, msg)
+		})
+
+		Convey("Should write the length of the content rather than the ContentLength", func() {
+			msg.ContentLength = 1000
+			actual, err := codec.Decode(bytes.NewReader(encode(codec, msg)))
+			So(err, ShouldBeNil)
+			So(actual.ContentLength, ShouldEqual, len(msg.Content))
+		})
+
+		Convey("Should return io.ErrUnexpectedEOF when a frame is cut short", func() {
+			data := encode(codec, msg)
+			for n := 1; n < len(data); n++ {
+				_, err := codec.Decode(bytes.NewReader(data[:n]))
+				So(err, ShouldEqual, io.ErrUnexpectedEOF)
+			}
+		})
+
+		Convey("Should return a protocol error for an unknown message type", func() {
+			_, err := codec.Decode(bytes.NewReader([]byte{0, 42}))
+			So(err, ShouldHaveSameTypeAs, &ProtocolError{})
+			So(err.Error(), ShouldEqual, "live: protocol error: unknown message type 42")
+
+			So(codec.Encode(&bytes.Buffer{}, Message{Type: "UNKNOWN"}), ShouldHaveSameTypeAs, &ProtocolError{})
+		})
+
+		Convey("Should return a protocol error for an empty topic", func() {
+			_, err := codec.Decode(bytes.NewReader([]byte{0, 11, 0, 0}))
+			So(err, ShouldHaveSameTypeAs, &ProtocolError{})
+		})
+
+		Convey("Should return a protocol error for a negative content length", func() {
+			data := []byte{0, 10, 0, 0, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(data[4:], 0xffffffff)
+
+			_, err := codec.Decode(bytes.NewReader(data))
+			So(err, ShouldHaveSameTypeAs, &ProtocolError{})
+		})
+	})
+
+	Convey("Given a codec with a maximum frame size", t, func() {
+		codec := Codec{MaxFrameSize: 8}
+		large := Message{Type: MessageTypeMessage, ContentType: "text/plain", Content: []byte("more than eight bytes")}
+
+		Convey("Should not write larger frames", func() {
+			So(codec.Encode(&bytes.Buffer{}, large), ShouldNotBeNil)
+		})
+
+		Convey("Should not read larger frames", func() {
+			_, err := codec.Decode(bytes.NewReader(encode(Codec{}, large)))
+			So(err, ShouldHaveSameTypeAs, &ProtocolError{})
+			So(err.Error(), ShouldEqual, "live: protocol error: content length 21 exceeds the maximum frame size of 8 bytes")
+		})
+	})
+}
+
+func TestCodecRandomInput(t *testing.T) {
+	codec := Codec{MaxFrameSize: 1024}
+
+	// Random input must never panic, and any frame that decodes must encode to
+	// the same bytes.
+	f := func(data []byte) bool {
+		r := bytes.NewReader(data)
+		msg, err := codec.Decode(r)
+		if err != nil {
+			return true
+		}
+
+		var buf bytes.Buffer
+		if err := codec.Encode(&buf, msg); err != nil {
+			return false
+		}
+		return bytes.Equal(buf.Bytes(), data[:len(data)-r.Len()])
+	}
+
+	// Random frames with a valid header are more likely to decode.
+	g := func(code bool, contentType string, content []byte) bool {
+		typ := MessageType(MessageTypePing)
+		if code {
+			typ = MessageTypeMessage
+		}
+		msg := Message{Type: typ, ContentType: contentType, Content: content}
+
+		var buf bytes.Buffer
+		if err := codec.Encode(&buf, msg); err != nil {
+			return len(content) > 1024
+		}
+		return f(buf.Bytes())
+	}
+
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+	if err := quick.Check(g, nil); err != nil {
+		t.Error(err)
+	}
+}
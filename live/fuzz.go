@@ -0,0 +1,26 @@
+// +build gofuzz
+
+package live
+
+import "bytes"
+
+// Fuzz is the entry point for go-fuzz.  Every frame that decodes must encode to
+// the same bytes.
+func Fuzz(data []byte) int {
+	codec := Codec{MaxFrameSize: 1 << 16}
+
+	r := bytes.NewReader(data)
+	msg, err := codec.Decode(r)
+	if err != nil {
+		return 0
+	}
+
+	var buf bytes.Buffer
+	if err := codec.Encode(&buf, msg); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data[:len(data)-r.Len()]) {
+		panic("live: the frame does not encode to the same bytes")
+	}
+	return 1
+}
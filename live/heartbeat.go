@@ -0,0 +1,145 @@
+package live
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrHeartbeatTimeout is reported when the client drops a connection because the
+// server did not answer its pings.
+var ErrHeartbeatTimeout = errors.New("live: the server did not answer the heartbeat")
+
+// Latency holds the round-trip times measured from the pings of a client and the
+// pongs of the server.
+type Latency struct {
+	Last    time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	Average time.Duration
+	Pongs   int64 // The pongs received in time
+	Missed  int64 // The pings that were not answered before the deadline
+}
+
+// liveness tracks the pending ping of a client.
+type liveness struct {
+	mu      sync.Mutex
+	sentAt  time.Time // When the pending ping was sent, zero when it was answered
+	missed  int       // The consecutive pings without a pong
+	total   time.Duration
+	latency Latency
+}
+
+// Latency returns the round-trip times of the heartbeats.
+func (cli *Client) Latency() Latency {
+	l := &cli.liveness
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.latency
+}
+
+// heartbeat sends a ping every HeartbeatInterval, and drops the connection when
+// MaxMissedPongs pings in a row are not answered within the PongTimeout.
+func (cli *Client) heartbeat() {
+	var deadline <-chan time.Time
+
+	for {
+		select {
+		case <-cli.ticker.C:
+			// The deadline can expire with the tick when the PongTimeout is the
+			// HeartbeatInterval
+			if deadline != nil {
+				deadline = nil
+				cli.expirePing()
+			}
+			if cli.sendPing() {
+				deadline = time.After(cli.options.PongTimeout)
+			}
+		case <-deadline:
+			deadline = nil
+			cli.expirePing()
+		case <-cli.closing:
+			return
+		}
+	}
+}
+
+// expirePing is called when the deadline of a ping expires, and drops the
+// connection when too many pings were missed.
+func (cli *Client) expirePing() {
+	if !cli.missedPong() {
+		return
+	}
+	cli.reportError(ErrHeartbeatTimeout)
+
+	// The reader fails on the closed transport, and reconnects
+	cli.mu.RLock()
+	transport := cli.transport
+	cli.mu.RUnlock()
+	transport.Close()
+}
+
+// sendPing sends a ping, and returns false if it could not be sent.
+func (cli *Client) sendPing() bool {
+	l := &cli.liveness
+	l.mu.Lock()
+	l.sentAt = time.Now()
+	l.mu.Unlock()
+
+	// Errors are detected by the reader, which reconnects
+	if err := cli.Send(NewPingMessage()); err != nil {
+		l.mu.Lock()
+		l.sentAt = time.Time{}
+		l.missed = 0
+		l.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// pong records the round-trip time of the pending ping.
+func (cli *Client) pong() {
+	l := &cli.liveness
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sentAt.IsZero() {
+		return
+	}
+	rtt := time.Since(l.sentAt)
+	l.sentAt = time.Time{}
+	l.missed = 0
+
+	s := &l.latency
+	s.Pongs++
+	l.total += rtt
+	s.Last = rtt
+	s.Average = l.total / time.Duration(s.Pongs)
+	if s.Min == 0 || rtt < s.Min {
+		s.Min = rtt
+	}
+	if rtt > s.Max {
+		s.Max = rtt
+	}
+}
+
+// missedPong records a ping that was not answered in time.  It returns true when
+// the connection must be dropped.
+func (cli *Client) missedPong() bool {
+	l := &cli.liveness
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sentAt.IsZero() {
+		return false
+	}
+	l.sentAt = time.Time{}
+	l.missed++
+	l.latency.Missed++
+
+	if cli.options.MaxMissedPongs < 0 || l.missed < cli.options.MaxMissedPongs {
+		return false
+	}
+	l.missed = 0
+	return true
+}
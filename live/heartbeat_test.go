@@ -0,0 +1,81 @@
+package live
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHeartbeat(t *testing.T) {
+
+	Convey("Given a server that does not answer pings", t, func() {
+		server := newTestServer("127.0.0.1:0")
+		addr := server.listener.Addr().String()
+
+		states := make(chan ConnectionState, 10)
+		options := ClientOptions{
+			MinBackoff:        10 * time.Millisecond,
+			HeartbeatInterval: 20 * time.Millisecond,
+			PongTimeout:       10 * time.Millisecond,
+			OnStateChange:     func(from, to ConnectionState) { states <- to },
+		}
+
+		var cli *Client
+		connect := func() {
+			var err error
+			cli, err = NewClient(TCPDialer(addr), options)
+			So(err, ShouldBeNil)
+			receiveHello(server)
+		}
+
+		Convey("Should reconnect after missing pongs", func() {
+			connect()
+			So(waitForState(states, StateReconnecting), ShouldBeTrue)
+			So(<-cli.Errors(), ShouldEqual, ErrHeartbeatTimeout)
+			So(cli.Latency().Missed, ShouldBeGreaterThanOrEqualTo, DefaultMaxMissedPongs)
+			So(waitForState(states, StateConnected), ShouldBeTrue)
+		})
+
+		Convey("Should count missed pongs when the check is disabled", func() {
+			options.MaxMissedPongs = -1
+			connect()
+			So(waitFor(func() bool { return cli.Latency().Missed >= 3 }), ShouldBeTrue)
+			So(cli.State(), ShouldEqual, StateConnected)
+		})
+
+		Reset(func() {
+			if cli != nil {
+				cli.Close()
+			}
+			server.stop()
+		})
+	})
+
+	Convey("Given a client connected to a live server", t, func() {
+		server := NewServer(ServerOptions{})
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		go server.Serve(l)
+
+		cli, err := NewClient(TCPDialer(l.Addr().String()), ClientOptions{ID: "agent", HeartbeatInterval: 10 * time.Millisecond})
+		So(err, ShouldBeNil)
+
+		Convey("Should measure the round-trip time of the pings", func() {
+			So(waitFor(func() bool { return cli.Latency().Pongs >= 3 }), ShouldBeTrue)
+
+			latency := cli.Latency()
+			So(latency.Min, ShouldBeGreaterThan, 0)
+			So(latency.Min, ShouldBeLessThanOrEqualTo, latency.Average)
+			So(latency.Average, ShouldBeLessThanOrEqualTo, latency.Max)
+			So(latency.Last, ShouldBeBetweenOrEqual, latency.Min, latency.Max)
+			So(cli.State(), ShouldEqual, StateConnected)
+		})
+
+		Reset(func() {
+			cli.Close()
+			server.Close()
+		})
+	})
+}
@@ -1,6 +1,69 @@
 package live
 
+// The versions of the live protocol.  Clients that do not send a version speak
+// version 1.
+const (
+	ProtocolVersion    = 1 // The version spoken by this package
+	MinProtocolVersion = 1 // The oldest version this package accepts
+)
+
+// The optional features of the live protocol.  A feature is only used when both
+// peers list it in their hello messages.
+const (
+	FeatureResume = "resume" // The server resumes after Hello.ResumeFrom, not implemented by Server
+	FeatureTopics = "topics" // The peers accept SUBSCRIBE, UNSUBSCRIBE and messages with topics
+)
+
 type Hello struct {
-	ClientID string `json:"client_id"`
-	Host     string `json:"host"`
+	ClientID   string   `json:"client_id"`
+	Host       string   `json:"host"`
+	ResumeFrom string   `json:"resume_from,omitempty"` // optional: The ID of the last message the client acknowledged
+	Version    int      `json:"version,omitempty"`     // The highest protocol version of the peer
+	Features   []string `json:"features,omitempty"`    // The features the peer supports
+	Token      string   `json:"token,omitempty"`       // optional: The bearer token of a TCP client
+}
+
+// EffectiveVersion returns the protocol version of the hello message.
+func (h Hello) EffectiveVersion() int {
+	if h.Version == 0 {
+		return 1
+	}
+	return h.Version
+}
+
+// HasFeature returns true if the feature is listed in the hello message.
+func (h Hello) HasFeature(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate returns the hello message a server replies to a client with.  The
+// version is the highest version both peers speak and the features are the
+// features both peers support.  A *ProtocolError is returned if the client
+// speaks a version older than MinProtocolVersion.
+func Negotiate(client, server Hello) (Hello, error) {
+	reply := server
+	reply.ResumeFrom = ""
+	reply.Features = nil
+	reply.Token = ""
+
+	reply.Version = server.EffectiveVersion()
+	if v := client.EffectiveVersion(); v < reply.Version {
+		reply.Version = v
+	}
+	if reply.Version < MinProtocolVersion {
+		return reply, protocolError("protocol version %d is not supported, the minimum is %d", reply.Version, MinProtocolVersion)
+	}
+
+	for _, f := range client.Features {
+		if server.HasFeature(f) && !reply.HasFeature(f) {
+			reply.Features = append(reply.Features, f)
+		}
+	}
+
+	return reply, nil
 }
@@ -0,0 +1,42 @@
+package live
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNegotiate(t *testing.T) {
+
+	Convey("Given a server hello", t, func() {
+		server := Hello{ClientID: "server", Host: "live", Version: 2, Features: []string{FeatureResume, "topics"}}
+
+		Convey("Should use the lowest version and the common features", func() {
+			reply, err := Negotiate(Hello{ClientID: "agent", Version: 1, Features: []string{"topics", "compression"}}, server)
+			So(err, ShouldBeNil)
+			So(reply.ClientID, ShouldEqual, "server")
+			So(reply.Version, ShouldEqual, 1)
+			So(reply.Features, ShouldResemble, []string{"topics"})
+		})
+
+		Convey("Should treat a client without a version as version 1", func() {
+			reply, err := Negotiate(Hello{ClientID: "agent", ResumeFrom: "42"}, server)
+			So(err, ShouldBeNil)
+			So(reply.Version, ShouldEqual, 1)
+			So(reply.Features, ShouldBeEmpty)
+			So(reply.ResumeFrom, ShouldEqual, "")
+		})
+
+		Convey("Should use the server version for newer clients", func() {
+			reply, err := Negotiate(Hello{Version: 3, Features: []string{FeatureResume, FeatureResume}}, server)
+			So(err, ShouldBeNil)
+			So(reply.Version, ShouldEqual, 2)
+			So(reply.Features, ShouldResemble, []string{FeatureResume})
+		})
+
+		Convey("Should reject versions older than the minimum", func() {
+			_, err := Negotiate(Hello{Version: -1}, server)
+			So(err, ShouldHaveSameTypeAs, &ProtocolError{})
+		})
+	})
+}
@@ -6,17 +6,20 @@ import (
 )
 
 const (
-	MessageTypeHello   = "HELLO"
-	MessageTypePing    = "PING"
-	MessageTypePong    = "PONG"
-	MessageTypeMessage = "MESSAGE"
-	MessageTypeGoodbye = "GOODBYE"
+	MessageTypeHello       = "HELLO"
+	MessageTypePing        = "PING"
+	MessageTypePong        = "PONG"
+	MessageTypeMessage     = "MESSAGE"
+	MessageTypeGoodbye     = "GOODBYE"
+	MessageTypeSubscribe   = "SUBSCRIBE"
+	MessageTypeUnsubscribe = "UNSUBSCRIBE"
 )
 
 type MessageType string
 
 type Message struct {
 	Type          MessageType
+	Topic         string // optional: The topic of a MESSAGE, see Client.Subscribe
 	ContentType   string
 	ContentLength int32
 	Content       []byte
@@ -57,13 +60,15 @@ func NewPongMessage() Message {
 }
 
 func NewHelloMessage(clientID, host string) Message {
-
-	msgContent := Hello{
+	return newHelloMessage(Hello{
 		ClientID: clientID,
 		Host:     host,
-	}
+	})
+}
 
-	buf, _ := json.Marshal(msgContent)
+func newHelloMessage(hello Hello) Message {
+
+	buf, _ := json.Marshal(hello)
 
 	return Message{
 		Type:          MessageTypeHello,
@@ -94,5 +99,9 @@ func (msg Message) ReadJSON(ptr interface{}) error {
 }
 
 func hasContent(messageType MessageType) bool {
-	return messageType == MessageTypeMessage || messageType == MessageTypeHello
+	switch messageType {
+	case MessageTypeMessage, MessageTypeHello, MessageTypeSubscribe, MessageTypeUnsubscribe:
+		return true
+	}
+	return false
 }
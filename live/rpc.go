@@ -0,0 +1,287 @@
+package live
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// DefaultRPCTimeout is the time Call waits for a response, unless the context has
+// a deadline or the RPC is configured otherwise.
+const DefaultRPCTimeout = 30 * time.Second
+
+// The codes of RPC errors, which are the codes of JSON-RPC.
+const (
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+)
+
+var (
+	// ErrRPCTimeout is returned by Call when no response arrived in time.
+	ErrRPCTimeout = errors.New("live: the call timed out")
+
+	// ErrRPCClosed is returned by Call when the RPC is closed.
+	ErrRPCClosed = errors.New("live: the rpc is closed")
+)
+
+// Peer is one end of a live connection.  Both Client and Conn are peers.
+type Peer interface {
+	Send(msg Message) error
+}
+
+// Method is the name of a method that can be called over a live connection.
+type Method string
+
+// RPCMessage is the JSON content of the messages of an RPC.  A request has a method,
+// and a response has the ID of the request as its correlation ID and either a result
+// or an error.
+type RPCMessage struct {
+	ID            string          `json:"id"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Method        Method          `json:"method,omitempty"`
+	Params        json.RawMessage `json:"params,omitempty"`
+	Result        json.RawMessage `json:"result,omitempty"`
+	Error         *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is the error of a response.  Handlers can return an *RPCError to reply
+// with a specific code, any other error is sent as an RPCInternalError.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("live: rpc error %d: %s", e.Code, e.Message)
+}
+
+// RPCHandler handles the requests of a method.  The result is sent to the caller
+// as JSON.  The context is cancelled when the RPC is closed.
+type RPCHandler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// RPC calls methods on the other end of a live connection, and handles the calls
+// it receives.  The application passes the messages it receives to Dispatch.
+type RPC struct {
+	nextID  uint64 // Accessed atomically, first for alignment
+	peer    Peer
+	timeout time.Duration
+	prefix  string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.RWMutex
+	handlers map[Method]RPCHandler
+	pending  map[string]chan RPCMessage
+	closed   bool
+}
+
+// NewRPC creates an RPC that sends its messages to the peer.
+func NewRPC(peer Peer) *RPC {
+	var buf [8]byte
+	rand.Read(buf[:])
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &RPC{
+		peer:     peer,
+		timeout:  DefaultRPCTimeout,
+		prefix:   hex.EncodeToString(buf[:]),
+		ctx:      ctx,
+		cancel:   cancel,
+		handlers: map[Method]RPCHandler{},
+		pending:  map[string]chan RPCMessage{},
+	}
+}
+
+// SetTimeout sets the time Call waits for a response when the context has no deadline.
+func (r *RPC) SetTimeout(timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeout = timeout
+}
+
+// Handle registers the handler of a method.  If Handle is called more than once
+// for a method, or if the handler is nil, it panics.
+func (r *RPC) Handle(method Method, handler RPCHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if handler == nil {
+		panic("live: rpc handler is nil")
+	}
+	if _, dup := r.handlers[method]; dup {
+		panic("live: rpc handler already registered for method " + string(method))
+	}
+	r.handlers[method] = handler
+}
+
+// Call calls the method on the other end of the connection and decodes the result
+// into result, unless it is nil.  It returns an *RPCError if the method failed.
+func (r *RPC) Call(ctx context.Context, method Method, params, result interface{}) error {
+	r.mu.RLock()
+	timeout := r.timeout
+	r.mu.RUnlock()
+
+	if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req := RPCMessage{ID: r.newID(), Method: method}
+	if params != nil {
+		buf, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = buf
+	}
+
+	responses := make(chan RPCMessage, 1)
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrRPCClosed
+	}
+	r.pending[req.ID] = responses
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, req.ID)
+		r.mu.Unlock()
+	}()
+
+	if err := r.send(req); err != nil {
+		return err
+	}
+
+	select {
+	case resp, ok := <-responses:
+		if !ok {
+			return ErrRPCClosed
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil && len(resp.Result) > 0 {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrRPCTimeout
+		}
+		return ctx.Err()
+	}
+}
+
+// Dispatch handles a message received from the peer.  It returns false if the
+// message is not an RPC message, in which case the application handles it.
+// Requests are handled in their own goroutine.
+func (r *RPC) Dispatch(msg Message) bool {
+	if msg.Type != MessageTypeMessage {
+		return false
+	}
+
+	var rpcMsg RPCMessage
+	if err := msg.ReadJSON(&rpcMsg); err != nil {
+		return false
+	}
+
+	switch {
+	case rpcMsg.ID == "":
+		return false
+	case rpcMsg.CorrelationID != "":
+		// Responses that arrive after the call timed out are dropped
+		r.mu.Lock()
+		if responses, ok := r.pending[rpcMsg.CorrelationID]; ok {
+			delete(r.pending, rpcMsg.CorrelationID)
+			responses <- rpcMsg
+		}
+		r.mu.Unlock()
+		return true
+	case rpcMsg.Method != "":
+		go withRecover(func() { r.serve(rpcMsg) })
+		return true
+	}
+
+	return false
+}
+
+// Close fails the pending calls and cancels the context of the running handlers.
+func (r *RPC) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+	r.closed = true
+	r.cancel()
+
+	for id, responses := range r.pending {
+		close(responses)
+		delete(r.pending, id)
+	}
+}
+
+func (r *RPC) serve(req RPCMessage) {
+	resp := RPCMessage{ID: r.newID(), CorrelationID: req.ID}
+
+	r.mu.RLock()
+	handler, ok := r.handlers[req.Method]
+	r.mu.RUnlock()
+
+	if !ok {
+		resp.Error = &RPCError{Code: RPCMethodNotFound, Message: fmt.Sprintf("method '%s' is not registered", req.Method)}
+	} else if result, err := handler(r.ctx, req.Params); err != nil {
+		resp.Error = toRPCError(err)
+	} else if result != nil {
+		if buf, err := json.Marshal(result); err != nil {
+			resp.Error = toRPCError(err)
+		} else {
+			resp.Result = buf
+		}
+	}
+
+	if err := r.send(resp); err != nil {
+		logrus.Warnf("live: could not reply to call of method '%s': %v", req.Method, err)
+	}
+}
+
+func (r *RPC) send(rpcMsg RPCMessage) error {
+	msg, err := NewJSONMessage(rpcMsg)
+	if err != nil {
+		return err
+	}
+	return r.peer.Send(msg)
+}
+
+func (r *RPC) newID() string {
+	return r.prefix + "-" + strconv.FormatUint(atomic.AddUint64(&r.nextID, 1), 10)
+}
+
+// InvalidParams returns an error for a handler to return when it cannot decode
+// its parameters.
+func InvalidParams(err error) error {
+	return &RPCError{Code: RPCInvalidParams, Message: err.Error()}
+}
+
+func toRPCError(err error) *RPCError {
+	if rpcErr, ok := err.(*RPCError); ok {
+		return rpcErr
+	}
+	return &RPCError{Code: RPCInternalError, Message: err.Error()}
+}
@@ -0,0 +1,106 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// pipePeer delivers the messages it sends to the RPC on the other end.
+type pipePeer struct {
+	other *RPC
+}
+
+func (p *pipePeer) Send(msg Message) error {
+	go p.other.Dispatch(msg)
+	return nil
+}
+
+func newRPCPair() (*RPC, *RPC) {
+	a, b := &pipePeer{}, &pipePeer{}
+	left, right := NewRPC(a), NewRPC(b)
+	a.other, b.other = right, left
+	return left, right
+}
+
+type echoParams struct {
+	Text string `json:"text"`
+}
+
+func TestRPC(t *testing.T) {
+
+	Convey("Given two connected RPCs", t, func() {
+		platform, agent := newRPCPair()
+
+		agent.Handle("Echo", func(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+			var params echoParams
+			if err := json.Unmarshal(raw, &params); err != nil {
+				return nil, InvalidParams(err)
+			}
+			return params, nil
+		})
+		agent.Handle("Fail", func(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+			return nil, errors.New("it failed")
+		})
+		agent.Handle("Wait", func(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+
+		Convey("Should return the result of the method", func() {
+			var result echoParams
+			err := platform.Call(context.Background(), "Echo", echoParams{Text: "hello"}, &result)
+			So(err, ShouldBeNil)
+			So(result.Text, ShouldEqual, "hello")
+		})
+
+		Convey("Should return the error of the method", func() {
+			err := platform.Call(context.Background(), "Fail", nil, nil)
+			So(err, ShouldResemble, &RPCError{Code: RPCInternalError, Message: "it failed"})
+
+			err = platform.Call(context.Background(), "Echo", "not an object", nil)
+			So(err.(*RPCError).Code, ShouldEqual, RPCInvalidParams)
+		})
+
+		Convey("Should return an error for unknown methods", func() {
+			err := platform.Call(context.Background(), "Unknown", nil, nil)
+			So(err, ShouldResemble, &RPCError{Code: RPCMethodNotFound, Message: "method 'Unknown' is not registered"})
+		})
+
+		Convey("Should time out when there is no response", func() {
+			platform.SetTimeout(20 * time.Millisecond)
+			So(platform.Call(context.Background(), "Wait", nil, nil), ShouldEqual, ErrRPCTimeout)
+		})
+
+		Convey("Should fail pending calls when it is closed", func() {
+			errs := make(chan error)
+			go func() { errs <- platform.Call(context.Background(), "Wait", nil, nil) }()
+
+			time.Sleep(20 * time.Millisecond)
+			platform.Close()
+			So(<-errs, ShouldEqual, ErrRPCClosed)
+			So(platform.Call(context.Background(), "Echo", nil, nil), ShouldEqual, ErrRPCClosed)
+		})
+
+		Convey("Should panic when a method is registered twice", func() {
+			So(func() {
+				agent.Handle("Echo", func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil })
+			}, ShouldPanic)
+		})
+
+		Convey("Should not dispatch other messages", func() {
+			So(agent.Dispatch(mustJSONMessage(t, map[string]string{"id": "1"})), ShouldBeFalse)
+			So(agent.Dispatch(mustJSONMessage(t, "text")), ShouldBeFalse)
+			So(agent.Dispatch(NewPingMessage()), ShouldBeFalse)
+		})
+
+		Reset(func() {
+			platform.Close()
+			agent.Close()
+		})
+	})
+}
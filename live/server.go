@@ -0,0 +1,565 @@
+package live
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultServerTimeout is the time a server waits for a message from a client
+// before closing the connection.  Clients ping every DefaultHeartbeatInterval.
+const DefaultServerTimeout = 3 * DefaultHeartbeatInterval
+
+var (
+	// ErrServerClosed is returned by Serve and ServeTransport after Close was called.
+	ErrServerClosed = errors.New("live: the server is closed")
+
+	// ErrUnknownClient is returned when sending to a client that is not connected.
+	ErrUnknownClient = errors.New("live: the client is not connected")
+
+	// ErrClientTimeout is passed to OnDisconnect when a client did not send anything
+	// within the timeout.
+	ErrClientTimeout = errors.New("live: the client timed out")
+
+	// ErrClientReplaced is passed to OnDisconnect when a client connected again with
+	// the same ID.
+	ErrClientReplaced = errors.New("live: the client connected again")
+
+	// ErrUnauthorized is returned by ServeTransport when Authenticate rejected the client.
+	ErrUnauthorized = errors.New("live: the client is not authorized")
+)
+
+// ServerOptions configure a server.
+type ServerOptions struct {
+	// optional: The hello message the server replies with.  The version defaults
+	// to ProtocolVersion, and FeatureTopics is always added to the features.  The
+	// server does not keep messages to resume from, so FeatureResume is removed.
+	Hello Hello
+
+	Timeout   time.Duration // optional: The time to wait for a message before closing a connection
+	Codec     Codec         // optional: The codec of TCP connections
+	TLSConfig *tls.Config   // optional: The TLS configuration of ListenAndServe
+
+	// optional: The content type of the binary frames of web socket clients, see
+	// NewWebSocketTransportWithContentType.
+	BinaryContentType string
+
+	// optional: Verifies the bearer token of a client before it is registered.  TCP
+	// clients send the token in their hello message, web socket clients send it in the
+	// Authorization header of the handshake.  The token is empty if the client did not
+	// send one.  Clients are rejected with ErrUnauthorized if an error is returned.
+	Authenticate func(hello Hello, token string) error
+
+	// optional: Called when a client has said hello, and when its connection ends.  The
+	// error is nil when the connection was closed with a goodbye message.  The hooks of
+	// a connection are called from its goroutine, one at a time.
+	OnConnect    func(conn *Conn)
+	OnDisconnect func(conn *Conn, err error)
+
+	// optional: Called for every message a client sends.
+	OnMessage func(conn *Conn, msg Message)
+}
+
+// Server accepts connections from live clients over TCP and web sockets.  A client
+// must say hello first, after which it is registered by its client ID.  The server
+// answers pings, and closes connections that are silent for longer than the timeout.
+type Server struct {
+	options  ServerOptions
+	upgrader websocket.Upgrader
+
+	mu        sync.RWMutex
+	conns     map[string]*Conn   // The registered connections by client ID
+	active    map[*Conn]struct{} // Every connection being served, including handshakes
+	listeners map[net.Listener]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a server.  Use Serve or ListenAndServe to accept TCP connections,
+// and use the server as an http.Handler to accept web socket connections.
+func NewServer(options ServerOptions) *Server {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultServerTimeout
+	}
+	if options.Hello.Version == 0 {
+		options.Hello.Version = ProtocolVersion
+	}
+	features := []string{}
+	for _, f := range options.Hello.Features {
+		if f != FeatureResume {
+			features = append(features, f)
+		}
+	}
+	if !options.Hello.HasFeature(FeatureTopics) {
+		features = append(features, FeatureTopics)
+	}
+	options.Hello.Features = features
+
+	return &Server{
+		options:   options,
+		conns:     map[string]*Conn{},
+		active:    map[*Conn]struct{}{},
+		listeners: map[net.Listener]struct{}{},
+	}
+}
+
+// ListenAndServe listens on the TCP address and accepts connections until the
+// server is closed.  The connections use TLS if the options have a TLS configuration.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.options.TLSConfig != nil {
+		l = tls.NewListener(l, s.options.TLSConfig)
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts TCP connections on the listener until the server is closed.  The
+// listener is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		go s.ServeTransport(NewTCPTransportWithCodec(conn, s.options.Codec))
+	}
+}
+
+// ServeHTTP upgrades the request to a web socket connection and serves it.  A
+// bearer token in the Authorization header is passed to Authenticate.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := ""
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	wsConn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with an error
+		return
+	}
+
+	s.serveTransport(NewWebSocketTransportWithContentType(wsConn, s.options.BinaryContentType), token)
+}
+
+// ServeTransport serves a connected transport until the connection ends, and returns
+// the error that ended it.  It can be used to serve transports the server does not
+// accept itself.  The token of the client is read from its hello message.
+func (s *Server) ServeTransport(transport Transport) error {
+	return s.serveTransport(transport, "")
+}
+
+// serveTransport serves a transport whose token was received before the hello message.
+func (s *Server) serveTransport(transport Transport, token string) error {
+	conn := &Conn{server: s, transport: transport, token: token}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		transport.Close()
+		return ErrServerClosed
+	}
+	s.active[conn] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.active, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	return conn.serve()
+}
+
+// Send writes a message to the client with the ID.
+func (s *Server) Send(id string, msg Message) error {
+	conn, ok := s.Client(id)
+	if !ok {
+		return ErrUnknownClient
+	}
+	return conn.Send(msg)
+}
+
+// Broadcast writes a message to every connected client and returns the number of
+// clients it was written to.  A client that cannot be written to is disconnected.
+func (s *Server) Broadcast(msg Message) int {
+	n := 0
+	for _, conn := range s.connections() {
+		if err := conn.Send(msg); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Publish writes a message with the topic to every client subscribed to a pattern
+// matching the topic, and returns the number of clients it was written to.
+func (s *Server) Publish(topic string, msg Message) int {
+	msg.Topic = topic
+
+	n := 0
+	for _, conn := range s.connections() {
+		if !conn.Subscribed(topic) {
+			continue
+		}
+		if err := conn.Send(msg); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Client returns the connection of the client with the ID.
+func (s *Server) Client(id string) (*Conn, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	conn, ok := s.conns[id]
+	return conn, ok
+}
+
+// Clients returns the IDs of the connected clients, sorted.
+func (s *Server) Clients() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.conns))
+	for id := range s.conns {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Close stops accepting connections, says goodbye to every registered client,
+// closes the connections that have not said hello yet and waits for the
+// connections to end.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+
+	registered := make(map[*Conn]bool, len(s.conns))
+	for _, conn := range s.conns {
+		registered[conn] = true
+	}
+	active := make([]*Conn, 0, len(s.active))
+	for conn := range s.active {
+		active = append(active, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range active {
+		conn.shutdown(registered[conn], ErrServerClosed)
+	}
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closed
+}
+
+func (s *Server) connections() []*Conn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	conns := make([]*Conn, 0, len(s.conns))
+	for _, conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// register adds the connection, replacing an earlier connection of the same client.
+func (s *Server) register(conn *Conn) bool {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	previous := s.conns[conn.ID()]
+	s.conns[conn.ID()] = conn
+	s.mu.Unlock()
+
+	if previous != nil {
+		previous.shutdown(true, ErrClientReplaced)
+	}
+	return true
+}
+
+func (s *Server) unregister(conn *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns[conn.ID()] == conn {
+		delete(s.conns, conn.ID())
+	}
+}
+
+// Conn is the connection of a client to a server.
+type Conn struct {
+	server    *Server
+	transport Transport
+	hello     Hello
+	protocol  Hello
+	token     string // The token of the handshake, cleared after the hello message
+	timer     *time.Timer
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	err       error // The reason the connection was closed
+
+	subMu         sync.RWMutex
+	subscriptions map[string]struct{} // The subscribed patterns
+}
+
+// ID returns the client ID of the hello message.
+func (c *Conn) ID() string {
+	return c.hello.ClientID
+}
+
+// Hello returns the hello message of the client.
+func (c *Conn) Hello() Hello {
+	return c.hello
+}
+
+// Protocol returns the hello message the server replied with, which holds the
+// negotiated protocol version and features.
+func (c *Conn) Protocol() Hello {
+	return c.protocol
+}
+
+// Transport returns the name of the transport of the connection.
+func (c *Conn) Transport() string {
+	return c.transport.Name()
+}
+
+// Subscriptions returns the patterns the client is subscribed to, sorted.
+func (c *Conn) Subscriptions() []string {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+
+	patterns := make([]string, 0, len(c.subscriptions))
+	for pattern := range c.subscriptions {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// Subscribed returns true if the client is subscribed to a pattern matching the topic.
+func (c *Conn) Subscribed(topic string) bool {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+
+	for pattern := range c.subscriptions {
+		if MatchTopic(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribe handles a SUBSCRIBE or UNSUBSCRIBE message.
+func (c *Conn) subscribe(msg Message) error {
+	patterns, err := readPatterns(msg)
+	if err != nil {
+		return protocolError("invalid %s message: %v", msg.Type, err)
+	}
+
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	if c.subscriptions == nil {
+		c.subscriptions = map[string]struct{}{}
+	}
+	for _, pattern := range patterns {
+		if msg.Type == MessageTypeSubscribe {
+			c.subscriptions[pattern] = struct{}{}
+		} else {
+			delete(c.subscriptions, pattern)
+		}
+	}
+	return nil
+}
+
+// Send writes a message to the client.  The connection is closed if the message
+// cannot be written.
+func (c *Conn) Send(msg Message) error {
+	c.writeMu.Lock()
+	err := c.transport.WriteMessage(msg)
+	c.writeMu.Unlock()
+
+	if err != nil {
+		c.shutdown(false, err)
+	}
+	return err
+}
+
+// Close says goodbye to the client and closes the connection.
+func (c *Conn) Close() error {
+	c.shutdown(true, nil)
+	return nil
+}
+
+func (c *Conn) shutdown(goodbye bool, err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		if goodbye {
+			c.writeMu.Lock()
+			c.transport.WriteMessage(NewGoodbyeMessage())
+			c.writeMu.Unlock()
+		}
+		c.transport.Close()
+	})
+}
+
+func (c *Conn) serve() error {
+	s := c.server
+
+	c.timer = time.AfterFunc(s.options.Timeout, func() { c.shutdown(false, ErrClientTimeout) })
+	defer c.timer.Stop()
+
+	if err := c.handshake(); err != nil {
+		c.shutdown(false, err)
+		return c.err
+	}
+
+	if !s.register(c) {
+		c.shutdown(true, ErrServerClosed)
+		return c.err
+	}
+
+	if s.options.OnConnect != nil {
+		s.options.OnConnect(c)
+	}
+
+loop:
+	for {
+		msg, err := c.transport.ReadMessage()
+		if err != nil {
+			c.shutdown(false, err)
+			break
+		}
+		c.timer.Reset(s.options.Timeout)
+
+		switch msg.Type {
+		case MessageTypePing:
+			c.Send(NewPongMessage())
+		case MessageTypePong, MessageTypeHello:
+			// Only keeps the connection alive
+		case MessageTypeSubscribe, MessageTypeUnsubscribe:
+			if err := c.subscribe(msg); err != nil {
+				c.shutdown(false, err)
+				break loop
+			}
+		case MessageTypeGoodbye:
+			c.shutdown(false, nil)
+			break loop
+		default:
+			if s.options.OnMessage != nil {
+				s.options.OnMessage(c, msg)
+			}
+		}
+	}
+
+	s.unregister(c)
+	if s.options.OnDisconnect != nil {
+		s.options.OnDisconnect(c, c.err)
+	}
+
+	logrus.Debugf("live: client '%s' disconnected: %v", c.ID(), c.err)
+	return c.err
+}
+
+// handshake reads the hello message of the client and replies with the negotiated
+// protocol.  Web socket transports cannot tell a hello message from other JSON
+// messages, so the first message is always read as the hello message, and the
+// server does not reply to web socket clients, which use protocol version 1.
+func (c *Conn) handshake() error {
+	msg, err := c.transport.ReadMessage()
+	if err != nil {
+		return err
+	}
+
+	isWebSocket := c.transport.Name() == "ws"
+	if msg.Type != MessageTypeHello && !(isWebSocket && msg.Type == MessageTypeMessage) {
+		return protocolError("expected a hello message, received %s", msg.Type)
+	}
+
+	if err := msg.ReadJSON(&c.hello); err != nil {
+		return protocolError("invalid hello message: %v", err)
+	}
+	if c.hello.ClientID == "" {
+		return protocolError("the hello message has no client ID")
+	}
+
+	// The token is not kept, so it cannot leak through Hello
+	token := c.token
+	if token == "" {
+		token = c.hello.Token
+	}
+	c.token, c.hello.Token = "", ""
+
+	if auth := c.server.options.Authenticate; auth != nil {
+		if err := auth(c.hello, token); err != nil {
+			logrus.Debugf("live: client '%s' is not authorized: %v", c.hello.ClientID, err)
+			return ErrUnauthorized
+		}
+	}
+
+	c.protocol, err = Negotiate(c.hello, c.server.options.Hello)
+	if err != nil {
+		return err
+	}
+
+	if isWebSocket {
+		c.protocol = Hello{ClientID: c.protocol.ClientID, Host: c.protocol.Host, Version: 1}
+		return nil
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.transport.WriteMessage(newHelloMessage(c.protocol))
+}
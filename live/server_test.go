@@ -0,0 +1,278 @@
+package live
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// serverEvents records the hooks of a server.
+type serverEvents struct {
+	connected    chan *Conn
+	disconnected chan error
+	messages     chan Message
+}
+
+func newServerEvents() (*serverEvents, ServerOptions) {
+	e := &serverEvents{
+		connected:    make(chan *Conn, 10),
+		disconnected: make(chan error, 10),
+		messages:     make(chan Message, 10),
+	}
+
+	return e, ServerOptions{
+		Hello:        Hello{ClientID: "server", Features: []string{FeatureResume}},
+		OnConnect:    func(conn *Conn) { e.connected <- conn },
+		OnDisconnect: func(conn *Conn, err error) { e.disconnected <- err },
+		OnMessage:    func(conn *Conn, msg Message) { e.messages <- msg },
+	}
+}
+
+func receiveConn(conns chan *Conn) *Conn {
+	select {
+	case conn := <-conns:
+		return conn
+	case <-time.After(2 * time.Second):
+		return nil
+	}
+}
+
+func receiveErr(errs chan error) error {
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(2 * time.Second):
+		return nil
+	}
+}
+
+func receiveMessage(msgs <-chan Message) Message {
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(2 * time.Second):
+		return Message{}
+	}
+}
+
+// dialServer connects a transport to the server and says hello.
+func dialServer(addr, id string) Transport {
+	conn, err := net.Dial("tcp", addr)
+	So(err, ShouldBeNil)
+
+	t := NewTCPTransport(conn)
+	So(t.WriteMessage(newHelloMessage(Hello{ClientID: id, Version: ProtocolVersion})), ShouldBeNil)
+	return t
+}
+
+func TestServer(t *testing.T) {
+
+	Convey("Given a TCP server", t, func() {
+		events, options := newServerEvents()
+		options.Timeout = 200 * time.Millisecond
+		server := NewServer(options)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		addr := l.Addr().String()
+		go server.Serve(l)
+
+		Convey("When a client connects", func() {
+			cli, err := NewClient(TCPDialer(addr), ClientOptions{
+				ID:                "agent-1",
+				Host:              "test",
+				Resume:            true,
+				HeartbeatInterval: 50 * time.Millisecond,
+			})
+			So(err, ShouldBeNil)
+			defer cli.Close()
+
+			conn := receiveConn(events.connected)
+			So(conn, ShouldNotBeNil)
+
+			Convey("Should register the client by its ID", func() {
+				So(conn.ID(), ShouldEqual, "agent-1")
+				So(conn.Hello().Host, ShouldEqual, "test")
+				So(conn.Transport(), ShouldEqual, "tcp")
+				So(server.Clients(), ShouldResemble, []string{"agent-1"})
+			})
+
+			Convey("Should negotiate the protocol without resume", func() {
+				So(conn.Protocol().Version, ShouldEqual, ProtocolVersion)
+				So(conn.Protocol().Features, ShouldResemble, []string{FeatureTopics})
+
+				So(server.Send("agent-1", mustJSONMessage(t, "first")), ShouldBeNil)
+				receiveMessage(cli.Incoming())
+				So(cli.Protocol().ClientID, ShouldEqual, "server")
+				So(cli.Protocol().HasFeature(FeatureResume), ShouldBeFalse)
+			})
+
+			Convey("Should send messages to the client", func() {
+				So(server.Send("agent-1", mustJSONMessage(t, "direct")), ShouldBeNil)
+				So(string(receiveMessage(cli.Incoming()).Content), ShouldEqual, `"direct"`)
+
+				So(server.Broadcast(mustJSONMessage(t, "everyone")), ShouldEqual, 1)
+				So(string(receiveMessage(cli.Incoming()).Content), ShouldEqual, `"everyone"`)
+			})
+
+			Convey("Should receive messages from the client", func() {
+				So(cli.Send(mustJSONMessage(t, "up")), ShouldBeNil)
+				So(string(receiveMessage(events.messages).Content), ShouldEqual, `"up"`)
+			})
+
+			Convey("Should keep the client connected while it pings", func() {
+				time.Sleep(3 * options.Timeout)
+				So(server.Clients(), ShouldResemble, []string{"agent-1"})
+			})
+
+			Convey("Should unregister the client when it says goodbye", func() {
+				cli.Close()
+				So(receiveErr(events.disconnected), ShouldBeNil)
+				So(server.Clients(), ShouldBeEmpty)
+				So(server.Send("agent-1", NewPingMessage()), ShouldEqual, ErrUnknownClient)
+			})
+		})
+
+		Convey("When a client connects with a bare transport", func() {
+			transport := dialServer(addr, "agent-2")
+			defer transport.Close()
+
+			msg, err := transport.ReadMessage()
+			So(err, ShouldBeNil)
+			So(msg.Type, ShouldEqual, MessageTypeHello)
+			receiveConn(events.connected)
+
+			Convey("Should answer pings with pongs", func() {
+				So(transport.WriteMessage(NewPingMessage()), ShouldBeNil)
+				msg, err := transport.ReadMessage()
+				So(err, ShouldBeNil)
+				So(msg.Type, ShouldEqual, MessageTypePong)
+			})
+
+			Convey("Should disconnect the client when it is silent", func() {
+				So(receiveErr(events.disconnected), ShouldEqual, ErrClientTimeout)
+				So(server.Clients(), ShouldBeEmpty)
+			})
+
+			Convey("Should replace the connection when the client connects again", func() {
+				again := dialServer(addr, "agent-2")
+				defer again.Close()
+				So(receiveConn(events.connected), ShouldNotBeNil)
+				So(receiveErr(events.disconnected), ShouldEqual, ErrClientReplaced)
+				So(server.Clients(), ShouldResemble, []string{"agent-2"})
+			})
+
+			Convey("Should say goodbye when the server is closed", func() {
+				So(server.Close(), ShouldBeNil)
+
+				msg, err := transport.ReadMessage()
+				So(err, ShouldBeNil)
+				So(msg.Type, ShouldEqual, MessageTypeGoodbye)
+				So(receiveErr(events.disconnected), ShouldEqual, ErrServerClosed)
+			})
+		})
+
+		Convey("Should close connections that do not start with a hello message", func() {
+			conn, err := net.Dial("tcp", addr)
+			So(err, ShouldBeNil)
+			transport := NewTCPTransport(conn)
+			defer transport.Close()
+
+			So(transport.WriteMessage(mustJSONMessage(t, "no hello")), ShouldBeNil)
+			_, err = transport.ReadMessage()
+			So(err, ShouldNotBeNil)
+			So(server.Clients(), ShouldBeEmpty)
+		})
+
+		Reset(func() {
+			server.Close()
+		})
+	})
+
+	Convey("Given a server with a connection that has not said hello", t, func() {
+		_, options := newServerEvents()
+		options.Timeout = time.Minute
+		server := NewServer(options)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		go server.Serve(l)
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		So(err, ShouldBeNil)
+		transport := NewTCPTransport(conn)
+		defer transport.Close()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for server.handshakes() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		So(server.handshakes(), ShouldEqual, 1)
+
+		Convey("Should close the connection without waiting for the timeout", func() {
+			start := time.Now()
+			So(server.Close(), ShouldBeNil)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+
+			_, err := transport.ReadMessage()
+			So(err, ShouldNotBeNil)
+			So(server.handshakes(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a web socket server", t, func() {
+		events, options := newServerEvents()
+		server := NewServer(options)
+		httpServer := httptest.NewServer(server)
+		addr := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+		cli, err := NewClient(WebSocketDialer(addr), ClientOptions{ID: "browser", Host: "test"})
+		So(err, ShouldBeNil)
+
+		conn := receiveConn(events.connected)
+		So(conn, ShouldNotBeNil)
+
+		Convey("Should register the client using protocol version 1", func() {
+			So(conn.ID(), ShouldEqual, "browser")
+			So(conn.Transport(), ShouldEqual, "ws")
+			So(conn.Protocol().Version, ShouldEqual, 1)
+			So(conn.Protocol().Features, ShouldBeEmpty)
+		})
+
+		Convey("Should refuse topics, which web sockets cannot carry", func() {
+			_, err := cli.Subscribe("publishers.#", func(Message) {})
+			So(err, ShouldEqual, ErrTopicsNotSupported)
+			So(cli.Publish("agents.browser", mustJSONMessage(t, "up")), ShouldEqual, ErrTopicsNotSupported)
+
+			cli.topicsMu.RLock()
+			defer cli.topicsMu.RUnlock()
+			So(cli.patterns, ShouldBeEmpty)
+		})
+
+		Convey("Should exchange messages", func() {
+			So(server.Send("browser", mustJSONMessage(t, "down")), ShouldBeNil)
+			So(string(receiveMessage(cli.Incoming()).Content), ShouldEqual, `"down"`)
+
+			So(cli.Send(mustJSONMessage(t, "up")), ShouldBeNil)
+			So(string(receiveMessage(events.messages).Content), ShouldEqual, `"up"`)
+		})
+
+		Reset(func() {
+			cli.Close()
+			server.Close()
+			httpServer.Close()
+		})
+	})
+}
+
+// handshakes returns the number of connections that are being served but have not
+// been registered.
+func (s *Server) handshakes() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.active) - len(s.conns)
+}
@@ -0,0 +1,60 @@
+package live
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSOptions configure the TLS connections of a client.
+type TLSOptions struct {
+	CAFile     string // optional: The PEM file of the CAs that sign the server certificate, the system CAs if empty
+	CertFile   string // optional: The PEM file of the client certificate
+	KeyFile    string // optional: The PEM file of the key of the client certificate
+	ServerName string // optional: The name the server certificate is verified against, the host of the address if empty
+
+	// optional: Do not verify the server certificate.  Only use this for testing.
+	InsecureSkipVerify bool
+}
+
+// ClientConfig returns the TLS configuration of the options.
+func (o TLSOptions) ClientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("live: could not read the CA file: %v", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("live: the CA file '%s' contains no certificates", o.CAFile)
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("live: could not load the client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// TLSDialer returns a dialer for a TCP server that uses TLS.
+func TLSDialer(addr string, config *tls.Config) Dialer {
+	return func() (Transport, error) {
+		conn, err := tls.Dial("tcp", addr, config)
+		if err != nil {
+			return nil, err
+		}
+		return NewTCPTransport(conn), nil
+	}
+}
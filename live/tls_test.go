@@ -0,0 +1,202 @@
+package live
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeCertificate writes a self signed certificate and its key to PEM files in
+// the directory, and returns their paths.
+func writeCertificate(dir, name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	So(err, ShouldBeNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	So(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600), ShouldBeNil)
+	return certFile, keyFile
+}
+
+func TestTLSOptions(t *testing.T) {
+
+	Convey("Given certificate files", t, func() {
+		dir, err := ioutil.TempDir("", "live-tls")
+		So(err, ShouldBeNil)
+		certFile, keyFile := writeCertificate(dir, "agent")
+
+		Convey("Should load the CAs and the client certificate", func() {
+			config, err := TLSOptions{CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "live"}.ClientConfig()
+			So(err, ShouldBeNil)
+			So(config.RootCAs, ShouldNotBeNil)
+			So(config.Certificates, ShouldHaveLength, 1)
+			So(config.ServerName, ShouldEqual, "live")
+		})
+
+		Convey("Should fail for files that are not certificates", func() {
+			_, err := TLSOptions{CAFile: keyFile}.ClientConfig()
+			So(err, ShouldNotBeNil)
+
+			_, err = TLSOptions{CertFile: certFile}.ClientConfig()
+			So(err, ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+	})
+}
+
+func TestServerTLS(t *testing.T) {
+
+	Convey("Given a server that requires TLS client certificates and a token", t, func() {
+		dir, err := ioutil.TempDir("", "live-tls")
+		So(err, ShouldBeNil)
+		serverCert, serverKey := writeCertificate(dir, "live")
+		clientCert, clientKey := writeCertificate(dir, "agent")
+
+		cert, err := tls.LoadX509KeyPair(serverCert, serverKey)
+		So(err, ShouldBeNil)
+		clientCAs, err := TLSOptions{CAFile: clientCert}.ClientConfig()
+		So(err, ShouldBeNil)
+
+		tokens := make(chan string, 10)
+		events, options := newServerEvents()
+		options.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    clientCAs.RootCAs,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		}
+		options.Authenticate = func(hello Hello, token string) error {
+			tokens <- token
+			if token != "secret" {
+				return errors.New("invalid token")
+			}
+			return nil
+		}
+		server := NewServer(options)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		addr := l.Addr().String()
+		l.Close()
+		go server.ListenAndServe(addr)
+		time.Sleep(20 * time.Millisecond)
+
+		config, err := TLSOptions{CAFile: serverCert, CertFile: clientCert, KeyFile: clientKey, ServerName: "live"}.ClientConfig()
+		So(err, ShouldBeNil)
+
+		Convey("Should accept a client with a valid token", func() {
+			cli, err := NewClient(TLSDialer(addr, config), ClientOptions{ID: "agent", Token: "secret"})
+			So(err, ShouldBeNil)
+			defer cli.Close()
+
+			conn := receiveConn(events.connected)
+			So(conn, ShouldNotBeNil)
+			So(conn.Hello().Token, ShouldEqual, "")
+			So(<-tokens, ShouldEqual, "secret")
+
+			So(server.Send("agent", mustJSONMessage(t, "secure")), ShouldBeNil)
+			So(string(receiveMessage(cli.Incoming()).Content), ShouldEqual, `"secure"`)
+		})
+
+		Convey("Should reject a client with an invalid token", func() {
+			transport, err := TLSDialer(addr, config)()
+			So(err, ShouldBeNil)
+			defer transport.Close()
+
+			So(transport.WriteMessage(newHelloMessage(Hello{ClientID: "agent", Token: "guess"})), ShouldBeNil)
+			_, err = transport.ReadMessage()
+			So(err, ShouldNotBeNil)
+			So(server.Clients(), ShouldBeEmpty)
+		})
+
+		Convey("Should reject a client without a certificate", func() {
+			transport, err := TLSDialer(addr, &tls.Config{RootCAs: config.RootCAs, ServerName: "live"})()
+			if err == nil {
+				// TLS 1.3 reports the missing certificate on the first read
+				_, err = transport.ReadMessage()
+				transport.Close()
+			}
+			So(err, ShouldNotBeNil)
+			So(server.Clients(), ShouldBeEmpty)
+		})
+
+		Reset(func() {
+			server.Close()
+			os.RemoveAll(dir)
+		})
+	})
+
+	Convey("Given a web socket server that requires a token", t, func() {
+		events, options := newServerEvents()
+		options.Authenticate = func(hello Hello, token string) error {
+			if token != "secret" {
+				return errors.New("invalid token")
+			}
+			return nil
+		}
+		server := NewServer(options)
+		httpServer := httptest.NewServer(server)
+		addr := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+		Convey("Should accept a client that sends the token in the handshake", func() {
+			cli, err := NewClient(WebSocketDialerWithOptions(addr, WebSocketOptions{Token: "secret"}), ClientOptions{ID: "browser"})
+			So(err, ShouldBeNil)
+			defer cli.Close()
+
+			So(receiveConn(events.connected), ShouldNotBeNil)
+		})
+
+		Convey("Should reject a client without a token", func() {
+			transport, err := WebSocketDialer(addr)()
+			So(err, ShouldBeNil)
+			defer transport.Close()
+
+			So(transport.WriteMessage(newHelloMessage(Hello{ClientID: "browser"})), ShouldBeNil)
+			msg, err := transport.ReadMessage()
+			So(err != nil || msg.Type == MessageTypeGoodbye, ShouldBeTrue)
+			So(server.Clients(), ShouldBeEmpty)
+		})
+
+		Reset(func() {
+			server.Close()
+			httpServer.Close()
+		})
+	})
+}
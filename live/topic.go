@@ -0,0 +1,116 @@
+package live
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTopicsNotSupported is returned when a topic is sent over a web socket transport,
+// which cannot carry topics, or to a server that did not negotiate FeatureTopics.
+var ErrTopicsNotSupported = errors.New("live: the transport does not support topics")
+
+// Topics are made of segments separated by dots, such as "publishers.csv.status".
+// In a pattern, SingleWildcard matches exactly one segment and MultiWildcard, which
+// must be the last segment, matches zero or more segments.
+const (
+	SingleWildcard = "*"
+	MultiWildcard  = "#"
+)
+
+// subscription is the content of SUBSCRIBE and UNSUBSCRIBE messages.
+type subscription struct {
+	Patterns []string `json:"patterns"`
+}
+
+// ValidateTopic checks that a topic has no empty segments and no wildcards.
+func ValidateTopic(topic string) error {
+	for _, segment := range strings.Split(topic, ".") {
+		switch segment {
+		case "":
+			return fmt.Errorf("live: topic '%s' has an empty segment", topic)
+		case SingleWildcard, MultiWildcard:
+			return fmt.Errorf("live: topic '%s' contains a wildcard", topic)
+		}
+	}
+	return nil
+}
+
+// ValidatePattern checks that a pattern has no empty segments, and that a
+// MultiWildcard is only used as the last segment.
+func ValidatePattern(pattern string) error {
+	segments := strings.Split(pattern, ".")
+	for i, segment := range segments {
+		switch {
+		case segment == "":
+			return fmt.Errorf("live: pattern '%s' has an empty segment", pattern)
+		case segment == MultiWildcard && i != len(segments)-1:
+			return fmt.Errorf("live: pattern '%s' has '%s' before its last segment", pattern, MultiWildcard)
+		}
+	}
+	return nil
+}
+
+// MatchTopic returns true if the topic matches the pattern.
+func MatchTopic(pattern, topic string) bool {
+	patterns := strings.Split(pattern, ".")
+	topics := strings.Split(topic, ".")
+
+	for i, p := range patterns {
+		if p == MultiWildcard {
+			return true
+		}
+		if i >= len(topics) {
+			return false
+		}
+		if p != SingleWildcard && p != topics[i] {
+			return false
+		}
+	}
+
+	return len(patterns) == len(topics)
+}
+
+// NewTopicMessage creates a JSON message with a topic.
+func NewTopicMessage(topic string, data interface{}) (Message, error) {
+	msg, err := NewJSONMessage(data)
+	msg.Topic = topic
+	return msg, err
+}
+
+// NewSubscribeMessage creates a message that subscribes to the topics matching the patterns.
+func NewSubscribeMessage(patterns ...string) Message {
+	return newSubscriptionMessage(MessageTypeSubscribe, patterns)
+}
+
+// NewUnsubscribeMessage creates a message that unsubscribes from the patterns.
+func NewUnsubscribeMessage(patterns ...string) Message {
+	return newSubscriptionMessage(MessageTypeUnsubscribe, patterns)
+}
+
+func newSubscriptionMessage(t MessageType, patterns []string) Message {
+	buf, _ := json.Marshal(subscription{Patterns: patterns})
+
+	return Message{
+		Type:          t,
+		ContentType:   "application/json",
+		ContentLength: int32(len(buf)),
+		Content:       buf,
+	}
+}
+
+// readPatterns returns the valid patterns of a SUBSCRIBE or UNSUBSCRIBE message.
+func readPatterns(msg Message) ([]string, error) {
+	var sub subscription
+	if err := msg.ReadJSON(&sub); err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range sub.Patterns {
+		if err := ValidatePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return sub.Patterns, nil
+}
@@ -0,0 +1,210 @@
+package live
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMatchTopic(t *testing.T) {
+
+	Convey("Given topic patterns", t, func() {
+		testCases := []struct {
+			pattern string
+			topic   string
+			match   bool
+		}{
+			{"publishers.csv", "publishers.csv", true},
+			{"publishers.csv", "publishers.sql", false},
+			{"publishers.*", "publishers.csv", true},
+			{"publishers.*", "publishers", false},
+			{"publishers.*", "publishers.csv.status", false},
+			{"*.csv.*", "publishers.csv.status", true},
+			{"publishers.#", "publishers", true},
+			{"publishers.#", "publishers.csv.status", true},
+			{"publishers.#", "subscribers.sql", false},
+			{"#", "anything.at.all", true},
+		}
+
+		Convey("Should match the topics", func() {
+			for _, tc := range testCases {
+				So(MatchTopic(tc.pattern, tc.topic), ShouldEqual, tc.match)
+			}
+		})
+
+		Convey("Should validate patterns and topics", func() {
+			So(ValidatePattern("publishers.*.#"), ShouldBeNil)
+			So(ValidatePattern("publishers.#.status"), ShouldNotBeNil)
+			So(ValidatePattern("publishers..csv"), ShouldNotBeNil)
+			So(ValidateTopic("publishers.csv"), ShouldBeNil)
+			So(ValidateTopic("publishers.*"), ShouldNotBeNil)
+			So(ValidateTopic(""), ShouldNotBeNil)
+		})
+	})
+}
+
+func waitNegotiated(cli *Client) bool {
+	select {
+	case <-cli.Negotiated():
+		return true
+	case <-time.After(2 * time.Second):
+		return false
+	}
+}
+
+func waitFor(condition func() bool) bool {
+	timeout := time.After(2 * time.Second)
+	for !condition() {
+		select {
+		case <-timeout:
+			return false
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+func TestTopics(t *testing.T) {
+
+	Convey("Given a client connected to a server", t, func() {
+		events, options := newServerEvents()
+		server := NewServer(options)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		addr := l.Addr().String()
+		go server.Serve(l)
+
+		cli, err := NewClient(TCPDialer(addr), ClientOptions{ID: "agent", MinBackoff: 10 * time.Millisecond})
+		So(err, ShouldBeNil)
+		conn := receiveConn(events.connected)
+		So(conn != nil, ShouldBeTrue)
+		So(waitNegotiated(cli), ShouldBeTrue)
+
+		status := make(chan Message, 10)
+		all := make(chan Message, 10)
+		statusSub, err := cli.Subscribe("publishers.*.status", func(msg Message) { status <- msg })
+		So(err, ShouldBeNil)
+		_, err = cli.Subscribe("publishers.#", func(msg Message) { all <- msg })
+		So(err, ShouldBeNil)
+
+		So(waitFor(func() bool { return len(conn.Subscriptions()) == 2 }), ShouldBeTrue)
+
+		Convey("Should register the patterns on the server", func() {
+			So(conn.Subscriptions(), ShouldResemble, []string{"publishers.#", "publishers.*.status"})
+		})
+
+		Convey("Should pass the messages of a topic to every matching handler", func() {
+			msg, _ := NewJSONMessage("running")
+			So(server.Publish("publishers.csv.status", msg), ShouldEqual, 1)
+
+			received := receiveMessage(status)
+			So(received.Topic, ShouldEqual, "publishers.csv.status")
+			So(string(received.Content), ShouldEqual, `"running"`)
+			So(receiveMessage(all).Topic, ShouldEqual, "publishers.csv.status")
+		})
+
+		Convey("Should not send topics the client did not subscribe to", func() {
+			msg, _ := NewJSONMessage("ignored")
+			So(server.Publish("subscribers.sql", msg), ShouldEqual, 0)
+		})
+
+		Convey("Should send topics without a handler to Incoming", func() {
+			msg, _ := NewTopicMessage("subscribers.sql", "direct")
+			So(conn.Send(msg), ShouldBeNil)
+			So(receiveMessage(cli.Incoming()).Topic, ShouldEqual, "subscribers.sql")
+		})
+
+		Convey("Should publish messages with a topic to the server", func() {
+			msg, _ := NewJSONMessage("up")
+			So(cli.Publish("agents.agent.status", msg), ShouldBeNil)
+			So(receiveMessage(events.messages).Topic, ShouldEqual, "agents.agent.status")
+
+			So(cli.Publish("agents.*", msg), ShouldNotBeNil)
+		})
+
+		Convey("Should unsubscribe when the last handler of a pattern is removed", func() {
+			So(statusSub.Unsubscribe(), ShouldBeNil)
+			So(waitFor(func() bool { return len(conn.Subscriptions()) == 1 }), ShouldBeTrue)
+			So(conn.Subscriptions(), ShouldResemble, []string{"publishers.#"})
+		})
+
+		Convey("Should subscribe again when the client reconnects", func() {
+			conn.Close()
+			conn = receiveConn(events.connected)
+			So(conn != nil, ShouldBeTrue)
+			So(waitFor(func() bool { return len(conn.Subscriptions()) == 2 }), ShouldBeTrue)
+		})
+
+		Reset(func() {
+			cli.Close()
+			server.Close()
+		})
+	})
+}
+
+// failingTransport replies to the hello message of the client, and fails to write
+// subscriptions.
+type failingTransport struct {
+	hello  chan Message
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newFailingTransport() *failingTransport {
+	t := &failingTransport{hello: make(chan Message, 1), closed: make(chan struct{})}
+	t.hello <- newHelloMessage(Hello{Version: ProtocolVersion, Features: []string{FeatureTopics}})
+	return t
+}
+
+func (t *failingTransport) Name() string { return "test" }
+
+func (t *failingTransport) WriteMessage(msg Message) error {
+	if msg.Type == MessageTypeSubscribe {
+		return errors.New("write failed")
+	}
+	return nil
+}
+
+func (t *failingTransport) ReadMessage() (Message, error) {
+	select {
+	case msg := <-t.hello:
+		return msg, nil
+	case <-t.closed:
+		return Message{}, errors.New("closed")
+	}
+}
+
+func (t *failingTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+func TestSubscribeRollback(t *testing.T) {
+
+	Convey("Given a client whose subscriptions cannot be sent", t, func() {
+		transport := newFailingTransport()
+		cli, err := newClient(transport, nil, ClientOptions{ID: "agent"})
+		So(err, ShouldBeNil)
+		So(waitNegotiated(cli), ShouldBeTrue)
+
+		Convey("Should remove the handler when the subscription fails", func() {
+			sub, err := cli.Subscribe("publishers.#", func(Message) {})
+			So(err, ShouldNotBeNil)
+			So(sub == nil, ShouldBeTrue)
+
+			cli.topicsMu.RLock()
+			defer cli.topicsMu.RUnlock()
+			So(cli.handlers, ShouldBeEmpty)
+			So(cli.patterns, ShouldBeEmpty)
+		})
+
+		Reset(func() {
+			cli.Close()
+		})
+	})
+}
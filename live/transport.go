@@ -1,5 +1,10 @@
 package live
 
+import "errors"
+
+// ErrTransportClosed is returned when reading from a transport that was closed.
+var ErrTransportClosed = errors.New("live: the transport is closed")
+
 type Transport interface {
 
 	// Name should return the name of the transport
@@ -1,6 +1,9 @@
 package live
 
 import (
+	"fmt"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -8,15 +11,37 @@ import (
 )
 
 type wsTransport struct {
-	wsConn   *websocket.Conn
-	incoming chan *Message
+	wsConn            *websocket.Conn
+	binaryContentType string
+	incoming          chan *Message
+	closed            chan struct{}
+	closeOnce         sync.Once
+	err               error // The error that ended the read loop
 }
 
 func NewWebSocketTransport(conn *websocket.Conn) Transport {
+	return NewWebSocketTransportWithContentType(conn, "")
+}
+
+// NewWebSocketTransportWithContentType creates a web socket transport whose binary
+// frames are read as messages of the content type, so that they can be decoded
+// with Message.Decode.  Web sockets do not carry content types: text frames are
+// ContentTypeJSON, and binary frames are ContentTypeBinary unless a content type
+// is given.  Compressed messages need a content type with the compression, such as
+// "application/msgpack; compression=gzip".
+//
+// Writing a message whose content type is neither ContentTypeJSON nor the content
+// type of binary frames fails, as the other end could not decode it.
+func NewWebSocketTransportWithContentType(conn *websocket.Conn, binaryContentType string) Transport {
+	if binaryContentType == "" {
+		binaryContentType = ContentTypeBinary
+	}
 
 	p := &wsTransport{
-		wsConn:   conn,
-		incoming: make(chan *Message),
+		wsConn:            conn,
+		binaryContentType: binaryContentType,
+		incoming:          make(chan *Message),
+		closed:            make(chan struct{}),
 	}
 
 	conn.SetPingHandler(p.ping)
@@ -32,13 +57,22 @@ func (p *wsTransport) Name() string {
 }
 
 func (p *wsTransport) WriteMessage(message Message) error {
+	if message.Topic != "" {
+		return ErrTopicsNotSupported
+	}
+
 	var err error
 	switch message.Type {
+	case MessageTypeSubscribe, MessageTypeUnsubscribe:
+		err = ErrTopicsNotSupported
 	case MessageTypeMessage:
-		if message.ContentType == "application/json" {
+		switch {
+		case message.ContentType == ContentTypeJSON:
 			err = p.wsConn.WriteMessage(websocket.TextMessage, message.Content)
-		} else {
+		case message.ContentType == "" || sameContentType(message.ContentType, p.binaryContentType):
 			err = p.wsConn.WriteMessage(websocket.BinaryMessage, message.Content)
+		default:
+			err = fmt.Errorf("live: the web socket transport cannot send content type '%s', its binary frames are '%s'", message.ContentType, p.binaryContentType)
 		}
 	case MessageTypeHello:
 		err = p.wsConn.WriteMessage(websocket.TextMessage, message.Content)
@@ -53,12 +87,18 @@ func (p *wsTransport) WriteMessage(message Message) error {
 	return err
 }
 
+// ReadMessage returns the next message.  When the server closes the connection
+// a goodbye message is returned, followed by the error that ended the connection.
 func (p *wsTransport) ReadMessage() (Message, error) {
-	msg := <-p.incoming
+	msg, ok := <-p.incoming
+	if !ok {
+		return Message{}, p.err
+	}
 	return *msg, nil
 }
 
 func (p *wsTransport) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
 	return p.wsConn.Close()
 }
 
@@ -68,6 +108,7 @@ func (p *wsTransport) readLoop() {
 		if err := recover(); err != nil {
 		}
 	}()
+	defer close(p.incoming)
 
 	for {
 		var msg Message
@@ -76,9 +117,12 @@ func (p *wsTransport) readLoop() {
 		if err != nil {
 
 			if _, ok := err.(*websocket.CloseError); ok {
-				msg.Type = MessageTypeGoodbye
+				goodbye := NewGoodbyeMessage()
+				p.send(&goodbye)
 			}
 
+			p.err = err
+			return
 		}
 
 		msg.ContentLength = int32(len(message))
@@ -90,7 +134,7 @@ func (p *wsTransport) readLoop() {
 			msg.ContentType = "application/json"
 		case websocket.BinaryMessage: // BinaryMessage
 			msg.Type = MessageTypeMessage
-			msg.ContentType = "application/octet-stream"
+			msg.ContentType = p.binaryContentType
 		case websocket.PingMessage: // PingMessage
 			logrus.Debug("PING")
 			msg.Type = MessageTypePing
@@ -100,18 +144,46 @@ func (p *wsTransport) readLoop() {
 
 		}
 
-		p.incoming <- &msg
+		if !p.send(&msg) {
+			p.err = ErrTransportClosed
+			return
+		}
+	}
+}
+
+// sameContentType returns true if the content types have the same media type and
+// parameters.
+func sameContentType(a, b string) bool {
+	aType, aParams, err := parseContentType(a)
+	if err != nil {
+		return a == b
+	}
+	bType, bParams, err := parseContentType(b)
+	if err != nil {
+		return false
+	}
+	return aType == bType && reflect.DeepEqual(aParams, bParams)
+}
+
+// send delivers a message to ReadMessage.  It returns false if the transport was
+// closed before the message was read.
+func (p *wsTransport) send(msg *Message) bool {
+	select {
+	case p.incoming <- msg:
+		return true
+	case <-p.closed:
+		return false
 	}
 }
 
 func (p *wsTransport) ping(data string) error {
 	pingMsg := NewPingMessage()
-	p.incoming <- &pingMsg
+	p.send(&pingMsg)
 	return nil
 }
 
 func (p *wsTransport) pong(data string) error {
 	pongMsg := NewPongMessage()
-	p.incoming <- &pongMsg
+	p.send(&pongMsg)
 	return nil
 }
@@ -3,6 +3,7 @@ package activity
 import (
 	"sort"
 	"sync"
+	"time"
 
 	pipeerrors "github.com/naveego/api/pipeline/errors"
 	"github.com/naveego/api/types/pipeline"
@@ -18,10 +19,19 @@ var (
 // new instances of activities.
 type ActivityFactory func() Activity
 
+// OutputCollector collects the data points emitted by an activity.  Emit
+// sends the data point to every output stream of the activity.
 type OutputCollector interface {
 	Emit(dataPoint pipeline.DataPoint) error
 }
 
+// StreamOutputCollector can be implemented by output collectors that are able
+// to emit a data point to a single output stream of the activity.
+type StreamOutputCollector interface {
+	OutputCollector
+	EmitTo(stream string, dataPoint pipeline.DataPoint) error
+}
+
 // Activity represents a core interface for building pipelines.  Activities
 // can will receive data from an input, and can optionally export data using
 // output channels
@@ -39,6 +49,39 @@ type ActivityIniter interface {
 	Init(settings map[string]interface{}) error
 }
 
+// ActivityFlusher can be implemented by activities that hold data points back,
+// such as aggregations.  Flush is called one time after the input of the
+// activity has been closed, so the activity can emit what it is still holding.
+type ActivityFlusher interface {
+	Flush(context Context) error
+}
+
+// ActivityCloser can be implemented by activities that hold resources, such as
+// connections or shared tables.  Close is called one time when the activity stops,
+// after it has been flushed.
+type ActivityCloser interface {
+	Close() error
+}
+
+// ActivityTicker can be implemented by activities that act on the passing of
+// time, such as closing time windows when no data points arrive.  Tick is called
+// every TickInterval with the current time, on the goroutine that executes the
+// activity, so it is never called at the same time as Execute or Flush.  An
+// interval that is not positive disables ticking.
+type ActivityTicker interface {
+	TickInterval() time.Duration
+	Tick(context Context, now time.Time) error
+}
+
+// ActivityCheckpointer can be implemented by activities with state that should
+// survive a restart.  Checkpoint returns a snapshot of the state that can be passed
+// to Restore on a new instance of the activity after it has been initialized.
+// Checkpoint may be called while the activity is executing.
+type ActivityCheckpointer interface {
+	Checkpoint() ([]byte, error)
+	Restore(state []byte) error
+}
+
 // RegisterActivityFactory registers a node with the system so it can be used
 // by a pipeline.
 func RegisterActivityFactory(name string, factory ActivityFactory) {
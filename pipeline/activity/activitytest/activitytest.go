@@ -0,0 +1,190 @@
+// Package activitytest provides utilities for testing activities.
+//
+// Run creates a registered activity, initializes it with its settings and
+// executes it for a list of data points, recording everything it emits:
+//
+//	result := activitytest.Run(pipeline.Activity{
+//		Type:     "filter",
+//		Settings: map[string]interface{}{"expression": "data.balance > 0"},
+//	}, inputs)
+//
+//	So(result, activitytest.ShouldSucceed)
+//	So(result, activitytest.ShouldEmitData, []map[string]interface{}{{"id": 1, "balance": 10}})
+//	So(result, activitytest.ShouldMatchGolden, "testdata/filter.golden")
+//
+// The assertions have the signature used by goconvey's So, and return an
+// empty string when they succeed, so they can also be used with the testing
+// package directly.
+package activitytest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/naveego/api/pipeline/activity"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+)
+
+// Emitted is a data point emitted by an activity.
+type Emitted struct {
+	Stream    string             `json:"stream,omitempty"` // The stream passed to EmitTo, empty for Emit
+	DataPoint pipeline.DataPoint `json:"dataPoint"`
+}
+
+// Recorder is an output collector that records the data points emitted by an
+// activity.  It implements activity.StreamOutputCollector.
+type Recorder struct {
+	outputs []string
+
+	mu      sync.Mutex
+	emitted []Emitted
+}
+
+// NewRecorder creates a recorder that accepts the given output streams in EmitTo.
+func NewRecorder(outputs ...string) *Recorder {
+	return &Recorder{outputs: outputs}
+}
+
+// Emit records a data point emitted to every output stream.
+func (r *Recorder) Emit(dataPoint pipeline.DataPoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emitted = append(r.emitted, Emitted{DataPoint: dataPoint})
+	return nil
+}
+
+// EmitTo records a data point emitted to a single output stream.  It returns an
+// error if the stream is not one of the outputs of the recorder.
+func (r *Recorder) EmitTo(stream string, dataPoint pipeline.DataPoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.outputs {
+		if s == stream {
+			r.emitted = append(r.emitted, Emitted{Stream: stream, DataPoint: dataPoint})
+			return nil
+		}
+	}
+
+	return errors.NewWithCode(pipeerrors.TopicToInputMismatch, fmt.Sprintf("activitytest: '%s' is not an output stream", stream))
+}
+
+// Emitted returns the recorded data points in the order they were emitted.
+func (r *Recorder) Emitted() []Emitted {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Emitted(nil), r.emitted...)
+}
+
+// DataPoints returns the recorded data points in the order they were emitted.
+func (r *Recorder) DataPoints() []pipeline.DataPoint {
+	list := []pipeline.DataPoint{}
+	for _, e := range r.Emitted() {
+		list = append(list, e.DataPoint)
+	}
+	return list
+}
+
+// Data returns the data of the recorded data points in the order they were emitted.
+func (r *Recorder) Data() []map[string]interface{} {
+	list := []map[string]interface{}{}
+	for _, e := range r.Emitted() {
+		list = append(list, e.DataPoint.Data)
+	}
+	return list
+}
+
+// Stream returns the data points emitted to the stream, including the data
+// points emitted to every stream using Emit.
+func (r *Recorder) Stream(stream string) []pipeline.DataPoint {
+	list := []pipeline.DataPoint{}
+	for _, e := range r.Emitted() {
+		if e.Stream == "" || e.Stream == stream {
+			list = append(list, e.DataPoint)
+		}
+	}
+	return list
+}
+
+// Reset removes the recorded data points.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emitted = nil
+}
+
+// Result is the outcome of running an activity.
+type Result struct {
+	*Recorder
+
+	Activity activity.Activity // The activity, nil if it could not be created
+	Err      error             // The first error returned by the activity
+	Failed   int               // The index of the input that failed, -1 if no input failed
+}
+
+// Run creates the activity registered for the type of def, initializes it with
+// the settings of def and executes it for each input in order.  Activities that
+// implement activity.ActivityFlusher are flushed after the last input, and
+// activities that implement activity.ActivityCloser are closed before Run
+// returns.  Run stops at the first error.
+func Run(def pipeline.Activity, inputs []pipeline.DataPoint) *Result {
+	factory, err := activity.GetActivityFactory(def.Type)
+	if err != nil {
+		return &Result{Recorder: NewRecorder(def.OutputStreams...), Err: err, Failed: -1}
+	}
+
+	act := factory()
+	if initer, ok := act.(activity.ActivityIniter); ok {
+		if err := initer.Init(def.Settings); err != nil {
+			return &Result{Recorder: NewRecorder(def.OutputStreams...), Activity: act, Err: err, Failed: -1}
+		}
+	}
+
+	result := RunActivity(act, def, inputs)
+	if closer, ok := act.(activity.ActivityCloser); ok {
+		if err := closer.Close(); err != nil && result.Err == nil {
+			result.Err = err
+		}
+	}
+	return result
+}
+
+// RunActivity executes an activity that has already been initialized for each
+// input in order, then flushes it if it implements activity.ActivityFlusher.
+func RunActivity(act activity.Activity, def pipeline.Activity, inputs []pipeline.DataPoint) *Result {
+	if def.ID == "" {
+		def.ID = "test"
+	}
+
+	result := &Result{Recorder: NewRecorder(def.OutputStreams...), Activity: act, Failed: -1}
+	ctx := activity.Context{
+		Pipeline:        pipeline.Pipeline{ID: "test"},
+		Activity:        def,
+		OutputCollector: result.Recorder,
+	}
+
+	for i, dp := range inputs {
+		if err := act.Execute(ctx, dp); err != nil {
+			result.Err = err
+			result.Failed = i
+			return result
+		}
+	}
+
+	if flusher, ok := act.(activity.ActivityFlusher); ok {
+		result.Err = flusher.Flush(ctx)
+	}
+
+	return result
+}
+
+// DataPoints creates upsert data points for the entity, one for each data map.
+func DataPoints(entity string, keyNames []string, data ...map[string]interface{}) []pipeline.DataPoint {
+	list := make([]pipeline.DataPoint, len(data))
+	for i, d := range data {
+		list[i] = pipeline.DataPoint{Entity: entity, Action: pipeline.DataPointUpsert, KeyNames: keyNames, Data: d}
+	}
+	return list
+}
@@ -0,0 +1,182 @@
+package activitytest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// upperActivity upper cases the "name" property and fails on names that are empty.
+type upperActivity struct {
+	suffix string
+}
+
+func (u *upperActivity) Init(settings map[string]interface{}) error {
+	suffix, ok := settings["suffix"].(string)
+	if !ok {
+		return fmt.Errorf("suffix is required")
+	}
+	u.suffix = suffix
+	return nil
+}
+
+func (u *upperActivity) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	name, _ := dataPoint.Data["name"].(string)
+	if name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	dataPoint.Data = map[string]interface{}{"id": dataPoint.Data["id"], "name": strings.ToUpper(name) + u.suffix}
+	return ctx.OutputCollector.Emit(dataPoint)
+}
+
+// totalActivity emits the number of data points it received when it is flushed.
+type totalActivity struct {
+	total int
+}
+
+func (t *totalActivity) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	t.total++
+	return nil
+}
+
+func (t *totalActivity) Flush(ctx activity.Context) error {
+	return ctx.OutputCollector.(activity.StreamOutputCollector).EmitTo("totals", pipeline.DataPoint{
+		Entity: "totals",
+		Action: pipeline.DataPointUpsert,
+		Data:   map[string]interface{}{"total": t.total},
+	})
+}
+
+func init() {
+	activity.RegisterActivityFactory("activitytest-upper", func() activity.Activity { return &upperActivity{} })
+	activity.RegisterActivityFactory("activitytest-total", func() activity.Activity { return &totalActivity{} })
+}
+
+var upper = pipeline.Activity{
+	Type:     "activitytest-upper",
+	Settings: map[string]interface{}{"suffix": "!"},
+}
+
+func TestRun(t *testing.T) {
+
+	inputs := DataPoints("customers", []string{"id"},
+		map[string]interface{}{"id": 1, "name": "acme"},
+		map[string]interface{}{"id": 2, "name": "globex"},
+	)
+
+	Convey("Given an activity that succeeds", t, func() {
+		result := Run(upper, inputs)
+
+		Convey("Should record the emitted data points", func() {
+			So(result, ShouldSucceed)
+			So(result, ShouldEmitCount, 2)
+			So(result, ShouldEmitData, []map[string]interface{}{
+				{"id": float64(1), "name": "ACME!"},
+				{"id": 2, "name": "GLOBEX!"},
+			})
+			So(result, ShouldEmitDataInAnyOrder, []map[string]interface{}{
+				{"id": 2, "name": "GLOBEX!"},
+				{"id": 1, "name": "ACME!"},
+			})
+			So(result, ShouldEmitToStreams, []string{"", ""})
+			So(result.DataPoints()[0].Entity, ShouldEqual, "customers")
+		})
+
+		Convey("Should match the golden file", func() {
+			So(result, ShouldMatchGolden, "testdata/upper.golden")
+		})
+
+		Convey("Should report differences", func() {
+			So(ShouldEmitData(result, []map[string]interface{}{{"id": 1, "name": "ACME!"}}), ShouldContainSubstring, "Expected the data")
+			So(ShouldEmitData(result, []map[string]interface{}{{"id": 2, "name": "GLOBEX!"}, {"id": 1, "name": "ACME!"}}), ShouldNotEqual, "")
+			So(ShouldEmitCount(result, 3), ShouldContainSubstring, "emit 3 data point(s), but it emitted 2")
+			So(ShouldFail(result), ShouldEqual, "Expected the activity to fail, but it succeeded.")
+			So(ShouldMatchGolden(result, "testdata/total.golden"), ShouldContainSubstring, "does not match the golden file")
+			So(ShouldMatchGolden(result, "testdata/missing.golden"), ShouldContainSubstring, "does not exist")
+		})
+	})
+
+	Convey("Given an activity that fails", t, func() {
+		inputs := DataPoints("customers", []string{"id"},
+			map[string]interface{}{"id": 1, "name": "acme"},
+			map[string]interface{}{"id": 2},
+			map[string]interface{}{"id": 3, "name": "initech"},
+		)
+		result := Run(upper, inputs)
+
+		Convey("Should stop at the failing input", func() {
+			So(result, ShouldFail, "name is required")
+			So(result, ShouldFailAt, 1)
+			So(result, ShouldEmitCount, 1)
+			So(ShouldSucceed(result), ShouldContainSubstring, "failed at input 1: name is required")
+			So(ShouldFailAt(result, 2), ShouldNotEqual, "")
+		})
+	})
+
+	Convey("Given invalid settings", t, func() {
+		result := Run(pipeline.Activity{Type: "activitytest-upper"}, inputs)
+
+		Convey("Should return the initialization error", func() {
+			So(result, ShouldFail, "suffix is required")
+			So(result.Failed, ShouldEqual, -1)
+			So(result, ShouldEmitCount, 0)
+		})
+	})
+
+	Convey("Given an activity type that is not registered", t, func() {
+		result := Run(pipeline.Activity{Type: "activitytest-unknown"}, inputs)
+
+		Convey("Should return an error", func() {
+			So(result, ShouldFail, "could not find activity factory")
+			So(result.Activity, ShouldBeNil)
+		})
+	})
+
+	Convey("Given an activity that emits when it is flushed", t, func() {
+		result := Run(pipeline.Activity{Type: "activitytest-total", OutputStreams: []string{"totals"}}, inputs)
+
+		Convey("Should record the data points emitted by Flush", func() {
+			So(result, ShouldSucceed)
+			So(result, ShouldEmitToStreams, []string{"totals"})
+			So(result.Stream("totals"), ShouldHaveLength, 1)
+			So(result, ShouldMatchGolden, "testdata/total.golden")
+		})
+	})
+
+	Convey("Given an assertion with the wrong arguments", t, func() {
+		Convey("Should explain the problem", func() {
+			So(ShouldSucceed("result"), ShouldContainSubstring, "must be a *activitytest.Result")
+			So(ShouldEmitCount(&Result{Recorder: NewRecorder()}), ShouldContainSubstring, "requires exactly 1 expected value")
+			So(ShouldEmitData(&Result{Recorder: NewRecorder()}, "data"), ShouldContainSubstring, "must be a []map[string]interface{}")
+		})
+	})
+}
+
+func TestRecorder(t *testing.T) {
+
+	Convey("Given a recorder with output streams", t, func() {
+		r := NewRecorder("a", "b")
+		r.Emit(pipeline.DataPoint{Entity: "all"})
+		r.EmitTo("b", pipeline.DataPoint{Entity: "b"})
+		err := r.EmitTo("c", pipeline.DataPoint{Entity: "c"})
+
+		Convey("Should reject unknown streams", func() {
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should return the data points of a stream", func() {
+			So(r.Stream("a"), ShouldHaveLength, 1)
+			So(r.Stream("b"), ShouldHaveLength, 2)
+		})
+
+		Convey("Should forget the data points when it is reset", func() {
+			r.Reset()
+			So(r.Emitted(), ShouldBeEmpty)
+		})
+	})
+}
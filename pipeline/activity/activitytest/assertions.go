@@ -0,0 +1,209 @@
+package activitytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const success = ""
+
+// ShouldSucceed asserts that the activity did not return an error.
+func ShouldSucceed(actual interface{}, expected ...interface{}) string {
+	r, msg := result(actual, expected, 0)
+	if msg != success {
+		return msg
+	}
+
+	if r.Err != nil {
+		return fmt.Sprintf("Expected the activity to succeed, but it failed%s: %v", failedAt(r), r.Err)
+	}
+	return success
+}
+
+// ShouldFail asserts that the activity returned an error.  The expected value is
+// an optional string that the error message must contain.
+func ShouldFail(actual interface{}, expected ...interface{}) string {
+	if len(expected) > 1 {
+		return fmt.Sprintf("This assertion accepts at most 1 expected value (you provided %d).", len(expected))
+	}
+
+	r, msg := result(actual, nil, 0)
+	if msg != success {
+		return msg
+	}
+
+	if r.Err == nil {
+		return "Expected the activity to fail, but it succeeded."
+	}
+
+	if len(expected) == 1 {
+		sub, ok := expected[0].(string)
+		if !ok {
+			return fmt.Sprintf("The expected value must be a string (you provided %T).", expected[0])
+		}
+		if !strings.Contains(r.Err.Error(), sub) {
+			return fmt.Sprintf("Expected the error to contain '%s', but it was: %v", sub, r.Err)
+		}
+	}
+	return success
+}
+
+// ShouldFailAt asserts that the activity returned an error for the input with
+// the expected index.
+func ShouldFailAt(actual interface{}, expected ...interface{}) string {
+	r, msg := result(actual, expected, 1)
+	if msg != success {
+		return msg
+	}
+
+	index, ok := expected[0].(int)
+	if !ok {
+		return fmt.Sprintf("The expected value must be an int (you provided %T).", expected[0])
+	}
+
+	if r.Err == nil {
+		return fmt.Sprintf("Expected the activity to fail at input %d, but it succeeded.", index)
+	}
+	if r.Failed != index {
+		return fmt.Sprintf("Expected the activity to fail at input %d, but it failed%s: %v", index, failedAt(r), r.Err)
+	}
+	return success
+}
+
+// ShouldEmitCount asserts the number of data points emitted by the activity.
+func ShouldEmitCount(actual interface{}, expected ...interface{}) string {
+	r, msg := result(actual, expected, 1)
+	if msg != success {
+		return msg
+	}
+
+	count, ok := expected[0].(int)
+	if !ok {
+		return fmt.Sprintf("The expected value must be an int (you provided %T).", expected[0])
+	}
+
+	if n := len(r.Emitted()); n != count {
+		return fmt.Sprintf("Expected the activity to emit %d data point(s), but it emitted %d:\n%s", count, n, toJSON(r.Data()))
+	}
+	return success
+}
+
+// ShouldEmitData asserts that the activity emitted data points with the expected
+// data, in the same order.  Values are compared as JSON, so numbers of different
+// types are equal.
+func ShouldEmitData(actual interface{}, expected ...interface{}) string {
+	r, msg := result(actual, expected, 1)
+	if msg != success {
+		return msg
+	}
+
+	want, ok := expected[0].([]map[string]interface{})
+	if !ok {
+		return fmt.Sprintf("The expected value must be a []map[string]interface{} (you provided %T).", expected[0])
+	}
+
+	return compareJSON("data", r.Data(), want)
+}
+
+// ShouldEmitDataInAnyOrder is like ShouldEmitData, but ignores the order in which
+// the data points were emitted.
+func ShouldEmitDataInAnyOrder(actual interface{}, expected ...interface{}) string {
+	r, msg := result(actual, expected, 1)
+	if msg != success {
+		return msg
+	}
+
+	want, ok := expected[0].([]map[string]interface{})
+	if !ok {
+		return fmt.Sprintf("The expected value must be a []map[string]interface{} (you provided %T).", expected[0])
+	}
+
+	return compareJSON("data", sortedJSON(r.Data()), sortedJSON(want))
+}
+
+// ShouldEmitToStreams asserts the streams the data points were emitted to, in
+// order.  Data points emitted to every stream using Emit have an empty stream.
+func ShouldEmitToStreams(actual interface{}, expected ...interface{}) string {
+	r, msg := result(actual, expected, 1)
+	if msg != success {
+		return msg
+	}
+
+	want, ok := expected[0].([]string)
+	if !ok {
+		return fmt.Sprintf("The expected value must be a []string (you provided %T).", expected[0])
+	}
+
+	streams := []string{}
+	for _, e := range r.Emitted() {
+		streams = append(streams, e.Stream)
+	}
+
+	if !reflect.DeepEqual(streams, want) {
+		return fmt.Sprintf("Expected the streams %q, but the activity emitted to %q", want, streams)
+	}
+	return success
+}
+
+func result(actual interface{}, expected []interface{}, count int) (*Result, string) {
+	if len(expected) != count {
+		return nil, fmt.Sprintf("This assertion requires exactly %d expected value(s) (you provided %d).", count, len(expected))
+	}
+
+	r, ok := actual.(*Result)
+	if !ok || r == nil {
+		return nil, fmt.Sprintf("The actual value must be a *activitytest.Result (you provided %T).", actual)
+	}
+	return r, success
+}
+
+func failedAt(r *Result) string {
+	if r.Failed < 0 {
+		return ""
+	}
+	return fmt.Sprintf(" at input %d", r.Failed)
+}
+
+// compareJSON compares values after a round trip through JSON.
+func compareJSON(what string, actual, expected interface{}) string {
+	a, e := normalize(actual), normalize(expected)
+	if reflect.DeepEqual(a, e) {
+		return success
+	}
+	return fmt.Sprintf("Expected the %s:\n%s\nActual:\n%s", what, toJSON(e), toJSON(a))
+}
+
+func normalize(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// sortedJSON sorts the data by its JSON representation.
+func sortedJSON(data []map[string]interface{}) []string {
+	list := make([]string, len(data))
+	for i, d := range data {
+		b, _ := json.Marshal(d)
+		list[i] = string(b)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func toJSON(v interface{}) string {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%#v", v)
+	}
+	return string(b)
+}
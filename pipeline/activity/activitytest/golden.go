@@ -0,0 +1,114 @@
+package activitytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/naveego/api/types/pipeline"
+)
+
+// update rewrites the golden files instead of comparing with them:
+//
+//	go test ./... -activitytest.update
+var update = flag.Bool("activitytest.update", false, "update the golden files of activity tests")
+
+// golden is the content of a golden file.
+type golden struct {
+	Emitted []goldenDataPoint `json:"emitted"`
+	Error   string            `json:"error,omitempty"`
+	Failed  *int              `json:"failed,omitempty"`
+}
+
+// goldenDataPoint leaves out the shape and empty fields of a data point,
+// so golden files only change when the output of the activity does.
+type goldenDataPoint struct {
+	Stream     string                   `json:"stream,omitempty"`
+	Repository string                   `json:"repository,omitempty"`
+	Source     string                   `json:"source,omitempty"`
+	Entity     string                   `json:"entity,omitempty"`
+	Action     pipeline.DataPointAction `json:"action,omitempty"`
+	KeyNames   []string                 `json:"keyNames,omitempty"`
+	Meta       map[string]string        `json:"meta,omitempty"`
+	Data       map[string]interface{}   `json:"data"`
+}
+
+// Golden returns the content of the golden file for the result, which is the
+// emitted data points and the error as indented JSON.
+func (r *Result) Golden() ([]byte, error) {
+	g := golden{Emitted: []goldenDataPoint{}}
+	for _, e := range r.Emitted() {
+		dp := e.DataPoint
+		g.Emitted = append(g.Emitted, goldenDataPoint{
+			Stream:     e.Stream,
+			Repository: dp.Repository,
+			Source:     dp.Source,
+			Entity:     dp.Entity,
+			Action:     dp.Action,
+			KeyNames:   dp.KeyNames,
+			Meta:       dp.Meta,
+			Data:       dp.Data,
+		})
+	}
+
+	if r.Err != nil {
+		g.Error = r.Err.Error()
+		if r.Failed >= 0 {
+			failed := r.Failed
+			g.Failed = &failed
+		}
+	}
+
+	b, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// ShouldMatchGolden asserts that the result matches the golden file at the
+// expected path.  When the tests are run with -activitytest.update the golden
+// file is written instead.
+func ShouldMatchGolden(actual interface{}, expected ...interface{}) string {
+	r, msg := result(actual, expected, 1)
+	if msg != success {
+		return msg
+	}
+
+	path, ok := expected[0].(string)
+	if !ok {
+		return fmt.Sprintf("The expected value must be the path of the golden file (you provided %T).", expected[0])
+	}
+
+	b, err := r.Golden()
+	if err != nil {
+		return fmt.Sprintf("Could not encode the result: %v", err)
+	}
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Sprintf("Could not create the directory of the golden file: %v", err)
+		}
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			return fmt.Sprintf("Could not write the golden file: %v", err)
+		}
+		return success
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Sprintf("The golden file %s does not exist, run the tests with -activitytest.update to create it.", path)
+	}
+	if err != nil {
+		return fmt.Sprintf("Could not read the golden file: %v", err)
+	}
+
+	if !bytes.Equal(bytes.TrimSpace(b), bytes.TrimSpace(want)) {
+		return fmt.Sprintf("The result does not match the golden file %s (run the tests with -activitytest.update to update it).\nExpected:\n%s\nActual:\n%s", path, want, b)
+	}
+	return success
+}
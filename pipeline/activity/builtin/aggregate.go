@@ -0,0 +1,549 @@
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/pipeline/mapping"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// The window types supported by the aggregate activity.
+const (
+	WindowTumbling = "tumbling"
+	WindowSliding  = "sliding"
+)
+
+// The functions supported by aggregations.
+const (
+	AggCount         = "count"
+	AggSum           = "sum"
+	AggMin           = "min"
+	AggMax           = "max"
+	AggAvg           = "avg"
+	AggDistinctCount = "distinctCount"
+)
+
+// The properties added to the data points emitted by the aggregate activity.
+const (
+	WindowStartProperty = "windowStart"
+	WindowEndProperty   = "windowEnd"
+)
+
+// Aggregate groups data points into time windows and emits one data point per
+// window, entity and group when the window closes.  A window closes when a data
+// point with a time at or after the end of the window arrives, when the clock
+// passes the end of the window, or when the activity is flushed.  In processing
+// time the clock closes a window at its end.  With a time property the clock only
+// closes windows when closeAfter is set, closeAfter past their end.  Data points
+// that arrive after all of their windows have closed are dropped and counted, see
+// Late.
+//
+// The emitted data points contain the groupBy properties, windowStart, windowEnd
+// and the result of each aggregation.  Their key names are the groupBy properties
+// and windowStart.
+//
+// Settings:
+//
+//	window        optional: "tumbling" (default) or "sliding"
+//	size          the length of a window, for example "5m"
+//	slide         the time between the starts of sliding windows, for example "1m"
+//	timeProperty  optional: the property with the time of the data point, defaults
+//	              to the time the data point is processed
+//	closeAfter    optional: the time after the end of a window that the clock
+//	              closes it, for example "30s"
+//	groupBy       optional: the properties to group the data points by
+//	aggregations  a list of aggregations, each with function, property and as
+type Aggregate struct {
+	late int64 // The number of late data points, first to be aligned for atomic access
+
+	Window       string
+	Size         time.Duration
+	Slide        time.Duration
+	TimeProperty string
+	CloseAfter   time.Duration
+	GroupBy      []string
+	Aggregations []Aggregation
+
+	mu        sync.Mutex
+	now       func() time.Time
+	watermark time.Time // The latest time seen
+	windows   map[string]*aggregateWindow
+}
+
+// Aggregation is a single result computed by the aggregate activity.  Only
+// count may omit the property, in which case the data points are counted.
+// The values of sum, min, max and avg must be numbers, nulls are ignored.
+type Aggregation struct {
+	Function string `json:"function"`     // One of the Agg constants
+	Property string `json:"property"`     // The property to aggregate, may be a dotted path
+	As       string `json:"as,omitempty"` // The property to store the result in
+}
+
+// aggregateWindow is the state of a window for one entity and group.  It is
+// serialized in checkpoints.
+type aggregateWindow struct {
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Repository string                 `json:"repository,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+	Entity     string                 `json:"entity"`
+	Group      map[string]interface{} `json:"group"`
+	Values     []aggregateValue       `json:"values"`
+}
+
+type aggregateValue struct {
+	Count    int64           `json:"count"`
+	Sum      float64         `json:"sum"`
+	Min      *float64        `json:"min,omitempty"`
+	Max      *float64        `json:"max,omitempty"`
+	Distinct map[string]bool `json:"distinct,omitempty"`
+}
+
+type aggregateCheckpoint struct {
+	Watermark time.Time          `json:"watermark"`
+	Windows   []*aggregateWindow `json:"windows"`
+}
+
+// NewAggregate creates an aggregate activity with tumbling windows.
+func NewAggregate() *Aggregate {
+	return &Aggregate{
+		Window:  WindowTumbling,
+		now:     time.Now,
+		windows: map[string]*aggregateWindow{},
+	}
+}
+
+// Init reads the windows and the aggregations from the settings.
+func (a *Aggregate) Init(values map[string]interface{}) error {
+	s := newSettings(AggregateType, values)
+	a.TimeProperty = s.string("timeProperty", false)
+	a.GroupBy = s.stringList("groupBy", false)
+
+	if window := s.string("window", false); window != "" {
+		a.Window = window
+	}
+
+	a.Size = aggregateDuration(s, "size", true)
+	a.CloseAfter = aggregateDuration(s, "closeAfter", false)
+	switch a.Window {
+	case WindowTumbling:
+		a.Slide = a.Size
+	case WindowSliding:
+		a.Slide = aggregateDuration(s, "slide", true)
+		if s.err == nil && a.Slide > a.Size {
+			s.fail("slide", "must not be longer than the size")
+		}
+	default:
+		s.fail("window", "must be '%s' or '%s' not '%s'", WindowTumbling, WindowSliding, a.Window)
+	}
+
+	list, ok := values["aggregations"].([]interface{})
+	if !ok || len(list) == 0 {
+		s.fail("aggregations", "must be a list of objects")
+	}
+
+	names := map[string]bool{}
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			s.fail("aggregations", "must be a list of objects")
+			break
+		}
+
+		c := newSettings(AggregateType, m)
+		agg := Aggregation{
+			Function: c.string("function", true),
+			Property: c.string("property", false),
+			As:       c.string("as", false),
+		}
+		if c.err == nil && !isAggregateFunction(agg.Function) {
+			c.fail("function", "has unknown function '%s'", agg.Function)
+		}
+		if c.err == nil && agg.Property == "" && agg.Function != AggCount {
+			c.fail("property", "is required")
+		}
+		if c.err != nil {
+			s.fail(fmt.Sprintf("aggregations[%d]", i), "is invalid: %v", c.err)
+			break
+		}
+
+		if agg.As == "" {
+			agg.As = agg.Function
+			if agg.Property != "" {
+				agg.As = strings.Replace(agg.Property, ".", "_", -1) + "_" + agg.Function
+			}
+		}
+		if names[agg.As] {
+			s.fail(fmt.Sprintf("aggregations[%d].as", i), "'%s' is used more than once", agg.As)
+		}
+		names[agg.As] = true
+
+		a.Aggregations = append(a.Aggregations, agg)
+	}
+
+	return s.err
+}
+
+func aggregateDuration(s *settings, name string, required bool) time.Duration {
+	str := s.string(name, required)
+	if str == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil || d <= 0 {
+		s.fail(name, "must be a positive duration such as '5m'")
+	}
+	return d
+}
+
+func isAggregateFunction(fn string) bool {
+	switch fn {
+	case AggCount, AggSum, AggMin, AggMax, AggAvg, AggDistinctCount:
+		return true
+	}
+	return false
+}
+
+// Execute adds the data point to its windows and emits the windows that have closed.
+func (a *Aggregate) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	t, err := a.timeOf(dataPoint)
+	if err != nil {
+		return err
+	}
+
+	group := make(map[string]interface{}, len(a.GroupBy))
+	for _, name := range a.GroupBy {
+		val, _ := getPath(dataPoint.Data, name)
+		if f, ok := mapping.AsNumber(val); ok {
+			val = f
+		}
+		group[name] = val
+	}
+
+	groupKey, err := json.Marshal(group)
+	if err != nil {
+		return fmt.Errorf("aggregate: could not group data point: %v", err)
+	}
+
+	a.mu.Lock()
+
+	added := false
+	for _, start := range a.windowStarts(t) {
+		end := start.Add(a.Size)
+		if !end.After(a.watermark) {
+			// The window has already been emitted
+			continue
+		}
+		added = true
+
+		key := windowKey(start, dataPoint.Entity, string(groupKey))
+		w, ok := a.windows[key]
+		if !ok {
+			w = &aggregateWindow{
+				Start:      start,
+				End:        end,
+				Repository: dataPoint.Repository,
+				Source:     dataPoint.Source,
+				Entity:     dataPoint.Entity,
+				Group:      group,
+				Values:     make([]aggregateValue, len(a.Aggregations)),
+			}
+			a.windows[key] = w
+		}
+
+		if err := a.add(w, dataPoint.Data); err != nil {
+			a.mu.Unlock()
+			return err
+		}
+	}
+
+	if !added {
+		atomic.AddInt64(&a.late, 1)
+	}
+
+	if t.After(a.watermark) {
+		a.watermark = t
+	}
+
+	closed := a.close(func(w *aggregateWindow) bool { return !w.End.After(a.watermark) })
+	a.mu.Unlock()
+
+	return a.emit(ctx, closed)
+}
+
+// TickInterval returns how often the clock closes windows, which is zero when
+// the windows are in event time and closeAfter is not set.
+func (a *Aggregate) TickInterval() time.Duration {
+	if a.TimeProperty != "" && a.CloseAfter == 0 {
+		return 0
+	}
+	if a.Slide < time.Second {
+		return a.Slide
+	}
+	return time.Second
+}
+
+// Tick emits the windows that ended closeAfter or more before now.  The windows
+// closed by the clock cannot receive more data points.
+func (a *Aggregate) Tick(ctx activity.Context, now time.Time) error {
+	cutoff := now.UTC().Add(-a.CloseAfter)
+
+	a.mu.Lock()
+	if cutoff.After(a.watermark) {
+		a.watermark = cutoff
+	}
+	closed := a.close(func(w *aggregateWindow) bool { return !w.End.After(a.watermark) })
+	a.mu.Unlock()
+
+	return a.emit(ctx, closed)
+}
+
+// Flush emits all of the open windows.
+func (a *Aggregate) Flush(ctx activity.Context) error {
+	a.mu.Lock()
+	closed := a.close(func(*aggregateWindow) bool { return true })
+	a.mu.Unlock()
+
+	return a.emit(ctx, closed)
+}
+
+// Late returns the number of data points that were dropped because all of
+// their windows had closed.
+func (a *Aggregate) Late() int64 {
+	return atomic.LoadInt64(&a.late)
+}
+
+// Checkpoint returns the open windows as JSON.
+func (a *Aggregate) Checkpoint() ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cp := aggregateCheckpoint{Watermark: a.watermark, Windows: []*aggregateWindow{}}
+	for _, key := range a.sortedKeys(nil) {
+		cp.Windows = append(cp.Windows, a.windows[key])
+	}
+	return json.Marshal(cp)
+}
+
+// Restore replaces the open windows with the windows in the checkpoint.
+func (a *Aggregate) Restore(state []byte) error {
+	var cp aggregateCheckpoint
+	if err := json.Unmarshal(state, &cp); err != nil {
+		return fmt.Errorf("aggregate: invalid checkpoint: %v", err)
+	}
+
+	windows := map[string]*aggregateWindow{}
+	for _, w := range cp.Windows {
+		if len(w.Values) != len(a.Aggregations) {
+			return fmt.Errorf("aggregate: checkpoint has %d aggregations but the activity has %d", len(w.Values), len(a.Aggregations))
+		}
+		groupKey, err := json.Marshal(w.Group)
+		if err != nil {
+			return fmt.Errorf("aggregate: invalid checkpoint: %v", err)
+		}
+		windows[windowKey(w.Start, w.Entity, string(groupKey))] = w
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.watermark = cp.Watermark
+	a.windows = windows
+	return nil
+}
+
+func (a *Aggregate) timeOf(dataPoint pipeline.DataPoint) (time.Time, error) {
+	if a.TimeProperty == "" {
+		return a.now().UTC(), nil
+	}
+
+	val, _ := getPath(dataPoint.Data, a.TimeProperty)
+	if val == nil {
+		return time.Time{}, fmt.Errorf("aggregate: the time property '%s' is missing", a.TimeProperty)
+	}
+
+	date, err := mapping.Convert(val, mapping.TypeOf(val), pipeline.PropertyTypeDate)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("aggregate: the time property '%s' is invalid: %v", a.TimeProperty, err)
+	}
+
+	t, _ := mapping.ParseDate(date.(string))
+	return t.UTC(), nil
+}
+
+// windowStarts returns the starts of the windows that contain t, oldest first.
+func (a *Aggregate) windowStarts(t time.Time) []time.Time {
+	var starts []time.Time
+	for start := truncateTime(t, a.Slide); start.Add(a.Size).After(t); start = start.Add(-a.Slide) {
+		starts = append([]time.Time{start}, starts...)
+	}
+	return starts
+}
+
+// truncateTime rounds t down to a multiple of d since the unix epoch.
+func truncateTime(t time.Time, d time.Duration) time.Time {
+	ns := t.UnixNano()
+	rem := ns % int64(d)
+	if rem < 0 {
+		rem += int64(d)
+	}
+	return time.Unix(0, ns-rem).UTC()
+}
+
+func windowKey(start time.Time, entity, group string) string {
+	return strconv.FormatInt(start.UnixNano(), 10) + ":" + strconv.Quote(entity) + ":" + group
+}
+
+func (a *Aggregate) add(w *aggregateWindow, data map[string]interface{}) error {
+	for i, agg := range a.Aggregations {
+		v := &w.Values[i]
+
+		if agg.Property == "" {
+			v.Count++
+			continue
+		}
+
+		val, _ := getPath(data, agg.Property)
+		if val == nil {
+			continue
+		}
+
+		if agg.Function == AggCount {
+			v.Count++
+			continue
+		}
+
+		if agg.Function == AggDistinctCount {
+			key, err := distinctKey(val)
+			if err != nil {
+				return fmt.Errorf("aggregate: property '%s': %v", agg.Property, err)
+			}
+			if v.Distinct == nil {
+				v.Distinct = map[string]bool{}
+			}
+			v.Distinct[key] = true
+			continue
+		}
+
+		f, ok := mapping.AsNumber(val)
+		if !ok {
+			return fmt.Errorf("aggregate: property '%s' must be a number to compute the %s, not '%v'", agg.Property, agg.Function, val)
+		}
+
+		v.Count++
+		v.Sum += f
+		if v.Min == nil || f < *v.Min {
+			min := f
+			v.Min = &min
+		}
+		if v.Max == nil || f > *v.Max {
+			max := f
+			v.Max = &max
+		}
+	}
+	return nil
+}
+
+// distinctKey returns a string that is equal for equal values.  Numbers of
+// different types are equal.
+func distinctKey(val interface{}) (string, error) {
+	if f, ok := mapping.AsNumber(val); ok {
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	}
+
+	b, err := json.Marshal(val)
+	return string(b), err
+}
+
+// close removes the windows selected by closed and returns them ordered by end,
+// start and group.  It must be called with a.mu held.
+func (a *Aggregate) close(closed func(w *aggregateWindow) bool) []*aggregateWindow {
+	var windows []*aggregateWindow
+	for _, key := range a.sortedKeys(closed) {
+		windows = append(windows, a.windows[key])
+		delete(a.windows, key)
+	}
+	return windows
+}
+
+// emit emits the closed windows.  It is called without a.mu held, because the
+// output collector may block.
+func (a *Aggregate) emit(ctx activity.Context, windows []*aggregateWindow) error {
+	for _, w := range windows {
+		if err := ctx.OutputCollector.Emit(a.result(w)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Aggregate) sortedKeys(include func(w *aggregateWindow) bool) []string {
+	var keys []string
+	for key, w := range a.windows {
+		if include == nil || include(w) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		x, y := a.windows[keys[i]], a.windows[keys[j]]
+		if !x.End.Equal(y.End) {
+			return x.End.Before(y.End)
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func (a *Aggregate) result(w *aggregateWindow) pipeline.DataPoint {
+	data := map[string]interface{}{}
+	for k, v := range w.Group {
+		setPath(data, k, v)
+	}
+	data[WindowStartProperty] = w.Start.Format(time.RFC3339Nano)
+	data[WindowEndProperty] = w.End.Format(time.RFC3339Nano)
+
+	for i, agg := range a.Aggregations {
+		v := w.Values[i]
+
+		var result interface{}
+		switch agg.Function {
+		case AggCount:
+			result = float64(v.Count)
+		case AggDistinctCount:
+			result = float64(len(v.Distinct))
+		case AggSum:
+			result = v.Sum
+		case AggMin:
+			if v.Min != nil {
+				result = *v.Min
+			}
+		case AggMax:
+			if v.Max != nil {
+				result = *v.Max
+			}
+		case AggAvg:
+			if v.Count > 0 {
+				result = v.Sum / float64(v.Count)
+			}
+		}
+		data[agg.As] = result
+	}
+
+	return pipeline.DataPoint{
+		Repository: w.Repository,
+		Source:     w.Source,
+		Entity:     w.Entity,
+		Action:     pipeline.DataPointUpsert,
+		KeyNames:   append(append([]string{}, a.GroupBy...), WindowStartProperty),
+		Data:       data,
+	}
+}
@@ -0,0 +1,357 @@
+package builtin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestAggregate(settings map[string]interface{}) (*Aggregate, *activitytest.Recorder, activity.Context) {
+	a := NewAggregate()
+	So(a.Init(settings), ShouldBeNil)
+
+	c := activitytest.NewRecorder()
+	ctx := activity.Context{
+		Activity:        pipeline.Activity{ID: "test", Type: AggregateType},
+		OutputCollector: c,
+	}
+	return a, c, ctx
+}
+
+// checkpointingCollector checkpoints the aggregate for every data point it
+// records, which fails if the aggregate emits while holding its lock.
+type checkpointingCollector struct {
+	*activitytest.Recorder
+	aggregate   *Aggregate
+	checkpoints [][]byte
+}
+
+func (c *checkpointingCollector) Emit(dataPoint pipeline.DataPoint) error {
+	state, err := c.aggregate.Checkpoint()
+	if err != nil {
+		return err
+	}
+	c.checkpoints = append(c.checkpoints, state)
+	return c.Recorder.Emit(dataPoint)
+}
+
+func TestAggregate(t *testing.T) {
+
+	Convey("Given tumbling windows grouped by a property", t, func() {
+		a, c, ctx := newTestAggregate(map[string]interface{}{
+			"size":         "1m",
+			"timeProperty": "at",
+			"groupBy":      []interface{}{"region"},
+			"aggregations": []interface{}{
+				map[string]interface{}{"function": "count"},
+				map[string]interface{}{"function": "sum", "property": "amount"},
+				map[string]interface{}{"function": "min", "property": "amount"},
+				map[string]interface{}{"function": "max", "property": "amount", "as": "largest"},
+				map[string]interface{}{"function": "avg", "property": "amount"},
+				map[string]interface{}{"function": "distinctCount", "property": "customer"},
+			},
+		})
+
+		inputs := activitytest.DataPoints("customers", []string{"id"},
+			map[string]interface{}{"at": "2017-02-16T12:00:10Z", "region": "east", "amount": 10, "customer": 1},
+			map[string]interface{}{"at": "2017-02-16T12:00:30Z", "region": "west", "amount": 5.5, "customer": 2},
+			map[string]interface{}{"at": "2017-02-16T12:00:50Z", "region": "east", "amount": int64(30), "customer": float64(1)},
+			map[string]interface{}{"at": "2017-02-16T12:00:55Z", "region": "east", "amount": nil, "customer": 3},
+		)
+		for _, dp := range inputs {
+			So(a.Execute(ctx, dp), ShouldBeNil)
+		}
+
+		Convey("Should not emit before the window closes", func() {
+			So(c.DataPoints(), ShouldBeEmpty)
+		})
+
+		Convey("Should emit the aggregates when the window closes", func() {
+			next := activitytest.DataPoints("customers", []string{"id"}, map[string]interface{}{"at": "2017-02-16T12:01:00Z", "region": "east", "amount": 1, "customer": 1})
+			So(a.Execute(ctx, next[0]), ShouldBeNil)
+
+			So(c.Data(), ShouldResemble, []map[string]interface{}{
+				{
+					"region":                 "east",
+					"windowStart":            "2017-02-16T12:00:00Z",
+					"windowEnd":              "2017-02-16T12:01:00Z",
+					"count":                  float64(3),
+					"amount_sum":             float64(40),
+					"amount_min":             float64(10),
+					"largest":                float64(30),
+					"amount_avg":             float64(20),
+					"customer_distinctCount": float64(2),
+				},
+				{
+					"region":                 "west",
+					"windowStart":            "2017-02-16T12:00:00Z",
+					"windowEnd":              "2017-02-16T12:01:00Z",
+					"count":                  float64(1),
+					"amount_sum":             5.5,
+					"amount_min":             5.5,
+					"largest":                5.5,
+					"amount_avg":             5.5,
+					"customer_distinctCount": float64(1),
+				},
+			})
+			So(c.DataPoints()[0].Entity, ShouldEqual, "customers")
+			So(c.DataPoints()[0].KeyNames, ShouldResemble, []string{"region", "windowStart"})
+		})
+
+		Convey("Should drop data points for windows that have closed", func() {
+			late := activitytest.DataPoints("customers", []string{"id"},
+				map[string]interface{}{"at": "2017-02-16T12:01:00Z", "region": "east", "amount": 1, "customer": 1},
+				map[string]interface{}{"at": "2017-02-16T12:00:59Z", "region": "east", "amount": 1000, "customer": 1},
+			)
+			So(a.Execute(ctx, late[0]), ShouldBeNil)
+			So(a.Execute(ctx, late[1]), ShouldBeNil)
+			So(a.Flush(ctx), ShouldBeNil)
+
+			So(c.DataPoints(), ShouldHaveLength, 3)
+			So(c.DataPoints()[0].Data["amount_sum"], ShouldEqual, 40)
+			So(c.DataPoints()[2].Data["amount_sum"], ShouldEqual, 1)
+			So(a.Late(), ShouldEqual, 1)
+		})
+
+		Convey("Should emit the open windows when flushed", func() {
+			So(a.Flush(ctx), ShouldBeNil)
+			So(c.DataPoints(), ShouldHaveLength, 2)
+			So(a.Flush(ctx), ShouldBeNil)
+			So(c.DataPoints(), ShouldHaveLength, 2)
+		})
+
+		Convey("Should restore the open windows from a checkpoint", func() {
+			state, err := a.Checkpoint()
+			So(err, ShouldBeNil)
+
+			restored, rc, rctx := newTestAggregate(map[string]interface{}{
+				"size":         "1m",
+				"timeProperty": "at",
+				"groupBy":      []interface{}{"region"},
+				"aggregations": []interface{}{
+					map[string]interface{}{"function": "count"},
+					map[string]interface{}{"function": "sum", "property": "amount"},
+					map[string]interface{}{"function": "min", "property": "amount"},
+					map[string]interface{}{"function": "max", "property": "amount", "as": "largest"},
+					map[string]interface{}{"function": "avg", "property": "amount"},
+					map[string]interface{}{"function": "distinctCount", "property": "customer"},
+				},
+			})
+			So(restored.Restore(state), ShouldBeNil)
+
+			more := activitytest.DataPoints("customers", []string{"id"}, map[string]interface{}{"at": "2017-02-16T12:00:59Z", "region": "east", "amount": 20, "customer": 4})
+			So(restored.Execute(rctx, more[0]), ShouldBeNil)
+			So(restored.Flush(rctx), ShouldBeNil)
+
+			So(rc.DataPoints(), ShouldHaveLength, 2)
+			So(rc.DataPoints()[0].Data["count"], ShouldEqual, 4)
+			So(rc.DataPoints()[0].Data["amount_sum"], ShouldEqual, 60)
+			So(rc.DataPoints()[0].Data["customer_distinctCount"], ShouldEqual, 3)
+			So(rc.DataPoints()[1].Data["region"], ShouldEqual, "west")
+		})
+	})
+
+	Convey("Given windows that close after a delay", t, func() {
+		a, c, ctx := newTestAggregate(map[string]interface{}{
+			"size":         "1m",
+			"timeProperty": "at",
+			"closeAfter":   "30s",
+			"aggregations": []interface{}{map[string]interface{}{"function": "count"}},
+		})
+		So(a.TickInterval(), ShouldEqual, time.Second)
+
+		inputs := activitytest.DataPoints("customers", []string{"id"},
+			map[string]interface{}{"at": "2017-02-16T12:00:10Z"},
+			map[string]interface{}{"at": "2017-02-16T12:00:50Z"},
+		)
+		for _, dp := range inputs {
+			So(a.Execute(ctx, dp), ShouldBeNil)
+		}
+
+		Convey("Should not close the window before the delay has passed", func() {
+			So(a.Tick(ctx, time.Date(2017, 2, 16, 12, 1, 29, 0, time.UTC)), ShouldBeNil)
+			So(c.DataPoints(), ShouldBeEmpty)
+		})
+
+		Convey("Should close the window when the clock passes the delay", func() {
+			So(a.Tick(ctx, time.Date(2017, 2, 16, 12, 1, 30, 0, time.UTC)), ShouldBeNil)
+			So(c.DataPoints(), ShouldHaveLength, 1)
+			So(c.DataPoints()[0].Data["count"], ShouldEqual, 2)
+
+			So(a.Execute(ctx, inputs[0]), ShouldBeNil)
+			So(a.Flush(ctx), ShouldBeNil)
+			So(c.DataPoints(), ShouldHaveLength, 1)
+			So(a.Late(), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given windows in event time without a delay", t, func() {
+		a, _, _ := newTestAggregate(map[string]interface{}{
+			"size":         "1m",
+			"timeProperty": "at",
+			"aggregations": []interface{}{map[string]interface{}{"function": "count"}},
+		})
+
+		Convey("Should not be closed by the clock", func() {
+			So(a.TickInterval(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given an output collector that checkpoints the activity", t, func() {
+		a := NewAggregate()
+		So(a.Init(map[string]interface{}{
+			"size":         "1m",
+			"timeProperty": "at",
+			"aggregations": []interface{}{map[string]interface{}{"function": "count"}},
+		}), ShouldBeNil)
+
+		c := &checkpointingCollector{Recorder: activitytest.NewRecorder(), aggregate: a}
+		ctx := activity.Context{OutputCollector: c}
+
+		So(a.Execute(ctx, activitytest.DataPoints("customers", []string{"id"}, map[string]interface{}{"at": "2017-02-16T12:00:10Z"})[0]), ShouldBeNil)
+
+		Convey("Should emit without holding the lock", func() {
+			So(a.Flush(ctx), ShouldBeNil)
+			So(c.DataPoints(), ShouldHaveLength, 1)
+			So(c.checkpoints, ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("Given orders grouped by region", t, func() {
+		result := activitytest.Run(pipeline.Activity{
+			Type: AggregateType,
+			Settings: map[string]interface{}{
+				"size":         "1h",
+				"timeProperty": "placed",
+				"groupBy":      []interface{}{"region"},
+				"aggregations": []interface{}{
+					map[string]interface{}{"function": "count", "as": "orders"},
+					map[string]interface{}{"function": "sum", "property": "total", "as": "revenue"},
+				},
+			},
+		}, activitytest.DataPoints("orders", []string{"id"},
+			map[string]interface{}{"id": 1, "placed": "2017-02-16T09:15:00Z", "region": "east", "total": 120.5},
+			map[string]interface{}{"id": 2, "placed": "2017-02-16T09:40:00Z", "region": "west", "total": 80},
+			map[string]interface{}{"id": 3, "placed": "2017-02-16T09:55:00Z", "region": "east", "total": 19.5},
+			map[string]interface{}{"id": 4, "placed": "2017-02-16T10:05:00Z", "region": "east", "total": 42},
+		))
+
+		Convey("Should emit the hourly totals", func() {
+			So(result, activitytest.ShouldSucceed)
+			So(result, activitytest.ShouldMatchGolden, "testdata/aggregate.golden")
+		})
+	})
+
+	Convey("Given sliding windows", t, func() {
+		a, c, ctx := newTestAggregate(map[string]interface{}{
+			"window":       "sliding",
+			"size":         "2m",
+			"slide":        "1m",
+			"timeProperty": "at",
+			"aggregations": []interface{}{map[string]interface{}{"function": "count"}},
+		})
+
+		for _, at := range []string{"2017-02-16T12:00:30Z", "2017-02-16T12:01:30Z", "2017-02-16T12:02:30Z"} {
+			So(a.Execute(ctx, activitytest.DataPoints("customers", []string{"id"}, map[string]interface{}{"at": at})[0]), ShouldBeNil)
+		}
+		So(a.Flush(ctx), ShouldBeNil)
+
+		Convey("Should add each data point to every window that contains it", func() {
+			type window struct {
+				start string
+				count interface{}
+			}
+			actual := []window{}
+			for _, d := range c.Data() {
+				actual = append(actual, window{d["windowStart"].(string), d["count"]})
+			}
+			So(actual, ShouldResemble, []window{
+				{"2017-02-16T11:59:00Z", float64(1)},
+				{"2017-02-16T12:00:00Z", float64(2)},
+				{"2017-02-16T12:01:00Z", float64(2)},
+				{"2017-02-16T12:02:00Z", float64(1)},
+			})
+		})
+	})
+
+	Convey("Given windows in processing time", t, func() {
+		now := time.Date(2017, 2, 16, 12, 0, 0, 0, time.UTC)
+		a, c, ctx := newTestAggregate(map[string]interface{}{
+			"size":         "5m",
+			"aggregations": []interface{}{map[string]interface{}{"function": "count"}},
+		})
+		a.now = func() time.Time { return now }
+
+		inputs := activitytest.DataPoints("customers", []string{"id"}, map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2})
+		So(a.Execute(ctx, inputs[0]), ShouldBeNil)
+		now = now.Add(4 * time.Minute)
+		So(a.Execute(ctx, inputs[1]), ShouldBeNil)
+		now = now.Add(time.Minute)
+		So(a.Execute(ctx, inputs[0]), ShouldBeNil)
+
+		Convey("Should use the current time", func() {
+			So(c.Data(), ShouldHaveLength, 1)
+			So(c.DataPoints()[0].Data["count"], ShouldEqual, 2)
+			So(c.DataPoints()[0].Data["windowEnd"], ShouldEqual, "2017-02-16T12:05:00Z")
+		})
+
+		Convey("Should close the windows when the clock passes their end", func() {
+			So(a.TickInterval(), ShouldEqual, time.Second)
+			So(a.Tick(ctx, now.Add(5*time.Minute)), ShouldBeNil)
+			So(c.Data(), ShouldHaveLength, 2)
+			So(c.DataPoints()[1].Data["windowEnd"], ShouldEqual, "2017-02-16T12:10:00Z")
+		})
+	})
+
+	Convey("Given a data point without the time property", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: AggregateType, Settings: map[string]interface{}{
+			"size":         "1m",
+			"timeProperty": "at",
+			"aggregations": []interface{}{map[string]interface{}{"function": "count"}},
+		}}, activitytest.DataPoints("customers", []string{"id"}, map[string]interface{}{"id": 1}))
+
+		Convey("Should return an error", func() {
+			So(result, activitytest.ShouldFail)
+		})
+	})
+
+	Convey("Given a value that is not a number", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: AggregateType, Settings: map[string]interface{}{
+			"size":         "1m",
+			"aggregations": []interface{}{map[string]interface{}{"function": "sum", "property": "name"}},
+		}}, activitytest.DataPoints("customers", []string{"id"}, map[string]interface{}{"name": "Acme"}))
+
+		Convey("Should return an error", func() {
+			So(result, activitytest.ShouldFail)
+		})
+	})
+
+	invalidSettings := []struct {
+		name     string
+		settings map[string]interface{}
+	}{
+		{"without a size", map[string]interface{}{"aggregations": []interface{}{map[string]interface{}{"function": "count"}}}},
+		{"with an invalid size", map[string]interface{}{"size": "soon", "aggregations": []interface{}{map[string]interface{}{"function": "count"}}}},
+		{"with an unknown window", map[string]interface{}{"window": "session", "size": "1m", "aggregations": []interface{}{map[string]interface{}{"function": "count"}}}},
+		{"without a slide", map[string]interface{}{"window": "sliding", "size": "1m", "aggregations": []interface{}{map[string]interface{}{"function": "count"}}}},
+		{"with a slide longer than the size", map[string]interface{}{"window": "sliding", "size": "1m", "slide": "2m", "aggregations": []interface{}{map[string]interface{}{"function": "count"}}}},
+		{"without aggregations", map[string]interface{}{"size": "1m"}},
+		{"with an unknown function", map[string]interface{}{"size": "1m", "aggregations": []interface{}{map[string]interface{}{"function": "median", "property": "x"}}}},
+		{"with a sum without a property", map[string]interface{}{"size": "1m", "aggregations": []interface{}{map[string]interface{}{"function": "sum"}}}},
+		{"with a duplicate result", map[string]interface{}{"size": "1m", "aggregations": []interface{}{map[string]interface{}{"function": "count"}, map[string]interface{}{"function": "count"}}}},
+	}
+
+	for _, tc := range invalidSettings {
+		Convey("Given settings "+tc.name, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: AggregateType, Settings: tc.settings}, nil)
+
+			Convey("Should return an error", func() {
+				So(result, activitytest.ShouldFail)
+			})
+		})
+	}
+}
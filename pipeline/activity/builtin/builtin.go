@@ -0,0 +1,303 @@
+// Package builtin contains the standard activities that are available to every
+// pipeline.  The activities are registered with the activity package when this
+// package is imported.
+//
+//	import _ "github.com/naveego/api/pipeline/activity/builtin"
+package builtin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/naveego/api/pipeline/activity"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/pipeline/expr"
+	"github.com/naveego/errors"
+)
+
+// The names the built-in activities are registered with.
+const (
+	FilterType      = "filter"
+	ProjectType     = "project"
+	CastType        = "cast"
+	ComputeType     = "compute"
+	SplitType       = "split"
+	FlattenType     = "flatten"
+	DropNullsType   = "drop-nulls"
+	DedupeType      = "dedupe"
+	AggregateType   = "aggregate"
+	LookupType      = "lookup"
+	LookupStoreType = "lookup-store"
+)
+
+func init() {
+	activity.RegisterActivityFactory(FilterType, func() activity.Activity { return &Filter{} })
+	activity.RegisterActivityFactory(ProjectType, func() activity.Activity { return &Project{} })
+	activity.RegisterActivityFactory(CastType, func() activity.Activity { return &Cast{} })
+	activity.RegisterActivityFactory(ComputeType, func() activity.Activity { return &Compute{} })
+	activity.RegisterActivityFactory(SplitType, func() activity.Activity { return &Split{} })
+	activity.RegisterActivityFactory(FlattenType, func() activity.Activity { return &Flatten{} })
+	activity.RegisterActivityFactory(DropNullsType, func() activity.Activity { return &DropNulls{} })
+	activity.RegisterActivityFactory(DedupeType, func() activity.Activity { return NewDedupe() })
+	activity.RegisterActivityFactory(AggregateType, func() activity.Activity { return NewAggregate() })
+	activity.RegisterActivityFactory(LookupType, func() activity.Activity { return &Lookup{} })
+	activity.RegisterActivityFactory(LookupStoreType, func() activity.Activity { return &LookupStore{} })
+}
+
+// settings wraps the settings of an activity and records the first problem
+// found while reading them.
+type settings struct {
+	activityType string
+	values       map[string]interface{}
+	err          error
+}
+
+func newSettings(activityType string, values map[string]interface{}) *settings {
+	return &settings{activityType: activityType, values: values}
+}
+
+func (s *settings) fail(name, format string, args ...interface{}) {
+	s.failWithCode(pipeerrors.InvalidActivitySettings, name, format, args...)
+}
+
+func (s *settings) failWithCode(code int, name, format string, args ...interface{}) {
+	if s.err == nil {
+		s.err = errors.NewWithCode(code, fmt.Sprintf("%s: setting '%s' %s", s.activityType, name, fmt.Sprintf(format, args...)))
+	}
+}
+
+func (s *settings) has(name string) bool {
+	_, ok := s.values[name]
+	return ok
+}
+
+func (s *settings) string(name string, required bool) string {
+	v, ok := s.values[name]
+	if !ok || v == nil {
+		if required {
+			s.fail(name, "is required")
+		}
+		return ""
+	}
+
+	str, ok := v.(string)
+	if !ok {
+		s.fail(name, "must be a string")
+	}
+	return str
+}
+
+func (s *settings) bool(name string) bool {
+	v, ok := s.values[name]
+	if !ok || v == nil {
+		return false
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		s.fail(name, "must be a boolean")
+	}
+	return b
+}
+
+func (s *settings) int(name string) int {
+	v, ok := s.values[name]
+	if !ok || v == nil {
+		return 0
+	}
+
+	switch x := v.(type) {
+	case int:
+		return x
+	case int64:
+		return int(x)
+	case float64:
+		// JSON deserialization always uses float64
+		if x == float64(int(x)) {
+			return int(x)
+		}
+	}
+
+	s.fail(name, "must be an integer")
+	return 0
+}
+
+// expression compiles an expression setting.  Compile errors are
+// reported with the ExpressionCompileError code.
+func (s *settings) expression(name string, required bool) *expr.Program {
+	source := s.string(name, required)
+	if source == "" {
+		return nil
+	}
+
+	p, err := expr.Compile(source)
+	if err != nil {
+		s.failWithCode(pipeerrors.ExpressionCompileError, name, "is invalid: %v", err)
+		return nil
+	}
+	return p
+}
+
+func (s *settings) stringList(name string, required bool) []string {
+	v, ok := s.values[name]
+	if !ok || v == nil {
+		if required {
+			s.fail(name, "is required")
+		}
+		return nil
+	}
+
+	switch x := v.(type) {
+	case []string:
+		return x
+	case []interface{}:
+		list := make([]string, len(x))
+		for i, item := range x {
+			str, ok := item.(string)
+			if !ok {
+				s.fail(name, "must be a list of strings")
+				return nil
+			}
+			list[i] = str
+		}
+		return list
+	}
+
+	s.fail(name, "must be a list of strings")
+	return nil
+}
+
+func (s *settings) stringMap(name string, required bool) map[string]string {
+	v, ok := s.values[name]
+	if !ok || v == nil {
+		if required {
+			s.fail(name, "is required")
+		}
+		return nil
+	}
+
+	switch x := v.(type) {
+	case map[string]string:
+		return x
+	case map[string]interface{}:
+		m := make(map[string]string, len(x))
+		for k, item := range x {
+			str, ok := item.(string)
+			if !ok {
+				s.fail(name, "must be a map of strings")
+				return nil
+			}
+			m[k] = str
+		}
+		return m
+	}
+
+	s.fail(name, "must be a map of strings")
+	return nil
+}
+
+// copyData makes a shallow copy of the data so that activities never modify
+// data that may be shared with other activities.
+func copyData(data map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		out[k] = v
+	}
+	return out
+}
+
+// copyValue makes a deep copy of objects and lists, for values such as reference
+// records that are shared between data points.  Other values are returned as is.
+func copyValue(val interface{}) interface{} {
+	switch x := val.(type) {
+	case map[string]interface{}:
+		if x == nil {
+			return x
+		}
+		out := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			out[k] = copyValue(v)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, v := range x {
+			out[i] = copyValue(v)
+		}
+		return out
+	}
+	return val
+}
+
+// getPath reads a value using a dotted path.  A top level property whose name
+// contains dots takes precedence over nested objects.
+func getPath(data map[string]interface{}, path string) (interface{}, bool) {
+	if val, ok := data[path]; ok {
+		return val, true
+	}
+
+	parts := strings.Split(path, ".")
+	current := data
+	for i, part := range parts {
+		val, ok := current[part]
+		if !ok {
+			return nil, false
+		}
+
+		if i == len(parts)-1 {
+			return val, true
+		}
+
+		current, ok = val.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// setPath writes a value using a dotted path.  Nested objects along the path are
+// copied before they are modified, so data must already be a copy.
+func setPath(data map[string]interface{}, path string, val interface{}) {
+	if _, ok := data[path]; ok || !strings.Contains(path, ".") {
+		data[path] = val
+		return
+	}
+
+	parts := strings.Split(path, ".")
+	current := data
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if ok {
+			next = copyData(next)
+		} else {
+			next = map[string]interface{}{}
+		}
+		current[part] = next
+		current = next
+	}
+	current[parts[len(parts)-1]] = val
+}
+
+// deletePath removes a value using a dotted path.  Like setPath, nested objects
+// along the path are copied before they are modified.
+func deletePath(data map[string]interface{}, path string) {
+	if _, ok := data[path]; ok || !strings.Contains(path, ".") {
+		delete(data, path)
+		return
+	}
+
+	parts := strings.Split(path, ".")
+	current := data
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		next = copyData(next)
+		current[part] = next
+		current = next
+	}
+	delete(current, parts[len(parts)-1])
+}
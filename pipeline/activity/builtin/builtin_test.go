@@ -0,0 +1,44 @@
+package builtin
+
+import (
+	"testing"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegisteredActivities(t *testing.T) {
+
+	Convey("Should register the built-in activities", t, func() {
+		factories := activity.ActivityFactories()
+		for _, name := range []string{FilterType, ProjectType, CastType, ComputeType, SplitType, FlattenType, DropNullsType, DedupeType} {
+			So(factories, ShouldContain, name)
+		}
+	})
+
+	Convey("Given invalid settings for each activity", t, func() {
+		testCases := map[string]map[string]interface{}{
+			FilterType:    {"property": "id", "operator": "like"},
+			ProjectType:   {},
+			CastType:      {"properties": map[string]interface{}{"id": "object"}},
+			ComputeType:   {"property": "full", "value": 1, "copy": "name"},
+			SplitType:     {"routes": "customers"},
+			FlattenType:   {"maxDepth": -1},
+			DropNullsType: {"properties": "name"},
+			DedupeType:    {"window": "soon"},
+		}
+
+		for name, settings := range testCases {
+			result := activitytest.Run(pipeline.Activity{Type: name, Settings: settings}, nil)
+
+			Convey("Should return an invalid settings error for "+name, func() {
+				So(result, activitytest.ShouldFail)
+				So(result.Err.(errors.Error).Code, ShouldEqual, pipeerrors.InvalidActivitySettings)
+			})
+		}
+	})
+}
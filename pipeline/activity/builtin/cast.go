@@ -0,0 +1,104 @@
+package builtin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/pipeline/mapping"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// The values of the onError setting of the cast activity.
+const (
+	CastErrorFail = "fail" // Fail the activity
+	CastErrorNull = "null" // Set the property to null
+	CastErrorKeep = "keep" // Keep the original value
+	CastErrorDrop = "drop" // Drop the data point
+)
+
+// Cast converts properties to another type using the same conversions as
+// the shape mappings.
+//
+// Settings:
+//
+//	properties  a map of property names to the type they are converted to
+//	onError     optional: "fail" (default), "null", "keep" or "drop"
+type Cast struct {
+	Properties map[string]string
+	OnError    string
+
+	names []string
+}
+
+// Init reads the properties and types from the settings.
+func (c *Cast) Init(values map[string]interface{}) error {
+	s := newSettings(CastType, values)
+	c.Properties = s.stringMap("properties", true)
+	c.OnError = s.string("onError", false)
+
+	switch c.OnError {
+	case "":
+		c.OnError = CastErrorFail
+	case CastErrorFail, CastErrorNull, CastErrorKeep, CastErrorDrop:
+	default:
+		s.fail("onError", "has unknown value '%s'", c.OnError)
+	}
+
+	for name, t := range c.Properties {
+		t = strings.ToLower(t)
+		switch t {
+		case pipeline.PropertyTypeString, pipeline.PropertyTypeNumber, pipeline.PropertyTypeBool, pipeline.PropertyTypeDate:
+		default:
+			s.fail("properties", "has unsupported type '%s' for '%s'", t, name)
+		}
+		c.Properties[name] = t
+		c.names = append(c.names, name)
+	}
+	sort.Strings(c.names)
+
+	return s.err
+}
+
+// Execute emits the data point with its properties converted.
+func (c *Cast) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	out, ok, err := c.Apply(dataPoint)
+	if err != nil || !ok {
+		return err
+	}
+	return ctx.OutputCollector.Emit(out)
+}
+
+// Apply returns a copy of the data point with its properties converted.  It
+// returns false if the data point should be dropped.
+func (c *Cast) Apply(dataPoint pipeline.DataPoint) (pipeline.DataPoint, bool, error) {
+	data := copyData(dataPoint.Data)
+
+	for _, name := range c.names {
+		val, ok := getPath(data, name)
+		if !ok || val == nil {
+			continue
+		}
+
+		converted, err := mapping.Convert(val, mapping.TypeOf(val), c.Properties[name])
+		if err != nil {
+			switch c.OnError {
+			case CastErrorNull:
+				converted = nil
+			case CastErrorKeep:
+				continue
+			case CastErrorDrop:
+				return dataPoint, false, nil
+			default:
+				return dataPoint, false, fmt.Errorf("cast: property '%s': %v", name, err)
+			}
+		}
+
+		setPath(data, name, converted)
+	}
+
+	dataPoint.Data = data
+	dataPoint.Shape = pipeline.Shape{}
+	return dataPoint, true, nil
+}
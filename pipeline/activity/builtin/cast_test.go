@@ -0,0 +1,84 @@
+package builtin
+
+import (
+	"testing"
+
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCast(t *testing.T) {
+
+	inputs := activitytest.DataPoints("customers", []string{"id"},
+		map[string]interface{}{"id": "1", "balance": "10.50", "active": "true", "created": "2017-02-16", "name": nil},
+		map[string]interface{}{"id": "2", "balance": "lots", "active": 0, "created": float64(0)},
+	)
+
+	testCases := []struct {
+		name     string
+		settings map[string]interface{}
+		expected []map[string]interface{}
+		fails    bool
+	}{
+		{
+			"Given convertible properties",
+			map[string]interface{}{"properties": map[string]interface{}{"id": "number", "active": "bool", "created": "date", "name": "string"}},
+			[]map[string]interface{}{
+				{"id": float64(1), "balance": "10.50", "active": true, "created": "2017-02-16T00:00:00Z", "name": nil},
+				{"id": float64(2), "balance": "lots", "active": false, "created": "1970-01-01T00:00:00Z"},
+			},
+			false,
+		},
+		{
+			"Given a value that cannot be converted",
+			map[string]interface{}{"properties": map[string]interface{}{"balance": "number"}},
+			nil,
+			true,
+		},
+		{
+			"Given a value that cannot be converted with onError null",
+			map[string]interface{}{"properties": map[string]interface{}{"balance": "number"}, "onError": "null"},
+			[]map[string]interface{}{
+				{"id": "1", "balance": 10.5, "active": "true", "created": "2017-02-16", "name": nil},
+				{"id": "2", "balance": nil, "active": 0, "created": float64(0)},
+			},
+			false,
+		},
+		{
+			"Given a value that cannot be converted with onError keep",
+			map[string]interface{}{"properties": map[string]interface{}{"balance": "number"}, "onError": "keep"},
+			[]map[string]interface{}{
+				{"id": "1", "balance": 10.5, "active": "true", "created": "2017-02-16", "name": nil},
+				{"id": "2", "balance": "lots", "active": 0, "created": float64(0)},
+			},
+			false,
+		},
+		{
+			"Given a value that cannot be converted with onError drop",
+			map[string]interface{}{"properties": map[string]interface{}{"balance": "number"}, "onError": "drop"},
+			[]map[string]interface{}{
+				{"id": "1", "balance": 10.5, "active": "true", "created": "2017-02-16", "name": nil},
+			},
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: CastType, Settings: tc.settings}, inputs)
+
+			if tc.fails {
+				Convey("Should return an error", func() {
+					So(result, activitytest.ShouldFail)
+				})
+				return
+			}
+
+			Convey("Should emit the converted data", func() {
+				So(result, activitytest.ShouldSucceed)
+				So(result.Data(), ShouldResemble, tc.expected)
+			})
+		})
+	}
+}
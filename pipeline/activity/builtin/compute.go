@@ -0,0 +1,176 @@
+package builtin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/pipeline/expr"
+	"github.com/naveego/api/pipeline/mapping"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// Compute adds a computed property to the data point.  Exactly one of value,
+// copy, template or expression must be provided.
+//
+// Settings:
+//
+//	property    the property to set, may be a dotted path
+//	value       a constant value
+//	copy        the property to copy the value from
+//	template    a string with {property} placeholders, for example "{first} {last}".
+//	            Use {{ and }} for literal braces.  Missing and null properties are
+//	            replaced with an empty string.
+//	expression  an expression, for example "round(data.price * data.quantity, 2)".
+//	            Dates are stored as RFC 3339 strings.
+type Compute struct {
+	Property string
+
+	value      interface{}
+	copy       string
+	template   []templatePart
+	expression *expr.Program
+}
+
+type templatePart struct {
+	literal  string
+	property string
+}
+
+// Init reads the property and the computation from the settings.
+func (c *Compute) Init(values map[string]interface{}) error {
+	s := newSettings(ComputeType, values)
+	c.Property = s.string("property", true)
+
+	count := 0
+	if s.has("value") {
+		c.value = values["value"]
+		count++
+	}
+
+	if s.has("copy") {
+		c.copy = s.string("copy", true)
+		count++
+	}
+
+	if s.has("template") {
+		var err error
+		if c.template, err = parseTemplate(s.string("template", true)); err != nil {
+			s.fail("template", "is invalid: %v", err)
+		}
+		count++
+	}
+
+	if s.has("expression") {
+		c.expression = s.expression("expression", true)
+		count++
+	}
+
+	if count != 1 {
+		s.fail("value", "copy, template or expression must be provided, but not more than one")
+	}
+
+	return s.err
+}
+
+// Execute emits the data point with the computed property.
+func (c *Compute) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	out, err := c.Apply(dataPoint)
+	if err != nil {
+		return err
+	}
+	return ctx.OutputCollector.Emit(out)
+}
+
+// Apply returns a copy of the data point with the computed property.
+func (c *Compute) Apply(dataPoint pipeline.DataPoint) (pipeline.DataPoint, error) {
+	data := copyData(dataPoint.Data)
+
+	var val interface{}
+	switch {
+	case c.expression != nil:
+		var err error
+		if val, err = c.expression.Eval(dataPoint); err != nil {
+			return dataPoint, err
+		}
+		if t, ok := val.(time.Time); ok {
+			val = t.Format(time.RFC3339Nano)
+		}
+	case c.template != nil:
+		val = c.render(dataPoint.Data)
+	case c.copy != "":
+		val, _ = getPath(dataPoint.Data, c.copy)
+	default:
+		val = c.value
+	}
+
+	setPath(data, c.Property, val)
+	dataPoint.Data = data
+	dataPoint.Shape = pipeline.Shape{}
+	return dataPoint, nil
+}
+
+func (c *Compute) render(data map[string]interface{}) string {
+	var b strings.Builder
+	for _, part := range c.template {
+		if part.property == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+
+		val, ok := getPath(data, part.property)
+		if !ok || val == nil {
+			continue
+		}
+
+		if str, err := mapping.Convert(val, mapping.TypeOf(val), pipeline.PropertyTypeString); err == nil {
+			b.WriteString(fmt.Sprint(str))
+		} else {
+			b.WriteString(fmt.Sprint(val))
+		}
+	}
+	return b.String()
+}
+
+func parseTemplate(template string) ([]templatePart, error) {
+	parts := []templatePart{}
+	var literal strings.Builder
+
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '{' && i+1 < len(template) && template[i+1] == '{':
+			literal.WriteByte('{')
+			i++
+		case c == '}' && i+1 < len(template) && template[i+1] == '}':
+			literal.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated placeholder at position %d", i)
+			}
+			name := strings.TrimSpace(template[i+1 : i+end])
+			if name == "" {
+				return nil, fmt.Errorf("empty placeholder at position %d", i)
+			}
+			if literal.Len() > 0 {
+				parts = append(parts, templatePart{literal: literal.String()})
+				literal.Reset()
+			}
+			parts = append(parts, templatePart{property: name})
+			i += end
+		case c == '}':
+			return nil, fmt.Errorf("unexpected '}' at position %d", i)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+
+	if literal.Len() > 0 {
+		parts = append(parts, templatePart{literal: literal.String()})
+	}
+
+	return parts, nil
+}
@@ -0,0 +1,93 @@
+package builtin
+
+import (
+	"testing"
+
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompute(t *testing.T) {
+
+	inputs := activitytest.DataPoints("customers", []string{"id"},
+		map[string]interface{}{"id": 1, "first": "Art", "last": "Vandelay", "balance": 10.5},
+		map[string]interface{}{"id": 2, "first": "Kel", "last": nil},
+	)
+
+	testCases := []struct {
+		name     string
+		settings map[string]interface{}
+		expected []interface{}
+	}{
+		{"Given a constant value", map[string]interface{}{"property": "source", "value": "crm"}, []interface{}{"crm", "crm"}},
+		{"Given a property to copy", map[string]interface{}{"property": "source", "copy": "first"}, []interface{}{"Art", "Kel"}},
+		{"Given a template", map[string]interface{}{"property": "source", "template": "{first} {last}"}, []interface{}{"Art Vandelay", "Kel "}},
+		{"Given a template with a number and braces", map[string]interface{}{"property": "source", "template": "{{{id}}}: {balance}"}, []interface{}{"{1}: 10.5", "{2}: "}},
+		{"Given an expression", map[string]interface{}{"property": "source", "expression": "upper(data.first) + ' ' + (data.last ?? '-')"}, []interface{}{"ART Vandelay", "KEL -"}},
+		{"Given an expression on a number", map[string]interface{}{"property": "source", "expression": "round((data.balance ?? 0) * 1.1, 2)"}, []interface{}{11.55, float64(0)}},
+		{"Given an expression that returns a date", map[string]interface{}{"property": "source", "expression": "addDays('2017-02-16T00:00:00Z', data.id)"}, []interface{}{"2017-02-17T00:00:00Z", "2017-02-18T00:00:00Z"}},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: ComputeType, Settings: tc.settings}, inputs)
+			So(result, activitytest.ShouldSucceed)
+
+			Convey("Should set the computed property", func() {
+				actual := []interface{}{}
+				for _, d := range result.Data() {
+					actual = append(actual, d["source"])
+				}
+				So(actual, ShouldResemble, tc.expected)
+			})
+		})
+	}
+
+	Convey("Given a nested target property", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: ComputeType, Settings: map[string]interface{}{"property": "meta.source", "value": "crm"}}, inputs[:1])
+
+		Convey("Should create the nested object", func() {
+			So(result.DataPoints()[0].Data["meta"], ShouldResemble, map[string]interface{}{"source": "crm"})
+		})
+	})
+
+	invalidTemplates := []string{"{first", "first}", "{}"}
+	for _, template := range invalidTemplates {
+		Convey("Given the invalid template "+template, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: ComputeType, Settings: map[string]interface{}{"property": "source", "template": template}}, nil)
+
+			Convey("Should return an error", func() {
+				So(result, activitytest.ShouldFail)
+			})
+		})
+	}
+
+	Convey("Given an invalid expression", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: ComputeType, Settings: map[string]interface{}{"property": "source", "expression": "data.first +"}}, nil)
+
+		Convey("Should return a compile error from Init", func() {
+			So(result, activitytest.ShouldFail)
+			So(result.Err.(errors.Error).Code, ShouldEqual, pipeerrors.ExpressionCompileError)
+		})
+	})
+
+	Convey("Given an expression that fails to evaluate", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: ComputeType, Settings: map[string]interface{}{"property": "source", "expression": "data.first * 2"}}, inputs)
+
+		Convey("Should return an evaluation error from Execute", func() {
+			So(result, activitytest.ShouldFail)
+			So(result.Err.(errors.Error).Code, ShouldEqual, pipeerrors.ExpressionEvalError)
+		})
+	})
+
+	Convey("Given both a value and an expression", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: ComputeType, Settings: map[string]interface{}{"property": "source", "value": 1, "expression": "1"}}, nil)
+
+		Convey("Should return an error", func() {
+			So(result, activitytest.ShouldFail)
+		})
+	})
+}
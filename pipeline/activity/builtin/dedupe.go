@@ -0,0 +1,141 @@
+package builtin
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// DefaultDedupeSize is the number of records remembered by the dedupe
+// activity when no size is configured.
+const DefaultDedupeSize = 10000
+
+// Dedupe drops data points whose record key has already been seen within the
+// window.  The window starts when a record is emitted, duplicates that are
+// dropped do not extend it.  Records are identified by their entity and the
+// values of their key properties.
+//
+// Settings:
+//
+//	keys         optional: the properties that identify a record, defaults to the key names
+//	window       optional: how long a record is remembered, for example "5m"
+//	size         optional: the maximum number of records remembered, defaults to 10000
+//	compareData  optional: only drop duplicates whose data has not changed
+type Dedupe struct {
+	Keys        []string
+	Window      time.Duration
+	Size        int
+	CompareData bool
+
+	mu      sync.Mutex
+	now     func() time.Time
+	order   *list.List // oldest first
+	records map[string]*list.Element
+}
+
+type dedupeRecord struct {
+	key  string
+	seen time.Time
+	hash uint64
+}
+
+// NewDedupe creates a dedupe activity using the default settings.
+func NewDedupe() *Dedupe {
+	return &Dedupe{
+		Size:    DefaultDedupeSize,
+		now:     time.Now,
+		order:   list.New(),
+		records: map[string]*list.Element{},
+	}
+}
+
+// Init reads the window from the settings.
+func (d *Dedupe) Init(values map[string]interface{}) error {
+	s := newSettings(DedupeType, values)
+	d.Keys = s.stringList("keys", false)
+	d.CompareData = s.bool("compareData")
+
+	if window := s.string("window", false); window != "" {
+		var err error
+		if d.Window, err = time.ParseDuration(window); err != nil || d.Window <= 0 {
+			s.fail("window", "must be a positive duration such as '5m'")
+		}
+	}
+
+	if s.has("size") {
+		d.Size = s.int("size")
+		if d.Size <= 0 {
+			s.fail("size", "must be greater than zero")
+		}
+	}
+
+	return s.err
+}
+
+// Execute emits the data point unless it is a duplicate.
+func (d *Dedupe) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	duplicate, err := d.IsDuplicate(dataPoint)
+	if err != nil || duplicate {
+		return err
+	}
+	return ctx.OutputCollector.Emit(dataPoint)
+}
+
+// IsDuplicate returns true if the data point has been seen within the window.
+// Data points that are not duplicates are remembered.
+func (d *Dedupe) IsDuplicate(dataPoint pipeline.DataPoint) (bool, error) {
+	if len(d.Keys) > 0 {
+		dataPoint.KeyNames = d.Keys
+	}
+
+	key, err := dataPoint.RecordKey()
+	if err != nil {
+		return false, err
+	}
+	key = strconv.Quote(dataPoint.Entity) + ":" + key
+
+	var hash uint64
+	if d.CompareData {
+		if hash, err = dataPoint.ContentHash(); err != nil {
+			return false, err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.evict(now)
+
+	if e, ok := d.records[key]; ok {
+		r := e.Value.(*dedupeRecord)
+		if !d.CompareData || r.hash == hash {
+			return true, nil
+		}
+		d.order.Remove(e)
+		delete(d.records, key)
+	}
+
+	d.records[key] = d.order.PushBack(&dedupeRecord{key: key, seen: now, hash: hash})
+	d.evict(now)
+
+	return false, nil
+}
+
+// evict removes the records that are outside of the window and the
+// oldest records when there are too many.
+func (d *Dedupe) evict(now time.Time) {
+	for e := d.order.Front(); e != nil; e = d.order.Front() {
+		r := e.Value.(*dedupeRecord)
+		expired := d.Window > 0 && now.Sub(r.seen) >= d.Window
+		if !expired && d.order.Len() <= d.Size {
+			return
+		}
+		d.order.Remove(e)
+		delete(d.records, r.key)
+	}
+}
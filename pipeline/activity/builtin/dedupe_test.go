@@ -0,0 +1,96 @@
+package builtin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDedupe(t *testing.T) {
+
+	start := time.Date(2017, 2, 16, 12, 0, 0, 0, time.UTC)
+
+	type step struct {
+		after     time.Duration // time since start
+		data      map[string]interface{}
+		duplicate bool
+	}
+
+	testCases := []struct {
+		name     string
+		settings map[string]interface{}
+		steps    []step
+	}{
+		{
+			"Given the default settings",
+			map[string]interface{}{},
+			[]step{
+				{0, map[string]interface{}{"id": 1, "name": "a"}, false},
+				{0, map[string]interface{}{"id": float64(1), "name": "b"}, true},
+				{0, map[string]interface{}{"id": 2, "name": "a"}, false},
+				{time.Hour, map[string]interface{}{"id": 1, "name": "a"}, true},
+			},
+		},
+		{
+			"Given a window",
+			map[string]interface{}{"window": "5m"},
+			[]step{
+				{0, map[string]interface{}{"id": 1}, false},
+				{4 * time.Minute, map[string]interface{}{"id": 1}, true},
+				{5 * time.Minute, map[string]interface{}{"id": 1}, false},
+				{6 * time.Minute, map[string]interface{}{"id": 1}, true},
+			},
+		},
+		{
+			"Given a size",
+			map[string]interface{}{"size": float64(2)},
+			[]step{
+				{0, map[string]interface{}{"id": 1}, false},
+				{0, map[string]interface{}{"id": 2}, false},
+				{0, map[string]interface{}{"id": 3}, false},
+				{0, map[string]interface{}{"id": 3}, true},
+				{0, map[string]interface{}{"id": 1}, false},
+			},
+		},
+		{
+			"Given keys and compareData",
+			map[string]interface{}{"keys": []interface{}{"email"}, "compareData": true},
+			[]step{
+				{0, map[string]interface{}{"id": 1, "email": "a@example.com"}, false},
+				{0, map[string]interface{}{"id": 1, "email": "a@example.com"}, true},
+				{0, map[string]interface{}{"id": 2, "email": "a@example.com"}, false},
+				{0, map[string]interface{}{"id": 2, "email": "a@example.com"}, true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			now := start
+			d := NewDedupe()
+			d.now = func() time.Time { return now }
+			So(d.Init(tc.settings), ShouldBeNil)
+
+			Convey("Should only drop the duplicates", func() {
+				for _, s := range tc.steps {
+					now = start.Add(s.after)
+					dp := pipeline.DataPoint{Entity: "customers", KeyNames: []string{"id"}, Data: s.data}
+					duplicate, err := d.IsDuplicate(dp)
+					So(err, ShouldBeNil)
+					So(duplicate, ShouldEqual, s.duplicate)
+				}
+			})
+		})
+	}
+
+	Convey("Given a data point without the key", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: DedupeType, Settings: map[string]interface{}{}}, activitytest.DataPoints("customers", []string{"id"}, map[string]interface{}{"name": "a"}))
+
+		Convey("Should return an error", func() {
+			So(result, activitytest.ShouldFail)
+		})
+	})
+}
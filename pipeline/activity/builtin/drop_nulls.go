@@ -0,0 +1,66 @@
+package builtin
+
+import (
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// DropNulls removes properties with null values from the data point.
+//
+// Settings:
+//
+//	properties  optional: only these properties are removed when null
+//	recursive   optional: also remove null values from nested objects
+type DropNulls struct {
+	Properties []string
+	Recursive  bool
+}
+
+// Init reads the properties from the settings.
+func (d *DropNulls) Init(values map[string]interface{}) error {
+	s := newSettings(DropNullsType, values)
+	d.Properties = s.stringList("properties", false)
+	d.Recursive = s.bool("recursive")
+	return s.err
+}
+
+// Execute emits the data point without its null properties.
+func (d *DropNulls) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	return ctx.OutputCollector.Emit(d.Apply(dataPoint))
+}
+
+// Apply returns a copy of the data point without its null properties.
+func (d *DropNulls) Apply(dataPoint pipeline.DataPoint) pipeline.DataPoint {
+	var data map[string]interface{}
+
+	if len(d.Properties) > 0 {
+		data = copyData(dataPoint.Data)
+		for _, name := range d.Properties {
+			if val, ok := getPath(data, name); ok && val == nil {
+				deletePath(data, name)
+			}
+		}
+	} else {
+		data = d.dropNulls(dataPoint.Data)
+	}
+
+	dataPoint.Data = data
+	dataPoint.Shape = pipeline.Shape{}
+	return dataPoint
+}
+
+func (d *DropNulls) dropNulls(data map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if v == nil {
+			continue
+		}
+
+		if nested, ok := v.(map[string]interface{}); ok && d.Recursive {
+			v = d.dropNulls(nested)
+		}
+
+		out[k] = v
+	}
+	return out
+}
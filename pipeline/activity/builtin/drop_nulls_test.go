@@ -0,0 +1,59 @@
+package builtin
+
+import (
+	"testing"
+
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDropNulls(t *testing.T) {
+
+	input := pipeline.DataPoint{
+		Data: map[string]interface{}{
+			"id":      1,
+			"name":    nil,
+			"email":   nil,
+			"address": map[string]interface{}{"city": "Erie", "zip": nil},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		settings map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			"Given the default settings",
+			map[string]interface{}{},
+			map[string]interface{}{"id": 1, "address": map[string]interface{}{"city": "Erie", "zip": nil}},
+		},
+		{
+			"Given recursive",
+			map[string]interface{}{"recursive": true},
+			map[string]interface{}{"id": 1, "address": map[string]interface{}{"city": "Erie"}},
+		},
+		{
+			"Given a list of properties",
+			map[string]interface{}{"properties": []interface{}{"name", "address.zip", "id"}},
+			map[string]interface{}{"id": 1, "email": nil, "address": map[string]interface{}{"city": "Erie"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: DropNullsType, Settings: tc.settings}, []pipeline.DataPoint{input})
+
+			Convey("Should remove the null properties", func() {
+				So(result, activitytest.ShouldSucceed)
+				So(result.DataPoints()[0].Data, ShouldResemble, tc.expected)
+			})
+
+			Convey("Should not modify the input", func() {
+				So(input.Data["address"], ShouldResemble, map[string]interface{}{"city": "Erie", "zip": nil})
+				So(len(input.Data), ShouldEqual, 4)
+			})
+		})
+	}
+}
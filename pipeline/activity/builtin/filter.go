@@ -0,0 +1,256 @@
+package builtin
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/pipeline/expr"
+	"github.com/naveego/api/pipeline/mapping"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// The operators supported by filter conditions.
+const (
+	OpEqual          = "eq"
+	OpNotEqual       = "ne"
+	OpGreater        = "gt"
+	OpGreaterOrEqual = "gte"
+	OpLess           = "lt"
+	OpLessOrEqual    = "lte"
+	OpIn             = "in"
+	OpContains       = "contains"
+	OpExists         = "exists"
+	OpMissing        = "missing"
+)
+
+// Condition is a single predicate of a filter.
+type Condition struct {
+	Property string      `json:"property"`        // The property to test, may be a dotted path
+	Operator string      `json:"operator"`        // One of the Op constants
+	Value    interface{} `json:"value,omitempty"` // The value to compare with
+}
+
+// Filter only emits the data points that match its conditions and expression.
+//
+// Settings:
+//
+//	property, operator, value  a single condition
+//	conditions                 a list of conditions, each with property, operator and value
+//	match                      "all" (default) or "any" of the conditions must match
+//	expression                 an expression that must also evaluate to true,
+//	                           for example "data.balance > 100 && entity == 'customers'"
+type Filter struct {
+	Conditions []Condition
+	MatchAny   bool
+	Expression *expr.Program
+}
+
+// Init reads the conditions from the settings.
+func (f *Filter) Init(values map[string]interface{}) error {
+	s := newSettings(FilterType, values)
+
+	if s.has("property") {
+		f.Conditions = append(f.Conditions, Condition{
+			Property: s.string("property", true),
+			Operator: s.string("operator", true),
+			Value:    values["value"],
+		})
+	}
+
+	if list, ok := values["conditions"].([]interface{}); ok {
+		for i, item := range list {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				s.fail("conditions", "must be a list of objects")
+				break
+			}
+			c := newSettings(FilterType, m)
+			f.Conditions = append(f.Conditions, Condition{
+				Property: c.string("property", true),
+				Operator: c.string("operator", true),
+				Value:    m["value"],
+			})
+			if c.err != nil {
+				s.fail(fmt.Sprintf("conditions[%d]", i), "is invalid: %v", c.err)
+			}
+		}
+	} else if s.has("conditions") {
+		s.fail("conditions", "must be a list of objects")
+	}
+
+	switch match := s.string("match", false); match {
+	case "", "all":
+	case "any":
+		f.MatchAny = true
+	default:
+		s.fail("match", "must be 'all' or 'any' not '%s'", match)
+	}
+
+	f.Expression = s.expression("expression", false)
+
+	if s.err == nil && len(f.Conditions) == 0 && f.Expression == nil {
+		s.fail("conditions", "or 'expression' is required")
+	}
+
+	for i, c := range f.Conditions {
+		if !isOperator(c.Operator) {
+			s.fail(fmt.Sprintf("conditions[%d].operator", i), "has unknown operator '%s'", c.Operator)
+		}
+	}
+
+	return s.err
+}
+
+// Execute emits the data point if it matches the filter.
+func (f *Filter) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	matched, err := f.MatchDataPoint(dataPoint)
+	if err != nil || !matched {
+		return err
+	}
+	return ctx.OutputCollector.Emit(dataPoint)
+}
+
+// MatchDataPoint returns true if the data point matches the conditions and the
+// expression of the filter.
+func (f *Filter) MatchDataPoint(dataPoint pipeline.DataPoint) (bool, error) {
+	if len(f.Conditions) > 0 && !f.Match(dataPoint.Data) {
+		return false, nil
+	}
+
+	if f.Expression != nil {
+		return f.Expression.EvalBool(dataPoint)
+	}
+
+	return true, nil
+}
+
+// Match returns true if the data matches the conditions of the filter.
+func (f *Filter) Match(data map[string]interface{}) bool {
+	for _, c := range f.Conditions {
+		matched := c.Match(data)
+		if matched && f.MatchAny {
+			return true
+		}
+		if !matched && !f.MatchAny {
+			return false
+		}
+	}
+	return !f.MatchAny
+}
+
+// Match returns true if the data matches the condition.
+func (c Condition) Match(data map[string]interface{}) bool {
+	val, ok := getPath(data, c.Property)
+
+	switch c.Operator {
+	case OpExists:
+		return ok
+	case OpMissing:
+		return !ok
+	case OpEqual:
+		return equalValues(val, c.Value)
+	case OpNotEqual:
+		return !equalValues(val, c.Value)
+	case OpIn:
+		return containsValue(c.Value, val)
+	case OpContains:
+		if str, ok := val.(string); ok {
+			sub, ok := c.Value.(string)
+			return ok && strings.Contains(str, sub)
+		}
+		return containsValue(val, c.Value)
+	}
+
+	cmp, ok := compareValues(val, c.Value)
+	if !ok {
+		return false
+	}
+
+	switch c.Operator {
+	case OpGreater:
+		return cmp > 0
+	case OpGreaterOrEqual:
+		return cmp >= 0
+	case OpLess:
+		return cmp < 0
+	case OpLessOrEqual:
+		return cmp <= 0
+	}
+
+	return false
+}
+
+func isOperator(op string) bool {
+	switch op {
+	case OpEqual, OpNotEqual, OpGreater, OpGreaterOrEqual, OpLess, OpLessOrEqual,
+		OpIn, OpContains, OpExists, OpMissing:
+		return true
+	}
+	return false
+}
+
+// equalValues compares values so that numbers of different types are equal.
+func equalValues(a, b interface{}) bool {
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders two numbers, two dates or two strings.  Strings that
+// both contain dates are compared as dates.
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := mapping.AsNumber(a); ok {
+		y, ok := mapping.AsNumber(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	x, ok := a.(string)
+	if !ok {
+		return 0, false
+	}
+	y, ok := b.(string)
+	if !ok {
+		return 0, false
+	}
+
+	if tx, ok := mapping.ParseDate(x); ok {
+		if ty, ok := mapping.ParseDate(y); ok {
+			switch {
+			case tx.Before(ty):
+				return -1, true
+			case tx.After(ty):
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	return strings.Compare(x, y), true
+}
+
+// containsValue returns true if list is a list that contains val.
+func containsValue(list, val interface{}) bool {
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return false
+	}
+
+	for i := 0; i < rv.Len(); i++ {
+		if equalValues(rv.Index(i).Interface(), val) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package builtin
+
+import (
+	"testing"
+
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFilter(t *testing.T) {
+
+	inputs := activitytest.DataPoints("customers", []string{"id"},
+		map[string]interface{}{"id": 1, "name": "Acme", "balance": 10.5, "created": "2017-01-01", "tags": []interface{}{"a", "b"}},
+		map[string]interface{}{"id": 2, "name": "Globex", "balance": float64(100), "created": "2017-06-01T10:00:00Z", "address": map[string]interface{}{"city": "Erie"}},
+		map[string]interface{}{"id": 3, "name": nil, "balance": int64(-5)},
+	)
+
+	testCases := []struct {
+		name     string
+		settings map[string]interface{}
+		expected []interface{}
+	}{
+		{"Given an equal condition on a number", map[string]interface{}{"property": "id", "operator": "eq", "value": float64(2)}, []interface{}{2}},
+		{"Given a not equal condition", map[string]interface{}{"property": "id", "operator": "ne", "value": 2}, []interface{}{1, 3}},
+		{"Given a greater than condition", map[string]interface{}{"property": "balance", "operator": "gt", "value": 10}, []interface{}{1, 2}},
+		{"Given a less than or equal condition", map[string]interface{}{"property": "balance", "operator": "lte", "value": 10.5}, []interface{}{1, 3}},
+		{"Given a date comparison", map[string]interface{}{"property": "created", "operator": "gte", "value": "2017-03-01"}, []interface{}{2}},
+		{"Given an in condition", map[string]interface{}{"property": "name", "operator": "in", "value": []interface{}{"Acme", "Initech"}}, []interface{}{1}},
+		{"Given a contains condition on a string", map[string]interface{}{"property": "name", "operator": "contains", "value": "lob"}, []interface{}{2}},
+		{"Given a contains condition on a list", map[string]interface{}{"property": "tags", "operator": "contains", "value": "b"}, []interface{}{1}},
+		{"Given an exists condition on a nested property", map[string]interface{}{"property": "address.city", "operator": "exists"}, []interface{}{2}},
+		{"Given a missing condition", map[string]interface{}{"property": "created", "operator": "missing"}, []interface{}{3}},
+		{"Given an equal condition on null", map[string]interface{}{"property": "name", "operator": "eq", "value": nil}, []interface{}{3}},
+		{
+			"Given conditions that must all match",
+			map[string]interface{}{"conditions": []interface{}{
+				map[string]interface{}{"property": "balance", "operator": "gt", "value": 0},
+				map[string]interface{}{"property": "name", "operator": "ne", "value": "Acme"},
+			}},
+			[]interface{}{2},
+		},
+		{
+			"Given conditions where any may match",
+			map[string]interface{}{"match": "any", "conditions": []interface{}{
+				map[string]interface{}{"property": "id", "operator": "eq", "value": 1},
+				map[string]interface{}{"property": "id", "operator": "eq", "value": 3},
+			}},
+			[]interface{}{1, 3},
+		},
+		{"Given an expression", map[string]interface{}{"expression": "data.balance > 0 && entity == 'customers'"}, []interface{}{1, 2}},
+		{"Given an expression with null-safe navigation", map[string]interface{}{"expression": "lower(data.address?.city ?? '') == 'erie'"}, []interface{}{2}},
+		{
+			"Given a condition and an expression",
+			map[string]interface{}{"property": "balance", "operator": "gt", "value": 0, "expression": "startsWith(data.name, 'G')"},
+			[]interface{}{2},
+		},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: FilterType, Settings: tc.settings}, inputs)
+
+			Convey("Should only emit the matching data points", func() {
+				So(result, activitytest.ShouldSucceed)
+				ids := []interface{}{}
+				for _, d := range result.Data() {
+					ids = append(ids, d["id"])
+				}
+				So(ids, ShouldResemble, tc.expected)
+			})
+		})
+	}
+
+	Convey("Given an invalid expression", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: FilterType, Settings: map[string]interface{}{"expression": "data.balance >"}}, nil)
+
+		Convey("Should return a compile error from Init", func() {
+			So(result, activitytest.ShouldFail)
+			So(result.Err.(errors.Error).Code, ShouldEqual, pipeerrors.ExpressionCompileError)
+			So(result.Err.Error(), ShouldContainSubstring, "setting 'expression'")
+		})
+	})
+
+	Convey("Given an expression that does not return a boolean", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: FilterType, Settings: map[string]interface{}{"expression": "data.balance"}}, inputs)
+
+		Convey("Should return an evaluation error from Execute", func() {
+			So(result, activitytest.ShouldFail)
+		})
+	})
+
+	Convey("Given neither conditions nor an expression", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: FilterType, Settings: map[string]interface{}{}}, nil)
+
+		Convey("Should return an error", func() {
+			So(result, activitytest.ShouldFail)
+			So(result.Err.(errors.Error).Code, ShouldEqual, pipeerrors.InvalidActivitySettings)
+		})
+	})
+}
@@ -0,0 +1,67 @@
+package builtin
+
+import (
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// Flatten replaces nested objects with top level properties whose names are
+// the path to the nested value, for example {"address": {"city": "Erie"}}
+// becomes {"address.city": "Erie"}.  Lists are not flattened.
+//
+// Settings:
+//
+//	separator  optional: the separator used to join the names, defaults to "."
+//	maxDepth   optional: the number of levels to flatten, defaults to all levels
+type Flatten struct {
+	Separator string
+	MaxDepth  int
+}
+
+// Init reads the separator and depth from the settings.
+func (f *Flatten) Init(values map[string]interface{}) error {
+	s := newSettings(FlattenType, values)
+	f.Separator = s.string("separator", false)
+	f.MaxDepth = s.int("maxDepth")
+
+	if f.Separator == "" {
+		f.Separator = "."
+	}
+
+	if f.MaxDepth < 0 {
+		s.fail("maxDepth", "must not be negative")
+	}
+
+	return s.err
+}
+
+// Execute emits the flattened data point.
+func (f *Flatten) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	return ctx.OutputCollector.Emit(f.Apply(dataPoint))
+}
+
+// Apply returns a copy of the data point with its data flattened.
+func (f *Flatten) Apply(dataPoint pipeline.DataPoint) pipeline.DataPoint {
+	data := make(map[string]interface{}, len(dataPoint.Data))
+	f.flatten(data, "", dataPoint.Data, 0)
+
+	dataPoint.Data = data
+	dataPoint.Shape = pipeline.Shape{}
+	return dataPoint
+}
+
+func (f *Flatten) flatten(out map[string]interface{}, prefix string, data map[string]interface{}, depth int) {
+	for k, v := range data {
+		name := k
+		if prefix != "" {
+			name = prefix + f.Separator + k
+		}
+
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 && (f.MaxDepth == 0 || depth < f.MaxDepth) {
+			f.flatten(out, name, nested, depth+1)
+			continue
+		}
+
+		out[name] = v
+	}
+}
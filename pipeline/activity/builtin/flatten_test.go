@@ -0,0 +1,77 @@
+package builtin
+
+import (
+	"testing"
+
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFlatten(t *testing.T) {
+
+	input := pipeline.DataPoint{
+		Data: map[string]interface{}{
+			"id": 1,
+			"address": map[string]interface{}{
+				"city": "Erie",
+				"geo":  map[string]interface{}{"lat": 42.1, "lng": -80.1},
+			},
+			"tags":  []interface{}{map[string]interface{}{"name": "a"}},
+			"empty": map[string]interface{}{},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		settings map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			"Given the default settings",
+			map[string]interface{}{},
+			map[string]interface{}{
+				"id":              1,
+				"address.city":    "Erie",
+				"address.geo.lat": 42.1,
+				"address.geo.lng": -80.1,
+				"tags":            []interface{}{map[string]interface{}{"name": "a"}},
+				"empty":           map[string]interface{}{},
+			},
+		},
+		{
+			"Given a separator",
+			map[string]interface{}{"separator": "_"},
+			map[string]interface{}{
+				"id":              1,
+				"address_city":    "Erie",
+				"address_geo_lat": 42.1,
+				"address_geo_lng": -80.1,
+				"tags":            []interface{}{map[string]interface{}{"name": "a"}},
+				"empty":           map[string]interface{}{},
+			},
+		},
+		{
+			"Given a maximum depth",
+			map[string]interface{}{"maxDepth": float64(1)},
+			map[string]interface{}{
+				"id":           1,
+				"address.city": "Erie",
+				"address.geo":  map[string]interface{}{"lat": 42.1, "lng": -80.1},
+				"tags":         []interface{}{map[string]interface{}{"name": "a"}},
+				"empty":        map[string]interface{}{},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: FlattenType, Settings: tc.settings}, []pipeline.DataPoint{input})
+
+			Convey("Should emit the flattened data", func() {
+				So(result, activitytest.ShouldSucceed)
+				So(result.DataPoints()[0].Data, ShouldResemble, tc.expected)
+			})
+		})
+	}
+}
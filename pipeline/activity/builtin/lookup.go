@@ -0,0 +1,287 @@
+package builtin
+
+import (
+	"container/list"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// The ways the lookup activity handles data points without a reference record.
+const (
+	LookupMissPass    = "pass"
+	LookupMissDrop    = "drop"
+	LookupMissDefault = "default"
+)
+
+// DefaultLookupCacheBytes is the approximate amount of memory the lookup cache
+// may use when no limit is configured.
+const DefaultLookupCacheBytes = 10 << 20
+
+// Lookup enriches data points with a reference record that is found using the
+// value of a key property.  The whole record is stored in the "as" property, or
+// the fields of the record are copied to the properties configured in "fields".
+//
+// Settings:
+//
+//	key            the property with the key of the reference record
+//	source         "file", "memory", "http" or a source registered with RegisterLookupSource.
+//	               The other settings of the source are read from the activity settings.
+//	as             the property the reference record is stored in
+//	fields         a map of properties to the fields of the reference record, used instead of as
+//	onMiss         optional: "pass" (default), "drop" or "default"
+//	default        the reference record used when onMiss is "default"
+//	cacheTTL       optional: how long reference records and misses are cached, for
+//	               example "10m".  Cached records do not expire by default.
+//	cacheMaxBytes  optional: the approximate memory used by the cache, defaults to 10 MB
+//	               when only cacheTTL is set.  Records are not cached unless cacheTTL
+//	               or cacheMaxBytes is set.
+//
+// The reference records are copied into the data points, so they can be modified
+// by later activities.
+type Lookup struct {
+	Key     string
+	Source  LookupSource
+	As      string
+	Fields  map[string]string
+	OnMiss  string
+	Default map[string]interface{}
+
+	cache *lookupCache
+}
+
+// Init reads the settings and creates the lookup source.
+func (l *Lookup) Init(values map[string]interface{}) error {
+	s := newSettings(LookupType, values)
+	l.Key = s.string("key", true)
+	l.As = s.string("as", false)
+	l.Fields = s.stringMap("fields", false)
+
+	if s.err == nil && (l.As == "") == (len(l.Fields) == 0) {
+		s.fail("as", "or 'fields' must be provided, but not both")
+	}
+
+	switch l.OnMiss = s.string("onMiss", false); l.OnMiss {
+	case "":
+		l.OnMiss = LookupMissPass
+	case LookupMissPass, LookupMissDrop:
+	case LookupMissDefault:
+		var ok bool
+		if l.Default, ok = values["default"].(map[string]interface{}); !ok {
+			s.fail("default", "must be an object when onMiss is 'default'")
+		}
+	default:
+		s.fail("onMiss", "must be '%s', '%s' or '%s' not '%s'", LookupMissPass, LookupMissDrop, LookupMissDefault, l.OnMiss)
+	}
+
+	var ttl time.Duration
+	if str := s.string("cacheTTL", false); str != "" {
+		var err error
+		if ttl, err = time.ParseDuration(str); err != nil || ttl <= 0 {
+			s.fail("cacheTTL", "must be a positive duration such as '10m'")
+		}
+	}
+
+	maxBytes := DefaultLookupCacheBytes
+	if s.has("cacheMaxBytes") {
+		if maxBytes = s.int("cacheMaxBytes"); maxBytes <= 0 {
+			s.fail("cacheMaxBytes", "must be greater than zero")
+		}
+	}
+
+	if ttl > 0 || s.has("cacheMaxBytes") {
+		l.cache = newLookupCache(ttl, maxBytes)
+	}
+
+	source := s.string("source", true)
+	if s.err != nil {
+		return s.err
+	}
+
+	factory, err := GetLookupSourceFactory(source)
+	if err != nil {
+		s.fail("source", "has unknown source '%s'", source)
+		return s.err
+	}
+
+	l.Source, err = factory(values)
+	return err
+}
+
+// Close closes the lookup source if it implements io.Closer.
+func (l *Lookup) Close() error {
+	if closer, ok := l.Source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Execute enriches the data point and emits it, unless it has no reference
+// record and misses are dropped.
+func (l *Lookup) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	out, ok, err := l.Enrich(dataPoint)
+	if err != nil || !ok {
+		return err
+	}
+	return ctx.OutputCollector.Emit(out)
+}
+
+// Enrich returns a copy of the data point with a copy of the reference record.
+// It returns false if the data point should be dropped.
+func (l *Lookup) Enrich(dataPoint pipeline.DataPoint) (pipeline.DataPoint, bool, error) {
+	var record map[string]interface{}
+	found := false
+
+	val, _ := getPath(dataPoint.Data, l.Key)
+	if key, ok := lookupKey(val); ok {
+		var err error
+		if record, found, err = l.lookup(key); err != nil {
+			return dataPoint, false, err
+		}
+	}
+
+	if !found {
+		switch l.OnMiss {
+		case LookupMissDrop:
+			return dataPoint, false, nil
+		case LookupMissPass:
+			return dataPoint, true, nil
+		}
+		record = l.Default
+	}
+
+	data := copyData(dataPoint.Data)
+	if l.As != "" {
+		setPath(data, l.As, copyValue(record))
+	}
+	for property, field := range l.Fields {
+		v, _ := getPath(record, field)
+		setPath(data, property, copyValue(v))
+	}
+
+	dataPoint.Data = data
+	dataPoint.Shape = pipeline.Shape{}
+	return dataPoint, true, nil
+}
+
+func (l *Lookup) lookup(key string) (map[string]interface{}, bool, error) {
+	if l.cache != nil {
+		if record, found, ok := l.cache.get(key); ok {
+			return record, found, nil
+		}
+	}
+
+	record, found, err := l.Source.Lookup(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if l.cache != nil {
+		l.cache.put(key, record, found)
+	}
+	return record, found, nil
+}
+
+// lookupCache is a least recently used cache of reference records whose entries
+// expire after the TTL, if it is not zero.  The size of an entry is estimated
+// from the length of its JSON.
+type lookupCache struct {
+	ttl      time.Duration
+	maxBytes int
+
+	mu      sync.Mutex
+	now     func() time.Time
+	size    int
+	order   *list.List // least recently used first
+	entries map[string]*list.Element
+}
+
+type lookupCacheEntry struct {
+	key     string
+	record  map[string]interface{}
+	found   bool
+	expires time.Time
+	size    int
+}
+
+// lookupCacheOverhead is the estimated memory used by an entry in addition to
+// its key and record.
+const lookupCacheOverhead = 128
+
+func newLookupCache(ttl time.Duration, maxBytes int) *lookupCache {
+	return &lookupCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		now:      time.Now,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// get returns the cached record for the key.  ok is false if the key is not
+// cached or the entry has expired.
+func (c *lookupCache) get(key string) (record map[string]interface{}, found, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+
+	entry := e.Value.(*lookupCacheEntry)
+	if c.ttl > 0 && !c.now().Before(entry.expires) {
+		c.remove(e)
+		return nil, false, false
+	}
+
+	c.order.MoveToBack(e)
+	return entry.record, entry.found, true
+}
+
+// put caches the record, evicting the least recently used entries if the cache
+// is full.  Records that are larger than the cache are not cached.
+func (c *lookupCache) put(key string, record map[string]interface{}, found bool) {
+	size := len(key) + lookupCacheOverhead
+	if record != nil {
+		b, err := json.Marshal(record)
+		if err != nil {
+			return
+		}
+		size += len(b)
+	}
+
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+
+	c.entries[key] = c.order.PushBack(&lookupCacheEntry{
+		key:     key,
+		record:  record,
+		found:   found,
+		expires: c.now().Add(c.ttl),
+		size:    size,
+	})
+	c.size += size
+
+	for c.size > c.maxBytes {
+		c.remove(c.order.Front())
+	}
+}
+
+func (c *lookupCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*lookupCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
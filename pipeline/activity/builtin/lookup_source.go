@@ -0,0 +1,422 @@
+package builtin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naveego/api/pipeline/activity"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/pipeline/mapping"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+)
+
+// The names the built-in lookup sources are registered with.
+const (
+	FileLookupSource   = "file"
+	MemoryLookupSource = "memory"
+	HTTPLookupSource   = "http"
+)
+
+// DefaultHTTPLookupTimeout is the time allowed for a request by the http lookup
+// source when no timeout is configured.
+const DefaultHTTPLookupTimeout = 10 * time.Second
+
+var (
+	lookupSourcesMU sync.RWMutex
+	lookupSources   = make(map[string]LookupSourceFactory)
+
+	memoryTablesMU sync.Mutex
+	memoryTables   = make(map[string]*MemoryTable)
+)
+
+// LookupSource provides the reference records used by the lookup activity.
+// Lookup returns found == false when there is no record with the key.  It may
+// be called concurrently.
+type LookupSource interface {
+	Lookup(key string) (record map[string]interface{}, found bool, err error)
+}
+
+// LookupSourceFactory creates a lookup source from the settings of the lookup
+// activity.
+type LookupSourceFactory func(settings map[string]interface{}) (LookupSource, error)
+
+func init() {
+	RegisterLookupSource(FileLookupSource, newFileSource)
+	RegisterLookupSource(MemoryLookupSource, newMemorySource)
+	RegisterLookupSource(HTTPLookupSource, newHTTPSource)
+}
+
+// RegisterLookupSource registers a lookup source so it can be used by
+// the lookup activity.
+func RegisterLookupSource(name string, factory LookupSourceFactory) {
+	lookupSourcesMU.Lock()
+	defer lookupSourcesMU.Unlock()
+
+	if factory == nil {
+		panic("pipeline: lookup source factory is nil")
+	}
+
+	if _, dup := lookupSources[name]; dup {
+		panic("pipeline: there is already a lookup source registered with name " + name)
+	}
+
+	lookupSources[name] = factory
+}
+
+// GetLookupSourceFactory returns the lookup source registered with the given name.
+func GetLookupSourceFactory(name string) (LookupSourceFactory, error) {
+	lookupSourcesMU.RLock()
+	defer lookupSourcesMU.RUnlock()
+
+	factory, ok := lookupSources[name]
+	if !ok {
+		return nil, errors.NewWithCode(pipeerrors.InvalidActivitySettings, "pipeline: could not find lookup source with name "+name)
+	}
+
+	return factory, nil
+}
+
+// LookupSources returns a sorted list of the names of the registered lookup sources.
+func LookupSources() []string {
+	lookupSourcesMU.RLock()
+	defer lookupSourcesMU.RUnlock()
+
+	var list []string
+	for name := range lookupSources {
+		list = append(list, name)
+	}
+
+	sort.Strings(list)
+	return list
+}
+
+// lookupKey converts a value to the key used to find reference records, so that
+// the number 1 and the string "1" find the same record.  Null values and
+// objects have no key.
+func lookupKey(val interface{}) (string, bool) {
+	switch x := val.(type) {
+	case nil:
+		return "", false
+	case string:
+		return x, true
+	case bool:
+		return strconv.FormatBool(x), true
+	}
+
+	if f, ok := mapping.AsNumber(val); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64), true
+	}
+
+	return "", false
+}
+
+// tableSource is a lookup source for reference records that are held in memory.
+type tableSource map[string]map[string]interface{}
+
+func (t tableSource) Lookup(key string) (map[string]interface{}, bool, error) {
+	record, ok := t[key]
+	return record, ok, nil
+}
+
+// newFileSource loads all of the reference records from a CSV or JSON file
+// when the activity is initialized.  CSV files must have a header row, JSON files
+// must contain a list of objects.
+//
+// Settings:
+//
+//	path      the path of the file
+//	format    optional: "csv" or "json", defaults to the extension of the path
+//	keyField  the field of the reference records that contains the key
+func newFileSource(values map[string]interface{}) (LookupSource, error) {
+	s := newSettings(LookupType, values)
+	path := s.string("path", true)
+	keyField := s.string("keyField", true)
+	format := s.string("format", false)
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		s.fail("path", "could not be opened: %v", err)
+		return nil, s.err
+	}
+	defer f.Close()
+
+	var records []map[string]interface{}
+	switch format {
+	case "csv":
+		records, err = readCSVRecords(f)
+	case "json":
+		err = json.NewDecoder(f).Decode(&records)
+	default:
+		s.fail("format", "must be 'csv' or 'json' not '%s'", format)
+		return nil, s.err
+	}
+	if err != nil {
+		s.fail("path", "could not be read: %v", err)
+		return nil, s.err
+	}
+
+	table := tableSource{}
+	for _, r := range records {
+		if key, ok := lookupKey(r[keyField]); ok {
+			table[key] = r
+		}
+	}
+
+	return table, nil
+}
+
+func readCSVRecords(r io.Reader) ([]map[string]interface{}, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+
+	header := rows[0]
+	records := make([]map[string]interface{}, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			record[name] = row[i]
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// MemoryTable is a named table of reference records that is shared by the
+// pipelines running in the process.  Tables are populated by the lookup-store
+// activity and read by the memory lookup source.  A table and its records are
+// released when every activity that opened it has been closed.
+type MemoryTable struct {
+	name string
+	refs int // Guarded by memoryTablesMU
+
+	mu      sync.RWMutex
+	records map[string]map[string]interface{}
+}
+
+// OpenMemoryTable returns the memory table with the given name, creating it if
+// it does not exist.  Every call must be matched by a call to Close.
+func OpenMemoryTable(name string) *MemoryTable {
+	memoryTablesMU.Lock()
+	defer memoryTablesMU.Unlock()
+
+	t, ok := memoryTables[name]
+	if !ok {
+		t = &MemoryTable{name: name, records: map[string]map[string]interface{}{}}
+		memoryTables[name] = t
+	}
+	t.refs++
+	return t
+}
+
+// Close releases the table opened with OpenMemoryTable.  The records are
+// released when the table has been closed as many times as it was opened.
+func (t *MemoryTable) Close() error {
+	memoryTablesMU.Lock()
+	defer memoryTablesMU.Unlock()
+
+	if t.refs <= 0 {
+		return nil
+	}
+
+	t.refs--
+	if t.refs == 0 {
+		delete(memoryTables, t.name)
+
+		t.mu.Lock()
+		t.records = map[string]map[string]interface{}{}
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// Put stores a record in the table, replacing any record with the same key.
+func (t *MemoryTable) Put(key string, record map[string]interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.records[key] = record
+}
+
+// Delete removes the record with the key from the table.
+func (t *MemoryTable) Delete(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.records, key)
+}
+
+// Lookup returns the record with the key.
+func (t *MemoryTable) Lookup(key string) (map[string]interface{}, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	record, ok := t.records[key]
+	return record, ok, nil
+}
+
+// Len returns the number of records in the table.
+func (t *MemoryTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.records)
+}
+
+// newMemorySource reads the reference records from a memory table.
+//
+// Settings:
+//
+//	table  the name of the memory table
+func newMemorySource(values map[string]interface{}) (LookupSource, error) {
+	s := newSettings(LookupType, values)
+	name := s.string("table", true)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return OpenMemoryTable(name), nil
+}
+
+// LookupStore stores the data of each data point in a memory table, so it can
+// be used as reference data by lookup activities in other pipelines or streams.
+// Delete data points remove the record.  The data points are emitted unchanged.
+// The records are kept until the activity and the lookups that read the table
+// have been closed.
+//
+// Settings:
+//
+//	table  the name of the memory table
+//	key    the property that contains the key of the record
+type LookupStore struct {
+	Table *MemoryTable
+	Key   string
+}
+
+// Init reads the table and the key from the settings.
+func (l *LookupStore) Init(values map[string]interface{}) error {
+	s := newSettings(LookupStoreType, values)
+	name := s.string("table", true)
+	l.Key = s.string("key", true)
+	if s.err != nil {
+		return s.err
+	}
+
+	l.Table = OpenMemoryTable(name)
+	return nil
+}
+
+// Close closes the memory table.
+func (l *LookupStore) Close() error {
+	if l.Table == nil {
+		return nil
+	}
+	return l.Table.Close()
+}
+
+// Execute stores the data point in the table and emits it.
+func (l *LookupStore) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	val, _ := getPath(dataPoint.Data, l.Key)
+	key, ok := lookupKey(val)
+	if !ok {
+		return fmt.Errorf("lookup-store: the key property '%s' is missing or invalid", l.Key)
+	}
+
+	if dataPoint.Action == pipeline.DataPointDelete {
+		l.Table.Delete(key)
+	} else {
+		l.Table.Put(key, copyValue(dataPoint.Data).(map[string]interface{}))
+	}
+
+	return ctx.OutputCollector.Emit(dataPoint)
+}
+
+// httpSource requests each reference record from an HTTP endpoint.  The endpoint
+// must respond with a JSON object, or with 404 if there is no record.
+//
+// Settings:
+//
+//	url      the URL of a record, {key} is replaced with the escaped key
+//	headers  optional: a map of headers sent with each request
+//	timeout  optional: the time allowed for a request, defaults to 10s
+type httpSource struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPSource(values map[string]interface{}) (LookupSource, error) {
+	s := newSettings(LookupType, values)
+	h := &httpSource{
+		url:     s.string("url", true),
+		headers: s.stringMap("headers", false),
+		client:  &http.Client{Timeout: DefaultHTTPLookupTimeout},
+	}
+
+	if s.err == nil && !strings.Contains(h.url, "{key}") {
+		s.fail("url", "must contain a {key} placeholder")
+	}
+
+	if timeout := s.string("timeout", false); timeout != "" {
+		var err error
+		if h.client.Timeout, err = time.ParseDuration(timeout); err != nil || h.client.Timeout <= 0 {
+			s.fail("timeout", "must be a positive duration such as '5s'")
+		}
+	}
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	return h, nil
+}
+
+func (h *httpSource) Lookup(key string) (map[string]interface{}, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.Replace(h.url, "{key}", url.PathEscape(key), -1), nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, false, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, false, fmt.Errorf("lookup: request for key '%s' failed with status %d", key, resp.StatusCode)
+	}
+
+	var record map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+		return nil, false, fmt.Errorf("lookup: response for key '%s' is not a JSON object: %v", key, err)
+	}
+
+	return record, true, nil
+}
@@ -0,0 +1,277 @@
+package builtin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLookup(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	csvPath := filepath.Join(dir, "customers.csv")
+	ioutil.WriteFile(csvPath, []byte("id,name,tier\n1,Vandelay Industries,gold\n2,Kramerica,silver\n"), 0644)
+
+	jsonPath := filepath.Join(dir, "customers.data")
+	ioutil.WriteFile(jsonPath, []byte(`[{"id": 1, "name": "Vandelay Industries", "tier": "gold"}, {"id": "2", "name": "Kramerica"}]`), 0644)
+
+	inputs := activitytest.DataPoints("customers", []string{"id"},
+		map[string]interface{}{"order": "a", "customerId": float64(1)},
+		map[string]interface{}{"order": "b", "customerId": "2"},
+		map[string]interface{}{"order": "c", "customerId": 3},
+		map[string]interface{}{"order": "d"},
+	)
+
+	testCases := []struct {
+		name     string
+		settings map[string]interface{}
+		expected []map[string]interface{}
+	}{
+		{
+			"Given a CSV file and fields",
+			map[string]interface{}{"source": "file", "path": csvPath, "keyField": "id", "key": "customerId", "fields": map[string]interface{}{"customer": "name"}},
+			[]map[string]interface{}{
+				{"order": "a", "customerId": float64(1), "customer": "Vandelay Industries"},
+				{"order": "b", "customerId": "2", "customer": "Kramerica"},
+				{"order": "c", "customerId": 3},
+				{"order": "d"},
+			},
+		},
+		{
+			"Given a JSON file and misses that are dropped",
+			map[string]interface{}{"source": "file", "path": jsonPath, "format": "json", "keyField": "id", "key": "customerId", "fields": map[string]interface{}{"customer.tier": "tier"}, "onMiss": "drop"},
+			[]map[string]interface{}{
+				{"order": "a", "customerId": float64(1), "customer": map[string]interface{}{"tier": "gold"}},
+				{"order": "b", "customerId": "2", "customer": map[string]interface{}{"tier": nil}},
+			},
+		},
+		{
+			"Given a default for misses",
+			map[string]interface{}{"source": "file", "path": csvPath, "keyField": "id", "key": "customerId", "as": "customer", "onMiss": "default", "default": map[string]interface{}{"name": "unknown"}},
+			[]map[string]interface{}{
+				{"order": "a", "customerId": float64(1), "customer": map[string]interface{}{"id": "1", "name": "Vandelay Industries", "tier": "gold"}},
+				{"order": "b", "customerId": "2", "customer": map[string]interface{}{"id": "2", "name": "Kramerica", "tier": "silver"}},
+				{"order": "c", "customerId": 3, "customer": map[string]interface{}{"name": "unknown"}},
+				{"order": "d", "customer": map[string]interface{}{"name": "unknown"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: LookupType, Settings: tc.settings}, inputs)
+			So(result, activitytest.ShouldSucceed)
+
+			Convey("Should enrich the data points", func() {
+				So(result.Data(), ShouldResemble, tc.expected)
+			})
+		})
+	}
+
+	Convey("Given a memory table populated by another stream", t, func() {
+		table := OpenMemoryTable("test-customers")
+		defer table.Close()
+
+		store := activitytest.Run(pipeline.Activity{Type: LookupStoreType, Settings: map[string]interface{}{"table": "test-customers", "key": "id"}}, []pipeline.DataPoint{
+			{Entity: "customers", Action: pipeline.DataPointUpsert, Data: map[string]interface{}{"id": 1, "name": "Vandelay Industries"}},
+			{Entity: "customers", Action: pipeline.DataPointUpsert, Data: map[string]interface{}{"id": 2, "name": "Kramerica"}},
+			{Entity: "customers", Action: pipeline.DataPointDelete, Data: map[string]interface{}{"id": 2}},
+		})
+		So(store, activitytest.ShouldSucceed)
+		So(table.Len(), ShouldEqual, 1)
+
+		result := activitytest.Run(pipeline.Activity{Type: LookupType, Settings: map[string]interface{}{"source": "memory", "table": "test-customers", "key": "customerId", "fields": map[string]interface{}{"customer": "name"}}}, inputs[:2])
+		So(result, activitytest.ShouldSucceed)
+
+		Convey("Should enrich the data points with the records in the table", func() {
+			So(result.Data(), ShouldResemble, []map[string]interface{}{
+				{"order": "a", "customerId": float64(1), "customer": "Vandelay Industries"},
+				{"order": "b", "customerId": "2"},
+			})
+		})
+
+		Convey("Should release the records when the table is closed", func() {
+			table.Close()
+			reopened := OpenMemoryTable("test-customers")
+			defer reopened.Close()
+
+			So(reopened.Len(), ShouldEqual, 0)
+			So(table.Len(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a reference record with nested objects", t, func() {
+		table := OpenMemoryTable("test-nested")
+		defer table.Close()
+		table.Put("1", map[string]interface{}{"name": "Vandelay Industries", "address": map[string]interface{}{"city": "New York"}})
+
+		result := activitytest.Run(pipeline.Activity{Type: LookupType, Settings: map[string]interface{}{"source": "memory", "table": "test-nested", "key": "customerId", "as": "customer"}}, inputs[:1])
+		So(result, activitytest.ShouldSucceed)
+
+		Convey("Should copy the record into the data point", func() {
+			customer := result.Data()[0]["customer"].(map[string]interface{})
+			customer["address"].(map[string]interface{})["city"] = "Newark"
+
+			record, _, _ := table.Lookup("1")
+			So(record["address"], ShouldResemble, map[string]interface{}{"city": "New York"})
+		})
+	})
+
+	Convey("Given an HTTP endpoint and a cache", t, func() {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			switch r.URL.Path {
+			case "/customers/1":
+				if r.Header.Get("Authorization") != "Bearer secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte(`{"name": "Vandelay Industries"}`))
+			case "/customers/2":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		settings := map[string]interface{}{
+			"source":   "http",
+			"url":      server.URL + "/customers/{key}",
+			"headers":  map[string]interface{}{"Authorization": "Bearer secret"},
+			"key":      "customerId",
+			"fields":   map[string]interface{}{"customer": "name"},
+			"cacheTTL": "1m",
+		}
+
+		Convey("Should request each key once", func() {
+			result := activitytest.Run(pipeline.Activity{Type: LookupType, Settings: settings}, []pipeline.DataPoint{inputs[0], inputs[2], inputs[0], inputs[2]})
+			So(result, activitytest.ShouldSucceed)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+			So(result.Data()[2], ShouldResemble, map[string]interface{}{"order": "a", "customerId": float64(1), "customer": "Vandelay Industries"})
+			So(result.Data()[3], ShouldResemble, map[string]interface{}{"order": "c", "customerId": 3})
+		})
+
+		Convey("Should return the errors of the endpoint", func() {
+			result := activitytest.Run(pipeline.Activity{Type: LookupType, Settings: settings}, inputs[1:2])
+			So(result, activitytest.ShouldFail)
+			So(result.Err.Error(), ShouldContainSubstring, "status 500")
+		})
+	})
+
+	invalidSettings := []struct {
+		name     string
+		settings map[string]interface{}
+	}{
+		{"without a key", map[string]interface{}{"source": "memory", "table": "t", "as": "x"}},
+		{"with an unknown source", map[string]interface{}{"source": "ftp", "key": "id", "as": "x"}},
+		{"with both as and fields", map[string]interface{}{"source": "memory", "table": "t", "key": "id", "as": "x", "fields": map[string]interface{}{"y": "y"}}},
+		{"with an unknown miss policy", map[string]interface{}{"source": "memory", "table": "t", "key": "id", "as": "x", "onMiss": "fail"}},
+		{"without a default", map[string]interface{}{"source": "memory", "table": "t", "key": "id", "as": "x", "onMiss": "default"}},
+		{"with an invalid TTL", map[string]interface{}{"source": "memory", "table": "t", "key": "id", "as": "x", "cacheTTL": "-1m"}},
+		{"with a missing file", map[string]interface{}{"source": "file", "path": filepath.Join(dir, "missing.csv"), "keyField": "id", "key": "id", "as": "x"}},
+		{"with an unknown file format", map[string]interface{}{"source": "file", "path": jsonPath, "keyField": "id", "key": "id", "as": "x"}},
+		{"with a URL without a key", map[string]interface{}{"source": "http", "url": "http://localhost/customers", "key": "id", "as": "x"}},
+	}
+
+	for _, tc := range invalidSettings {
+		Convey("Given settings "+tc.name, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: LookupType, Settings: tc.settings}, nil)
+
+			Convey("Should return an error", func() {
+				So(result, activitytest.ShouldFail)
+			})
+		})
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+
+	Convey("Given a lookup with a memory limit and no TTL", t, func() {
+		l := &Lookup{}
+		So(l.Init(map[string]interface{}{"source": "memory", "table": "test-cache", "key": "id", "as": "x", "cacheMaxBytes": 1000}), ShouldBeNil)
+		defer l.Close()
+
+		Convey("Should cache records without expiring them", func() {
+			So(l.cache, ShouldNotBeNil)
+			So(l.cache.ttl, ShouldEqual, 0)
+			So(l.cache.maxBytes, ShouldEqual, 1000)
+		})
+	})
+
+	Convey("Given a lookup cache without a TTL", t, func() {
+		now := time.Date(2017, 2, 16, 12, 0, 0, 0, time.UTC)
+		c := newLookupCache(0, 1000)
+		c.now = func() time.Time { return now }
+		c.put("1", map[string]interface{}{"name": "abc"}, true)
+
+		Convey("Should not expire entries", func() {
+			now = now.Add(24 * time.Hour)
+			_, _, ok := c.get("1")
+			So(ok, ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a lookup cache", t, func() {
+		now := time.Date(2017, 2, 16, 12, 0, 0, 0, time.UTC)
+		c := newLookupCache(time.Minute, 3*(lookupCacheOverhead+20))
+		c.now = func() time.Time { return now }
+
+		record := map[string]interface{}{"name": "abc"} // 14 bytes of JSON
+
+		c.put("1", record, true)
+		c.put("2", nil, false)
+
+		Convey("Should return the cached records and misses", func() {
+			r, found, ok := c.get("1")
+			So(ok, ShouldBeTrue)
+			So(found, ShouldBeTrue)
+			So(r, ShouldResemble, record)
+
+			_, found, ok = c.get("2")
+			So(ok, ShouldBeTrue)
+			So(found, ShouldBeFalse)
+		})
+
+		Convey("Should expire entries after the TTL", func() {
+			now = now.Add(time.Minute)
+			_, _, ok := c.get("1")
+			So(ok, ShouldBeFalse)
+			So(c.order.Len(), ShouldEqual, 1)
+		})
+
+		Convey("Should evict the least recently used entries when it is full", func() {
+			c.get("1")
+			c.put("3", record, true)
+			c.put("4", record, true)
+
+			_, _, ok := c.get("2")
+			So(ok, ShouldBeFalse)
+			_, _, ok = c.get("1")
+			So(ok, ShouldBeTrue)
+			So(c.size, ShouldBeLessThanOrEqualTo, c.maxBytes)
+		})
+
+		Convey("Should not cache records that are larger than the cache", func() {
+			c.put("big", map[string]interface{}{"data": string(make([]byte, c.maxBytes))}, true)
+			_, _, ok := c.get("big")
+			So(ok, ShouldBeFalse)
+			So(c.order.Len(), ShouldEqual, 2)
+		})
+	})
+}
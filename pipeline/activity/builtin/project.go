@@ -0,0 +1,98 @@
+package builtin
+
+import (
+	"sort"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// Project keeps a subset of the properties of a data point and renames
+// properties.  Key properties are always kept, and renaming a key property
+// renames it in the key names as well.
+//
+// Settings:
+//
+//	properties  optional: the properties to keep, all properties are kept if omitted
+//	rename      optional: a map of property names to their new names
+type Project struct {
+	Properties []string
+	Rename     map[string]string
+
+	renameOrder []string
+}
+
+// Init reads the properties and renames from the settings.
+func (p *Project) Init(values map[string]interface{}) error {
+	s := newSettings(ProjectType, values)
+	p.Properties = s.stringList("properties", false)
+	p.Rename = s.stringMap("rename", false)
+
+	if s.err == nil && len(p.Properties) == 0 && len(p.Rename) == 0 {
+		s.fail("properties", "or 'rename' is required")
+	}
+
+	targets := map[string]bool{}
+	for from, to := range p.Rename {
+		if to == "" {
+			s.fail("rename", "has no new name for '%s'", from)
+		}
+		if targets[to] {
+			s.fail("rename", "renames more than one property to '%s'", to)
+		}
+		targets[to] = true
+		p.renameOrder = append(p.renameOrder, from)
+	}
+	sort.Strings(p.renameOrder)
+
+	return s.err
+}
+
+// Execute emits the projected data point.
+func (p *Project) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	return ctx.OutputCollector.Emit(p.Apply(dataPoint))
+}
+
+// Apply returns a copy of the data point with the projection applied.
+func (p *Project) Apply(dataPoint pipeline.DataPoint) pipeline.DataPoint {
+	var data map[string]interface{}
+
+	if len(p.Properties) > 0 {
+		data = make(map[string]interface{}, len(p.Properties)+len(dataPoint.KeyNames))
+		for _, list := range [][]string{dataPoint.KeyNames, p.Properties} {
+			for _, name := range list {
+				if val, ok := getPath(dataPoint.Data, name); ok {
+					setPath(data, name, val)
+				}
+			}
+		}
+	} else {
+		data = copyData(dataPoint.Data)
+	}
+
+	renamed := map[string]interface{}{}
+	for _, from := range p.renameOrder {
+		if val, ok := getPath(data, from); ok {
+			deletePath(data, from)
+			renamed[p.Rename[from]] = val
+		}
+	}
+	for to, val := range renamed {
+		setPath(data, to, val)
+	}
+
+	if len(p.Rename) > 0 && len(dataPoint.KeyNames) > 0 {
+		keyNames := make([]string, len(dataPoint.KeyNames))
+		for i, key := range dataPoint.KeyNames {
+			if to, ok := p.Rename[key]; ok {
+				key = to
+			}
+			keyNames[i] = key
+		}
+		dataPoint.KeyNames = keyNames
+	}
+
+	dataPoint.Data = data
+	dataPoint.Shape = pipeline.Shape{}
+	return dataPoint
+}
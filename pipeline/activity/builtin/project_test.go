@@ -0,0 +1,79 @@
+package builtin
+
+import (
+	"testing"
+
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProject(t *testing.T) {
+
+	input := pipeline.DataPoint{
+		Entity:   "customers",
+		KeyNames: []string{"id"},
+		Data: map[string]interface{}{
+			"id":      1,
+			"name":    "Acme",
+			"balance": 10.5,
+			"address": map[string]interface{}{"city": "Erie", "zip": "16501"},
+		},
+		Shape: pipeline.Shape{Properties: []string{"id:number"}, PropertyHash: 1},
+	}
+
+	testCases := []struct {
+		name         string
+		settings     map[string]interface{}
+		expected     map[string]interface{}
+		expectedKeys []string
+	}{
+		{
+			"Given a list of properties",
+			map[string]interface{}{"properties": []interface{}{"name", "address.city"}},
+			map[string]interface{}{"id": 1, "name": "Acme", "address": map[string]interface{}{"city": "Erie"}},
+			[]string{"id"},
+		},
+		{
+			"Given properties to rename",
+			map[string]interface{}{"rename": map[string]interface{}{"name": "customer_name", "address.zip": "zip"}},
+			map[string]interface{}{"id": 1, "customer_name": "Acme", "balance": 10.5, "zip": "16501", "address": map[string]interface{}{"city": "Erie"}},
+			[]string{"id"},
+		},
+		{
+			"Given a key property to rename",
+			map[string]interface{}{"properties": []interface{}{"name"}, "rename": map[string]interface{}{"id": "customer_id"}},
+			map[string]interface{}{"customer_id": 1, "name": "Acme"},
+			[]string{"customer_id"},
+		},
+		{
+			"Given properties that are swapped",
+			map[string]interface{}{"properties": []interface{}{"name", "balance"}, "rename": map[string]interface{}{"name": "balance", "balance": "name"}},
+			map[string]interface{}{"id": 1, "name": 10.5, "balance": "Acme"},
+			[]string{"id"},
+		},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: ProjectType, Settings: tc.settings}, []pipeline.DataPoint{input})
+			So(result, activitytest.ShouldSucceed)
+			out := result.DataPoints()[0]
+
+			Convey("Should emit the projected data", func() {
+				So(out.Data, ShouldResemble, tc.expected)
+				So(out.KeyNames, ShouldResemble, tc.expectedKeys)
+			})
+
+			Convey("Should clear the shape", func() {
+				So(out.Shape.PropertyHash, ShouldEqual, 0)
+			})
+
+			Convey("Should not modify the input", func() {
+				So(input.Data["name"], ShouldEqual, "Acme")
+				So(input.Data["address"], ShouldResemble, map[string]interface{}{"city": "Erie", "zip": "16501"})
+				So(input.KeyNames, ShouldResemble, []string{"id"})
+			})
+		})
+	}
+}
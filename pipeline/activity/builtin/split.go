@@ -0,0 +1,61 @@
+package builtin
+
+import (
+	"fmt"
+
+	"github.com/naveego/api/pipeline/activity"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// Split routes each data point to a single output stream based on its entity.
+// A data point is emitted to the stream configured for its entity, or to the
+// output stream with the same name as the entity.  Otherwise it is emitted to the
+// default stream, or dropped if there is no default.
+//
+// Settings:
+//
+//	routes   optional: a map of entity names to output streams
+//	default  optional: the stream for entities without a route
+type Split struct {
+	Routes  map[string]string
+	Default string
+}
+
+// Init reads the routes from the settings.
+func (sp *Split) Init(values map[string]interface{}) error {
+	s := newSettings(SplitType, values)
+	sp.Routes = s.stringMap("routes", false)
+	sp.Default = s.string("default", false)
+	return s.err
+}
+
+// Execute emits the data point to the stream for its entity.
+func (sp *Split) Execute(ctx activity.Context, dataPoint pipeline.DataPoint) error {
+	stream := sp.Stream(dataPoint.Entity, ctx.Activity.OutputStreams)
+	if stream == "" {
+		return nil
+	}
+
+	collector, ok := ctx.OutputCollector.(activity.StreamOutputCollector)
+	if !ok {
+		return fmt.Errorf("split: the output collector cannot emit to a single stream")
+	}
+
+	return collector.EmitTo(stream, dataPoint)
+}
+
+// Stream returns the stream for the entity, or an empty string
+// if data points for the entity should be dropped.
+func (sp *Split) Stream(entity string, outputs []string) string {
+	if stream, ok := sp.Routes[entity]; ok {
+		return stream
+	}
+
+	for _, s := range outputs {
+		if s == entity {
+			return s
+		}
+	}
+
+	return sp.Default
+}
@@ -0,0 +1,47 @@
+package builtin
+
+import (
+	"testing"
+
+	"github.com/naveego/api/pipeline/activity/activitytest"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSplit(t *testing.T) {
+
+	inputs := []pipeline.DataPoint{
+		{Entity: "customers"},
+		{Entity: "orders"},
+		{Entity: "vendors"},
+	}
+
+	testCases := []struct {
+		name     string
+		settings map[string]interface{}
+		expected []string
+	}{
+		{"Given outputs named after the entities", map[string]interface{}{}, []string{"customers", "orders"}},
+		{"Given routes", map[string]interface{}{"routes": map[string]interface{}{"customers": "other", "vendors": "other"}}, []string{"other", "orders", "other"}},
+		{"Given a default stream", map[string]interface{}{"default": "other"}, []string{"customers", "orders", "other"}},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			result := activitytest.Run(pipeline.Activity{Type: SplitType, Settings: tc.settings, OutputStreams: []string{"customers", "orders", "other"}}, inputs)
+
+			Convey("Should emit each data point to the stream for its entity", func() {
+				So(result, activitytest.ShouldSucceed)
+				So(result, activitytest.ShouldEmitToStreams, tc.expected)
+			})
+		})
+	}
+
+	Convey("Given a route to a stream that is not an output", t, func() {
+		result := activitytest.Run(pipeline.Activity{Type: SplitType, Settings: map[string]interface{}{"default": "missing"}, OutputStreams: []string{"customers"}}, inputs)
+
+		Convey("Should return an error", func() {
+			So(result, activitytest.ShouldFail)
+		})
+	})
+}
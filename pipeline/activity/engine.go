@@ -0,0 +1,621 @@
+package activity
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/dataflow"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+)
+
+// DefaultBufferSize is the number of data points that can be queued
+// for an activity before the producer blocks.
+const DefaultBufferSize = 100
+
+// SinkFunc receives the data points emitted to a sink stream.  It may be called
+// concurrently by different activities.
+type SinkFunc func(stream string, dataPoint pipeline.DataPoint) error
+
+// LogFunc receives a data flow log entry for every error returned by an activity.
+// It may be called concurrently by different activities.
+type LogFunc func(entry dataflow.Log)
+
+// EngineConfig configures the streams of an engine.
+type EngineConfig struct {
+	Sources    []string // Streams that data points are sent to using Send
+	Sinks      []string // Streams that are delivered to the Sink function
+	Sink       SinkFunc // optional: Receives the data points emitted to the sinks
+	BufferSize int      // optional: The size of the input buffer of each activity
+	Log        LogFunc  // optional: Receives the log entries for activity errors
+
+	// optional: The log entry that the log entries for activity errors are copied
+	// from, typically with the tenant and correlation ID filled in.
+	LogTemplate dataflow.Log
+
+	// optional: The state of activities keyed by activity ID, as returned by
+	// Engine.Checkpoint.  The state is restored after the activities are initialized.
+	Checkpoints map[string][]byte
+}
+
+// Engine runs the activities of a pipeline.  Every activity runs in its own
+// goroutine and receives its input through a bounded channel, so a slow activity
+// applies back pressure to the activities that feed it.  The first error returned
+// by an activity stops the engine, unless the error policy of the activity says
+// otherwise.  Activities that implement ActivityTicker are ticked while they run.
+// When the engine is closed, every activity that implements ActivityFlusher is
+// flushed after its input has been processed.  Activities that implement
+// ActivityCloser are closed when they stop.
+type Engine struct {
+	pipeline pipeline.Pipeline
+	graph    *Graph
+	config   EngineConfig
+	nodes    []*node
+	sources  map[string]bool
+
+	mu      sync.RWMutex
+	started bool
+	closed  bool
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	stopped chan struct{}
+
+	errMU sync.Mutex
+	err   error
+}
+
+type node struct {
+	def        pipeline.Activity
+	activity   Activity
+	policy     ErrorPolicy
+	input      chan pipeline.DataPoint
+	downstream []*node
+	outputs    map[string][]*node // output stream -> consuming nodes
+	sinks      []string
+	targets    []*node  // The nodes Emit delivers to, which excludes the error stream
+	emitSinks  []string // The sinks Emit delivers to, which excludes the error stream
+	fromSource bool     // true if any of the input streams is a source
+
+	mu      sync.Mutex
+	pending int // The number of producers that can still send to the input
+}
+
+// NewEngine builds the activity graph, creates each activity using the factory
+// registered for its type, initializes the activities that implement ActivityIniter
+// and restores the checkpoints in the config.  The error policy of each activity is
+// read from its settings, see ErrorPolicySetting.  If the engine cannot be built,
+// the activities that were initialized are closed.
+func NewEngine(p pipeline.Pipeline, activities []pipeline.Activity, config EngineConfig) (engine *Engine, err error) {
+	graph, err := BuildGraph(activities, config.Sources, config.Sinks)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	e := &Engine{
+		pipeline: p,
+		graph:    graph,
+		config:   config,
+		nodes:    make([]*node, len(graph.Activities)),
+		sources:  toSet(config.Sources),
+		stopped:  make(chan struct{}),
+	}
+
+	isSink := toSet(config.Sinks)
+
+	var initialized []Activity
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, act := range initialized {
+			if closer, ok := act.(ActivityCloser); ok {
+				closer.Close()
+			}
+		}
+	}()
+
+	for i, def := range graph.Activities {
+		factory, err := GetActivityFactory(def.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		policy, err := ParseErrorPolicy(def)
+		if err != nil {
+			return nil, err
+		}
+
+		act := factory()
+		if initer, ok := act.(ActivityIniter); ok {
+			if err := initer.Init(def.Settings); err != nil {
+				return nil, errors.NewWithCode(pipeerrors.PipelineActivityRunError, fmt.Sprintf("pipeline: could not initialize activity '%s': %v", def.ID, err))
+			}
+		}
+		initialized = append(initialized, act)
+
+		if state, ok := config.Checkpoints[def.ID]; ok {
+			checkpointer, ok := act.(ActivityCheckpointer)
+			if !ok {
+				return nil, errors.NewWithCode(pipeerrors.PipelineActivityRunError, fmt.Sprintf("pipeline: activity '%s' does not support checkpoints", def.ID))
+			}
+			if err := checkpointer.Restore(state); err != nil {
+				return nil, errors.NewWithCode(pipeerrors.PipelineActivityRunError, fmt.Sprintf("pipeline: could not restore activity '%s': %v", def.ID, err))
+			}
+		}
+
+		n := &node{
+			def:      def,
+			activity: act,
+			policy:   policy,
+			input:    make(chan pipeline.DataPoint, config.BufferSize),
+			outputs:  map[string][]*node{},
+		}
+
+		for _, s := range def.OutputStreams {
+			if isSink[s] {
+				n.sinks = append(n.sinks, s)
+				if s != n.errorStream() {
+					n.emitSinks = append(n.emitSinks, s)
+				}
+			}
+		}
+
+		for _, s := range def.InputStreams {
+			if e.sources[s] {
+				// All of the sources count as a single producer
+				n.fromSource = true
+				n.pending = 1
+				break
+			}
+		}
+
+		e.nodes[i] = n
+	}
+
+	for i, n := range e.nodes {
+		for _, d := range graph.downstream(i) {
+			n.downstream = append(n.downstream, e.nodes[d])
+			e.nodes[d].pending++
+		}
+
+		targets := map[*node]bool{}
+		for _, s := range n.def.OutputStreams {
+			for _, c := range graph.consumers[s] {
+				n.outputs[s] = append(n.outputs[s], e.nodes[c])
+				if s != n.errorStream() {
+					targets[e.nodes[c]] = true
+				}
+			}
+		}
+
+		for _, d := range n.downstream {
+			if targets[d] {
+				n.targets = append(n.targets, d)
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// Graph returns the activity graph of the engine.
+func (e *Engine) Graph() *Graph {
+	return e.graph
+}
+
+// Start starts the activities.  Cancelling the context stops the engine.
+func (e *Engine) Start(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.started {
+		return
+	}
+	e.started = true
+	e.ctx, e.cancel = context.WithCancel(ctx)
+
+	for _, n := range e.nodes {
+		e.wg.Add(1)
+		go e.run(n)
+	}
+
+	if e.closed {
+		e.closeSources()
+	}
+
+	go func() {
+		e.wg.Wait()
+		close(e.stopped)
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			e.fail(ctx.Err())
+		case <-e.stopped:
+		}
+		e.cancel()
+	}()
+}
+
+// Send sends a data point to the activities that consume the source stream.  Send
+// blocks while the input of a consuming activity is full.
+func (e *Engine) Send(stream string, dataPoint pipeline.DataPoint) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.started {
+		return errors.New("pipeline: the engine has not been started")
+	}
+
+	if e.closed {
+		return errors.New("pipeline: the engine has been closed")
+	}
+
+	if !e.sources[stream] {
+		return errors.NewWithCode(pipeerrors.InvalidInputStreamID, fmt.Sprintf("pipeline: '%s' is not a source stream", stream))
+	}
+
+	for _, i := range e.graph.consumers[stream] {
+		if err := e.deliver(e.nodes[i], dataPoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close signals that no more data points will be sent.  The activities finish
+// processing the queued data points and stop.
+func (e *Engine) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return
+	}
+	e.closed = true
+
+	if e.started {
+		e.closeSources()
+	}
+}
+
+// Wait waits for all of the activities to stop and returns the error
+// that stopped the engine, if any.
+func (e *Engine) Wait() error {
+	e.mu.RLock()
+	started := e.started
+	e.mu.RUnlock()
+
+	if !started {
+		return errors.New("pipeline: the engine has not been started")
+	}
+
+	<-e.stopped
+	return e.Err()
+}
+
+// Checkpoint returns the state of the activities that implement ActivityCheckpointer
+// keyed by activity ID.  The state can be restored using EngineConfig.Checkpoints.
+func (e *Engine) Checkpoint() (map[string][]byte, error) {
+	checkpoints := map[string][]byte{}
+	for _, n := range e.nodes {
+		checkpointer, ok := n.activity.(ActivityCheckpointer)
+		if !ok {
+			continue
+		}
+
+		state, err := checkpointer.Checkpoint()
+		if err != nil {
+			return nil, errors.NewWithCode(pipeerrors.PipelineActivityRunError, fmt.Sprintf("pipeline: could not checkpoint activity '%s': %v", n.def.ID, err))
+		}
+		checkpoints[n.def.ID] = state
+	}
+	return checkpoints, nil
+}
+
+// Err returns the error that stopped the engine, if any.
+func (e *Engine) Err() error {
+	e.errMU.Lock()
+	defer e.errMU.Unlock()
+	return e.err
+}
+
+func (e *Engine) run(n *node) {
+	defer e.wg.Done()
+	defer func() {
+		for _, d := range n.downstream {
+			d.producerDone()
+		}
+	}()
+	defer e.close(n)
+
+	ctx := Context{
+		Pipeline:        e.pipeline,
+		Activity:        n.def,
+		OutputCollector: &collector{engine: e, node: n},
+	}
+
+	var ticks <-chan time.Time
+	if ticker, ok := n.activity.(ActivityTicker); ok {
+		if interval := ticker.TickInterval(); interval > 0 {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			ticks = t.C
+		}
+	}
+
+	done := e.ctx.Done()
+	for {
+		select {
+		case <-done:
+			e.fail(e.ctx.Err())
+			return
+		case now := <-ticks:
+			if err := n.activity.(ActivityTicker).Tick(ctx, now); err != nil {
+				e.failActivity(n, errors.NewWithCode(pipeerrors.PipelineActivityRunError, fmt.Sprintf("pipeline: activity '%s' failed to tick: %v", n.def.ID, err)))
+				return
+			}
+		case dp, ok := <-n.input:
+			if !ok {
+				e.flush(ctx, n)
+				return
+			}
+			if !e.execute(ctx, n, dp) {
+				return
+			}
+		}
+	}
+}
+
+// execute executes the activity and applies its error policy when it fails.
+// It returns false if the activity should stop.
+func (e *Engine) execute(ctx Context, n *node, dataPoint pipeline.DataPoint) bool {
+	for retry := 0; ; retry++ {
+		err := n.activity.Execute(ctx, dataPoint)
+		if err == nil {
+			return true
+		}
+
+		if e.ctx.Err() != nil {
+			// The engine was stopped while the activity was executing
+			e.fail(e.ctx.Err())
+			return false
+		}
+
+		err = errors.NewWithCode(pipeerrors.PipelineActivityRunError, fmt.Sprintf("pipeline: activity '%s' failed: %v", n.def.ID, err))
+
+		action := n.policy.Action
+		if action == ErrorActionRetry {
+			if retry < n.policy.Retries {
+				e.log(n, dataPoint, err, ErrorActionRetry)
+				select {
+				case <-time.After(n.policy.backoff(retry)):
+					continue
+				case <-e.ctx.Done():
+					e.fail(e.ctx.Err())
+					return false
+				}
+			}
+			action = n.policy.Then
+		}
+
+		e.log(n, dataPoint, err, action)
+
+		switch action {
+		case ErrorActionSkip:
+			return true
+		case ErrorActionRoute:
+			c := &collector{engine: e, node: n}
+			if err := c.EmitTo(n.policy.Stream, errorDataPoint(dataPoint, n.def.ID, err)); err != nil {
+				e.fail(err)
+				return false
+			}
+			return true
+		}
+
+		e.fail(err)
+		return false
+	}
+}
+
+// errorDataPoint returns a copy of the data point with the error in the meta data.
+func errorDataPoint(dataPoint pipeline.DataPoint, activityID string, err error) pipeline.DataPoint {
+	meta := make(map[string]string, len(dataPoint.Meta)+3)
+	for k, v := range dataPoint.Meta {
+		meta[k] = v
+	}
+
+	meta[ErrorMetaKey] = err.Error()
+	meta[ErrorActivityMetaKey] = activityID
+	if e, ok := err.(errors.Error); ok {
+		meta[ErrorCodeMetaKey] = strconv.Itoa(e.Code)
+	}
+
+	dataPoint.Meta = meta
+	return dataPoint
+}
+
+// log writes a log entry for an error returned by an activity.  The action is the
+// action of the error policy that was taken.  Errors that are not about a data
+// point, such as those of Tick, Flush and Close, have an empty data point.
+func (e *Engine) log(n *node, dataPoint pipeline.DataPoint, err error, action string) {
+	if e.config.Log == nil {
+		return
+	}
+
+	entry := e.config.LogTemplate
+	entry.Timestamp = time.Now().UTC()
+	entry.Resource = "pipeline"
+	entry.ResourceID = e.pipeline.ID
+	entry.Object = "activity"
+	entry.ObjectID = n.def.ID
+	entry.Action = action
+	entry.Level = "warning"
+	if action == ErrorActionFail {
+		entry.Level = "error"
+	}
+	entry.Message = err.Error()
+
+	entry.Error = &dataflow.Error{Message: err.Error(), Details: n.def.Type}
+	if coded, ok := err.(errors.Error); ok {
+		entry.Error.Code = int64(coded.Code)
+	}
+
+	if dataPoint.Entity != "" || len(dataPoint.Data) > 0 {
+		entry.DataPoint = &dataflow.DataPoint{Type: dataPoint.Entity}
+		if key, err := dataPoint.RecordKey(); err == nil {
+			entry.DataPoint.Key = key
+		}
+	}
+
+	e.config.Log(entry)
+}
+
+func (e *Engine) flush(ctx Context, n *node) {
+	flusher, ok := n.activity.(ActivityFlusher)
+	if !ok {
+		return
+	}
+
+	if err := flusher.Flush(ctx); err != nil {
+		e.failActivity(n, errors.NewWithCode(pipeerrors.PipelineActivityRunError, fmt.Sprintf("pipeline: activity '%s' failed to flush: %v", n.def.ID, err)))
+	}
+}
+
+func (e *Engine) close(n *node) {
+	closer, ok := n.activity.(ActivityCloser)
+	if !ok {
+		return
+	}
+
+	if err := closer.Close(); err != nil {
+		e.failActivity(n, errors.NewWithCode(pipeerrors.PipelineActivityRunError, fmt.Sprintf("pipeline: activity '%s' failed to close: %v", n.def.ID, err)))
+	}
+}
+
+func (e *Engine) closeSources() {
+	for _, n := range e.nodes {
+		if n.fromSource {
+			n.producerDone()
+		}
+	}
+}
+
+func (e *Engine) deliver(n *node, dataPoint pipeline.DataPoint) error {
+	select {
+	case n.input <- dataPoint:
+		return nil
+	case <-e.ctx.Done():
+		if err := e.Err(); err != nil {
+			return err
+		}
+		return errors.New("pipeline: the engine has been stopped")
+	}
+}
+
+// failActivity logs an error of the activity that is not about a data point, and
+// stops the engine.  The error policy does not apply, as there is no data point to
+// skip, retry or route.
+func (e *Engine) failActivity(n *node, err error) {
+	e.log(n, pipeline.DataPoint{}, err, ErrorActionFail)
+	e.fail(err)
+}
+
+// fail records the first error and stops the engine.
+func (e *Engine) fail(err error) {
+	e.errMU.Lock()
+	if e.err == nil {
+		e.err = err
+	}
+	e.errMU.Unlock()
+	e.cancel()
+}
+
+// errorStream returns the stream data points are routed to when the activity
+// fails, or an empty string.
+func (n *node) errorStream() string {
+	if n.policy.Action == ErrorActionRoute || n.policy.Then == ErrorActionRoute {
+		return n.policy.Stream
+	}
+	return ""
+}
+
+func (n *node) producerDone() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.pending--
+	if n.pending == 0 {
+		close(n.input)
+	}
+}
+
+// collector routes the output of an activity to the downstream activities and sinks.
+// Emit does not deliver to the stream that errors are routed to.
+type collector struct {
+	engine *Engine
+	node   *node
+}
+
+func (c *collector) Emit(dataPoint pipeline.DataPoint) error {
+	for _, d := range c.node.targets {
+		if err := c.engine.deliver(d, dataPoint); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range c.node.emitSinks {
+		if err := c.sink(s, dataPoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *collector) EmitTo(stream string, dataPoint pipeline.DataPoint) error {
+	found := false
+	for _, s := range c.node.def.OutputStreams {
+		if s == stream {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return errors.NewWithCode(pipeerrors.TopicToInputMismatch, fmt.Sprintf("pipeline: '%s' is not an output stream of activity '%s'", stream, c.node.def.ID))
+	}
+
+	for _, d := range c.node.outputs[stream] {
+		if err := c.engine.deliver(d, dataPoint); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range c.node.sinks {
+		if s == stream {
+			return c.sink(s, dataPoint)
+		}
+	}
+
+	return nil
+}
+
+func (c *collector) sink(stream string, dataPoint pipeline.DataPoint) error {
+	if c.engine.config.Sink == nil {
+		return nil
+	}
+	return c.engine.config.Sink(stream, dataPoint)
+}
@@ -0,0 +1,694 @@
+package activity
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/dataflow"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// appendActivity appends its configured suffix to the "name" property.
+type appendActivity struct {
+	suffix string
+}
+
+func (a *appendActivity) Init(settings map[string]interface{}) error {
+	suffix, ok := settings["suffix"].(string)
+	if !ok {
+		return fmt.Errorf("suffix is required")
+	}
+	a.suffix = suffix
+	return nil
+}
+
+func (a *appendActivity) Execute(ctx Context, dataPoint pipeline.DataPoint) error {
+	data := map[string]interface{}{}
+	for k, v := range dataPoint.Data {
+		data[k] = v
+	}
+	data["name"] = fmt.Sprint(data["name"]) + a.suffix
+	dataPoint.Data = data
+	return ctx.OutputCollector.Emit(dataPoint)
+}
+
+// failActivity fails when it receives a data point with a "fail" property.
+type failActivity struct{}
+
+func (f *failActivity) Execute(ctx Context, dataPoint pipeline.DataPoint) error {
+	if _, ok := dataPoint.Data["fail"]; ok {
+		return fmt.Errorf("failed on purpose")
+	}
+	return ctx.OutputCollector.Emit(dataPoint)
+}
+
+// flakyActivity fails the first time it receives each data point.
+type flakyActivity struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (f *flakyActivity) Execute(ctx Context, dataPoint pipeline.DataPoint) error {
+	f.mu.Lock()
+	name := fmt.Sprint(dataPoint.Data["name"])
+	seen := f.seen[name]
+	f.seen[name] = true
+	f.mu.Unlock()
+
+	if !seen {
+		return fmt.Errorf("not this time")
+	}
+	return ctx.OutputCollector.Emit(dataPoint)
+}
+
+// routeActivity emits to the output stream named by the "stream" property.
+type routeActivity struct{}
+
+func (r *routeActivity) Execute(ctx Context, dataPoint pipeline.DataPoint) error {
+	return ctx.OutputCollector.(StreamOutputCollector).EmitTo(fmt.Sprint(dataPoint.Data["stream"]), dataPoint)
+}
+
+// countActivity counts the data points it receives and emits the count when it
+// is flushed.
+type countActivity struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (c *countActivity) Execute(ctx Context, dataPoint pipeline.DataPoint) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	return nil
+}
+
+func (c *countActivity) Flush(ctx Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ctx.OutputCollector.Emit(pipeline.DataPoint{Data: map[string]interface{}{"name": c.count}})
+}
+
+func (c *countActivity) Checkpoint() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return []byte(strconv.Itoa(c.count)), nil
+}
+
+func (c *countActivity) Restore(state []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	c.count, err = strconv.Atoi(string(state))
+	return err
+}
+
+// tickActivity emits a data point on the first tick.
+type tickActivity struct {
+	once sync.Once
+}
+
+func (t *tickActivity) Execute(ctx Context, dataPoint pipeline.DataPoint) error {
+	return nil
+}
+
+func (t *tickActivity) TickInterval() time.Duration {
+	return time.Millisecond
+}
+
+func (t *tickActivity) Tick(ctx Context, now time.Time) error {
+	var err error
+	t.once.Do(func() {
+		err = ctx.OutputCollector.Emit(pipeline.DataPoint{Data: map[string]interface{}{"name": "tick"}})
+	})
+	return err
+}
+
+// closeActivity counts the instances that have been closed.
+type closeActivity struct{}
+
+var closedActivities int32
+
+func (c *closeActivity) Execute(ctx Context, dataPoint pipeline.DataPoint) error {
+	return ctx.OutputCollector.Emit(dataPoint)
+}
+
+func (c *closeActivity) Close() error {
+	atomic.AddInt32(&closedActivities, 1)
+	return nil
+}
+
+// brokenActivity fails to tick, flush or close.
+type brokenActivity struct {
+	fails string
+}
+
+func (b *brokenActivity) Execute(ctx Context, dataPoint pipeline.DataPoint) error {
+	return nil
+}
+
+func (b *brokenActivity) TickInterval() time.Duration {
+	if b.fails == "tick" {
+		return time.Millisecond
+	}
+	return 0
+}
+
+func (b *brokenActivity) Tick(ctx Context, now time.Time) error {
+	return errors.New("tick failed on purpose")
+}
+
+func (b *brokenActivity) Flush(ctx Context) error {
+	if b.fails == "flush" {
+		return errors.New("flush failed on purpose")
+	}
+	return nil
+}
+
+func (b *brokenActivity) Close() error {
+	if b.fails == "close" {
+		return errors.New("close failed on purpose")
+	}
+	return nil
+}
+
+type sinkRecorder struct {
+	mu    sync.Mutex
+	names map[string][]string
+}
+
+func (r *sinkRecorder) sink(stream string, dataPoint pipeline.DataPoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names[stream] = append(r.names[stream], fmt.Sprint(dataPoint.Data["name"]))
+	return nil
+}
+
+func (r *sinkRecorder) sorted(stream string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := append([]string(nil), r.names[stream]...)
+	sort.Strings(list)
+	return list
+}
+
+func registerEngineTestActivities() {
+	unregisterAllActivityFactories()
+	RegisterActivityFactory("append", func() Activity { return &appendActivity{} })
+	RegisterActivityFactory("fail", func() Activity { return &failActivity{} })
+	RegisterActivityFactory("route", func() Activity { return &routeActivity{} })
+	RegisterActivityFactory("count", func() Activity { return &countActivity{} })
+	RegisterActivityFactory("tick", func() Activity { return &tickActivity{} })
+	RegisterActivityFactory("close", func() Activity { return &closeActivity{} })
+	RegisterActivityFactory("flaky", func() Activity { return &flakyActivity{seen: map[string]bool{}} })
+	RegisterActivityFactory("brokentick", func() Activity { return &brokenActivity{fails: "tick"} })
+	RegisterActivityFactory("brokenflush", func() Activity { return &brokenActivity{fails: "flush"} })
+	RegisterActivityFactory("brokenclose", func() Activity { return &brokenActivity{fails: "close"} })
+}
+
+type logRecorder struct {
+	mu      sync.Mutex
+	entries []dataflow.Log
+}
+
+func (r *logRecorder) log(entry dataflow.Log) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+func (r *logRecorder) actions() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := []string{}
+	for _, e := range r.entries {
+		list = append(list, e.Action)
+	}
+	return list
+}
+
+// runWithPolicy runs a single activity with the error policy and sends it the data
+// points with the names.  The outputs of the activity are "out" and "errors".
+func runWithPolicy(activityType string, policy interface{}, names ...interface{}) (*sinkRecorder, *logRecorder, error) {
+	registerEngineTestActivities()
+	recorder := &sinkRecorder{names: map[string][]string{}}
+	logs := &logRecorder{}
+
+	activities := []pipeline.Activity{
+		{ID: "check", Type: activityType, InputStreams: []string{"in"}, OutputStreams: []string{"out", "errors"}, Settings: map[string]interface{}{ErrorPolicySetting: policy}},
+	}
+
+	engine, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{
+		Sources:     []string{"in"},
+		Sinks:       []string{"out", "errors"},
+		Sink:        recorder.sink,
+		Log:         logs.log,
+		LogTemplate: dataflow.Log{TenantID: "vandelay"},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	engine.Start(context.Background())
+	for _, name := range names {
+		data := map[string]interface{}{"name": name}
+		if name == "bad" {
+			data["fail"] = true
+		}
+		if engine.Send("in", pipeline.DataPoint{Entity: "customers", KeyNames: []string{"name"}, Data: data}) != nil {
+			break
+		}
+	}
+	engine.Close()
+
+	return recorder, logs, engine.Wait()
+}
+
+// runUntilFailure runs a single activity with the error policy until it stops the
+// engine.
+func runUntilFailure(activityType string, policy interface{}) (*logRecorder, error) {
+	registerEngineTestActivities()
+	logs := &logRecorder{}
+
+	activities := []pipeline.Activity{
+		{ID: "check", Type: activityType, InputStreams: []string{"in"}, OutputStreams: []string{"out"}, Settings: map[string]interface{}{ErrorPolicySetting: policy}},
+	}
+
+	engine, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{Sources: []string{"in"}, Sinks: []string{"out"}, Log: logs.log})
+	if err != nil {
+		return nil, err
+	}
+
+	engine.Start(context.Background())
+	return logs, engine.Wait()
+}
+
+func TestEngine(t *testing.T) {
+
+	Convey("Given a pipeline with a fan out and fan in", t, func() {
+		registerEngineTestActivities()
+		recorder := &sinkRecorder{names: map[string][]string{}}
+
+		activities := []pipeline.Activity{
+			{ID: "join", Type: "append", InputStreams: []string{"left", "right"}, OutputStreams: []string{"out"}, Settings: map[string]interface{}{"suffix": "!"}},
+			{ID: "a", Type: "append", InputStreams: []string{"in"}, OutputStreams: []string{"left"}, Settings: map[string]interface{}{"suffix": "-a"}},
+			{ID: "b", Type: "append", InputStreams: []string{"in"}, OutputStreams: []string{"right", "audit"}, Settings: map[string]interface{}{"suffix": "-b"}},
+		}
+
+		engine, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{
+			Sources:    []string{"in"},
+			Sinks:      []string{"out", "audit"},
+			Sink:       recorder.sink,
+			BufferSize: 1,
+		})
+		So(err, ShouldBeNil)
+
+		engine.Start(context.Background())
+		for i := 0; i < 3; i++ {
+			So(engine.Send("in", pipeline.DataPoint{Data: map[string]interface{}{"name": i}}), ShouldBeNil)
+		}
+		engine.Close()
+
+		Convey("Should route the data points through every activity", func() {
+			So(engine.Wait(), ShouldBeNil)
+			So(recorder.sorted("out"), ShouldResemble, []string{"0-a!", "0-b!", "1-a!", "1-b!", "2-a!", "2-b!"})
+			So(recorder.sorted("audit"), ShouldResemble, []string{"0-b", "1-b", "2-b"})
+		})
+
+		Convey("Should not accept data points after it is closed", func() {
+			So(engine.Send("in", pipeline.DataPoint{}), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an activity that emits to a single stream", t, func() {
+		registerEngineTestActivities()
+		recorder := &sinkRecorder{names: map[string][]string{}}
+
+		activities := []pipeline.Activity{
+			{ID: "route", Type: "route", InputStreams: []string{"in"}, OutputStreams: []string{"a", "b"}},
+			{ID: "append", Type: "append", InputStreams: []string{"b"}, OutputStreams: []string{"out"}, Settings: map[string]interface{}{"suffix": "!"}},
+		}
+
+		engine, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{
+			Sources: []string{"in"},
+			Sinks:   []string{"a", "out"},
+			Sink:    recorder.sink,
+		})
+		So(err, ShouldBeNil)
+
+		engine.Start(context.Background())
+		engine.Send("in", pipeline.DataPoint{Data: map[string]interface{}{"name": "first", "stream": "a"}})
+		engine.Send("in", pipeline.DataPoint{Data: map[string]interface{}{"name": "second", "stream": "b"}})
+		engine.Close()
+
+		Convey("Should only deliver to the selected stream", func() {
+			So(engine.Wait(), ShouldBeNil)
+			So(recorder.sorted("a"), ShouldResemble, []string{"first"})
+			So(recorder.sorted("out"), ShouldResemble, []string{"second!"})
+		})
+	})
+
+	Convey("Given an activity that fails", t, func() {
+		registerEngineTestActivities()
+		recorder := &sinkRecorder{names: map[string][]string{}}
+
+		activities := []pipeline.Activity{
+			{ID: "check", Type: "fail", InputStreams: []string{"in"}, OutputStreams: []string{"out"}},
+		}
+
+		engine, _ := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{
+			Sources:    []string{"in"},
+			Sinks:      []string{"out"},
+			Sink:       recorder.sink,
+			BufferSize: 1,
+		})
+
+		engine.Start(context.Background())
+		engine.Send("in", pipeline.DataPoint{Data: map[string]interface{}{"name": "ok"}})
+		engine.Send("in", pipeline.DataPoint{Data: map[string]interface{}{"fail": true}})
+
+		// Keep sending until the engine stops accepting data points
+		for i := 0; i < 10; i++ {
+			if engine.Send("in", pipeline.DataPoint{Data: map[string]interface{}{"name": i}}) != nil {
+				break
+			}
+		}
+		engine.Close()
+
+		Convey("Should stop the engine with the activity error", func() {
+			err := engine.Wait()
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, pipeerrors.PipelineActivityRunError)
+			So(err.Error(), ShouldContainSubstring, "check")
+			So(recorder.sorted("out"), ShouldResemble, []string{"ok"})
+		})
+	})
+
+	Convey("Given a context that is cancelled", t, func() {
+		registerEngineTestActivities()
+
+		activities := []pipeline.Activity{
+			{ID: "check", Type: "fail", InputStreams: []string{"in"}, OutputStreams: []string{"out"}},
+		}
+
+		engine, _ := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{Sources: []string{"in"}, Sinks: []string{"out"}})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		engine.Start(ctx)
+		cancel()
+
+		Convey("Should stop the engine", func() {
+			So(engine.Wait(), ShouldEqual, context.Canceled)
+		})
+	})
+
+	Convey("Given an activity with invalid settings", t, func() {
+		registerEngineTestActivities()
+
+		activities := []pipeline.Activity{
+			{ID: "a", Type: "append", InputStreams: []string{"in"}, OutputStreams: []string{"out"}},
+		}
+
+		_, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{Sources: []string{"in"}, Sinks: []string{"out"}})
+
+		Convey("Should return the initialization error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, pipeerrors.PipelineActivityRunError)
+		})
+	})
+
+	Convey("Given an activity type that is not registered", t, func() {
+		unregisterAllActivityFactories()
+
+		activities := []pipeline.Activity{
+			{ID: "a", Type: "unknown", InputStreams: []string{"in"}, OutputStreams: []string{"out"}},
+		}
+
+		_, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{Sources: []string{"in"}, Sinks: []string{"out"}})
+
+		Convey("Should return an error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, pipeerrors.GetActivityNodeError)
+		})
+	})
+
+	Convey("Given an activity that holds data points back", t, func() {
+		registerEngineTestActivities()
+		recorder := &sinkRecorder{names: map[string][]string{}}
+
+		activities := []pipeline.Activity{
+			{ID: "count", Type: "count", InputStreams: []string{"in"}, OutputStreams: []string{"counted"}},
+			{ID: "append", Type: "append", InputStreams: []string{"counted"}, OutputStreams: []string{"out"}, Settings: map[string]interface{}{"suffix": "!"}},
+		}
+		config := EngineConfig{Sources: []string{"in"}, Sinks: []string{"out"}, Sink: recorder.sink}
+
+		engine, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, config)
+		So(err, ShouldBeNil)
+
+		engine.Start(context.Background())
+		for i := 0; i < 3; i++ {
+			So(engine.Send("in", pipeline.DataPoint{}), ShouldBeNil)
+		}
+
+		Convey("Should flush the activity when the engine is closed", func() {
+			engine.Close()
+			So(engine.Wait(), ShouldBeNil)
+			So(recorder.sorted("out"), ShouldResemble, []string{"3!"})
+		})
+
+		Convey("Should restore the checkpoint in a new engine", func() {
+			engine.Close()
+			So(engine.Wait(), ShouldBeNil)
+
+			checkpoints, err := engine.Checkpoint()
+			So(err, ShouldBeNil)
+			So(checkpoints, ShouldResemble, map[string][]byte{"count": []byte("3")})
+
+			config.Checkpoints = checkpoints
+			restored, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, config)
+			So(err, ShouldBeNil)
+
+			restored.Start(context.Background())
+			So(restored.Send("in", pipeline.DataPoint{}), ShouldBeNil)
+			restored.Close()
+			So(restored.Wait(), ShouldBeNil)
+			So(recorder.sorted("out"), ShouldResemble, []string{"3!", "4!"})
+		})
+	})
+
+	Convey("Given an activity that acts on the passing of time", t, func() {
+		registerEngineTestActivities()
+		recorder := &sinkRecorder{names: map[string][]string{}}
+
+		activities := []pipeline.Activity{
+			{ID: "tick", Type: "tick", InputStreams: []string{"in"}, OutputStreams: []string{"out"}},
+		}
+		engine, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{Sources: []string{"in"}, Sinks: []string{"out"}, Sink: recorder.sink})
+		So(err, ShouldBeNil)
+
+		engine.Start(context.Background())
+
+		Convey("Should tick the activity while it runs", func() {
+			deadline := time.Now().Add(5 * time.Second)
+			for len(recorder.sorted("out")) == 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			engine.Close()
+			So(engine.Wait(), ShouldBeNil)
+			So(recorder.sorted("out"), ShouldResemble, []string{"tick"})
+		})
+	})
+
+	Convey("Given activities that hold resources", t, func() {
+		registerEngineTestActivities()
+		atomic.StoreInt32(&closedActivities, 0)
+
+		activities := []pipeline.Activity{
+			{ID: "close", Type: "close", InputStreams: []string{"in"}, OutputStreams: []string{"closed"}},
+			{ID: "append", Type: "append", InputStreams: []string{"closed"}, OutputStreams: []string{"out"}, Settings: map[string]interface{}{"suffix": "!"}},
+		}
+
+		Convey("Should close them when the engine stops", func() {
+			engine, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{Sources: []string{"in"}, Sinks: []string{"out"}})
+			So(err, ShouldBeNil)
+
+			engine.Start(context.Background())
+			So(engine.Send("in", pipeline.DataPoint{}), ShouldBeNil)
+			engine.Close()
+			So(engine.Wait(), ShouldBeNil)
+			So(atomic.LoadInt32(&closedActivities), ShouldEqual, 1)
+		})
+
+		Convey("Should close them when the engine cannot be built", func() {
+			activities[1].Settings = map[string]interface{}{}
+			_, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{Sources: []string{"in"}, Sinks: []string{"out"}})
+			So(err, ShouldNotBeNil)
+			So(atomic.LoadInt32(&closedActivities), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a checkpoint for an activity that does not support checkpoints", t, func() {
+		registerEngineTestActivities()
+
+		activities := []pipeline.Activity{
+			{ID: "a", Type: "fail", InputStreams: []string{"in"}, OutputStreams: []string{"out"}},
+		}
+
+		_, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{
+			Sources:     []string{"in"},
+			Sinks:       []string{"out"},
+			Checkpoints: map[string][]byte{"a": []byte("{}")},
+		})
+
+		Convey("Should return an error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "does not support checkpoints")
+		})
+	})
+
+	Convey("Given an activity that fails with the default policy", t, func() {
+		recorder, logs, err := runWithPolicy("fail", nil, "a", "bad", "c")
+
+		Convey("Should stop the engine and log the error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, pipeerrors.PipelineActivityRunError)
+			So(recorder.sorted("out"), ShouldResemble, []string{"a"})
+			So(logs.entries, ShouldHaveLength, 1)
+
+			entry := logs.entries[0]
+			So(entry.TenantID, ShouldEqual, "vandelay")
+			So(entry.ResourceID, ShouldEqual, "test")
+			So(entry.ObjectID, ShouldEqual, "check")
+			So(entry.Action, ShouldEqual, ErrorActionFail)
+			So(entry.Level, ShouldEqual, "error")
+			So(entry.Message, ShouldEqual, "pipeline: activity 'check' failed: failed on purpose")
+			So(entry.Error.Code, ShouldEqual, pipeerrors.PipelineActivityRunError)
+			So(entry.DataPoint, ShouldResemble, &dataflow.DataPoint{Type: "customers", Key: `"name"="bad"`})
+		})
+	})
+
+	for _, fails := range []string{"tick", "flush", "close"} {
+		Convey("Given an activity that fails to "+fails, t, func() {
+			var logs *logRecorder
+			var err error
+			if fails == "tick" {
+				logs, err = runUntilFailure("brokentick", "skip")
+			} else {
+				_, logs, err = runWithPolicy("broken"+fails, "skip", "a")
+			}
+
+			Convey("Should stop the engine and log the error, whatever the error policy", func() {
+				So(err, ShouldNotBeNil)
+				So(err.(errors.Error).Code, ShouldEqual, pipeerrors.PipelineActivityRunError)
+				So(logs.actions(), ShouldResemble, []string{ErrorActionFail})
+
+				entry := logs.entries[0]
+				So(entry.ObjectID, ShouldEqual, "check")
+				So(entry.Level, ShouldEqual, "error")
+				So(entry.Message, ShouldEqual, fmt.Sprintf("pipeline: activity 'check' failed to %s: %s failed on purpose", fails, fails))
+				So(entry.Error.Code, ShouldEqual, pipeerrors.PipelineActivityRunError)
+				So(entry.DataPoint, ShouldBeNil)
+			})
+		})
+	}
+
+	Convey("Given an activity that skips data points that fail", t, func() {
+		recorder, logs, err := runWithPolicy("fail", "skip", "a", "bad", "c")
+
+		Convey("Should continue with the next data point", func() {
+			So(err, ShouldBeNil)
+			So(recorder.sorted("out"), ShouldResemble, []string{"a", "c"})
+			So(logs.actions(), ShouldResemble, []string{"skip"})
+			So(logs.entries[0].Level, ShouldEqual, "warning")
+		})
+	})
+
+	Convey("Given an activity that routes data points that fail", t, func() {
+		var routed []pipeline.DataPoint
+		registerEngineTestActivities()
+
+		activities := []pipeline.Activity{
+			{ID: "check", Type: "fail", InputStreams: []string{"in"}, OutputStreams: []string{"out", "errors"}, Settings: map[string]interface{}{
+				ErrorPolicySetting: map[string]interface{}{"action": "route", "stream": "errors"},
+			}},
+		}
+
+		var mu sync.Mutex
+		out := []string{}
+		engine, err := NewEngine(pipeline.Pipeline{ID: "test"}, activities, EngineConfig{
+			Sources: []string{"in"},
+			Sinks:   []string{"out", "errors"},
+			Sink: func(stream string, dp pipeline.DataPoint) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if stream == "errors" {
+					routed = append(routed, dp)
+				} else {
+					out = append(out, fmt.Sprint(dp.Data["name"]))
+				}
+				return nil
+			},
+		})
+		So(err, ShouldBeNil)
+
+		engine.Start(context.Background())
+		engine.Send("in", pipeline.DataPoint{Data: map[string]interface{}{"name": "a"}})
+		engine.Send("in", pipeline.DataPoint{Meta: map[string]string{"batch": "1"}, Data: map[string]interface{}{"name": "bad", "fail": true}})
+		engine.Close()
+
+		Convey("Should emit the data point to the error stream only", func() {
+			So(engine.Wait(), ShouldBeNil)
+			So(out, ShouldResemble, []string{"a"})
+			So(routed, ShouldHaveLength, 1)
+			So(routed[0].Data["name"], ShouldEqual, "bad")
+			So(routed[0].Meta, ShouldResemble, map[string]string{
+				"batch":              "1",
+				ErrorMetaKey:         "pipeline: activity 'check' failed: failed on purpose",
+				ErrorCodeMetaKey:     strconv.Itoa(pipeerrors.PipelineActivityRunError),
+				ErrorActivityMetaKey: "check",
+			})
+		})
+	})
+
+	Convey("Given an activity that is retried", t, func() {
+		policy := map[string]interface{}{"action": "retry", "retries": float64(2), "backoff": "1ms"}
+
+		Convey("Should emit the data point when a retry succeeds", func() {
+			recorder, logs, err := runWithPolicy("flaky", policy, "a", "b")
+			So(err, ShouldBeNil)
+			So(recorder.sorted("out"), ShouldResemble, []string{"a", "b"})
+			So(logs.actions(), ShouldResemble, []string{"retry", "retry"})
+		})
+
+		Convey("Should take the next action when the retries are exhausted", func() {
+			policy["then"] = "skip"
+			recorder, logs, err := runWithPolicy("fail", policy, "bad", "c")
+			So(err, ShouldBeNil)
+			So(recorder.sorted("out"), ShouldResemble, []string{"c"})
+			So(logs.actions(), ShouldResemble, []string{"retry", "retry", "skip"})
+		})
+
+		Convey("Should fail when the retries are exhausted by default", func() {
+			_, logs, err := runWithPolicy("fail", policy, "bad")
+			So(err, ShouldNotBeNil)
+			So(logs.actions(), ShouldResemble, []string{"retry", "retry", "fail"})
+		})
+	})
+
+	Convey("Given an invalid error policy", t, func() {
+		_, _, err := runWithPolicy("fail", "ignore")
+
+		Convey("Should return an error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, pipeerrors.InvalidActivitySettings)
+		})
+	})
+}
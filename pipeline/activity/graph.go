@@ -0,0 +1,211 @@
+package activity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+)
+
+// Graph is a directed acyclic graph of activities connected by streams.  An
+// activity consumes every stream listed in its InputStreams and emits to every
+// stream listed in its OutputStreams.
+type Graph struct {
+	Activities []pipeline.Activity // The activities in topological order
+	Sources    []string            // Streams that are fed from outside the graph
+	Sinks      []string            // Streams that leave the graph
+
+	consumers map[string][]int // stream -> index of the consuming activities
+	producers map[string][]int // stream -> index of the producing activities
+}
+
+// BuildGraph connects the activities using their streams.  Sources are the streams
+// that will be fed from outside of the graph and sinks are the streams that will be
+// read from outside of the graph.  An error is returned if the activities contain a
+// cycle, if an input stream is never produced, or if an output stream is never consumed.
+func BuildGraph(activities []pipeline.Activity, sources, sinks []string) (*Graph, error) {
+	isSource := toSet(sources)
+	isSink := toSet(sinks)
+	ids := map[string]bool{}
+
+	consumers := map[string][]int{}
+	producers := map[string][]int{}
+
+	for i, a := range activities {
+		if ids[a.ID] {
+			return nil, errors.NewWithCode(pipeerrors.DuplicateActivityID, fmt.Sprintf("pipeline: activity id '%s' is used more than once", a.ID))
+		}
+		ids[a.ID] = true
+
+		if len(a.InputStreams) == 0 {
+			return nil, errors.NewWithCode(pipeerrors.InvalidInputStreamID, fmt.Sprintf("pipeline: activity '%s' does not have any input streams", a.ID))
+		}
+
+		for _, s := range a.InputStreams {
+			consumers[s] = append(consumers[s], i)
+		}
+		for _, s := range a.OutputStreams {
+			producers[s] = append(producers[s], i)
+		}
+	}
+
+	for _, a := range activities {
+		for _, s := range a.InputStreams {
+			if !isSource[s] && len(producers[s]) == 0 {
+				return nil, errors.NewWithCode(pipeerrors.InvalidInputStreamID, fmt.Sprintf("pipeline: input stream '%s' of activity '%s' is not produced by any activity", s, a.ID))
+			}
+		}
+		for _, s := range a.OutputStreams {
+			if !isSink[s] && len(consumers[s]) == 0 {
+				return nil, errors.NewWithCode(pipeerrors.TopicToInputMismatch, fmt.Sprintf("pipeline: output stream '%s' of activity '%s' is not consumed by any activity", s, a.ID))
+			}
+		}
+	}
+
+	for _, s := range sources {
+		if len(consumers[s]) == 0 {
+			return nil, errors.NewWithCode(pipeerrors.InvalidInputStreamID, fmt.Sprintf("pipeline: source stream '%s' is not consumed by any activity", s))
+		}
+	}
+
+	for _, s := range sinks {
+		if len(producers[s]) == 0 {
+			return nil, errors.NewWithCode(pipeerrors.TopicToInputMismatch, fmt.Sprintf("pipeline: sink stream '%s' is not produced by any activity", s))
+		}
+	}
+
+	order, err := topologicalOrder(activities, consumers)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Graph{
+		Activities: make([]pipeline.Activity, len(order)),
+		Sources:    sources,
+		Sinks:      sinks,
+		consumers:  map[string][]int{},
+		producers:  map[string][]int{},
+	}
+
+	// Re-index the streams using the sorted positions
+	position := make([]int, len(activities))
+	for pos, i := range order {
+		position[i] = pos
+		g.Activities[pos] = activities[i]
+	}
+	for s, list := range consumers {
+		for _, i := range list {
+			g.consumers[s] = append(g.consumers[s], position[i])
+		}
+	}
+	for s, list := range producers {
+		for _, i := range list {
+			g.producers[s] = append(g.producers[s], position[i])
+		}
+	}
+
+	return g, nil
+}
+
+// Downstream returns the IDs of the activities that consume the outputs of the activity.
+func (g *Graph) Downstream(id string) []string {
+	var list []string
+	for _, i := range g.downstream(g.indexOf(id)) {
+		list = append(list, g.Activities[i].ID)
+	}
+	return list
+}
+
+func (g *Graph) indexOf(id string) int {
+	for i, a := range g.Activities {
+		if a.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// downstream returns the sorted, distinct positions of the activities that
+// consume the outputs of the activity at position i.
+func (g *Graph) downstream(i int) []int {
+	if i < 0 {
+		return nil
+	}
+
+	seen := map[int]bool{}
+	var list []int
+	for _, s := range g.Activities[i].OutputStreams {
+		for _, c := range g.consumers[s] {
+			if !seen[c] {
+				seen[c] = true
+				list = append(list, c)
+			}
+		}
+	}
+	sort.Ints(list)
+	return list
+}
+
+// topologicalOrder sorts the activities so that every activity comes after the
+// activities it consumes from.  Activities keep their original order when possible.
+func topologicalOrder(activities []pipeline.Activity, consumers map[string][]int) ([]int, error) {
+	inDegree := make([]int, len(activities))
+	edges := make([][]int, len(activities))
+
+	for i, a := range activities {
+		seen := map[int]bool{}
+		for _, s := range a.OutputStreams {
+			for _, c := range consumers[s] {
+				if !seen[c] {
+					seen[c] = true
+					edges[i] = append(edges[i], c)
+					inDegree[c]++
+				}
+			}
+		}
+	}
+
+	var ready, order []int
+	for i := range activities {
+		if inDegree[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		i := ready[0]
+		ready = ready[1:]
+		order = append(order, i)
+
+		for _, c := range edges[i] {
+			inDegree[c]--
+			if inDegree[c] == 0 {
+				ready = append(ready, c)
+			}
+		}
+	}
+
+	if len(order) != len(activities) {
+		var cycle []string
+		for i, d := range inDegree {
+			if d > 0 {
+				cycle = append(cycle, activities[i].ID)
+			}
+		}
+		return nil, errors.NewWithCode(pipeerrors.ActivityGraphCycle, fmt.Sprintf("pipeline: activities contain a cycle: %s", strings.Join(cycle, ", ")))
+	}
+
+	return order, nil
+}
+
+func toSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, s := range list {
+		set[s] = true
+	}
+	return set
+}
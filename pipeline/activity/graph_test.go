@@ -0,0 +1,98 @@
+package activity
+
+import (
+	"testing"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBuildGraph(t *testing.T) {
+
+	Convey("Given activities that are out of order", t, func() {
+		activities := []pipeline.Activity{
+			{ID: "c", InputStreams: []string{"b-out"}, OutputStreams: []string{"out"}},
+			{ID: "b", InputStreams: []string{"a-out"}, OutputStreams: []string{"b-out"}},
+			{ID: "a", InputStreams: []string{"in"}, OutputStreams: []string{"a-out"}},
+		}
+
+		g, err := BuildGraph(activities, []string{"in"}, []string{"out"})
+
+		Convey("Should sort the activities topologically", func() {
+			So(err, ShouldBeNil)
+			So(activityIDs(g.Activities), ShouldResemble, []string{"a", "b", "c"})
+		})
+
+		Convey("Should return the downstream activities", func() {
+			So(g.Downstream("a"), ShouldResemble, []string{"b"})
+			So(g.Downstream("c"), ShouldBeEmpty)
+		})
+	})
+
+	testCases := []struct {
+		name       string
+		activities []pipeline.Activity
+		code       int
+	}{
+		{
+			"Given activities with a cycle",
+			[]pipeline.Activity{
+				{ID: "a", InputStreams: []string{"in", "c-out"}, OutputStreams: []string{"a-out", "out"}},
+				{ID: "b", InputStreams: []string{"a-out"}, OutputStreams: []string{"b-out"}},
+				{ID: "c", InputStreams: []string{"b-out"}, OutputStreams: []string{"c-out"}},
+			},
+			pipeerrors.ActivityGraphCycle,
+		},
+		{
+			"Given an input stream that is never produced",
+			[]pipeline.Activity{
+				{ID: "a", InputStreams: []string{"missing"}, OutputStreams: []string{"out"}},
+			},
+			pipeerrors.InvalidInputStreamID,
+		},
+		{
+			"Given an output stream that is never consumed",
+			[]pipeline.Activity{
+				{ID: "a", InputStreams: []string{"in"}, OutputStreams: []string{"out", "dangling"}},
+			},
+			pipeerrors.TopicToInputMismatch,
+		},
+		{
+			"Given an activity without inputs",
+			[]pipeline.Activity{
+				{ID: "a", InputStreams: []string{"in"}, OutputStreams: []string{"out"}},
+				{ID: "b", OutputStreams: []string{"out"}},
+			},
+			pipeerrors.InvalidInputStreamID,
+		},
+		{
+			"Given duplicate activity ids",
+			[]pipeline.Activity{
+				{ID: "a", InputStreams: []string{"in"}, OutputStreams: []string{"out"}},
+				{ID: "a", InputStreams: []string{"in"}, OutputStreams: []string{"out"}},
+			},
+			pipeerrors.DuplicateActivityID,
+		},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			_, err := BuildGraph(tc.activities, []string{"in"}, []string{"out"})
+
+			Convey("Should return an error with the correct code", func() {
+				So(err, ShouldNotBeNil)
+				So(err.(errors.Error).Code, ShouldEqual, tc.code)
+			})
+		})
+	}
+}
+
+func activityIDs(activities []pipeline.Activity) []string {
+	ids := make([]string, len(activities))
+	for i, a := range activities {
+		ids[i] = a.ID
+	}
+	return ids
+}
@@ -0,0 +1,192 @@
+package activity
+
+import (
+	"fmt"
+	"time"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+)
+
+// ErrorPolicySetting is the activity setting that configures what the engine
+// does when the activity returns an error.  The setting is either the name of
+// an action, or an object with the action and its options:
+//
+//	"errorPolicy": "skip"
+//	"errorPolicy": {"action": "retry", "retries": 5, "backoff": "1s", "then": "route", "stream": "errors"}
+//	"errorPolicy": {"action": "route", "stream": "errors"}
+const ErrorPolicySetting = "errorPolicy"
+
+// The actions of an error policy.
+const (
+	ErrorActionFail  = "fail"  // Stop the pipeline, this is the default
+	ErrorActionSkip  = "skip"  // Drop the data point and continue
+	ErrorActionRetry = "retry" // Execute the activity again after a delay
+	ErrorActionRoute = "route" // Emit the data point to an error stream and continue
+)
+
+// The meta data added to data points that are routed to an error stream.
+const (
+	ErrorMetaKey         = "error"
+	ErrorCodeMetaKey     = "errorCode"
+	ErrorActivityMetaKey = "errorActivity"
+)
+
+// The default options of the retry action.
+const (
+	DefaultRetries    = 3
+	DefaultBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+// ErrorPolicy defines what the engine does when an activity returns an error.
+// Retries wait for the backoff, which is doubled after each retry up to the
+// maximum.  When the retries are exhausted the Then action is taken.  Activities
+// that are retried should not emit data points before they fail, because the
+// data points are emitted again when the retry succeeds.
+type ErrorPolicy struct {
+	Action     string        `json:"action"`
+	Retries    int           `json:"retries,omitempty"`
+	Backoff    time.Duration `json:"backoff,omitempty"`
+	MaxBackoff time.Duration `json:"maxBackoff,omitempty"`
+	Then       string        `json:"then,omitempty"`   // The action after the retries: fail, skip or route
+	Stream     string        `json:"stream,omitempty"` // The output stream for the route action
+}
+
+// ParseErrorPolicy reads the error policy from the settings of the activity.  The
+// stream of the route action must be one of the output streams of the activity.
+func ParseErrorPolicy(def pipeline.Activity) (ErrorPolicy, error) {
+	policy := ErrorPolicy{Action: ErrorActionFail}
+
+	switch v := def.Settings[ErrorPolicySetting].(type) {
+	case nil:
+		return policy, nil
+	case string:
+		policy.Action = v
+	case map[string]interface{}:
+		var err error
+		if policy, err = parseErrorPolicyObject(v); err != nil {
+			return policy, policyError(def, "%v", err)
+		}
+	default:
+		return policy, policyError(def, "must be a string or an object")
+	}
+
+	switch policy.Action {
+	case ErrorActionFail, ErrorActionSkip, ErrorActionRoute:
+		if policy.Then != "" {
+			return policy, policyError(def, "'then' is only allowed for the retry action")
+		}
+	case ErrorActionRetry:
+		if policy.Retries == 0 {
+			policy.Retries = DefaultRetries
+		}
+		if policy.Backoff == 0 {
+			policy.Backoff = DefaultBackoff
+		}
+		if policy.MaxBackoff == 0 {
+			policy.MaxBackoff = DefaultMaxBackoff
+		}
+		switch policy.Then {
+		case "":
+			policy.Then = ErrorActionFail
+		case ErrorActionFail, ErrorActionSkip, ErrorActionRoute:
+		default:
+			return policy, policyError(def, "'then' must be fail, skip or route not '%s'", policy.Then)
+		}
+	default:
+		return policy, policyError(def, "has unknown action '%s'", policy.Action)
+	}
+
+	if policy.Action == ErrorActionRoute || policy.Then == ErrorActionRoute {
+		if policy.Stream == "" {
+			return policy, policyError(def, "requires a stream to route to")
+		}
+		found := false
+		for _, s := range def.OutputStreams {
+			found = found || s == policy.Stream
+		}
+		if !found {
+			return policy, policyError(def, "stream '%s' is not an output stream of the activity", policy.Stream)
+		}
+	} else if policy.Stream != "" {
+		return policy, policyError(def, "'stream' is only allowed when errors are routed")
+	}
+
+	return policy, nil
+}
+
+func parseErrorPolicyObject(values map[string]interface{}) (ErrorPolicy, error) {
+	var policy ErrorPolicy
+
+	for k, v := range values {
+		switch k {
+		case "action", "then", "stream":
+			s, ok := v.(string)
+			if !ok {
+				return policy, fmt.Errorf("'%s' must be a string", k)
+			}
+			switch k {
+			case "action":
+				policy.Action = s
+			case "then":
+				policy.Then = s
+			default:
+				policy.Stream = s
+			}
+		case "retries":
+			n, ok := positiveInt(v)
+			if !ok {
+				return policy, fmt.Errorf("'retries' must be a positive integer")
+			}
+			policy.Retries = n
+		case "backoff", "maxBackoff":
+			s, _ := v.(string)
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				return policy, fmt.Errorf("'%s' must be a positive duration such as '1s'", k)
+			}
+			if k == "backoff" {
+				policy.Backoff = d
+			} else {
+				policy.MaxBackoff = d
+			}
+		default:
+			return policy, fmt.Errorf("has unknown option '%s'", k)
+		}
+	}
+
+	if policy.Action == "" {
+		return policy, fmt.Errorf("requires an action")
+	}
+
+	return policy, nil
+}
+
+func positiveInt(v interface{}) (int, bool) {
+	switch x := v.(type) {
+	case int:
+		return x, x > 0
+	case float64:
+		// JSON deserialization always uses float64
+		return int(x), x > 0 && x == float64(int(x))
+	}
+	return 0, false
+}
+
+func policyError(def pipeline.Activity, format string, args ...interface{}) error {
+	return errors.NewWithCode(pipeerrors.InvalidActivitySettings, fmt.Sprintf("pipeline: setting '%s' of activity '%s' %s", ErrorPolicySetting, def.ID, fmt.Sprintf(format, args...)))
+}
+
+// backoff returns the delay before the retry with the given index, starting at 0.
+func (p ErrorPolicy) backoff(retry int) time.Duration {
+	d := p.Backoff
+	for i := 0; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
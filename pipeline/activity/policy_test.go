@@ -0,0 +1,94 @@
+package activity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseErrorPolicy(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		policy   interface{}
+		expected ErrorPolicy
+	}{
+		{"Given no policy", nil, ErrorPolicy{Action: ErrorActionFail}},
+		{"Given the name of an action", "skip", ErrorPolicy{Action: ErrorActionSkip}},
+		{"Given a route", map[string]interface{}{"action": "route", "stream": "errors"}, ErrorPolicy{Action: ErrorActionRoute, Stream: "errors"}},
+		{
+			"Given a retry with the default options",
+			"retry",
+			ErrorPolicy{Action: ErrorActionRetry, Retries: DefaultRetries, Backoff: DefaultBackoff, MaxBackoff: DefaultMaxBackoff, Then: ErrorActionFail},
+		},
+		{
+			"Given a retry with options",
+			map[string]interface{}{"action": "retry", "retries": float64(5), "backoff": "1s", "maxBackoff": "1m", "then": "route", "stream": "errors"},
+			ErrorPolicy{Action: ErrorActionRetry, Retries: 5, Backoff: time.Second, MaxBackoff: time.Minute, Then: ErrorActionRoute, Stream: "errors"},
+		},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			policy, err := ParseErrorPolicy(pipeline.Activity{
+				ID:            "a",
+				OutputStreams: []string{"out", "errors"},
+				Settings:      map[string]interface{}{ErrorPolicySetting: tc.policy},
+			})
+
+			Convey("Should return the policy", func() {
+				So(err, ShouldBeNil)
+				So(policy, ShouldResemble, tc.expected)
+			})
+		})
+	}
+
+	invalid := []struct {
+		name   string
+		policy interface{}
+	}{
+		{"an unknown action", "ignore"},
+		{"a number", float64(3)},
+		{"an object without an action", map[string]interface{}{"retries": float64(2)}},
+		{"an unknown option", map[string]interface{}{"action": "skip", "delay": "1s"}},
+		{"a route without a stream", "route"},
+		{"a route to a stream that is not an output", map[string]interface{}{"action": "route", "stream": "missing"}},
+		{"a stream without a route", map[string]interface{}{"action": "skip", "stream": "errors"}},
+		{"then without a retry", map[string]interface{}{"action": "skip", "then": "fail"}},
+		{"an invalid then", map[string]interface{}{"action": "retry", "then": "retry"}},
+		{"negative retries", map[string]interface{}{"action": "retry", "retries": float64(-1)}},
+		{"an invalid backoff", map[string]interface{}{"action": "retry", "backoff": "soon"}},
+	}
+
+	for _, tc := range invalid {
+		Convey("Given "+tc.name, t, func() {
+			_, err := ParseErrorPolicy(pipeline.Activity{
+				ID:            "a",
+				OutputStreams: []string{"out", "errors"},
+				Settings:      map[string]interface{}{ErrorPolicySetting: tc.policy},
+			})
+
+			Convey("Should return an error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "activity 'a'")
+			})
+		})
+	}
+}
+
+func TestErrorPolicyBackoff(t *testing.T) {
+
+	Convey("Given a retry policy", t, func() {
+		policy := ErrorPolicy{Action: ErrorActionRetry, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+		Convey("Should double the backoff up to the maximum", func() {
+			So(policy.backoff(0), ShouldEqual, time.Second)
+			So(policy.backoff(1), ShouldEqual, 2*time.Second)
+			So(policy.backoff(2), ShouldEqual, 4*time.Second)
+			So(policy.backoff(3), ShouldEqual, 5*time.Second)
+			So(policy.backoff(100), ShouldEqual, 5*time.Second)
+		})
+	})
+}
@@ -0,0 +1,86 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/naveego/api/pipeline/runner"
+	"github.com/spf13/cobra"
+)
+
+var (
+	maxRecords int
+	summary    bool
+	verbose    bool
+)
+
+// RootCmd runs a pipeline definition in a single process.  The publishers,
+// subscribers and activities used by the definition must be registered by
+// importing their packages into the executable.
+var RootCmd = &cobra.Command{
+	Use:   "run [definition]",
+	Short: "Runs a pipeline locally from a JSON or YAML definition",
+	RunE:  runLocal,
+}
+
+func init() {
+	RootCmd.SilenceUsage = true
+	RootCmd.Flags().IntVarP(&maxRecords, "max-records", "n", 0, "Stop the publisher after this many records (0 for no limit)")
+	RootCmd.Flags().BoolVarP(&summary, "summary", "s", true, "Print the number of records that passed through each stage")
+	RootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Turn on verbose logging")
+}
+
+// Execute is the main entry command for the package.
+func Execute() error {
+	return RootCmd.Execute()
+}
+
+func runLocal(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected the path of the pipeline definition")
+	}
+
+	if verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	def, err := runner.Load(args[0])
+	if err != nil {
+		return err
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"pipeline": map[string]interface{}{
+			"id":         def.Pipeline.ID,
+			"publisher":  def.Pipeline.PublisherType,
+			"subscriber": def.Pipeline.SubscriberType,
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			log.Info("Stopping the pipeline")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	log.Infof("Running pipeline from %s", args[0])
+	result, err := runner.Run(ctx, def, runner.Options{MaxRecords: maxRecords, Logger: log})
+
+	if summary {
+		result.Print(os.Stdout)
+	}
+
+	return err
+}
@@ -92,4 +92,5 @@ func addCommands() {
 	RootCmd.AddCommand(shapesCmd)
 	RootCmd.AddCommand(publishCmd)
 	RootCmd.AddCommand(runCmd)
+	RootCmd.AddCommand(scheduleCmd)
 }
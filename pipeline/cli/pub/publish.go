@@ -1,6 +1,9 @@
 package pub
 
 import (
+	"errors"
+
+	"github.com/naveego/api/pipeline/command"
 	"github.com/naveego/api/pipeline/publisher"
 	"github.com/spf13/cobra"
 )
@@ -12,18 +15,24 @@ var publishCmd = &cobra.Command{
 }
 
 func runPublish(cmd *cobra.Command, args []string) error {
-	pubFactory, err := publisher.GetFactory(TypeName)
+	dispatcher, err := command.NewDispatcher(command.Options{
+		Type: TypeName,
+		Context: publisher.Context{
+			Settings: publisherInstance.Settings,
+			APIToken: apitoken,
+			Logger:   log,
+		},
+		Transport: func() publisher.DataTransport {
+			return publisher.NewDataTransport(apiURL, apitoken, log)
+		},
+	})
 	if err != nil {
 		return err
 	}
 
-	ctx := publisher.Context{
-		Logger:            log,
-		PublisherInstance: publisherInstance,
+	result := dispatcher.Run(command.Command{Type: command.PublishNow})
+	if !result.Success {
+		return errors.New(result.Error)
 	}
-
-	transport := publisher.NewDataTransport(apiURL, apitoken, log)
-	p := pubFactory()
-	p.Publish(ctx, transport)
 	return nil
 }
@@ -1,18 +1,31 @@
 package pub
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/naveego/api/live"
+	"github.com/naveego/api/pipeline/command"
 	"github.com/naveego/api/pipeline/publisher"
+	"github.com/naveego/api/pipeline/schedule"
 	"github.com/naveego/api/types/queue"
 	"github.com/robfig/cron"
 	"github.com/spf13/cobra"
 )
 
+var liveTLS live.TLSOptions
+
+func init() {
+	runCmd.Flags().StringVar(&liveTLS.CAFile, "live-ca", "", "The CA certificate file that verifies the live endpoint")
+	runCmd.Flags().StringVar(&liveTLS.CertFile, "live-cert", "", "The client certificate file of the live connection")
+	runCmd.Flags().StringVar(&liveTLS.KeyFile, "live-key", "", "The client key file of the live connection")
+	runCmd.Flags().StringVar(&liveTLS.ServerName, "live-server-name", "", "The server name that is verified on the live endpoint")
+}
+
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Runs a Naveego pipeline publisher",
@@ -20,7 +33,30 @@ var runCmd = &cobra.Command{
 }
 
 func runRun(cmd *cobra.Command, args []string) error {
-	pubFactory, err := publisher.GetFactory(TypeName)
+	// Refuse to start with a schedule that would never run the publisher
+	sched, err := schedule.Parse(publisherInstance.Schedule)
+	if err != nil {
+		return err
+	}
+
+	dispatcher, err := command.NewDispatcher(command.Options{
+		Type: TypeName,
+		Context: publisher.Context{
+			Settings: publisherInstance.Settings,
+			APIToken: apitoken,
+			Logger:   log,
+		},
+		Transport: func() publisher.DataTransport {
+			return publisher.NewDataTransport(apiURL, apitoken, log)
+		},
+		LoadSettings: func() (map[string]interface{}, error) {
+			instance, err := apiClient.GetPublisherInstance(publisherInstance.ID)
+			if err != nil {
+				return nil, err
+			}
+			return instance.Settings, nil
+		},
+	})
 	if err != nil {
 		return err
 	}
@@ -29,63 +65,122 @@ func runRun(cmd *cobra.Command, args []string) error {
 	scheduler.Start()
 	defer scheduler.Stop()
 
-	/*
-		host, _ := os.Hostname()
-		var liveCli *live.Client
-
-		if strings.HasPrefix(publisherInstance.LiveEndpoint, "tcp://") {
-			tcpURL := publisherInstance.LiveEndpoint[6:]
-			liveCli, err = live.NewTCPClient(tcpURL, publisherInstance.ID, host)
-		} else if strings.HasPrefix(publisherInstance.LiveEndpoint, "ws://") || strings.HasPrefix(publisherInstance.LiveEndpoint, "wss://") {
-			liveCli, err = live.NewWebSocketClient(publisherInstance.LiveEndpoint, publisherInstance.ID, host)
-		}
+	if publisherInstance.LiveEndpoint != "" {
+		dial, options, err := liveDialer(publisherInstance.LiveEndpoint)
 		if err != nil {
 			return err
 		}
 
-		go liveRead(liveCli)
-	*/
-
-	log.Infof("Scheduling publisher with schedule: %s", publisherInstance.Schedule)
-
-	err = scheduler.AddFunc(publisherInstance.Schedule, func() {
-
-		ctx := publisher.Context{
-			Logger:            log,
-			PublisherInstance: publisherInstance,
-		}
-
-		transport := publisher.NewDataTransport(apiURL, apitoken, log)
-		p := pubFactory()
-		p.Publish(ctx, transport)
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			runLive(dial, options, dispatcher, stop)
+		}()
+		defer func() {
+			close(stop)
+			<-stopped
+		}()
+	}
 
-	})
+	log.Infof("Scheduling publisher with schedule: %s, next run at %v", sched, sched.Next(time.Now()))
 
-	if err != nil {
-		return err
-	}
+	scheduler.Schedule(sched, cron.FuncJob(func() {
+		dispatcher.Run(command.Command{Type: command.PublishNow})
+	}))
 
 	done := make(chan bool, 1)
 
-	go monitorQueue(done)
+	go monitorQueue(done, dispatcher)
 
 	log.Info("Successfully scheduled publisher")
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt)
-	<-sigs
+
+	select {
+	case <-sigs:
+	case <-dispatcher.Done():
+		log.Info("Shutting down on command")
+	}
 
 	done <- true
 
 	return nil
 }
 
-func liveRead(client *live.Client) {
+// liveDialer returns the dialer and client options of the live endpoint, which is
+// a tcp://, tls://, ws:// or wss:// URL.  The API token authenticates the publisher,
+// but is only sent over tls:// and wss:// so that it never crosses the network in
+// plain text.
+func liveDialer(endpoint string) (live.Dialer, live.ClientOptions, error) {
+	host, _ := os.Hostname()
+
+	options := live.ClientOptions{ID: publisherInstance.ID, Host: host, Resume: true}
+
+	switch {
+	case strings.HasPrefix(endpoint, "tcp://"):
+		log.Warnf("Live endpoint '%s' is not encrypted, connecting without the API token", endpoint)
+		return live.TCPDialer(endpoint[6:]), options, nil
+	case strings.HasPrefix(endpoint, "tls://"):
+		config, err := liveTLS.ClientConfig()
+		if err != nil {
+			return nil, options, err
+		}
+		options.Token = apitoken
+		return live.TLSDialer(endpoint[6:], config), options, nil
+	case strings.HasPrefix(endpoint, "ws://"):
+		log.Warnf("Live endpoint '%s' is not encrypted, connecting without the API token", endpoint)
+		return live.WebSocketDialer(endpoint), options, nil
+	case strings.HasPrefix(endpoint, "wss://"):
+		config, err := liveTLS.ClientConfig()
+		if err != nil {
+			return nil, options, err
+		}
+		wsOptions := live.WebSocketOptions{TLSConfig: config, Token: apitoken}
+		return live.WebSocketDialerWithOptions(endpoint, wsOptions), options, nil
+	}
+	return nil, options, fmt.Errorf("unsupported live endpoint '%s'", endpoint)
+}
+
+// runLive connects to the live endpoint and handles its messages until stop is
+// closed.  The publisher keeps running while the endpoint is down: the first
+// connection is retried with backoff, and the client reconnects by itself after
+// that.
+func runLive(dial live.Dialer, options live.ClientOptions, dispatcher *command.Dispatcher, stop <-chan struct{}) {
+	backoff := live.DefaultMinBackoff
+	for {
+		liveCli, err := live.NewClient(dial, options)
+		if err == nil {
+			go func() {
+				<-stop
+				liveCli.Close()
+			}()
+			liveRead(liveCli, dispatcher)
+			return
+		}
+
+		log.Warnf("Could not connect to the live endpoint, retrying in %v: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return
+		}
+		if backoff *= 2; backoff > live.DefaultMaxBackoff {
+			backoff = live.DefaultMaxBackoff
+		}
+	}
+}
+
+func liveRead(client *live.Client, dispatcher *command.Dispatcher) {
 	for msg := range client.Incoming() {
 		log.Debugf("Received message: %s", string(msg.Content))
+		if err := dispatcher.HandleLiveMessage(client, msg); err != nil {
+			log.Warn("Error handling live message: ", err)
+		}
 	}
 }
 
-func monitorQueue(done chan bool) {
+func monitorQueue(done chan bool, dispatcher *command.Dispatcher) {
 	tickChan := time.NewTicker(15 * time.Second).C
 
 	for {
@@ -97,24 +192,17 @@ func monitorQueue(done chan bool) {
 				logrus.Warn("Error reading messages from queue: ", err)
 				continue
 			}
-			handleQueueMessages(messages)
+			handleQueueMessages(messages, dispatcher)
 		case <-done:
 			return
 		}
 	}
 }
 
-func handleQueueMessages(messages []queue.Message) {
-
-	ackIds := []int64{}
-
-	for _, msg := range messages {
-		logrus.Debugf("Received Message: %s", msg.ID)
-		ackIds = append(ackIds, msg.ID)
-
-	}
-
-	err := apiClient.AcknowledgeQueueMessages(ackIds)
+// handleQueueMessages runs the commands of the messages, and acknowledges each
+// message once its command has completed.
+func handleQueueMessages(messages []queue.Message, dispatcher *command.Dispatcher) {
+	err := dispatcher.HandleQueueMessages(messages, apiClient.AcknowledgeQueueMessages)
 	if err != nil {
 		logrus.Warn("Could not acknowledge queue messages: ", err)
 	}
@@ -0,0 +1,32 @@
+package pub
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/naveego/api/pipeline/schedule"
+	"github.com/spf13/cobra"
+)
+
+var scheduleCount int
+
+func init() {
+	scheduleCmd.Flags().IntVarP(&scheduleCount, "count", "n", 5, "The number of run times to print")
+}
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Validates the publisher schedule and prints the next run times",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sched, err := schedule.Parse(publisherInstance.Schedule)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Schedule: %s\n", sched)
+		for _, t := range sched.NextN(time.Now(), scheduleCount) {
+			fmt.Println(t.Format(time.RFC1123Z))
+		}
+		return nil
+	},
+}
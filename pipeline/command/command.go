@@ -0,0 +1,105 @@
+// Package command decodes the commands that are sent to a running publisher over
+// the queue or a live connection, and dispatches them to the publisher.
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/naveego/api/live"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/api/types/queue"
+	"github.com/naveego/errors"
+)
+
+// Type is the type of a command.
+type Type string
+
+// The commands a publisher accepts.
+const (
+	PublishNow     Type = "publish-now"     // Publish the shape, or all shapes, immediately
+	TestConnection Type = "test-connection" // Test the connection of the publisher
+	RefreshShapes  Type = "refresh-shapes"  // Return the current shapes of the publisher
+	ReloadSettings Type = "reload-settings" // Replace the settings of the publisher
+	Shutdown       Type = "shutdown"        // Stop the publisher process
+)
+
+// Command is a command sent to a publisher.
+type Command struct {
+	ID       string                 `json:"id,omitempty"`       // The ID the command is acknowledged with
+	Type     Type                   `json:"type"`               // The type of the command
+	Shape    string                 `json:"shape,omitempty"`    // optional: The name or ID of the shape to publish
+	Settings map[string]interface{} `json:"settings,omitempty"` // optional: The new settings of reload-settings
+}
+
+// Validate checks that the type of the command is known.
+func (c Command) Validate() error {
+	switch c.Type {
+	case PublishNow, TestConnection, RefreshShapes, ReloadSettings, Shutdown:
+		return nil
+	case "":
+		return errors.NewWithCode(pipeerrors.InvalidCommand, "command: the command has no type")
+	}
+	return errors.NewWithCode(pipeerrors.InvalidCommand, fmt.Sprintf("command: unknown command type '%s'", c.Type))
+}
+
+// Result is the outcome of a command, which is reported back to the platform.
+type Result struct {
+	CommandID  string                    `json:"command_id,omitempty"`
+	Type       Type                      `json:"type"`
+	Success    bool                      `json:"success"`
+	Message    string                    `json:"message,omitempty"`     // test-connection: The message of the publisher
+	Shapes     pipeline.ShapeDefinitions `json:"shapes,omitempty"`      // refresh-shapes: The shapes of the publisher
+	DataPoints int                       `json:"data_points,omitempty"` // publish-now: The number of data points published
+	Code       int                       `json:"code,omitempty"`        // The error code if the command failed
+	Error      string                    `json:"error,omitempty"`       // The error if the command failed
+}
+
+// Decode decodes a command from the data of a queue message or the JSON content
+// of a live message.
+func Decode(data map[string]interface{}) (Command, error) {
+	var cmd Command
+
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return cmd, errors.NewWithCode(pipeerrors.InvalidCommand, fmt.Sprintf("command: could not decode command: %v", err))
+	}
+	if err := json.Unmarshal(buf, &cmd); err != nil {
+		return cmd, errors.NewWithCode(pipeerrors.InvalidCommand, fmt.Sprintf("command: could not decode command: %v", err))
+	}
+
+	return cmd, cmd.Validate()
+}
+
+// FromQueueMessage decodes the command of a queue message.  The ID of the command
+// is the ID of the message.
+func FromQueueMessage(msg queue.Message) (Command, error) {
+	cmd, err := Decode(msg.Data)
+	cmd.ID = strconv.FormatInt(msg.ID, 10)
+	return cmd, err
+}
+
+// FromLiveMessage decodes the command of a live message.
+func FromLiveMessage(msg live.Message) (Command, error) {
+	var data map[string]interface{}
+	if err := msg.ReadJSON(&data); err != nil {
+		return Command{}, errors.NewWithCode(pipeerrors.InvalidCommand, fmt.Sprintf("command: could not decode command: %v", err))
+	}
+	return Decode(data)
+}
+
+// resultOf returns the result of a command, which failed if the error is not nil.
+func resultOf(cmd Command, err error) Result {
+	result := Result{CommandID: cmd.ID, Type: cmd.Type, Success: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+		result.Code = pipeerrors.CommandFailed
+		if e, ok := err.(errors.Error); ok && e.Code != 0 {
+			result.Code = e.Code
+			result.Error = e.Message
+		}
+	}
+	return result
+}
@@ -0,0 +1,351 @@
+package command
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/naveego/api/live"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/pipeline/publisher"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/api/types/queue"
+	"github.com/naveego/errors"
+)
+
+// Reporter reports the results of commands back to the platform.
+type Reporter interface {
+	Report(result Result) error
+}
+
+// ReporterFunc is a function that is a Reporter.
+type ReporterFunc func(result Result) error
+
+// Report calls the function.
+func (f ReporterFunc) Report(result Result) error {
+	return f(result)
+}
+
+// Options configure a dispatcher.
+type Options struct {
+	Type    string            // The registered type of the publisher
+	Context publisher.Context // The context the publisher is initialized with
+
+	// The transport the data points of publish-now are sent to.
+	Transport func() publisher.DataTransport
+
+	// optional: Loads the settings for a reload-settings command without settings,
+	// for example from the publisher instance in the API.
+	LoadSettings func() (map[string]interface{}, error)
+
+	// optional: Receives the results of the commands.  Results are logged if it is nil.
+	Reporter Reporter
+}
+
+// Dispatcher runs commands against a registered publisher.  Commands run one at a
+// time, each with a new instance of the publisher.
+type Dispatcher struct {
+	options Options
+	factory publisher.Factory
+	log     *logrus.Entry
+
+	runMu    sync.Mutex // Commands run one at a time
+	mu       sync.RWMutex
+	settings map[string]interface{}
+	shutdown bool
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// NewDispatcher creates a dispatcher for the publisher type of the options.
+func NewDispatcher(options Options) (*Dispatcher, error) {
+	factory, err := publisher.GetFactory(options.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	log := options.Context.Logger
+	if log == nil {
+		log = logrus.WithField("publisher", options.Type)
+	}
+
+	return &Dispatcher{
+		options:  options,
+		factory:  factory,
+		log:      log,
+		settings: options.Context.Settings,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Done is closed after a shutdown command has completed and was acknowledged.
+func (d *Dispatcher) Done() <-chan struct{} {
+	return d.done
+}
+
+// Settings returns the current settings of the publisher.
+func (d *Dispatcher) Settings() map[string]interface{} {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.settings
+}
+
+// Run runs a command and reports its result.
+func (d *Dispatcher) Run(cmd Command) Result {
+	result := d.run(cmd)
+	d.report(result)
+	d.finish()
+	return result
+}
+
+// HandleQueueMessages runs the commands of the queue messages in order, and
+// acknowledges each message once its command has completed.  Messages that are
+// not valid commands are acknowledged too, as they would never succeed.  After a
+// shutdown command the remaining messages are left on the queue.
+func (d *Dispatcher) HandleQueueMessages(messages []queue.Message, ack func(messageIDs []int64) error) error {
+	defer d.finish()
+
+	for _, msg := range messages {
+		if d.isShutdown() {
+			break
+		}
+
+		cmd, err := FromQueueMessage(msg)
+		if err != nil {
+			d.report(resultOf(cmd, err))
+		} else {
+			d.report(d.run(cmd))
+		}
+
+		if err := ack([]int64{msg.ID}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HandleLiveMessage runs the command of a live message.  The result is sent back
+// over the connection, and the command is acknowledged with Client.Ack once it
+// has completed, so a resumed connection does not send it again.
+func (d *Dispatcher) HandleLiveMessage(cli *live.Client, msg live.Message) error {
+	defer d.finish()
+
+	if d.isShutdown() {
+		return nil
+	}
+
+	cmd, err := FromLiveMessage(msg)
+	result := resultOf(cmd, err)
+	if err == nil {
+		result = d.run(cmd)
+	}
+	d.report(result)
+
+	reply, err := live.NewJSONMessage(result)
+	if err != nil {
+		return err
+	}
+	if err := cli.Send(reply); err != nil {
+		return err
+	}
+
+	if cmd.ID != "" {
+		cli.Ack(cmd.ID)
+	}
+	return nil
+}
+
+func (d *Dispatcher) run(cmd Command) Result {
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+
+	log := d.log.WithField("command", cmd.Type)
+	if cmd.ID != "" {
+		log = log.WithField("command_id", cmd.ID)
+	}
+	log.Debug("Running command")
+
+	if err := cmd.Validate(); err != nil {
+		return resultOf(cmd, err)
+	}
+
+	result := resultOf(cmd, nil)
+	if err := d.execute(cmd, &result); err != nil {
+		log.WithError(err).Warn("Command failed")
+		failed := resultOf(cmd, err)
+		result.Success, result.Code, result.Error = false, failed.Code, failed.Error
+	}
+	return result
+}
+
+// execute runs the command and sets the fields of the result it returns.
+func (d *Dispatcher) execute(cmd Command, result *Result) (err error) {
+	// A panicking publisher fails the command rather than the process
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.NewWithCode(pipeerrors.CommandFailed, fmt.Sprintf("command: the publisher panicked: %v", r))
+		}
+	}()
+
+	switch cmd.Type {
+	case PublishNow:
+		result.DataPoints, err = d.publish(cmd.Shape)
+	case TestConnection:
+		var ok bool
+		ok, result.Message, err = d.testConnection()
+		if err == nil && !ok {
+			err = errors.NewWithCode(pipeerrors.CommandFailed, "command: the connection test failed: "+result.Message)
+		}
+	case RefreshShapes:
+		result.Shapes, err = d.shapes()
+	case ReloadSettings:
+		err = d.reloadSettings(cmd.Settings)
+	case Shutdown:
+		d.mu.Lock()
+		d.shutdown = true
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// withPublisher creates and initializes a publisher, and disposes it after calling f.
+func (d *Dispatcher) withPublisher(f func(p publisher.Publisher, ctx publisher.Context) error) error {
+	ctx := d.options.Context
+	ctx.Settings = d.Settings()
+	ctx.Logger = d.log
+
+	p := d.factory()
+	if err := p.Init(ctx); err != nil {
+		return err
+	}
+	defer p.Dispose(ctx)
+
+	return f(p, ctx)
+}
+
+func (d *Dispatcher) testConnection() (ok bool, message string, err error) {
+	err = d.withPublisher(func(p publisher.Publisher, ctx publisher.Context) error {
+		var testErr error
+		ok, message, testErr = p.TestConnection(ctx)
+		return testErr
+	})
+	return
+}
+
+func (d *Dispatcher) shapes() (shapes pipeline.ShapeDefinitions, err error) {
+	err = d.withPublisher(func(p publisher.Publisher, ctx publisher.Context) error {
+		var shapesErr error
+		shapes, shapesErr = p.Shapes(ctx)
+		return shapesErr
+	})
+	return
+}
+
+// publish publishes the shape with the name or ID, or every shape if it is empty,
+// and returns the number of data points that were sent.
+func (d *Dispatcher) publish(shape string) (int, error) {
+	return d.publishShapes(func(p publisher.Publisher, ctx publisher.Context) (pipeline.ShapeDefinitions, error) {
+		shapes, err := p.Shapes(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var selected pipeline.ShapeDefinitions
+		for _, sd := range shapes {
+			if shape == "" || sd.Name == shape || sd.ID == shape {
+				selected = append(selected, sd)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, errors.NewWithCode(pipeerrors.InvalidCommand, fmt.Sprintf("command: the publisher does not publish shape '%s'", shape))
+		}
+		return selected, nil
+	})
+}
+
+// publishShapes publishes the shapes returned by selectShapes and returns the
+// number of data points that were sent.
+func (d *Dispatcher) publishShapes(selectShapes func(publisher.Publisher, publisher.Context) (pipeline.ShapeDefinitions, error)) (int, error) {
+	if d.options.Transport == nil {
+		return 0, errors.NewWithCode(pipeerrors.CommandFailed, "command: the dispatcher has no transport to publish to")
+	}
+
+	transport := &countingTransport{DataTransport: d.options.Transport()}
+
+	err := d.withPublisher(func(p publisher.Publisher, ctx publisher.Context) error {
+		selected, err := selectShapes(p, ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, sd := range selected {
+			p.Publish(ctx, sd, transport)
+		}
+		return transport.Done()
+	})
+
+	return int(atomic.LoadInt64(&transport.count)), err
+}
+
+func (d *Dispatcher) reloadSettings(settings map[string]interface{}) error {
+	if settings == nil {
+		if d.options.LoadSettings == nil {
+			return errors.NewWithCode(pipeerrors.InvalidCommand, "command: reload-settings has no settings and the dispatcher cannot load them")
+		}
+
+		var err error
+		if settings, err = d.options.LoadSettings(); err != nil {
+			return err
+		}
+	}
+
+	d.mu.Lock()
+	d.settings = settings
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Dispatcher) report(result Result) {
+	if d.options.Reporter == nil {
+		d.log.WithFields(logrus.Fields{
+			"command":    result.Type,
+			"command_id": result.CommandID,
+			"success":    result.Success,
+		}).Info("Command completed")
+		return
+	}
+
+	if err := d.options.Reporter.Report(result); err != nil {
+		d.log.WithError(err).Warnf("Could not report the result of command '%s'", result.CommandID)
+	}
+}
+
+func (d *Dispatcher) isShutdown() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.shutdown
+}
+
+// finish closes Done after a shutdown command.
+func (d *Dispatcher) finish() {
+	if d.isShutdown() {
+		d.doneOnce.Do(func() { close(d.done) })
+	}
+}
+
+// countingTransport counts the data points sent to a transport.
+type countingTransport struct {
+	publisher.DataTransport
+	count int64
+}
+
+func (t *countingTransport) Send(dataPoints []pipeline.DataPoint) error {
+	if err := t.DataTransport.Send(dataPoints); err != nil {
+		return err
+	}
+	atomic.AddInt64(&t.count, int64(len(dataPoints)))
+	return nil
+}
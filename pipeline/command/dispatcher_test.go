@@ -0,0 +1,237 @@
+package command
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/naveego/api/live"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/pipeline/publisher"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/api/types/queue"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testPublisher struct {
+	settings map[string]interface{}
+}
+
+func (p *testPublisher) Init(ctx publisher.Context) error {
+	p.settings = ctx.Settings
+	return nil
+}
+
+func (p *testPublisher) Dispose(ctx publisher.Context) error { return nil }
+
+func (p *testPublisher) TestConnection(ctx publisher.Context) (bool, string, error) {
+	host, _ := ctx.GetStringSetting("host")
+	if host == "" {
+		return false, "no host", nil
+	}
+	return true, "connected to " + host, nil
+}
+
+func (p *testPublisher) Shapes(ctx publisher.Context) (pipeline.ShapeDefinitions, error) {
+	return pipeline.ShapeDefinitions{{ID: "1", Name: "users"}, {ID: "2", Name: "orders"}}, nil
+}
+
+func (p *testPublisher) Publish(ctx publisher.Context, shape pipeline.ShapeDefinition, transport publisher.DataTransport) {
+	if shape.Name == "orders" {
+		panic("orders are broken")
+	}
+	transport.Send([]pipeline.DataPoint{ctx.NewDataPoint(shape.Name, nil, nil), ctx.NewDataPoint(shape.Name, nil, nil)})
+}
+
+type testTransport struct {
+	dataPoints []pipeline.DataPoint
+}
+
+func (t *testTransport) Send(dataPoints []pipeline.DataPoint) error {
+	t.dataPoints = append(t.dataPoints, dataPoints...)
+	return nil
+}
+
+func (t *testTransport) Done() error { return nil }
+
+func init() {
+	publisher.RegisterFactory("command-test", func() publisher.Publisher { return &testPublisher{} })
+}
+
+func newTestDispatcher(transport *testTransport, results *[]Result) *Dispatcher {
+	d, err := NewDispatcher(Options{
+		Type:      "command-test",
+		Context:   publisher.Context{Settings: map[string]interface{}{"host": "db"}},
+		Transport: func() publisher.DataTransport { return transport },
+		LoadSettings: func() (map[string]interface{}, error) {
+			return map[string]interface{}{"host": "loaded"}, nil
+		},
+		Reporter: ReporterFunc(func(result Result) error {
+			*results = append(*results, result)
+			return nil
+		}),
+	})
+	So(err, ShouldBeNil)
+	return d
+}
+
+func TestDecode(t *testing.T) {
+
+	Convey("Given the data of a queue message", t, func() {
+		msg := queue.Message{ID: 12, Data: map[string]interface{}{"type": "publish-now", "shape": "users"}}
+
+		Convey("Should decode the command", func() {
+			cmd, err := FromQueueMessage(msg)
+			So(err, ShouldBeNil)
+			So(cmd, ShouldResemble, Command{ID: "12", Type: PublishNow, Shape: "users"})
+		})
+
+		Convey("Should reject unknown commands", func() {
+			msg.Data["type"] = "format-disk"
+			_, err := FromQueueMessage(msg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "command: unknown command type 'format-disk'")
+		})
+
+		Convey("Should reject commands without a type", func() {
+			_, err := Decode(map[string]interface{}{"shape": "users"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a live message", t, func() {
+		msg, _ := live.NewJSONMessage(Command{ID: "abc", Type: Shutdown})
+
+		Convey("Should decode the command", func() {
+			cmd, err := FromLiveMessage(msg)
+			So(err, ShouldBeNil)
+			So(cmd, ShouldResemble, Command{ID: "abc", Type: Shutdown})
+		})
+	})
+}
+
+func TestDispatcher(t *testing.T) {
+
+	Convey("Given a dispatcher", t, func() {
+		transport := &testTransport{}
+		var results []Result
+		d := newTestDispatcher(transport, &results)
+
+		Convey("Should publish a shape now", func() {
+			result := d.Run(Command{ID: "1", Type: PublishNow, Shape: "users"})
+			So(result, ShouldResemble, Result{CommandID: "1", Type: PublishNow, Success: true, DataPoints: 2})
+			So(transport.dataPoints, ShouldHaveLength, 2)
+			So(results, ShouldResemble, []Result{result})
+		})
+
+		Convey("Should fail when the publisher panics", func() {
+			result := d.Run(Command{Type: PublishNow, Shape: "2"})
+			So(result.Success, ShouldBeFalse)
+			So(result.Code, ShouldEqual, pipeerrors.CommandFailed)
+			So(result.Error, ShouldEqual, "command: the publisher panicked: orders are broken")
+		})
+
+		Convey("Should fail to publish an unknown shape", func() {
+			result := d.Run(Command{Type: PublishNow, Shape: "products"})
+			So(result.Code, ShouldEqual, pipeerrors.InvalidCommand)
+		})
+
+		Convey("Should test the connection", func() {
+			result := d.Run(Command{Type: TestConnection})
+			So(result.Success, ShouldBeTrue)
+			So(result.Message, ShouldEqual, "connected to db")
+		})
+
+		Convey("Should refresh the shapes", func() {
+			result := d.Run(Command{Type: RefreshShapes})
+			So(result.Success, ShouldBeTrue)
+			So(result.Shapes, ShouldHaveLength, 2)
+		})
+
+		Convey("Should reload the settings", func() {
+			d.Run(Command{Type: ReloadSettings, Settings: map[string]interface{}{"host": ""}})
+			result := d.Run(Command{Type: TestConnection})
+			So(result.Success, ShouldBeFalse)
+			So(result.Message, ShouldEqual, "no host")
+
+			d.Run(Command{Type: ReloadSettings})
+			So(d.Settings(), ShouldResemble, map[string]interface{}{"host": "loaded"})
+		})
+
+		Convey("Should acknowledge queue messages after their commands complete", func() {
+			messages := []queue.Message{
+				{ID: 1, Data: map[string]interface{}{"type": "test-connection"}},
+				{ID: 2, Data: map[string]interface{}{"type": "unknown"}},
+				{ID: 3, Data: map[string]interface{}{"type": "shutdown"}},
+				{ID: 4, Data: map[string]interface{}{"type": "publish-now"}},
+			}
+
+			var acked []int64
+			err := d.HandleQueueMessages(messages, func(ids []int64) error {
+				So(results, ShouldHaveLength, len(acked)+1)
+				acked = append(acked, ids...)
+				return nil
+			})
+			So(err, ShouldBeNil)
+
+			Convey("Should stop after a shutdown command", func() {
+				So(acked, ShouldResemble, []int64{1, 2, 3})
+				So(results[1].Code, ShouldEqual, pipeerrors.InvalidCommand)
+				So(transport.dataPoints, ShouldBeEmpty)
+
+				_, open := <-d.Done()
+				So(open, ShouldBeFalse)
+			})
+		})
+
+		Convey("Should not acknowledge the remaining messages when acknowledging fails", func() {
+			messages := []queue.Message{
+				{ID: 1, Data: map[string]interface{}{"type": "test-connection"}},
+				{ID: 2, Data: map[string]interface{}{"type": "test-connection"}},
+			}
+
+			err := d.HandleQueueMessages(messages, func(ids []int64) error { return errors.New("queue is down") })
+			So(err, ShouldNotBeNil)
+			So(results, ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestDispatcherLive(t *testing.T) {
+
+	Convey("Given a dispatcher connected to a live server", t, func() {
+		replies := make(chan live.Message, 10)
+		server := live.NewServer(live.ServerOptions{
+			OnMessage: func(conn *live.Conn, msg live.Message) { replies <- msg },
+		})
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		go server.Serve(l)
+
+		cli, err := live.NewClient(live.TCPDialer(l.Addr().String()), live.ClientOptions{ID: "publisher"})
+		So(err, ShouldBeNil)
+		for len(server.Clients()) == 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		var results []Result
+		d := newTestDispatcher(&testTransport{}, &results)
+
+		Convey("Should run the command and reply with the result", func() {
+			msg, _ := live.NewJSONMessage(Command{ID: "cmd-1", Type: RefreshShapes})
+			So(d.HandleLiveMessage(cli, msg), ShouldBeNil)
+
+			var result Result
+			So((<-replies).ReadJSON(&result), ShouldBeNil)
+			So(result.CommandID, ShouldEqual, "cmd-1")
+			So(result.Success, ShouldBeTrue)
+			So(result.Shapes, ShouldHaveLength, 2)
+		})
+
+		Reset(func() {
+			cli.Close()
+			server.Close()
+		})
+	})
+}
@@ -0,0 +1,168 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/naveego/api/live"
+	"github.com/naveego/api/pipeline/publisher"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// The methods the platform calls on a remote agent over a live RPC.
+const (
+	MethodTestConnection live.Method = "TestConnection"
+	MethodShapes         live.Method = "Shapes"
+	MethodPublish        live.Method = "Publish"
+)
+
+// PublisherParams are the parameters of TestConnection and Shapes.
+type PublisherParams struct {
+	Type     string                 `json:"type"` // The registered publisher type
+	Settings map[string]interface{} `json:"settings,omitempty"`
+}
+
+// PublishParams are the parameters of Publish.
+type PublishParams struct {
+	PublisherParams
+	Shape pipeline.ShapeDefinition `json:"shape"` // The shape to publish
+}
+
+// TestConnectionResult is the result of TestConnection.
+type TestConnectionResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+// PublishResult is the result of Publish.
+type PublishResult struct {
+	DataPoints int `json:"data_points"` // The number of data points that were sent
+}
+
+// RemotePublisher calls the publisher methods of a remote agent.
+type RemotePublisher struct {
+	rpc *live.RPC
+}
+
+// NewRemotePublisher creates a remote publisher that calls the agent on the other
+// end of the RPC.
+func NewRemotePublisher(rpc *live.RPC) *RemotePublisher {
+	return &RemotePublisher{rpc: rpc}
+}
+
+// TestConnection calls TestConnection on the remote agent.
+func (r *RemotePublisher) TestConnection(ctx context.Context, params PublisherParams) (TestConnectionResult, error) {
+	var result TestConnectionResult
+	err := r.rpc.Call(ctx, MethodTestConnection, params, &result)
+	return result, err
+}
+
+// Shapes calls Shapes on the remote agent.
+func (r *RemotePublisher) Shapes(ctx context.Context, params PublisherParams) (pipeline.ShapeDefinitions, error) {
+	var result pipeline.ShapeDefinitions
+	err := r.rpc.Call(ctx, MethodShapes, params, &result)
+	return result, err
+}
+
+// Publish calls Publish on the remote agent, which returns when the publisher is done.
+func (r *RemotePublisher) Publish(ctx context.Context, params PublishParams) (PublishResult, error) {
+	var result PublishResult
+	err := r.rpc.Call(ctx, MethodPublish, params, &result)
+	return result, err
+}
+
+// PublisherMethods serve the publisher methods on an agent, using the registered
+// publisher factories.  Each call is run by a new Dispatcher for the publisher type
+// and settings of the call, like the commands of a publisher process.
+type PublisherMethods struct {
+	APIToken string        // optional: The API token of the publisher context
+	Logger   *logrus.Entry // optional: The logger of the publisher context
+
+	// The transport the data points of Publish are sent to.
+	Transport func(params PublishParams) (publisher.DataTransport, error)
+}
+
+// Register registers the handlers of the publisher methods.
+func (m PublisherMethods) Register(r *live.RPC) {
+	r.Handle(MethodTestConnection, m.testConnection)
+	r.Handle(MethodShapes, m.shapes)
+	r.Handle(MethodPublish, m.publish)
+}
+
+func (m PublisherMethods) testConnection(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var params PublisherParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, live.InvalidParams(err)
+	}
+
+	d, err := m.dispatcher(params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result TestConnectionResult
+	result.Success, result.Message, err = d.testConnection()
+	return result, err
+}
+
+func (m PublisherMethods) shapes(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var params PublisherParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, live.InvalidParams(err)
+	}
+
+	d, err := m.dispatcher(params, nil)
+	if err != nil {
+		return nil, err
+	}
+	return d.shapes()
+}
+
+func (m PublisherMethods) publish(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var params PublishParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, live.InvalidParams(err)
+	}
+
+	if m.Transport == nil {
+		return nil, &live.RPCError{Code: live.RPCMethodNotFound, Message: "the agent does not publish on demand"}
+	}
+
+	transport, err := m.Transport(params)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := m.dispatcher(params.PublisherParams, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := d.publishShapes(func(publisher.Publisher, publisher.Context) (pipeline.ShapeDefinitions, error) {
+		return pipeline.ShapeDefinitions{params.Shape}, nil
+	})
+	return PublishResult{DataPoints: n}, err
+}
+
+// dispatcher creates the dispatcher that runs a call for the publisher of the
+// parameters.  The transport is only needed by Publish.
+func (m PublisherMethods) dispatcher(params PublisherParams, transport publisher.DataTransport) (*Dispatcher, error) {
+	options := Options{
+		Type: params.Type,
+		Context: publisher.Context{
+			Settings: params.Settings,
+			APIToken: m.APIToken,
+			Logger:   m.Logger,
+		},
+	}
+	if transport != nil {
+		options.Transport = func() publisher.DataTransport { return transport }
+	}
+
+	d, err := NewDispatcher(options)
+	if err != nil {
+		return nil, &live.RPCError{Code: live.RPCInvalidParams, Message: err.Error()}
+	}
+	return d, nil
+}
@@ -0,0 +1,80 @@
+package command
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/naveego/api/live"
+	"github.com/naveego/api/pipeline/publisher"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPublisherMethods(t *testing.T) {
+
+	Convey("Given an agent connected to a server", t, func() {
+		connected := make(chan *live.RPC, 1)
+		rpcs := map[*live.Conn]*live.RPC{}
+
+		server := live.NewServer(live.ServerOptions{
+			OnConnect: func(conn *live.Conn) {
+				rpcs[conn] = live.NewRPC(conn)
+				connected <- rpcs[conn]
+			},
+			OnMessage: func(conn *live.Conn, msg live.Message) { rpcs[conn].Dispatch(msg) },
+		})
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		go server.Serve(l)
+
+		cli, err := live.NewClient(live.TCPDialer(l.Addr().String()), live.ClientOptions{ID: "agent"})
+		So(err, ShouldBeNil)
+
+		transport := &testTransport{}
+		agent := live.NewRPC(cli)
+		PublisherMethods{
+			Transport: func(params PublishParams) (publisher.DataTransport, error) { return transport, nil },
+		}.Register(agent)
+
+		go func() {
+			for msg := range cli.Incoming() {
+				agent.Dispatch(msg)
+			}
+		}()
+
+		platform := NewRemotePublisher(<-connected)
+		ctx := context.Background()
+		params := PublisherParams{Type: "command-test", Settings: map[string]interface{}{"host": "db"}}
+
+		Convey("Should test the connection of a publisher", func() {
+			result, err := platform.TestConnection(ctx, params)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, TestConnectionResult{Success: true, Message: "connected to db"})
+		})
+
+		Convey("Should return the shapes of a publisher", func() {
+			shapes, err := platform.Shapes(ctx, params)
+			So(err, ShouldBeNil)
+			So(shapes, ShouldHaveLength, 2)
+			So(shapes[0].Name, ShouldEqual, "users")
+		})
+
+		Convey("Should publish on demand", func() {
+			result, err := platform.Publish(ctx, PublishParams{PublisherParams: params, Shape: pipeline.ShapeDefinition{Name: "users"}})
+			So(err, ShouldBeNil)
+			So(result.DataPoints, ShouldEqual, 2)
+			So(transport.dataPoints, ShouldHaveLength, 2)
+		})
+
+		Convey("Should return an error for unknown publishers", func() {
+			_, err := platform.TestConnection(ctx, PublisherParams{Type: "unknown"})
+			So(err.(*live.RPCError).Code, ShouldEqual, live.RPCInvalidParams)
+		})
+
+		Reset(func() {
+			cli.Close()
+			server.Close()
+		})
+	})
+}
@@ -8,4 +8,33 @@ var (
 	TopicToInputMismatch      = 5002003
 	InvalidInputStreamID      = 5002004
 	PipelineActivityRunError  = 5002005
+
+	// Shape mapping errors
+	MappingCompilationError      = 5002006
+	MappingSourceNotFound        = 5002007
+	MappingTargetNotFound        = 5002008
+	MappingTypeMismatch          = 5002009
+	MappingUnsupportedConversion = 5002010
+	MappingDuplicateTarget       = 5002011
+	MappingUnmappedKey           = 5002012
+	MappingConversionError       = 5002013
+
+	// Activity graph errors
+	ActivityGraphCycle      = 5002014
+	DuplicateActivityID     = 5002015
+	InvalidActivitySettings = 5002016
+
+	// Expression errors
+	ExpressionCompileError = 5002017
+	ExpressionEvalError    = 5002018
+
+	// Local runner errors
+	InvalidPipelineDefinition = 5002019
+
+	// Schedule errors
+	InvalidSchedule = 5002020
+
+	// Publisher command errors
+	InvalidCommand = 5002021
+	CommandFailed  = 5002022
 )
@@ -0,0 +1,272 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/naveego/api/pipeline/mapping"
+	"github.com/naveego/api/types/pipeline"
+)
+
+var binaryOperators = map[string]func(l, r interface{}) (interface{}, error){
+	"==": func(l, r interface{}) (interface{}, error) { return equal(l, r), nil },
+	"!=": func(l, r interface{}) (interface{}, error) { return !equal(l, r), nil },
+	"<":  comparison("<", func(c int) bool { return c < 0 }),
+	"<=": comparison("<=", func(c int) bool { return c <= 0 }),
+	">":  comparison(">", func(c int) bool { return c > 0 }),
+	">=": comparison(">=", func(c int) bool { return c >= 0 }),
+	"+":  add,
+	"-":  arithmetic("-", func(l, r float64) (float64, error) { return l - r, nil }),
+	"*":  arithmetic("*", func(l, r float64) (float64, error) { return l * r, nil }),
+	"/": arithmetic("/", func(l, r float64) (float64, error) {
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	}),
+	"%": arithmetic("%", func(l, r float64) (float64, error) {
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return math.Mod(l, r), nil
+	}),
+}
+
+func isNull(v interface{}) bool {
+	return v == nil
+}
+
+// truthy returns the value of a boolean operand, null is false.
+func truthy(v interface{}, op string) (bool, error) {
+	switch x := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return x, nil
+	}
+	return false, evalError("operator %s requires a boolean, not %s", op, typeName(v))
+}
+
+func negate(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if f, ok := mapping.AsNumber(v); ok {
+		return -f, nil
+	}
+	return nil, evalError("operator - requires a number, not %s", typeName(v))
+}
+
+// add adds numbers or concatenates strings.  Arithmetic with null returns null.
+func add(l, r interface{}) (interface{}, error) {
+	if l == nil || r == nil {
+		return nil, nil
+	}
+
+	_, ls := l.(string)
+	_, rs := r.(string)
+	if ls || rs {
+		return toString(l) + toString(r), nil
+	}
+
+	return arithmetic("+", func(l, r float64) (float64, error) { return l + r, nil })(l, r)
+}
+
+func arithmetic(op string, fn func(l, r float64) (float64, error)) func(l, r interface{}) (interface{}, error) {
+	return func(l, r interface{}) (interface{}, error) {
+		if l == nil || r == nil {
+			return nil, nil
+		}
+
+		lf, lok := mapping.AsNumber(l)
+		rf, rok := mapping.AsNumber(r)
+		if !lok || !rok {
+			return nil, evalError("operator %s cannot be applied to %s and %s", op, typeName(l), typeName(r))
+		}
+
+		f, err := fn(lf, rf)
+		if err != nil {
+			return nil, evalError("%v", err)
+		}
+		return f, nil
+	}
+}
+
+// comparison orders numbers, strings and dates.  Comparisons with null are false.
+func comparison(op string, test func(int) bool) func(l, r interface{}) (interface{}, error) {
+	return func(l, r interface{}) (interface{}, error) {
+		if l == nil || r == nil {
+			return false, nil
+		}
+
+		c, ok := compare(l, r)
+		if !ok {
+			return nil, evalError("operator %s cannot be applied to %s and %s", op, typeName(l), typeName(r))
+		}
+		return test(c), nil
+	}
+}
+
+func compare(l, r interface{}) (int, bool) {
+	if lf, ok := mapping.AsNumber(l); ok {
+		rf, ok := mapping.AsNumber(r)
+		if !ok {
+			return 0, false
+		}
+		return compareFloats(lf, rf), true
+	}
+
+	lt, lok := l.(time.Time)
+	rt, rok := r.(time.Time)
+	if lok || rok {
+		if !lok {
+			if lt, lok = toTime(l); !lok {
+				return 0, false
+			}
+		}
+		if !rok {
+			if rt, rok = toTime(r); !rok {
+				return 0, false
+			}
+		}
+		switch {
+		case lt.Before(rt):
+			return -1, true
+		case lt.After(rt):
+			return 1, true
+		}
+		return 0, true
+	}
+
+	ls, lok := l.(string)
+	rs, rok := r.(string)
+	if lok && rok {
+		return strings.Compare(ls, rs), true
+	}
+
+	return 0, false
+}
+
+func compareFloats(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+// equal compares values so that numbers of different types and
+// dates in different time zones are equal.
+func equal(l, r interface{}) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+
+	if c, ok := compare(l, r); ok {
+		return c == 0
+	}
+
+	return reflect.DeepEqual(l, r)
+}
+
+// member reads a property of an object.  Missing properties are null.
+func member(v interface{}, name string) (interface{}, error) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		return x[name], nil
+	case map[string]string:
+		if s, ok := x[name]; ok {
+			return s, nil
+		}
+		return nil, nil
+	}
+	return nil, evalError("cannot read property '%s' of %s", name, typeName(v))
+}
+
+// index reads a property of an object or an item of a list.
+func index(v, key interface{}) (interface{}, error) {
+	if name, ok := key.(string); ok {
+		return member(v, name)
+	}
+
+	f, ok := mapping.AsNumber(key)
+	if !ok || f != math.Trunc(f) {
+		return nil, evalError("cannot index %s with %s", typeName(v), typeName(key))
+	}
+	i := int(f)
+
+	switch x := v.(type) {
+	case []interface{}:
+		if i < 0 || i >= len(x) {
+			return nil, nil
+		}
+		return x[i], nil
+	case []string:
+		if i < 0 || i >= len(x) {
+			return nil, nil
+		}
+		return x[i], nil
+	}
+	return nil, evalError("cannot index %s with a number", typeName(v))
+}
+
+func toString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	if s, err := mapping.Convert(v, mapping.TypeOf(v), pipeline.PropertyTypeString); err == nil {
+		return fmt.Sprint(s)
+	}
+	return fmt.Sprint(v)
+}
+
+// toTime converts dates, date strings and unix timestamps in seconds to a time.
+func toTime(v interface{}) (time.Time, bool) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, true
+	case string:
+		return mapping.ParseDate(x)
+	}
+
+	if f, ok := mapping.AsNumber(v); ok {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), true
+	}
+
+	return time.Time{}, false
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case time.Time:
+		return "date"
+	case map[string]interface{}, map[string]string:
+		return "object"
+	case []interface{}, []string:
+		return "list"
+	}
+
+	if _, ok := mapping.AsNumber(v); ok {
+		return "number"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,117 @@
+// Package expr implements a small expression language used to describe logic in
+// activity settings.  Expressions are evaluated against a data point and can not
+// call any Go code other than the built-in functions, which makes them safe to
+// accept from pipeline definitions.
+//
+// The data point is available through the following names:
+//
+//	data        the data of the data point, for example data.address.city
+//	meta        the meta data of the data point
+//	entity      the entity name
+//	action      the action
+//	repository  the repository name
+//	source      the source
+//	keyNames    the list of key names
+//
+// Expressions support numbers, strings in single or double quotes, true, false
+// and null, the arithmetic operators + - * / %, the comparison operators
+// == != < <= > >=, the logical operators && || ! (or and, or, not), the null
+// coalescing operator ??, the conditional operator a ? b : c and function calls.
+// Reading a property that does not exist returns null, and ?. returns null instead
+// of failing when the value on its left is null:
+//
+//	data.balance > 100 && lower(data.status) == 'active'
+//	data.address?.city ?? 'unknown'
+//	daysBetween(date(data.created), now()) < 30
+package expr
+
+import (
+	"fmt"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+)
+
+const (
+	// MaxLength is the maximum length of an expression.
+	MaxLength = 4096
+
+	// MaxDepth is the maximum nesting depth of an expression.
+	MaxDepth = 64
+)
+
+// Program is a compiled expression.  A Program is immutable and
+// is safe for concurrent use.
+type Program struct {
+	source string
+	eval   evalFunc
+}
+
+// Compile parses the expression and returns a program that can be evaluated.
+// Syntax errors, unknown names and calls with the wrong number of arguments are
+// reported with the ExpressionCompileError code.
+func Compile(source string) (*Program, error) {
+	if len(source) > MaxLength {
+		return nil, errors.NewWithCode(pipeerrors.ExpressionCompileError, fmt.Sprintf("expr: expression is longer than %d characters", MaxLength))
+	}
+
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	eval, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Program{source: source, eval: eval}, nil
+}
+
+// MustCompile is like Compile but panics if the expression is invalid.
+func MustCompile(source string) *Program {
+	p, err := Compile(source)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the source of the expression.
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the expression against the data point.  Numbers are
+// returned as float64 and dates as time.Time.
+func (p *Program) Eval(dataPoint pipeline.DataPoint) (interface{}, error) {
+	return p.eval(&dataPoint)
+}
+
+// EvalBool evaluates the expression and returns the result as a boolean.
+// A null result is false, any other result that is not a boolean is an error.
+func (p *Program) EvalBool(dataPoint pipeline.DataPoint) (bool, error) {
+	val, err := p.eval(&dataPoint)
+	if err != nil {
+		return false, err
+	}
+
+	switch x := val.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return x, nil
+	}
+
+	return false, evalError("expression must return a boolean, not %s", typeName(val))
+}
+
+func syntaxError(pos int, format string, args ...interface{}) error {
+	return errors.NewWithCode(pipeerrors.ExpressionCompileError, fmt.Sprintf("expr: %s at position %d", fmt.Sprintf(format, args...), pos))
+}
+
+func evalError(format string, args ...interface{}) error {
+	return errors.NewWithCode(pipeerrors.ExpressionEvalError, "expr: "+fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,217 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testDataPoint = pipeline.DataPoint{
+	Repository: "crm",
+	Entity:     "customers",
+	Action:     pipeline.DataPointUpsert,
+	KeyNames:   []string{"id"},
+	Meta:       map[string]string{"batch": "42"},
+	Data: map[string]interface{}{
+		"id":      1,
+		"name":    "  Vandelay Industries ",
+		"balance": 1024.5,
+		"count":   int64(3),
+		"active":  true,
+		"status":  nil,
+		"created": "2017-02-16T00:00:00Z",
+		"tags":    []interface{}{"import", "export"},
+		"address": map[string]interface{}{"city": "New York", "zip": "10001"},
+	},
+}
+
+func TestEval(t *testing.T) {
+
+	nowFunc = func() time.Time { return time.Date(2017, 3, 18, 12, 0, 0, 0, time.UTC) }
+	defer func() { nowFunc = time.Now }()
+
+	testCases := []struct {
+		source   string
+		expected interface{}
+	}{
+		// Literals and data point fields
+		{"1 + 2 * 3", float64(7)},
+		{"(1 + 2) * 3", float64(9)},
+		{"-data.balance", -1024.5},
+		{"10 % 4 - 1 / 4", 1.75},
+		{`'it\'s'`, "it's"},
+		{`"say \"hi\""`, `say "hi"`},
+		{"entity", "customers"},
+		{"action == 'upsert'", true},
+		{"repository + '.' + entity", "crm.customers"},
+		{"meta.batch", "42"},
+		{"keyNames[0]", "id"},
+		{"data.address.city", "New York"},
+		{"data['address']['zip']", "10001"},
+		{"data.tags[1]", "export"},
+		{"data.tags[5]", nil},
+		{"data.missing", nil},
+
+		// Comparison and logic
+		{"data.id == 1.0", true},
+		{"data.count >= 3 && data.balance > 1000", true},
+		{"data.count > 3 or not data.active", false},
+		{"!(data.name == null)", true},
+		{"data.status == null", true},
+		{"data.status > 1", false},
+		{"data.created < '2017-03-01'", true},
+		{"date(data.created) == date('2017-02-16')", true},
+		{"data.count > 1 ? 'many' : 'one'", "many"},
+		{"data.status && data.missing.value", false},
+		{"data.active || data.missing.value", true},
+
+		// Null handling
+		{"data.status ?? 'none'", "none"},
+		{"data.missing?.city", nil},
+		{"data.missing?.city.name", nil},
+		{"data.address?.city", "New York"},
+		{"data.balance + data.status", nil},
+		{"'a' + 1", "a1"},
+
+		// Functions
+		{"lower(trim(data.name))", "vandelay industries"},
+		{"upper(data.address.city)", "NEW YORK"},
+		{"len(trim(data.name))", float64(19)},
+		{"len(data.tags)", float64(2)},
+		{"substr(data.address.zip, 1, 3)", "000"},
+		{"replace(data.address.city, ' ', '_')", "New_York"},
+		{"startsWith(data.address.city, 'New') && endsWith(data.address.city, 'York')", true},
+		{"contains(data.tags, 'export')", true},
+		{"contains(data.address.city, 'York')", true},
+		{"concat(data.id, '-', data.status, data.address.zip)", "1-10001"},
+		{"string(data.balance)", "1024.5"},
+		{"number(meta.batch) + 1", float64(43)},
+		{"round(data.balance / 3, 2)", 341.5},
+		{"floor(2.7) + ceil(2.1) + abs(-1)", float64(6)},
+		{"min(3, data.count, 1.5)", 1.5},
+		{"max(3, null, 7)", float64(7)},
+		{"year(data.created) * 100 + month(data.created)", float64(201702)},
+		{"daysBetween(data.created, now())", 30.5},
+		{"formatDate(addDays(data.created, 1), '2006-01-02')", "2017-02-17"},
+		{"coalesce(data.status, data.missing, 'fallback')", "fallback"},
+		{"isNull(data.status)", true},
+		{"lower(data.status)", nil},
+	}
+
+	for _, tc := range testCases {
+		Convey("Given the expression "+tc.source, t, func() {
+			p, err := Compile(tc.source)
+			So(err, ShouldBeNil)
+
+			Convey("Should return the expected value", func() {
+				actual, err := p.Eval(testDataPoint)
+				So(err, ShouldBeNil)
+				So(actual, ShouldResemble, tc.expected)
+			})
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+
+	testCases := []string{
+		"data.missing.city",
+		"data.name.first",
+		"data.balance / 0",
+		"data.name * 2",
+		"data.name > 1",
+		"data.name && true",
+		"lower(data.balance)",
+		"date('not a date')",
+	}
+
+	for _, source := range testCases {
+		Convey("Given the expression "+source, t, func() {
+			p, err := Compile(source)
+			So(err, ShouldBeNil)
+
+			Convey("Should return an evaluation error", func() {
+				_, err := p.Eval(testDataPoint)
+				So(err, ShouldNotBeNil)
+				So(err.(errors.Error).Code, ShouldEqual, pipeerrors.ExpressionEvalError)
+			})
+		})
+	}
+
+	Convey("Given an expression that does not return a boolean", t, func() {
+		p := MustCompile("data.balance")
+
+		Convey("Should return an error from EvalBool", func() {
+			_, err := p.EvalBool(testDataPoint)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an expression that returns null", t, func() {
+		p := MustCompile("data.missing?.active")
+
+		Convey("Should be false", func() {
+			b, err := p.EvalBool(testDataPoint)
+			So(err, ShouldBeNil)
+			So(b, ShouldBeFalse)
+		})
+	})
+}
+
+func TestCompileErrors(t *testing.T) {
+
+	testCases := []struct {
+		source  string
+		message string
+	}{
+		{"", "unexpected end of expression at position 0"},
+		{"1 +", "unexpected end of expression at position 3"},
+		{"(1 + 2", "expected ')' but found end of expression at position 6"},
+		{"data.", "expected a property name but found end of expression at position 5"},
+		{"1 2", "unexpected '2' at position 2"},
+		{"'abc", "unterminated string at position 0"},
+		{"data.x # 1", "unexpected character '#' at position 7"},
+		{"price > 1", "unknown name 'price' at position 0"},
+		{"exec('rm')", "unknown function 'exec' at position 0"},
+		{"lower()", "function 'lower' takes 1 argument(s) at position 0"},
+		{"true ? 1", "expected ':' but found end of expression at position 8"},
+		{strings.Repeat("(", MaxDepth+1) + "1" + strings.Repeat(")", MaxDepth+1), "nested more than"},
+		{strings.Repeat("1+", MaxLength), "longer than"},
+	}
+
+	for _, tc := range testCases {
+		name := tc.source
+		if len(name) > 20 {
+			name = name[:20] + "..."
+		}
+
+		Convey("Given the invalid expression "+name, t, func() {
+			_, err := Compile(tc.source)
+
+			Convey("Should return a compile error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.(errors.Error).Code, ShouldEqual, pipeerrors.ExpressionCompileError)
+				So(err.Error(), ShouldContainSubstring, tc.message)
+			})
+		})
+	}
+}
+
+func BenchmarkEval(b *testing.B) {
+	p := MustCompile("data.balance > 100 && lower(data.address?.city ?? '') == 'new york' && data.count * 2 >= 6")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ok, err := p.EvalBool(testDataPoint)
+		if err != nil || !ok {
+			b.Fatal(ok, err)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/naveego/api/pipeline/mapping"
+	"github.com/naveego/api/types/pipeline"
+)
+
+// nowFunc returns the current time, it is replaced in tests.
+var nowFunc = time.Now
+
+type function struct {
+	min  int // The minimum number of arguments
+	max  int // The maximum number of arguments, -1 for any number
+	call func(args []interface{}) (interface{}, error)
+}
+
+func (f function) arity() string {
+	switch {
+	case f.min == f.max:
+		return fmt.Sprintf("takes %d argument(s)", f.min)
+	case f.max < 0:
+		return fmt.Sprintf("takes at least %d argument(s)", f.min)
+	}
+	return fmt.Sprintf("takes %d to %d arguments", f.min, f.max)
+}
+
+// functions are the functions that can be called from an expression.  Unless
+// noted otherwise they return null when their first argument is null.
+var functions = map[string]function{
+	// Strings
+	"lower": {1, 1, stringFunc(func(s string, _ []interface{}) (interface{}, error) { return strings.ToLower(s), nil })},
+	"upper": {1, 1, stringFunc(func(s string, _ []interface{}) (interface{}, error) { return strings.ToUpper(s), nil })},
+	"trim":  {1, 1, stringFunc(func(s string, _ []interface{}) (interface{}, error) { return strings.TrimSpace(s), nil })},
+	"startsWith": {2, 2, stringFunc(func(s string, args []interface{}) (interface{}, error) {
+		return strings.HasPrefix(s, toString(args[0])), nil
+	})},
+	"endsWith": {2, 2, stringFunc(func(s string, args []interface{}) (interface{}, error) {
+		return strings.HasSuffix(s, toString(args[0])), nil
+	})},
+	"replace": {3, 3, stringFunc(func(s string, args []interface{}) (interface{}, error) {
+		return strings.Replace(s, toString(args[0]), toString(args[1]), -1), nil
+	})},
+	"substr":   {2, 3, substr},
+	"contains": {2, 2, contains},
+	"len":      {1, 1, length},
+	"concat":   {0, -1, concat},
+	"string": {1, 1, func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		return toString(args[0]), nil
+	}},
+
+	// Numbers
+	"number": {1, 1, func(args []interface{}) (interface{}, error) {
+		if t, ok := args[0].(time.Time); ok {
+			return float64(t.Unix()), nil
+		}
+		return mapping.Convert(args[0], mapping.TypeOf(args[0]), pipeline.PropertyTypeNumber)
+	}},
+	"round": {1, 2, numberFunc(func(f float64, args []interface{}) (interface{}, error) {
+		digits := 0.0
+		if len(args) > 0 {
+			var ok bool
+			if digits, ok = mapping.AsNumber(args[0]); !ok {
+				return nil, fmt.Errorf("digits must be a number")
+			}
+		}
+		pow := math.Pow(10, digits)
+		return math.Round(f*pow) / pow, nil
+	})},
+	"floor": {1, 1, numberFunc(func(f float64, _ []interface{}) (interface{}, error) { return math.Floor(f), nil })},
+	"ceil":  {1, 1, numberFunc(func(f float64, _ []interface{}) (interface{}, error) { return math.Ceil(f), nil })},
+	"abs":   {1, 1, numberFunc(func(f float64, _ []interface{}) (interface{}, error) { return math.Abs(f), nil })},
+	"min":   {1, -1, extreme(-1)},
+	"max":   {1, -1, extreme(1)},
+
+	// Dates
+	"now": {0, 0, func([]interface{}) (interface{}, error) { return nowFunc().UTC(), nil }},
+	"date": {1, 1, func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		t, ok := toTime(args[0])
+		if !ok {
+			return nil, fmt.Errorf("could not convert %s '%v' to a date", typeName(args[0]), args[0])
+		}
+		return t, nil
+	}},
+	"year":   {1, 1, dateFunc(func(t time.Time, _ []interface{}) (interface{}, error) { return float64(t.Year()), nil })},
+	"month":  {1, 1, dateFunc(func(t time.Time, _ []interface{}) (interface{}, error) { return float64(t.Month()), nil })},
+	"day":    {1, 1, dateFunc(func(t time.Time, _ []interface{}) (interface{}, error) { return float64(t.Day()), nil })},
+	"hour":   {1, 1, dateFunc(func(t time.Time, _ []interface{}) (interface{}, error) { return float64(t.Hour()), nil })},
+	"minute": {1, 1, dateFunc(func(t time.Time, _ []interface{}) (interface{}, error) { return float64(t.Minute()), nil })},
+	"addDays": {2, 2, dateFunc(func(t time.Time, args []interface{}) (interface{}, error) {
+		days, ok := mapping.AsNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("days must be a number")
+		}
+		return t.Add(time.Duration(days * float64(24*time.Hour))), nil
+	})},
+	"daysBetween": {2, 2, dateFunc(func(t time.Time, args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		end, ok := toTime(args[0])
+		if !ok {
+			return nil, fmt.Errorf("could not convert %s to a date", typeName(args[0]))
+		}
+		return end.Sub(t).Hours() / 24, nil
+	})},
+	"formatDate": {2, 2, dateFunc(func(t time.Time, args []interface{}) (interface{}, error) {
+		return t.Format(toString(args[0])), nil
+	})},
+
+	// Nulls
+	"coalesce": {1, -1, func(args []interface{}) (interface{}, error) {
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	}},
+	"isNull": {1, 1, func(args []interface{}) (interface{}, error) { return args[0] == nil, nil }},
+}
+
+func stringFunc(fn func(s string, args []interface{}) (interface{}, error)) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("requires a string, not %s", typeName(args[0]))
+		}
+		return fn(s, args[1:])
+	}
+}
+
+func numberFunc(fn func(f float64, args []interface{}) (interface{}, error)) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		f, ok := mapping.AsNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("requires a number, not %s", typeName(args[0]))
+		}
+		return fn(f, args[1:])
+	}
+}
+
+func dateFunc(fn func(t time.Time, args []interface{}) (interface{}, error)) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		t, ok := toTime(args[0])
+		if !ok {
+			return nil, fmt.Errorf("requires a date, not %s", typeName(args[0]))
+		}
+		return fn(t, args[1:])
+	}
+}
+
+// substr returns part of a string, start and length are counted in characters.
+func substr(args []interface{}) (interface{}, error) {
+	return stringFunc(func(s string, args []interface{}) (interface{}, error) {
+		runes := []rune(s)
+
+		start, ok := mapping.AsNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("start must be a number")
+		}
+		from := clamp(int(start), len(runes))
+
+		to := len(runes)
+		if len(args) > 1 {
+			length, ok := mapping.AsNumber(args[1])
+			if !ok {
+				return nil, fmt.Errorf("length must be a number")
+			}
+			to = clamp(from+int(length), len(runes))
+		}
+
+		if to < from {
+			return "", nil
+		}
+		return string(runes[from:to]), nil
+	})(args)
+}
+
+func clamp(i, max int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > max {
+		return max
+	}
+	return i
+}
+
+// contains tests if a string contains a substring or a list contains an item.
+func contains(args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.Contains(x, toString(args[1])), nil
+	case []interface{}:
+		for _, item := range x {
+			if equal(item, args[1]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case []string:
+		for _, item := range x {
+			if equal(item, args[1]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return nil, fmt.Errorf("requires a string or a list, not %s", typeName(args[0]))
+}
+
+// length returns the number of characters in a string, items in a list or
+// properties in an object.  The length of null is 0.
+func length(args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case nil:
+		return float64(0), nil
+	case string:
+		return float64(utf8.RuneCountInString(x)), nil
+	case []interface{}:
+		return float64(len(x)), nil
+	case []string:
+		return float64(len(x)), nil
+	case map[string]interface{}:
+		return float64(len(x)), nil
+	case map[string]string:
+		return float64(len(x)), nil
+	}
+	return nil, fmt.Errorf("requires a string, list or object, not %s", typeName(args[0]))
+}
+
+// concat joins the arguments as strings, null arguments are ignored.
+func concat(args []interface{}) (interface{}, error) {
+	var b strings.Builder
+	for _, a := range args {
+		b.WriteString(toString(a))
+	}
+	return b.String(), nil
+}
+
+// extreme returns the smallest (-1) or largest (1) of the arguments, ignoring nulls.
+func extreme(sign int) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		var result interface{}
+		for _, a := range args {
+			if a == nil {
+				continue
+			}
+			if result == nil {
+				result = a
+				continue
+			}
+			c, ok := compare(a, result)
+			if !ok {
+				return nil, fmt.Errorf("cannot compare %s and %s", typeName(a), typeName(result))
+			}
+			if c == sign {
+				result = a
+			}
+		}
+		if f, ok := mapping.AsNumber(result); ok {
+			return f, nil
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,163 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOp
+)
+
+type token struct {
+	kind  tokenKind
+	pos   int
+	text  string      // The source text, or the operator
+	value interface{} // The value of number and string literals
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.value.(string))
+	}
+	return "'" + t.text + "'"
+}
+
+// operators are sorted so that longer operators are matched first.
+var operators = []string{
+	"?.", "??", "==", "!=", "<=", ">=", "&&", "||",
+	"(", ")", "[", "]", ",", ".", "?", ":", "+", "-", "*", "/", "%", "<", ">", "!",
+}
+
+// lex splits the expression into tokens.
+func lex(src string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			start := i
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			if i < len(src) && src[i] == '.' {
+				i++
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			f, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, syntaxError(start, "invalid number '%s'", src[start:i])
+			}
+			tokens = append(tokens, token{kind: tokenNumber, pos: start, text: src[start:i], value: f})
+
+		case c == '"' || c == '\'':
+			start := i
+			s, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, syntaxError(start, "%s", err.Error())
+			}
+			i += n
+			tokens = append(tokens, token{kind: tokenString, pos: start, text: src[start:i], value: s})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && isIdentPart(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, pos: start, text: src[start:i]})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					// "a ?.5 : 1" is a conditional followed by a number
+					if op == "?." && i+2 < len(src) && isDigit(src[i+2]) {
+						continue
+					}
+					tokens = append(tokens, token{kind: tokenOp, pos: i, text: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, syntaxError(i, "unexpected character '%c'", c)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
+
+// lexString reads a quoted string and returns its value and length.
+func lexString(src string) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\':
+			i++
+			if i >= len(src) {
+				break
+			}
+			switch src[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '\'', '"':
+				b.WriteByte(src[i])
+			default:
+				return "", 0, fmt.Errorf("invalid escape sequence '\\%c'", src[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
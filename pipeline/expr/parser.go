@@ -0,0 +1,505 @@
+package expr
+
+import (
+	"github.com/naveego/api/types/pipeline"
+)
+
+// evalFunc is a compiled piece of an expression.
+type evalFunc func(dp *pipeline.DataPoint) (interface{}, error)
+
+// roots are the names that can be used to read from the data point.
+var roots = map[string]evalFunc{
+	"data":       func(dp *pipeline.DataPoint) (interface{}, error) { return dp.Data, nil },
+	"meta":       func(dp *pipeline.DataPoint) (interface{}, error) { return dp.Meta, nil },
+	"entity":     func(dp *pipeline.DataPoint) (interface{}, error) { return dp.Entity, nil },
+	"action":     func(dp *pipeline.DataPoint) (interface{}, error) { return string(dp.Action), nil },
+	"repository": func(dp *pipeline.DataPoint) (interface{}, error) { return dp.Repository, nil },
+	"source":     func(dp *pipeline.DataPoint) (interface{}, error) { return dp.Source, nil },
+	"keyNames":   func(dp *pipeline.DataPoint) (interface{}, error) { return dp.KeyNames, nil },
+}
+
+// parser is a recursive descent parser that compiles the
+// expression into a tree of closures while it parses.
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) parse() (evalFunc, error) {
+	eval, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, syntaxError(t.pos, "unexpected %s", t)
+	}
+
+	return eval, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the operators or keywords.
+func (p *parser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOp && t.kind != tokenIdent {
+		return "", false
+	}
+
+	for _, op := range ops {
+		if t.text == op {
+			p.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		t := p.peek()
+		return syntaxError(t.pos, "expected '%s' but found %s", op, t)
+	}
+	return nil
+}
+
+func (p *parser) parseExpr() (evalFunc, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.depth > MaxDepth {
+		return nil, syntaxError(p.peek().pos, "expression is nested more than %d levels", MaxDepth)
+	}
+
+	return p.parseConditional()
+}
+
+func (p *parser) parseConditional() (evalFunc, error) {
+	cond, err := p.parseCoalesce()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+
+	then, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	otherwise, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(dp *pipeline.DataPoint) (interface{}, error) {
+		c, err := cond(dp)
+		if err != nil {
+			return nil, err
+		}
+		b, err := truthy(c, "?")
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			return then(dp)
+		}
+		return otherwise(dp)
+	}, nil
+}
+
+func (p *parser) parseCoalesce() (evalFunc, error) {
+	left, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, ok := p.accept("??"); !ok {
+			return left, nil
+		}
+
+		right, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(dp *pipeline.DataPoint) (interface{}, error) {
+			v, err := l(dp)
+			if err != nil || v != nil {
+				return v, err
+			}
+			return right(dp)
+		}
+	}
+}
+
+func (p *parser) parseOr() (evalFunc, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, ok := p.accept("||", "or"); !ok {
+			return left, nil
+		}
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = logical(left, right, true)
+	}
+}
+
+func (p *parser) parseAnd() (evalFunc, error) {
+	left, err := p.parseEquality()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, ok := p.accept("&&", "and"); !ok {
+			return left, nil
+		}
+
+		right, err := p.parseEquality()
+		if err != nil {
+			return nil, err
+		}
+
+		left = logical(left, right, false)
+	}
+}
+
+// logical evaluates the right side only when the left side does
+// not decide the result.
+func logical(left, right evalFunc, or bool) evalFunc {
+	op := "&&"
+	if or {
+		op = "||"
+	}
+
+	return func(dp *pipeline.DataPoint) (interface{}, error) {
+		l, err := left(dp)
+		if err != nil {
+			return nil, err
+		}
+		lb, err := truthy(l, op)
+		if err != nil {
+			return nil, err
+		}
+		if lb == or {
+			return lb, nil
+		}
+
+		r, err := right(dp)
+		if err != nil {
+			return nil, err
+		}
+		return truthy(r, op)
+	}
+}
+
+func (p *parser) parseEquality() (evalFunc, error) {
+	return p.parseBinary(p.parseComparison, "==", "!=")
+}
+
+func (p *parser) parseComparison() (evalFunc, error) {
+	return p.parseBinary(p.parseAdditive, "<", "<=", ">", ">=")
+}
+
+func (p *parser) parseAdditive() (evalFunc, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *parser) parseMultiplicative() (evalFunc, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+// parseBinary parses left associative binary operators.
+func (p *parser) parseBinary(operand func() (evalFunc, error), ops ...string) (evalFunc, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binary(op, left, right)
+	}
+}
+
+func binary(op string, left, right evalFunc) evalFunc {
+	apply := binaryOperators[op]
+	return func(dp *pipeline.DataPoint) (interface{}, error) {
+		l, err := left(dp)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(dp)
+		if err != nil {
+			return nil, err
+		}
+		return apply(l, r)
+	}
+}
+
+func (p *parser) parseUnary() (evalFunc, error) {
+	op, ok := p.accept("!", "not", "-")
+	if !ok {
+		return p.parsePostfix()
+	}
+
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MaxDepth {
+		return nil, syntaxError(p.peek().pos, "expression is nested more than %d levels", MaxDepth)
+	}
+
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	if op == "-" {
+		return func(dp *pipeline.DataPoint) (interface{}, error) {
+			v, err := operand(dp)
+			if err != nil {
+				return nil, err
+			}
+			return negate(v)
+		}, nil
+	}
+
+	return func(dp *pipeline.DataPoint) (interface{}, error) {
+		v, err := operand(dp)
+		if err != nil {
+			return nil, err
+		}
+		b, err := truthy(v, "!")
+		if err != nil {
+			return nil, err
+		}
+		return !b, nil
+	}, nil
+}
+
+// step is a single property access in a chain such as data.address?.city.
+type step struct {
+	nullSafe bool
+	name     string
+	index    evalFunc
+}
+
+func (p *parser) parsePostfix() (evalFunc, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []step
+	for {
+		op, ok := p.accept(".", "?.", "[")
+		if !ok {
+			break
+		}
+
+		s := step{nullSafe: op == "?."}
+		if op == "[" {
+			if s.index, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		} else {
+			if s.nullSafe {
+				if _, ok := p.accept("["); ok {
+					if s.index, err = p.parseExpr(); err != nil {
+						return nil, err
+					}
+					if err := p.expect("]"); err != nil {
+						return nil, err
+					}
+					steps = append(steps, s)
+					continue
+				}
+			}
+			t := p.next()
+			if t.kind != tokenIdent {
+				return nil, syntaxError(t.pos, "expected a property name but found %s", t)
+			}
+			s.name = t.text
+		}
+		steps = append(steps, s)
+	}
+
+	if len(steps) == 0 {
+		return base, nil
+	}
+
+	return func(dp *pipeline.DataPoint) (interface{}, error) {
+		cur, err := base(dp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range steps {
+			if isNull(cur) {
+				if s.nullSafe {
+					return nil, nil
+				}
+				if s.index != nil {
+					return nil, evalError("cannot index null")
+				}
+				return nil, evalError("cannot read property '%s' of null", s.name)
+			}
+
+			if s.index != nil {
+				key, err := s.index(dp)
+				if err != nil {
+					return nil, err
+				}
+				if cur, err = index(cur, key); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			if cur, err = member(cur, s.name); err != nil {
+				return nil, err
+			}
+		}
+
+		return cur, nil
+	}, nil
+}
+
+func (p *parser) parsePrimary() (evalFunc, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenNumber, tokenString:
+		return constant(t.value), nil
+
+	case tokenOp:
+		if t.text == "(" {
+			eval, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return eval, nil
+		}
+
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		case "null":
+			return constant(nil), nil
+		}
+
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t)
+		}
+
+		if root, ok := roots[t.text]; ok {
+			return root, nil
+		}
+
+		return nil, syntaxError(t.pos, "unknown name '%s'", t.text)
+
+	case tokenEOF:
+		return nil, syntaxError(t.pos, "unexpected end of expression")
+	}
+
+	return nil, syntaxError(t.pos, "unexpected %s", t)
+}
+
+func (p *parser) parseCall(name token) (evalFunc, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, syntaxError(name.pos, "unknown function '%s'", name.text)
+	}
+
+	var args []evalFunc
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if _, ok := p.accept(")"); ok {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(args) < fn.min || (fn.max >= 0 && len(args) > fn.max) {
+		return nil, syntaxError(name.pos, "function '%s' %s", name.text, fn.arity())
+	}
+
+	return func(dp *pipeline.DataPoint) (interface{}, error) {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := arg(dp)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+
+		v, err := fn.call(values)
+		if err != nil {
+			return nil, evalError("%s: %v", name.text, err)
+		}
+		return v, nil
+	}, nil
+}
+
+func constant(v interface{}) evalFunc {
+	return func(*pipeline.DataPoint) (interface{}, error) {
+		return v, nil
+	}
+}
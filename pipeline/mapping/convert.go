@@ -0,0 +1,275 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+)
+
+// Converter converts a single value from one property type to another.
+// Converters are always called with a non-nil value.
+type Converter func(value interface{}) (interface{}, error)
+
+var dateFormats = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	time.RFC822Z,
+	time.RFC822,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// GetConverter returns the converter used to turn values of fromType into values
+// of toType.  An error is returned if the conversion is not supported.  An empty
+// type on either side means the value is passed through untouched.
+func GetConverter(fromType, toType string) (Converter, error) {
+	fromType = strings.ToLower(fromType)
+	toType = strings.ToLower(toType)
+
+	if fromType == "" || toType == "" || fromType == toType {
+		return identity, nil
+	}
+
+	if !isKnownType(fromType) {
+		return nil, errors.NewWithCode(pipeerrors.MappingUnsupportedConversion, fmt.Sprintf("unknown type '%s'", fromType))
+	}
+
+	if !isKnownType(toType) {
+		return nil, errors.NewWithCode(pipeerrors.MappingUnsupportedConversion, fmt.Sprintf("unknown type '%s'", toType))
+	}
+
+	switch toType {
+	case pipeline.PropertyTypeString:
+		return toString, nil
+	case pipeline.PropertyTypeNumber:
+		if fromType == pipeline.PropertyTypeDate {
+			return dateToNumber, nil
+		}
+		if fromType != pipeline.PropertyTypeObject {
+			return toNumber, nil
+		}
+	case pipeline.PropertyTypeBool:
+		if fromType == pipeline.PropertyTypeString || fromType == pipeline.PropertyTypeNumber {
+			return toBool, nil
+		}
+	case pipeline.PropertyTypeDate:
+		if fromType == pipeline.PropertyTypeString || fromType == pipeline.PropertyTypeNumber {
+			return toDate, nil
+		}
+	}
+
+	return nil, errors.NewWithCode(pipeerrors.MappingUnsupportedConversion, fmt.Sprintf("cannot convert '%s' to '%s'", fromType, toType))
+}
+
+// Convert converts a value from one property type to another.
+func Convert(value interface{}, fromType, toType string) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	convert, err := GetConverter(fromType, toType)
+	if err != nil {
+		return nil, err
+	}
+
+	return convert(value)
+}
+
+// TypeOf returns the property type of a value.  Strings are always of type
+// string, even when they contain a date.  An empty string is returned for nil
+// and for values that are not one of the property types.
+func TypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return ""
+	case string:
+		return pipeline.PropertyTypeString
+	case bool:
+		return pipeline.PropertyTypeBool
+	case time.Time:
+		return pipeline.PropertyTypeDate
+	case map[string]interface{}:
+		return pipeline.PropertyTypeObject
+	}
+
+	if _, ok := asFloat(value); ok {
+		return pipeline.PropertyTypeNumber
+	}
+
+	return ""
+}
+
+// AsNumber returns the value as a float64 if it is one of the number types.
+func AsNumber(value interface{}) (float64, bool) {
+	return asFloat(value)
+}
+
+func isKnownType(t string) bool {
+	switch t {
+	case pipeline.PropertyTypeString, pipeline.PropertyTypeNumber, pipeline.PropertyTypeBool,
+		pipeline.PropertyTypeDate, pipeline.PropertyTypeObject:
+		return true
+	}
+	return false
+}
+
+func identity(value interface{}) (interface{}, error) {
+	return value, nil
+}
+
+func toString(value interface{}) (interface{}, error) {
+	switch x := value.(type) {
+	case string:
+		return x, nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case time.Time:
+		return x.Format(time.RFC3339Nano), nil
+	case json.Number:
+		return x.String(), nil
+	case map[string]interface{}, []interface{}:
+		buf, err := json.Marshal(x)
+		if err != nil {
+			return nil, conversionError(value, pipeline.PropertyTypeString)
+		}
+		return string(buf), nil
+	}
+
+	if f, ok := asFloat(value); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+
+	return fmt.Sprint(value), nil
+}
+
+func toNumber(value interface{}) (interface{}, error) {
+	switch x := value.(type) {
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return nil, conversionError(value, pipeline.PropertyTypeNumber)
+		}
+		return f, nil
+	case bool:
+		if x {
+			return float64(1), nil
+		}
+		return float64(0), nil
+	case time.Time:
+		return float64(x.Unix()), nil
+	}
+
+	if f, ok := asFloat(value); ok {
+		return f, nil
+	}
+
+	return nil, conversionError(value, pipeline.PropertyTypeNumber)
+}
+
+// dateToNumber converts a date to a unix timestamp in seconds.
+func dateToNumber(value interface{}) (interface{}, error) {
+	switch x := value.(type) {
+	case time.Time:
+		return float64(x.Unix()), nil
+	case string:
+		if t, ok := ParseDate(x); ok {
+			return float64(t.Unix()), nil
+		}
+	}
+
+	return nil, conversionError(value, pipeline.PropertyTypeNumber)
+}
+
+func toBool(value interface{}) (interface{}, error) {
+	switch x := value.(type) {
+	case bool:
+		return x, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(x))
+		if err != nil {
+			return nil, conversionError(value, pipeline.PropertyTypeBool)
+		}
+		return b, nil
+	}
+
+	if f, ok := asFloat(value); ok {
+		return f != 0, nil
+	}
+
+	return nil, conversionError(value, pipeline.PropertyTypeBool)
+}
+
+// toDate converts strings and unix timestamps (in seconds) to RFC 3339 date strings.
+func toDate(value interface{}) (interface{}, error) {
+	switch x := value.(type) {
+	case time.Time:
+		return x.Format(time.RFC3339Nano), nil
+	case string:
+		if t, ok := ParseDate(x); ok {
+			return t.Format(time.RFC3339Nano), nil
+		}
+		return nil, conversionError(value, pipeline.PropertyTypeDate)
+	}
+
+	if f, ok := asFloat(value); ok {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC().Format(time.RFC3339Nano), nil
+	}
+
+	return nil, conversionError(value, pipeline.PropertyTypeDate)
+}
+
+// ParseDate parses a date string using the formats supported by the mapper.
+func ParseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func asFloat(value interface{}) (float64, bool) {
+	switch x := value.(type) {
+	case int:
+		return float64(x), true
+	case int8:
+		return float64(x), true
+	case int16:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint:
+		return float64(x), true
+	case uint8:
+		return float64(x), true
+	case uint16:
+		return float64(x), true
+	case uint32:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func conversionError(value interface{}, toType string) error {
+	return errors.NewWithCode(pipeerrors.MappingConversionError, fmt.Sprintf("could not convert '%v' to %s", value, toType))
+}
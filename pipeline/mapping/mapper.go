@@ -0,0 +1,358 @@
+// Package mapping applies the shape mappings of a pipeline to the data
+// flowing from a publisher to a subscriber.
+package mapping
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+)
+
+// MappingError describes a problem with a single shape mapping.
+type MappingError struct {
+	Index   int                   `json:"index"`   // The index of the mapping in the pipeline
+	Mapping pipeline.ShapeMapping `json:"mapping"` // The mapping with the problem
+	Code    int                   `json:"code"`    // The error code
+	Message string                `json:"message"` // A human readable message
+}
+
+func (e MappingError) Error() string {
+	return fmt.Sprintf("mapping %d (%s -> %s): %s", e.Index, e.Mapping.From, e.Mapping.To, e.Message)
+}
+
+type rule struct {
+	mapping pipeline.ShapeMapping
+	from    []string
+	to      []string
+	convert Converter
+}
+
+// Mapper transforms data from the published shape to the subscribed shape.  A
+// Mapper is immutable once compiled and is safe for concurrent use.
+type Mapper struct {
+	rules           []rule
+	keyNames        []string
+	unmappedSources []string
+	unmappedTargets []string
+}
+
+// Compile compiles the mappings against the published and subscribed shape definitions.
+// Property existence is only checked against shape definitions that declare properties.
+// All problems are described by a single error with the MappingCompilationError code,
+// and Validate returns them one by one.
+func Compile(mappings []pipeline.ShapeMapping, published, subscribed pipeline.ShapeDefinition) (*Mapper, error) {
+	m, errs := compile(mappings, published, subscribed)
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = e.Error()
+		}
+		return nil, errors.NewWithCode(pipeerrors.MappingCompilationError, "mapping: could not compile mappings: "+strings.Join(msgs, "; "))
+	}
+	return m, nil
+}
+
+// Validate returns every problem Compile finds in the mappings.
+func Validate(mappings []pipeline.ShapeMapping, published, subscribed pipeline.ShapeDefinition) []MappingError {
+	_, errs := compile(mappings, published, subscribed)
+	return errs
+}
+
+func compile(mappings []pipeline.ShapeMapping, published, subscribed pipeline.ShapeDefinition) (*Mapper, []MappingError) {
+	var errs []MappingError
+	addErr := func(i int, m pipeline.ShapeMapping, code int, format string, args ...interface{}) {
+		errs = append(errs, MappingError{Index: i, Mapping: m, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	fromTypes := propertyTypes(published)
+	toTypes := propertyTypes(subscribed)
+	mappedSources := map[string]bool{}
+	mappedTargets := map[string]bool{}
+
+	m := &Mapper{}
+
+	for i, sm := range mappings {
+		ok := true
+
+		if sm.From == "" {
+			addErr(i, sm, pipeerrors.MappingSourceNotFound, "no source property was provided")
+			ok = false
+		}
+
+		if sm.To == "" {
+			addErr(i, sm, pipeerrors.MappingTargetNotFound, "no target property was provided")
+			ok = false
+		}
+
+		fromType := strings.ToLower(sm.FromType)
+		if declared, exists := fromTypes[sm.From]; fromTypes != nil && sm.From != "" {
+			if !exists {
+				addErr(i, sm, pipeerrors.MappingSourceNotFound, "property '%s' does not exist in shape '%s'", sm.From, published.Name)
+				ok = false
+			} else if fromType == "" {
+				fromType = declared
+			} else if declared != fromType {
+				addErr(i, sm, pipeerrors.MappingTypeMismatch, "property '%s' is of type '%s' not '%s'", sm.From, declared, fromType)
+				ok = false
+			}
+		}
+
+		toType := strings.ToLower(sm.ToType)
+		if declared, exists := toTypes[sm.To]; toTypes != nil && sm.To != "" {
+			if !exists {
+				addErr(i, sm, pipeerrors.MappingTargetNotFound, "property '%s' does not exist in shape '%s'", sm.To, subscribed.Name)
+				ok = false
+			} else if toType == "" {
+				toType = declared
+			} else if declared != toType {
+				addErr(i, sm, pipeerrors.MappingTypeMismatch, "property '%s' is of type '%s' not '%s'", sm.To, declared, toType)
+				ok = false
+			}
+		}
+
+		if sm.To != "" {
+			if mappedTargets[sm.To] {
+				addErr(i, sm, pipeerrors.MappingDuplicateTarget, "property '%s' is the target of more than one mapping", sm.To)
+				ok = false
+			} else if other := nestedTarget(mappings[:i], sm.To); other != "" {
+				addErr(i, sm, pipeerrors.MappingDuplicateTarget, "property '%s' overlaps the target '%s' of another mapping", sm.To, other)
+				ok = false
+			}
+		}
+
+		convert, err := GetConverter(fromType, toType)
+		if err != nil {
+			addErr(i, sm, pipeerrors.MappingUnsupportedConversion, "%s", err.Error())
+			ok = false
+		}
+
+		mappedSources[sm.From] = true
+		mappedTargets[sm.To] = true
+
+		if ok {
+			m.rules = append(m.rules, rule{
+				mapping: sm,
+				from:    strings.Split(sm.From, "."),
+				to:      strings.Split(sm.To, "."),
+				convert: convert,
+			})
+		}
+	}
+
+	for _, key := range subscribed.Keys {
+		if !mappedTargets[key] {
+			errs = append(errs, MappingError{
+				Index:   -1,
+				Mapping: pipeline.ShapeMapping{To: key},
+				Code:    pipeerrors.MappingUnmappedKey,
+				Message: fmt.Sprintf("key property '%s' is not the target of any mapping", key),
+			})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	m.unmappedSources = unmapped(fromTypes, mappedSources)
+	m.unmappedTargets = unmapped(toTypes, mappedTargets)
+	m.keyNames = subscribed.Keys
+
+	return m, nil
+}
+
+// CompilePipeline compiles the mappings of a pipeline using the published shape
+// from the publisher instance and the shape accepted by the subscriber instance.
+func CompilePipeline(p pipeline.Pipeline, pub pipeline.PublisherInstance, sub pipeline.SubscriberInstance) (*Mapper, error) {
+	var published pipeline.ShapeDefinition
+	found := false
+	for _, sd := range pub.Shapes {
+		if sd.Name == p.PublishedShape || sd.ID == p.PublishedShape {
+			published = sd
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return nil, errors.NewWithCode(pipeerrors.MappingCompilationError, fmt.Sprintf("mapping: publisher '%s' does not publish shape '%s'", pub.ID, p.PublishedShape))
+	}
+
+	if p.SubscribedShape != "" && sub.Shape.Name != p.SubscribedShape && sub.Shape.ID != p.SubscribedShape {
+		return nil, errors.NewWithCode(pipeerrors.MappingCompilationError, fmt.Sprintf("mapping: subscriber '%s' does not accept shape '%s'", sub.ID, p.SubscribedShape))
+	}
+
+	return Compile(p.Mappings, published, sub.Shape)
+}
+
+// UnmappedSources returns the published properties that are not read by any mapping.
+func (m *Mapper) UnmappedSources() []string {
+	return m.unmappedSources
+}
+
+// UnmappedTargets returns the subscribed properties that are not written by any mapping.
+func (m *Mapper) UnmappedTargets() []string {
+	return m.unmappedTargets
+}
+
+// Map transforms the data using the compiled mappings.  Properties that are not
+// mapped are not copied.  Missing source properties are skipped.
+func (m *Mapper) Map(data map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(m.rules))
+
+	for _, r := range m.rules {
+		val, ok := readPath(data, r.mapping.From, r.from)
+		if !ok {
+			continue
+		}
+
+		if val != nil {
+			var err error
+			val, err = r.convert(val)
+			if err != nil {
+				return nil, errors.NewWithCode(pipeerrors.MappingConversionError, fmt.Sprintf("mapping: property '%s': %v", r.mapping.From, err))
+			}
+		}
+
+		// Objects are copied, so that changes to the output do not modify the input
+		if obj, ok := val.(map[string]interface{}); ok {
+			val = copyObject(obj)
+		}
+
+		writePath(out, r.to, val)
+	}
+
+	return out, nil
+}
+
+// MapDataPoint returns a copy of the data point with its data transformed.  The key
+// names are replaced with the keys of the subscribed shape, or renamed using the
+// mappings when the subscribed shape does not declare keys.  Because the properties
+// change the shape of the returned data point is cleared.
+func (m *Mapper) MapDataPoint(dataPoint pipeline.DataPoint) (pipeline.DataPoint, error) {
+	out := dataPoint
+	out.Shape = pipeline.Shape{}
+
+	if dataPoint.Data != nil {
+		data, err := m.Map(dataPoint.Data)
+		if err != nil {
+			return dataPoint, err
+		}
+		out.Data = data
+	}
+
+	if len(m.keyNames) > 0 {
+		out.KeyNames = append([]string(nil), m.keyNames...)
+		return out, nil
+	}
+
+	out.KeyNames = make([]string, 0, len(dataPoint.KeyNames))
+	for _, key := range dataPoint.KeyNames {
+		for _, r := range m.rules {
+			if r.mapping.From == key {
+				out.KeyNames = append(out.KeyNames, r.mapping.To)
+				break
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func propertyTypes(def pipeline.ShapeDefinition) map[string]string {
+	if len(def.Properties) == 0 {
+		return nil
+	}
+
+	types := make(map[string]string, len(def.Properties))
+	for _, p := range def.Properties {
+		types[p.Name] = strings.ToLower(p.Type)
+	}
+	return types
+}
+
+func unmapped(all map[string]string, mapped map[string]bool) []string {
+	var list []string
+	for name, t := range all {
+		// Objects are containers, their children are mapped individually
+		if !mapped[name] && t != pipeline.PropertyTypeObject {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// readPath reads a value using a dotted path.  A top level property whose name
+// contains dots takes precedence over nested objects.
+func readPath(data map[string]interface{}, path string, parts []string) (interface{}, bool) {
+	if val, ok := data[path]; ok {
+		return val, true
+	}
+
+	if len(parts) == 1 {
+		return nil, false
+	}
+
+	current := data
+	last := len(parts) - 1
+	for i, part := range parts {
+		val, ok := current[part]
+		if !ok {
+			return nil, false
+		}
+
+		if i == last {
+			return val, true
+		}
+
+		current, ok = val.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// nestedTarget returns the target of the mappings that contains the target or is
+// contained by it, such as "a" and "a.b", as writing one would overwrite the other.
+func nestedTarget(mappings []pipeline.ShapeMapping, to string) string {
+	for _, m := range mappings {
+		if m.To != "" && (strings.HasPrefix(to, m.To+".") || strings.HasPrefix(m.To, to+".")) {
+			return m.To
+		}
+	}
+	return ""
+}
+
+// writePath writes a value using a dotted path, creating nested objects as needed.
+func writePath(data map[string]interface{}, parts []string, val interface{}) {
+	current := data
+	last := len(parts) - 1
+	for _, part := range parts[:last] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[last]] = val
+}
+
+// copyObject copies an object and the objects nested in it.
+func copyObject(obj map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if nested, ok := v.(map[string]interface{}); ok {
+			v = copyObject(nested)
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,299 @@
+package mapping
+
+import (
+	"testing"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	publishedShape = pipeline.ShapeDefinition{
+		Name: "customers",
+		Keys: []string{"CustomerID"},
+		Properties: []pipeline.PropertyDefinition{
+			{Name: "CustomerID", Type: "string"},
+			{Name: "Name", Type: "string"},
+			{Name: "Balance", Type: "string"},
+			{Name: "Active", Type: "number"},
+			{Name: "Address", Type: "object"},
+			{Name: "Address.City", Type: "string"},
+			{Name: "Created", Type: "string"},
+		},
+	}
+
+	subscribedShape = pipeline.ShapeDefinition{
+		Name: "customer",
+		Keys: []string{"id"},
+		Properties: []pipeline.PropertyDefinition{
+			{Name: "id", Type: "number"},
+			{Name: "name", Type: "string"},
+			{Name: "balance", Type: "number"},
+			{Name: "active", Type: "bool"},
+			{Name: "city", Type: "string"},
+			{Name: "created", Type: "date"},
+			{Name: "details", Type: "object"},
+			{Name: "details.name", Type: "string"},
+		},
+	}
+
+	validMappings = []pipeline.ShapeMapping{
+		{From: "CustomerID", To: "id"},
+		{From: "Name", To: "details.name"},
+		{From: "Balance", FromType: "string", To: "balance", ToType: "number"},
+		{From: "Active", To: "active"},
+		{From: "Address.City", To: "city"},
+		{From: "Created", To: "created"},
+	}
+)
+
+func TestCompile(t *testing.T) {
+
+	Convey("Given valid mappings", t, func() {
+		m, err := Compile(validMappings, publishedShape, subscribedShape)
+
+		Convey("Should compile without error", func() {
+			So(err, ShouldBeNil)
+			So(m, ShouldNotBeNil)
+		})
+
+		Convey("Should report unmapped target properties", func() {
+			So(m.UnmappedTargets(), ShouldResemble, []string{"name"})
+		})
+
+		Convey("Should report no unmapped source properties", func() {
+			So(m.UnmappedSources(), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given invalid mappings", t, func() {
+		mappings := []pipeline.ShapeMapping{
+			{From: "Missing", To: "name"},
+			{From: "Name", To: "missing"},
+			{From: "Name", FromType: "number", To: "city"},
+			{From: "Address", To: "balance"},
+			{From: "Balance", To: "name"},
+		}
+
+		_, err := Compile(mappings, publishedShape, subscribedShape)
+
+		Convey("Should return a compile error with every problem", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, pipeerrors.MappingCompilationError)
+			So(err.Error(), ShouldContainSubstring, "mapping 4 (Balance -> name)")
+
+			codes := []int{}
+			for _, e := range Validate(mappings, publishedShape, subscribedShape) {
+				codes = append(codes, e.Code)
+			}
+			So(codes, ShouldResemble, []int{
+				pipeerrors.MappingSourceNotFound,
+				pipeerrors.MappingTargetNotFound,
+				pipeerrors.MappingTypeMismatch,
+				pipeerrors.MappingUnsupportedConversion,
+				pipeerrors.MappingDuplicateTarget,
+				pipeerrors.MappingUnmappedKey,
+			})
+		})
+	})
+
+	Convey("Given mappings to a property and to a property nested in it", t, func() {
+		mappings := []pipeline.ShapeMapping{
+			{From: "a", To: "address"},
+			{From: "b", To: "address.city"},
+		}
+
+		Convey("Should reject the overlapping targets", func() {
+			_, err := Compile(mappings, pipeline.ShapeDefinition{}, pipeline.ShapeDefinition{})
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, pipeerrors.MappingCompilationError)
+
+			errs := Validate(mappings, pipeline.ShapeDefinition{}, pipeline.ShapeDefinition{})
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].Index, ShouldEqual, 1)
+			So(errs[0].Code, ShouldEqual, pipeerrors.MappingDuplicateTarget)
+		})
+
+		Convey("Should reject them in either order", func() {
+			mappings[0], mappings[1] = mappings[1], mappings[0]
+			errs := Validate(mappings, pipeline.ShapeDefinition{}, pipeline.ShapeDefinition{})
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].Code, ShouldEqual, pipeerrors.MappingDuplicateTarget)
+		})
+
+		Convey("Should accept targets that only share a name prefix", func() {
+			_, err := Compile([]pipeline.ShapeMapping{{From: "a", To: "address"}, {From: "b", To: "addresses"}}, pipeline.ShapeDefinition{}, pipeline.ShapeDefinition{})
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Given shapes without property definitions", t, func() {
+		m, err := Compile([]pipeline.ShapeMapping{{From: "a", To: "b"}}, pipeline.ShapeDefinition{}, pipeline.ShapeDefinition{})
+
+		Convey("Should compile without checking properties", func() {
+			So(err, ShouldBeNil)
+			So(m, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMap(t *testing.T) {
+
+	m, err := Compile(validMappings, publishedShape, subscribedShape)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Given data in the published shape", t, func() {
+		data := map[string]interface{}{
+			"CustomerID": "42",
+			"Name":       "Vandelay Industries",
+			"Balance":    "1024.50",
+			"Active":     1,
+			"Address":    map[string]interface{}{"City": "New York"},
+			"Created":    "2017-02-16",
+		}
+
+		Convey("Should return the data in the subscribed shape", func() {
+			out, err := m.Map(data)
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, map[string]interface{}{
+				"id":      float64(42),
+				"details": map[string]interface{}{"name": "Vandelay Industries"},
+				"balance": 1024.5,
+				"active":  true,
+				"city":    "New York",
+				"created": "2017-02-16T00:00:00Z",
+			})
+		})
+	})
+
+	Convey("Given data with flattened property names", t, func() {
+		flat, _ := Compile([]pipeline.ShapeMapping{{From: "Address.City", To: "address_city"}}, pipeline.ShapeDefinition{}, pipeline.ShapeDefinition{})
+		out, err := flat.Map(map[string]interface{}{"Address.City": "Erie"})
+
+		Convey("Should prefer the literal property name", func() {
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, map[string]interface{}{"address_city": "Erie"})
+		})
+	})
+
+	Convey("Given a mapping of an object", t, func() {
+		nested, err := Compile([]pipeline.ShapeMapping{{From: "company", To: "org"}}, pipeline.ShapeDefinition{}, pipeline.ShapeDefinition{})
+		So(err, ShouldBeNil)
+
+		company := map[string]interface{}{"name": "Naveego", "address": map[string]interface{}{"city": "Erie"}}
+		out, err := nested.Map(map[string]interface{}{"company": company})
+
+		Convey("Should copy the object so changes to the output do not modify the input", func() {
+			So(err, ShouldBeNil)
+			org := out["org"].(map[string]interface{})
+			org["extra"] = 1
+			org["address"].(map[string]interface{})["city"] = "Pittsburgh"
+			So(company, ShouldResemble, map[string]interface{}{"name": "Naveego", "address": map[string]interface{}{"city": "Erie"}})
+		})
+	})
+
+	Convey("Given data with missing and null properties", t, func() {
+		out, err := m.Map(map[string]interface{}{"CustomerID": "42", "Name": nil})
+
+		Convey("Should skip missing properties and keep nulls", func() {
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, map[string]interface{}{
+				"id":      float64(42),
+				"details": map[string]interface{}{"name": nil},
+			})
+		})
+	})
+
+	Convey("Given data that cannot be converted", t, func() {
+		_, err := m.Map(map[string]interface{}{"CustomerID": "42", "Balance": "lots"})
+
+		Convey("Should return a conversion error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a data point", t, func() {
+		dp := pipeline.DataPoint{
+			Entity:   "customers",
+			Action:   pipeline.DataPointUpsert,
+			KeyNames: []string{"CustomerID"},
+			Data:     map[string]interface{}{"CustomerID": "42"},
+			Shape:    pipeline.Shape{PropertyHash: 12},
+		}
+
+		out, err := m.MapDataPoint(dp)
+
+		Convey("Should use the subscribed keys and clear the shape", func() {
+			So(err, ShouldBeNil)
+			So(out.KeyNames, ShouldResemble, []string{"id"})
+			So(out.Shape.PropertyHash, ShouldEqual, 0)
+			So(dp.Data, ShouldResemble, map[string]interface{}{"CustomerID": "42"})
+		})
+	})
+}
+
+func TestConvert(t *testing.T) {
+
+	testCases := []struct {
+		value    interface{}
+		from     string
+		to       string
+		expected interface{}
+		fails    bool
+	}{
+		{"12.5", "string", "number", 12.5, false},
+		{12, "number", "string", "12", false},
+		{12.25, "number", "string", "12.25", false},
+		{"true", "string", "bool", true, false},
+		{0, "number", "bool", false, false},
+		{true, "bool", "number", float64(1), false},
+		{"02 Jan 06 15:04 MST", "string", "date", "2006-01-02T15:04:00Z", false},
+		{float64(0), "number", "date", "1970-01-01T00:00:00Z", false},
+		{"1970-01-01T00:01:00Z", "date", "number", float64(60), false},
+		{map[string]interface{}{"a": 1}, "object", "string", `{"a":1}`, false},
+		{"abc", "string", "number", nil, true},
+		{"abc", "string", "object", nil, true},
+		{nil, "string", "number", nil, false},
+	}
+
+	for _, tc := range testCases {
+		Convey("Converting "+tc.from+" to "+tc.to, t, func() {
+			actual, err := Convert(tc.value, tc.from, tc.to)
+			if tc.fails {
+				So(err, ShouldNotBeNil)
+			} else {
+				So(err, ShouldBeNil)
+				So(actual, ShouldEqual, tc.expected)
+			}
+		})
+	}
+}
+
+func BenchmarkMap(b *testing.B) {
+	m, err := Compile(validMappings, publishedShape, subscribedShape)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	data := map[string]interface{}{
+		"CustomerID": "42",
+		"Name":       "Vandelay Industries",
+		"Balance":    "1024.50",
+		"Active":     1,
+		"Address":    map[string]interface{}{"City": "New York"},
+		"Created":    "2017-02-16",
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Map(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/pipeline/publisher"
+	"github.com/naveego/api/pipeline/schedule"
+	"github.com/naveego/api/pipeline/subscriber"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+)
+
+// The streams that connect the activities of a definition to the publisher
+// and the subscriber.  The published data points are sent to SourceStream after
+// they are mapped, and the data points emitted to SinkStream are received by
+// the subscriber.
+const (
+	SourceStream = "published"
+	SinkStream   = "subscribed"
+)
+
+// Definition is everything needed to run a pipeline in a single process.  The
+// publisher and subscriber are created using the factories registered for the
+// PublisherType and SubscriberType of the pipeline.
+type Definition struct {
+	Pipeline   pipeline.Pipeline           `json:"pipeline"`
+	Publisher  pipeline.PublisherInstance  `json:"publisher"`  // The settings and shapes of the publisher
+	Subscriber pipeline.SubscriberInstance `json:"subscriber"` // The settings and shape of the subscriber
+	Activities []pipeline.Activity         `json:"activities"` // optional: The activities between the mappings and the subscriber
+}
+
+// Load reads a definition from a JSON or YAML file.
+func Load(path string) (Definition, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Definition{}, errors.NewWithCode(pipeerrors.InvalidPipelineDefinition, fmt.Sprintf("runner: could not read definition: %v", err))
+	}
+	return Parse(data)
+}
+
+// Parse parses a JSON or YAML definition.  YAML is converted to JSON first, so
+// both formats use the JSON names of the fields.
+func Parse(data []byte) (Definition, error) {
+	var def Definition
+	if err := yaml.Unmarshal(data, &def); err != nil {
+		return def, errors.NewWithCode(pipeerrors.InvalidPipelineDefinition, fmt.Sprintf("runner: could not parse definition: %v", err))
+	}
+
+	if err := def.Validate(); err != nil {
+		return def, err
+	}
+	return def, nil
+}
+
+// Validate checks that the definition names a publisher, a subscriber and the
+// published shape, and that the schedules of the pipeline and the publisher are
+// valid if they are set.
+func (d Definition) Validate() error {
+	switch {
+	case d.Pipeline.PublisherType == "":
+		return invalidDefinition("the pipeline requires a publisher_type")
+	case d.Pipeline.SubscriberType == "":
+		return invalidDefinition("the pipeline requires a subscriber_type")
+	case d.Pipeline.PublishedShape == "":
+		return invalidDefinition("the pipeline requires a published_shape")
+	}
+
+	for _, spec := range []string{d.Pipeline.Schedule, d.Publisher.Schedule} {
+		if spec == "" {
+			continue
+		}
+		if err := schedule.Validate(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidationContext returns the context that pipelines are validated against in
+// this process, with the registered publisher and subscriber types and the
+// schedule parser.  The instances of the pipeline are added by the caller.
+func ValidationContext() pipeline.PipelineValidationContext {
+	return pipeline.PipelineValidationContext{
+		PublisherTypes:   append([]string{}, publisher.Factories()...),
+		SubscriberTypes:  append([]string{}, subscriber.Factories()...),
+		ValidateSchedule: schedule.Validate,
+	}
+}
+
+func invalidDefinition(msg string) error {
+	return errors.NewWithCode(pipeerrors.InvalidPipelineDefinition, "runner: "+msg)
+}
@@ -0,0 +1,342 @@
+// Package runner runs a pipeline in a single process for end-to-end dry runs.
+// The registered publisher sends its data points through the mappings and the
+// activities of the pipeline to the registered subscriber using in-memory streams.
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/naveego/api/pipeline/activity"
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/api/pipeline/mapping"
+	"github.com/naveego/api/pipeline/publisher"
+	"github.com/naveego/api/pipeline/subscriber"
+	"github.com/naveego/api/types/dataflow"
+	"github.com/naveego/api/types/pipeline"
+	"github.com/naveego/errors"
+)
+
+// ErrRecordLimit is returned to the publisher by the data transport once the
+// maximum number of records has been published.
+var ErrRecordLimit = errors.New("runner: the maximum number of records has been published")
+
+// Options configure a run.
+type Options struct {
+	MaxRecords int           // optional: The number of records after which the publisher is stopped
+	Logger     *logrus.Entry // optional: The logger passed to the publisher and the subscriber
+}
+
+// StageSummary holds the number of data points that entered and left a stage.
+type StageSummary struct {
+	Stage string `json:"stage"`
+	In    int    `json:"in"`
+	Out   int    `json:"out"`
+}
+
+// Summary describes a run.  The stages are in the order the data flows through
+// them, and only the stages the pipeline uses are included.
+type Summary struct {
+	Stages   []StageSummary `json:"stages"`
+	Limited  bool           `json:"limited"` // Whether the publisher was stopped by the record limit
+	Duration time.Duration  `json:"duration"`
+}
+
+// Stage returns the summary of the named stage.
+func (s Summary) Stage(name string) (StageSummary, bool) {
+	for _, st := range s.Stages {
+		if st.Stage == name {
+			return st, true
+		}
+	}
+	return StageSummary{}, false
+}
+
+// Print writes the summary as a table.
+func (s Summary) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STAGE\tIN\tOUT")
+	for _, st := range s.Stages {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", st.Stage, st.In, st.Out)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	limited := ""
+	if s.Limited {
+		limited = " (stopped at the record limit)"
+	}
+	_, err := fmt.Fprintf(w, "Completed in %v%s\n", s.Duration, limited)
+	return err
+}
+
+// The names of the stages in the summary.
+const (
+	StagePublisher  = "publisher"
+	StageMapping    = "mapping"
+	StageActivities = "activities"
+	StageSubscriber = "subscriber"
+)
+
+type counter struct {
+	in, out int64
+}
+
+func (c *counter) summary(stage string) StageSummary {
+	return StageSummary{Stage: stage, In: int(atomic.LoadInt64(&c.in)), Out: int(atomic.LoadInt64(&c.out))}
+}
+
+// run holds the state of a single run.
+type run struct {
+	def     Definition
+	opts    Options
+	shape   pipeline.ShapeDefinition
+	mapper  *mapping.Mapper
+	engine  *activity.Engine
+	sub     subscriber.Subscriber
+	subCtx  subscriber.Context
+	subMU   sync.Mutex
+	limited int32
+
+	publisher, mapping, activities, subscriber counter
+
+	errMU sync.Mutex
+	err   error
+}
+
+// Run runs the pipeline of the definition.  The publisher is initialized, publishes
+// the published shape of the pipeline and is disposed.  The first error returned
+// by a mapping, an activity or the subscriber stops the run.  Errors that activities
+// recover from using their error policy are logged as warnings.
+func Run(ctx context.Context, def Definition, opts Options) (Summary, error) {
+	start := time.Now()
+	if opts.Logger == nil {
+		opts.Logger = logrus.NewEntry(logrus.StandardLogger())
+	}
+
+	if err := def.Validate(); err != nil {
+		return Summary{}, err
+	}
+
+	pubFactory, err := publisher.GetFactory(def.Pipeline.PublisherType)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	subFactory, err := subscriber.GetFactory(def.Pipeline.SubscriberType)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	r := &run{def: def, opts: opts}
+
+	pubCtx := publisher.Context{Settings: def.Publisher.Settings, Logger: opts.Logger}
+	pub := pubFactory()
+	if err := pub.Init(pubCtx); err != nil {
+		return Summary{}, fmt.Errorf("runner: could not initialize publisher '%s': %v", def.Pipeline.PublisherType, err)
+	}
+	defer pub.Dispose(pubCtx)
+
+	if err := r.prepare(pub, pubCtx); err != nil {
+		return Summary{}, err
+	}
+
+	r.subCtx = subscriber.Context{Subscriber: r.def.Subscriber, Pipeline: def.Pipeline, Logger: opts.Logger}
+	r.sub = subFactory()
+	if err := r.sub.Init(r.subCtx, def.Subscriber.Settings); err != nil {
+		return Summary{}, fmt.Errorf("runner: could not initialize subscriber '%s': %v", def.Pipeline.SubscriberType, err)
+	}
+	defer r.sub.Dispose(r.subCtx)
+
+	if len(def.Activities) > 0 {
+		r.engine, err = activity.NewEngine(def.Pipeline, def.Activities, activity.EngineConfig{
+			Sources: []string{SourceStream},
+			Sinks:   []string{SinkStream},
+			Sink:    r.sink,
+			Log:     r.log,
+		})
+		if err != nil {
+			return Summary{}, err
+		}
+		r.engine.Start(ctx)
+	}
+
+	pub.Publish(pubCtx, r.shape, &transport{run: r, ctx: ctx})
+
+	if r.engine != nil {
+		r.engine.Close()
+		if err := r.engine.Wait(); err != nil {
+			r.fail(err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		r.fail(err)
+	}
+
+	return r.summary(time.Since(start)), r.Err()
+}
+
+// prepare finds the published shape and compiles the mappings.  The shapes of the
+// publisher are read from the publisher when the definition does not list them.
+func (r *run) prepare(pub publisher.Publisher, pubCtx publisher.Context) error {
+	if len(r.def.Publisher.Shapes) == 0 {
+		shapes, err := pub.Shapes(pubCtx)
+		if err != nil {
+			return fmt.Errorf("runner: could not read the shapes of publisher '%s': %v", r.def.Pipeline.PublisherType, err)
+		}
+		r.def.Publisher.Shapes = shapes
+	}
+
+	found := false
+	for _, sd := range r.def.Publisher.Shapes {
+		if sd.Name == r.def.Pipeline.PublishedShape || sd.ID == r.def.Pipeline.PublishedShape {
+			r.shape = sd
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.NewWithCode(pipeerrors.InvalidPipelineDefinition, fmt.Sprintf("runner: publisher '%s' does not publish shape '%s'", r.def.Pipeline.PublisherType, r.def.Pipeline.PublishedShape))
+	}
+
+	if r.def.Subscriber.Shape.Name == "" && len(r.def.Pipeline.Mappings) == 0 {
+		// Without mappings the subscriber receives the published shape
+		r.def.Subscriber.Shape = r.shape
+	}
+
+	if len(r.def.Pipeline.Mappings) > 0 {
+		var err error
+		if r.mapper, err = mapping.CompilePipeline(r.def.Pipeline, r.def.Publisher, r.def.Subscriber); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send maps a published data point and sends it to the activities, or to the
+// subscriber when there are no activities.
+func (r *run) send(dataPoint pipeline.DataPoint) error {
+	atomic.AddInt64(&r.publisher.out, 1)
+
+	if r.mapper != nil {
+		atomic.AddInt64(&r.mapping.in, 1)
+		mapped, err := r.mapper.MapDataPoint(dataPoint)
+		if err != nil {
+			return err
+		}
+		dataPoint = mapped
+		atomic.AddInt64(&r.mapping.out, 1)
+	}
+
+	if r.engine == nil {
+		return r.receive(dataPoint)
+	}
+
+	atomic.AddInt64(&r.activities.in, 1)
+	return r.engine.Send(SourceStream, dataPoint)
+}
+
+func (r *run) sink(stream string, dataPoint pipeline.DataPoint) error {
+	atomic.AddInt64(&r.activities.out, 1)
+	return r.receive(dataPoint)
+}
+
+// receive delivers a data point to the subscriber.  Subscribers are not expected
+// to be safe for concurrent use, so the data points are received one at a time.
+func (r *run) receive(dataPoint pipeline.DataPoint) error {
+	r.subMU.Lock()
+	defer r.subMU.Unlock()
+
+	atomic.AddInt64(&r.subscriber.in, 1)
+	if err := r.sub.Receive(r.subCtx, r.def.Subscriber.Shape, dataPoint); err != nil {
+		return fmt.Errorf("runner: subscriber '%s' failed: %v", r.def.Pipeline.SubscriberType, err)
+	}
+	atomic.AddInt64(&r.subscriber.out, 1)
+	return nil
+}
+
+func (r *run) log(entry dataflow.Log) {
+	r.opts.Logger.WithField("activity", entry.ObjectID).Warnf("%s: %s", entry.Action, entry.Message)
+}
+
+// fail records the first error of the run.
+func (r *run) fail(err error) {
+	r.errMU.Lock()
+	defer r.errMU.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Err returns the first error of the run.
+func (r *run) Err() error {
+	r.errMU.Lock()
+	defer r.errMU.Unlock()
+	return r.err
+}
+
+func (r *run) summary(d time.Duration) Summary {
+	s := Summary{
+		Stages:   []StageSummary{r.publisher.summary(StagePublisher)},
+		Limited:  atomic.LoadInt32(&r.limited) == 1,
+		Duration: d,
+	}
+	if r.mapper != nil {
+		s.Stages = append(s.Stages, r.mapping.summary(StageMapping))
+	}
+	if r.engine != nil {
+		s.Stages = append(s.Stages, r.activities.summary(StageActivities))
+	}
+	s.Stages = append(s.Stages, r.subscriber.summary(StageSubscriber))
+	return s
+}
+
+// transport is the in-memory publisher.DataTransport of a run.
+type transport struct {
+	run *run
+	ctx context.Context
+	mu  sync.Mutex
+}
+
+// Send sends the data points one at a time.  Once the run has failed or the record
+// limit has been reached, Send returns an error so the publisher can stop.  Only
+// the data points that are accepted are counted.
+func (t *transport) Send(dataPoints []pipeline.DataPoint) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.run
+	for _, dp := range dataPoints {
+		if err := r.Err(); err != nil {
+			return err
+		}
+		if err := t.ctx.Err(); err != nil {
+			return err
+		}
+
+		if max := r.opts.MaxRecords; max > 0 && atomic.LoadInt64(&r.publisher.out) >= int64(max) {
+			atomic.StoreInt32(&r.limited, 1)
+			return ErrRecordLimit
+		}
+
+		atomic.AddInt64(&r.publisher.in, 1)
+		if err := r.send(dp); err != nil {
+			r.fail(err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *transport) Done() error {
+	return nil
+}
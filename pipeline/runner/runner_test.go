@@ -0,0 +1,269 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	_ "github.com/naveego/api/pipeline/activity/builtin"
+	"github.com/naveego/api/pipeline/publisher"
+	"github.com/naveego/api/pipeline/subscriber"
+	"github.com/naveego/api/types/pipeline"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testPublisher publishes the number of customers in the count setting, two at a time.
+type testPublisher struct{}
+
+func (p *testPublisher) Init(ctx publisher.Context) error { return nil }
+
+func (p *testPublisher) Dispose(ctx publisher.Context) error { return nil }
+
+func (p *testPublisher) TestConnection(ctx publisher.Context) (bool, string, error) {
+	return true, "", nil
+}
+
+func (p *testPublisher) Shapes(ctx publisher.Context) (pipeline.ShapeDefinitions, error) {
+	return pipeline.ShapeDefinitions{{Name: "customers", Keys: []string{"CustomerID"}}}, nil
+}
+
+func (p *testPublisher) Publish(ctx publisher.Context, shape pipeline.ShapeDefinition, dataTransport publisher.DataTransport) {
+	count, _ := ctx.Settings["count"].(float64)
+
+	var batch []pipeline.DataPoint
+	for i := 1; i <= int(count); i++ {
+		batch = append(batch, ctx.NewDataPoint(shape.Name, shape.Keys, map[string]interface{}{
+			"CustomerID": i,
+			"Balance":    strconv.Itoa(i * 100),
+		}))
+		if len(batch) == 2 || i == int(count) {
+			if err := dataTransport.Send(batch); err != nil {
+				return
+			}
+			batch = nil
+		}
+	}
+	dataTransport.Done()
+}
+
+// testSubscriber records the data points it receives and fails on the id in the
+// failOn setting.
+type testSubscriber struct {
+	mu       sync.Mutex
+	failOn   float64
+	received []pipeline.DataPoint
+	disposed bool
+}
+
+var received *testSubscriber
+
+func (s *testSubscriber) Init(ctx subscriber.Context, settings map[string]interface{}) error {
+	s.failOn, _ = settings["failOn"].(float64)
+	return nil
+}
+
+func (s *testSubscriber) TestConnection(ctx subscriber.Context, connSettings map[string]interface{}) (bool, string, error) {
+	return true, "", nil
+}
+
+func (s *testSubscriber) Shapes(ctx subscriber.Context) (pipeline.ShapeDefinitions, error) {
+	return nil, nil
+}
+
+func (s *testSubscriber) Receive(ctx subscriber.Context, shape pipeline.ShapeDefinition, dataPoint pipeline.DataPoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, _ := dataPoint.Data["CustomerID"].(int); s.failOn > 0 && float64(id) == s.failOn {
+		return fmt.Errorf("customer %d was rejected", id)
+	}
+	s.received = append(s.received, dataPoint)
+	return nil
+}
+
+func (s *testSubscriber) Dispose(ctx subscriber.Context) error {
+	s.disposed = true
+	return nil
+}
+
+func init() {
+	publisher.RegisterFactory("runner-test", func() publisher.Publisher { return &testPublisher{} })
+	subscriber.RegisterFactory("runner-test", func() subscriber.Subscriber {
+		received = &testSubscriber{}
+		return received
+	})
+}
+
+func simpleDefinition(count int) Definition {
+	return Definition{
+		Pipeline: pipeline.Pipeline{
+			ID:             "simple",
+			PublisherType:  "runner-test",
+			SubscriberType: "runner-test",
+			PublishedShape: "customers",
+		},
+		Publisher: pipeline.PublisherInstance{Settings: map[string]interface{}{"count": float64(count)}},
+	}
+}
+
+func TestRun(t *testing.T) {
+
+	Convey("Given a definition with mappings and activities", t, func() {
+		def, err := Load("testdata/pipeline.yaml")
+		So(err, ShouldBeNil)
+
+		summary, err := Run(context.Background(), def, Options{})
+
+		Convey("Should deliver the mapped and filtered data points to the subscriber", func() {
+			So(err, ShouldBeNil)
+			So(received.received, ShouldHaveLength, 4)
+			So(received.received[0].Data, ShouldResemble, map[string]interface{}{"id": 2, "balance": float64(200)})
+			So(received.received[0].KeyNames, ShouldResemble, []string{"id"})
+			So(received.disposed, ShouldBeTrue)
+		})
+
+		Convey("Should count the data points of every stage", func() {
+			So(summary.Stages, ShouldResemble, []StageSummary{
+				{Stage: StagePublisher, In: 5, Out: 5},
+				{Stage: StageMapping, In: 5, Out: 5},
+				{Stage: StageActivities, In: 5, Out: 4},
+				{Stage: StageSubscriber, In: 4, Out: 4},
+			})
+			So(summary.Limited, ShouldBeFalse)
+		})
+
+		Convey("Should print the summary", func() {
+			var buf bytes.Buffer
+			So(summary.Print(&buf), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, "STAGE")
+			So(buf.String(), ShouldContainSubstring, "activities")
+			So(buf.String(), ShouldContainSubstring, "Completed in")
+		})
+	})
+
+	Convey("Given a definition without mappings and activities", t, func() {
+		summary, err := Run(context.Background(), simpleDefinition(3), Options{})
+
+		Convey("Should deliver the published data points with the published shape", func() {
+			So(err, ShouldBeNil)
+			So(received.received, ShouldHaveLength, 3)
+			So(received.received[2].Data["CustomerID"], ShouldEqual, 3)
+			So(summary.Stages, ShouldHaveLength, 2)
+		})
+	})
+
+	Convey("Given a record limit", t, func() {
+		summary, err := Run(context.Background(), simpleDefinition(10), Options{MaxRecords: 3})
+
+		Convey("Should stop the publisher at the limit", func() {
+			So(err, ShouldBeNil)
+			So(received.received, ShouldHaveLength, 3)
+			So(summary.Limited, ShouldBeTrue)
+
+			pub, _ := summary.Stage(StagePublisher)
+			So(pub.In, ShouldEqual, 3)
+			So(pub.Out, ShouldEqual, 3)
+		})
+	})
+
+	Convey("Given a subscriber that fails", t, func() {
+		def := simpleDefinition(10)
+		def.Subscriber.Settings = map[string]interface{}{"failOn": float64(4)}
+		summary, err := Run(context.Background(), def, Options{})
+
+		Convey("Should stop the run", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "customer 4 was rejected")
+			So(received.received, ShouldHaveLength, 3)
+
+			pub, _ := summary.Stage(StagePublisher)
+			So(pub.Out, ShouldEqual, 4)
+		})
+	})
+
+	Convey("Given a publisher that is not registered", t, func() {
+		def := simpleDefinition(1)
+		def.Pipeline.PublisherType = "runner-missing"
+		_, err := Run(context.Background(), def, Options{})
+
+		Convey("Should return an error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "runner-missing")
+		})
+	})
+
+	Convey("Given a shape that is not published", t, func() {
+		def := simpleDefinition(1)
+		def.Pipeline.PublishedShape = "orders"
+		_, err := Run(context.Background(), def, Options{})
+
+		Convey("Should return an error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "does not publish shape 'orders'")
+		})
+	})
+}
+
+func TestParse(t *testing.T) {
+
+	Convey("Given a JSON definition", t, func() {
+		def, err := Parse([]byte(`{"pipeline": {"publisher_type": "a", "subscriber_type": "b", "published_shape": "c"}}`))
+
+		Convey("Should parse the definition", func() {
+			So(err, ShouldBeNil)
+			So(def.Pipeline.PublisherType, ShouldEqual, "a")
+		})
+	})
+
+	Convey("Given a definition without a subscriber", t, func() {
+		_, err := Parse([]byte("pipeline:\n  publisher_type: a\n  published_shape: c\n"))
+
+		Convey("Should return an error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "subscriber_type")
+		})
+	})
+
+	Convey("Given a definition with an invalid schedule", t, func() {
+		_, err := Parse([]byte("pipeline:\n  publisher_type: a\n  subscriber_type: b\n  published_shape: c\n  schedule: '* * *'\n"))
+
+		Convey("Should return an error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "invalid schedule")
+		})
+	})
+
+	Convey("Given the validation context of the process", t, func() {
+		ctx := ValidationContext()
+
+		Convey("Should validate pipelines against the registered types", func() {
+			p := pipeline.Pipeline{
+				Name:           "customers",
+				Status:         pipeline.PipelineActive,
+				Schedule:       "* * *",
+				PublisherID:    "pub1",
+				PublisherType:  "runner-test",
+				SubscriberID:   "sub1",
+				SubscriberType: "runner-missing",
+				PublishedShape: "customers",
+			}
+
+			result := p.ValidateWithContext(ctx)
+			So(result.Errors, ShouldHaveLength, 2)
+			So(result.Errors[0].Code, ShouldEqual, pipeline.PipelineInvalidScheduleError)
+			So(result.Errors[1].Code, ShouldEqual, pipeline.PipelineUnknownSubscriberError)
+		})
+	})
+
+	Convey("Given invalid YAML", t, func() {
+		_, err := Parse([]byte("pipeline: [a"))
+
+		Convey("Should return an error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "could not parse definition")
+		})
+	})
+}
@@ -0,0 +1,172 @@
+// Package schedule parses and validates the cron schedules of pipelines and
+// publishers, and previews when they will run.
+//
+// A schedule is one of:
+//
+//	"0 */15 * * *"       cron with a leading seconds field, without the day of week
+//	"30 */15 * * * *"    cron with a leading seconds field
+//	"@daily"             a descriptor: @yearly, @annually, @monthly, @weekly, @daily, @midnight or @hourly
+//	"@every 1h30m"       a fixed interval of at least one second
+//
+// Cron schedules are read the way robfig/cron has always read the schedules of
+// publishers, so the first field is the seconds even when there are only five
+// fields: "0 */5 * * *" runs every five minutes, not every five hours.  Standard
+// cron, whose first field is the minute, must be asked for with the CRON_FORMAT
+// prefix, as in "CRON_FORMAT=standard */5 * * * *".
+//
+// Any schedule can be prefixed with a time zone from the IANA database, such as
+// "CRON_TZ=America/New_York 0 0 9 * * *".  TZ= is accepted as well.
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/errors"
+	"github.com/robfig/cron"
+)
+
+// FormatStandard is the CRON_FORMAT of standard cron schedules.
+const FormatStandard = "standard"
+
+var (
+	standardParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	secondsParser  = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.DowOptional | cron.Descriptor)
+)
+
+// Schedule is a parsed schedule.  It implements cron.Schedule, so it can be
+// added to a cron.Cron using its Schedule method.
+type Schedule struct {
+	Spec     string         // The schedule without the time zone and format prefixes
+	Location *time.Location // The time zone the schedule is evaluated in
+	Standard bool           // The schedule is standard cron, whose first field is the minute
+
+	schedule cron.Schedule
+}
+
+// Parse parses a schedule that is evaluated in the local time zone, unless the
+// schedule has a time zone prefix.
+func Parse(spec string) (*Schedule, error) {
+	return ParseInLocation(spec, time.Local)
+}
+
+// ParseInLocation parses a schedule that is evaluated in the given time zone, unless
+// the schedule has a time zone prefix.  Schedules that never run are invalid.
+func ParseInLocation(spec string, loc *time.Location) (*Schedule, error) {
+	s := strings.TrimSpace(spec)
+	if s == "" {
+		return nil, invalid(spec, "the schedule is empty")
+	}
+
+	standard := false
+	for strings.HasPrefix(s, "CRON_TZ=") || strings.HasPrefix(s, "TZ=") || strings.HasPrefix(s, "CRON_FORMAT=") {
+		i := strings.IndexAny(s, " \t")
+		if i < 0 {
+			return nil, invalid(spec, "the prefix must be followed by a schedule")
+		}
+
+		name, value := s[:strings.Index(s, "=")], s[strings.Index(s, "=")+1:i]
+		if name == "CRON_FORMAT" {
+			if value != FormatStandard {
+				return nil, invalid(spec, fmt.Sprintf("unknown format '%s'", value))
+			}
+			standard = true
+		} else {
+			var err error
+			if loc, err = time.LoadLocation(value); err != nil {
+				return nil, invalid(spec, fmt.Sprintf("unknown time zone '%s'", value))
+			}
+		}
+		s = strings.TrimSpace(s[i:])
+	}
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	sched, err := parse(s, standard)
+	if err != nil {
+		return nil, invalid(spec, err.Error())
+	}
+
+	result := &Schedule{Spec: s, Location: loc, Standard: standard, schedule: sched}
+	if result.Next(time.Now()).IsZero() {
+		return nil, invalid(spec, "the schedule never runs")
+	}
+	return result, nil
+}
+
+func parse(spec string, standard bool) (cron.Schedule, error) {
+	if strings.HasPrefix(spec, "@") {
+		sched, err := secondsParser.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		// cron silently rounds shorter intervals up to a second
+		if d, err := time.ParseDuration(strings.TrimPrefix(spec, "@every ")); err == nil && d < time.Second {
+			return nil, fmt.Errorf("the interval must be at least 1s")
+		}
+		return sched, nil
+	}
+
+	n := len(strings.Fields(spec))
+	if standard {
+		if n != 5 {
+			return nil, fmt.Errorf("expected 5 fields in a standard schedule, found %d", n)
+		}
+		return standardParser.Parse(spec)
+	}
+	if n != 5 && n != 6 {
+		return nil, fmt.Errorf("expected 5 or 6 fields, found %d", n)
+	}
+	return secondsParser.Parse(spec)
+}
+
+// Validate returns an error if the schedule cannot be parsed or never runs.
+func Validate(spec string) error {
+	_, err := Parse(spec)
+	return err
+}
+
+// Next returns the first time the schedule runs after t, in the time zone of the
+// schedule.  It returns the zero time if the schedule does not run in the next
+// five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	return s.schedule.Next(t.In(s.Location))
+}
+
+// NextN returns the next n times the schedule runs after t.
+func (s *Schedule) NextN(t time.Time, n int) []time.Time {
+	times := make([]time.Time, 0, n)
+	for i := 0; i < n; i++ {
+		t = s.Next(t)
+		if t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// Preview parses the schedule and returns the next n times it runs after t.
+func Preview(spec string, t time.Time, n int) ([]time.Time, error) {
+	s, err := Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	return s.NextN(t, n), nil
+}
+
+// String returns the schedule with its time zone and format prefixes.
+func (s *Schedule) String() string {
+	if s.Standard {
+		return fmt.Sprintf("CRON_TZ=%s CRON_FORMAT=%s %s", s.Location, FormatStandard, s.Spec)
+	}
+	return fmt.Sprintf("CRON_TZ=%s %s", s.Location, s.Spec)
+}
+
+func invalid(spec, msg string) error {
+	return errors.NewWithCode(pipeerrors.InvalidSchedule, fmt.Sprintf("schedule: invalid schedule '%s': %s", spec, msg))
+}
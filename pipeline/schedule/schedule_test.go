@@ -0,0 +1,161 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	pipeerrors "github.com/naveego/api/pipeline/errors"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var start = time.Date(2017, 3, 1, 10, 7, 30, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+
+	testCases := []struct {
+		spec     string
+		expected []time.Time
+	}{
+		{"CRON_FORMAT=standard */15 * * * *", []time.Time{
+			time.Date(2017, 3, 1, 10, 15, 0, 0, time.UTC),
+			time.Date(2017, 3, 1, 10, 30, 0, 0, time.UTC),
+			time.Date(2017, 3, 1, 10, 45, 0, 0, time.UTC),
+		}},
+		{"45 */15 * * * *", []time.Time{
+			time.Date(2017, 3, 1, 10, 15, 45, 0, time.UTC),
+			time.Date(2017, 3, 1, 10, 30, 45, 0, time.UTC),
+			time.Date(2017, 3, 1, 10, 45, 45, 0, time.UTC),
+		}},
+		{"@daily", []time.Time{
+			time.Date(2017, 3, 2, 0, 0, 0, 0, time.UTC),
+			time.Date(2017, 3, 3, 0, 0, 0, 0, time.UTC),
+			time.Date(2017, 3, 4, 0, 0, 0, 0, time.UTC),
+		}},
+		{"@every 1h30m", []time.Time{
+			time.Date(2017, 3, 1, 11, 37, 30, 0, time.UTC),
+			time.Date(2017, 3, 1, 13, 7, 30, 0, time.UTC),
+			time.Date(2017, 3, 1, 14, 37, 30, 0, time.UTC),
+		}},
+		{"CRON_FORMAT=standard 0 9 * * MON-FRI", []time.Time{
+			time.Date(2017, 3, 2, 9, 0, 0, 0, time.UTC),
+			time.Date(2017, 3, 3, 9, 0, 0, 0, time.UTC),
+			time.Date(2017, 3, 6, 9, 0, 0, 0, time.UTC),
+		}},
+	}
+
+	for _, tc := range testCases {
+		Convey("Given the schedule "+tc.spec, t, func() {
+			s, err := ParseInLocation(tc.spec, time.UTC)
+
+			Convey("Should return the next run times", func() {
+				So(err, ShouldBeNil)
+				So(s.NextN(start, 3), ShouldResemble, tc.expected)
+			})
+		})
+	}
+
+	Convey("Given a legacy schedule with five fields", t, func() {
+		s, err := ParseInLocation("0 */5 * * *", time.UTC)
+
+		Convey("Should read the first field as the seconds, like robfig/cron", func() {
+			So(err, ShouldBeNil)
+			So(s.Standard, ShouldBeFalse)
+			So(s.NextN(start, 3), ShouldResemble, []time.Time{
+				time.Date(2017, 3, 1, 10, 10, 0, 0, time.UTC),
+				time.Date(2017, 3, 1, 10, 15, 0, 0, time.UTC),
+				time.Date(2017, 3, 1, 10, 20, 0, 0, time.UTC),
+			})
+		})
+
+		Convey("Should accept minutes that are not valid hours", func() {
+			s, err := ParseInLocation("0 30 * * *", time.UTC)
+			So(err, ShouldBeNil)
+			So(s.Next(start), ShouldResemble, time.Date(2017, 3, 1, 10, 30, 0, 0, time.UTC))
+		})
+
+		Convey("Should read the same fields as hours in standard cron", func() {
+			s, err := ParseInLocation("CRON_FORMAT=standard 0 */5 * * *", time.UTC)
+			So(err, ShouldBeNil)
+			So(s.Standard, ShouldBeTrue)
+			So(s.Next(start), ShouldResemble, time.Date(2017, 3, 1, 15, 0, 0, 0, time.UTC))
+			So(Validate("CRON_FORMAT=standard 0 30 * * *"), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a schedule with a time zone", t, func() {
+		s, err := ParseInLocation("CRON_TZ=America/New_York 0 0 9 * * *", time.UTC)
+
+		Convey("Should run in the time zone of the schedule", func() {
+			So(err, ShouldBeNil)
+			So(s.Spec, ShouldEqual, "0 0 9 * * *")
+			So(s.Location.String(), ShouldEqual, "America/New_York")
+			So(s.Next(start).UTC(), ShouldResemble, time.Date(2017, 3, 1, 14, 0, 0, 0, time.UTC))
+			So(s.String(), ShouldEqual, "CRON_TZ=America/New_York 0 0 9 * * *")
+		})
+
+		Convey("Should accept the TZ prefix", func() {
+			s, err := Parse("TZ=Europe/London 0 0 9 * * *")
+			So(err, ShouldBeNil)
+			So(s.Location.String(), ShouldEqual, "Europe/London")
+		})
+
+		Convey("Should combine the time zone with the format", func() {
+			s, err := Parse("CRON_TZ=America/New_York CRON_FORMAT=standard 0 9 * * *")
+			So(err, ShouldBeNil)
+			So(s.Next(start).UTC(), ShouldResemble, time.Date(2017, 3, 1, 14, 0, 0, 0, time.UTC))
+			So(s.String(), ShouldEqual, "CRON_TZ=America/New_York CRON_FORMAT=standard 0 9 * * *")
+		})
+	})
+
+	invalid := []struct {
+		name string
+		spec string
+	}{
+		{"an empty schedule", " "},
+		{"too few fields", "* * * *"},
+		{"too many fields", "0 0 0 * * * *"},
+		{"a minute out of range", "60 * * * *"},
+		{"an unknown descriptor", "@sometimes"},
+		{"an invalid interval", "@every soon"},
+		{"an interval under a second", "@every 10ms"},
+		{"an unknown time zone", "CRON_TZ=Mars/Olympus 0 9 * * *"},
+		{"a time zone without a schedule", "CRON_TZ=UTC"},
+		{"an unknown format", "CRON_FORMAT=quartz 0 9 * * *"},
+		{"a standard schedule with a seconds field", "CRON_FORMAT=standard 0 0 9 * * *"},
+		{"a schedule that never runs", "0 0 0 30 2 *"},
+	}
+
+	for _, tc := range invalid {
+		Convey("Given "+tc.name, t, func() {
+			err := Validate(tc.spec)
+
+			Convey("Should return an invalid schedule error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.(errors.Error).Code, ShouldEqual, pipeerrors.InvalidSchedule)
+				So(err.Error(), ShouldContainSubstring, "invalid schedule")
+			})
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+
+	Convey("Given a valid schedule", t, func() {
+		times, err := Preview("@hourly", start, 2)
+
+		Convey("Should return the next run times", func() {
+			So(err, ShouldBeNil)
+			So(times, ShouldHaveLength, 2)
+			So(times[0].Minute(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given an invalid schedule", t, func() {
+		_, err := Preview("daily", start, 2)
+
+		Convey("Should return an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
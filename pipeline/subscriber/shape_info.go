@@ -54,7 +54,7 @@ func GenerateShapeInfo(knownShapes map[string]pipeline.Shape, dataPoint pipeline
 
 		// If the shape is exactly the same as the previous shape, or it is a subset of the previous shape
 		// then there is no change.  We can just use the previous shape.
-		if shape.PropertyHash != prevShape.PropertyHash && isSubsetOf(shape.Properties, prevShape.Properties) {
+		if !shape.HasSameProperties(prevShape) && isSubsetOf(shape.Properties, prevShape.Properties) {
 			info.Shape = prevShape
 		}
 
@@ -1,7 +1,6 @@
 package pipeline
 
 import (
-	"regexp"
 	"strings"
 
 	"github.com/naveego/errors"
@@ -44,9 +43,6 @@ func (d *DataPointAction) UnmarshalJSON(bytes []byte) error {
 }
 
 var (
-	validRepositoryRegex *regexp.Regexp
-	validEntityRegex     *regexp.Regexp
-
 	// Error codes start with the HTTP status codes they represent
 	// followed by a more specific code
 	DecodeDataPointError      = 4000001
@@ -63,12 +59,6 @@ var (
 	DataMissingKeysError      = 4220010
 )
 
-func init() {
-	// Get the name regex ready to go
-	validRepositoryRegex = regexp.MustCompile("^[a-zA-Z0-9_]{3,15}$")
-	validEntityRegex = regexp.MustCompile("^[a-zA-Z0-9_]{3,30}$")
-}
-
 // DataPoint represents a pipeline dataPoint that can flow through the
 // system.  DataPoints
 type DataPoint struct {
@@ -83,13 +73,20 @@ type DataPoint struct {
 }
 
 // Validate ensures that the data dataPoints is valid for processing
+// using the default naming rules.
 func (d *DataPoint) Validate() error {
+	return d.ValidateWithRules(DefaultNamingRules)
+}
+
+// ValidateWithRules ensures that the data dataPoint is valid for processing
+// using the provided naming rules for the repository and entity.
+func (d *DataPoint) ValidateWithRules(rules NamingRules) error {
 
 	if d.Repository == "" {
 		return errors.Error{Code: NoRepositoryError, Message: "no repository was defined"}
 	}
 
-	if validRepositoryRegex.MatchString(d.Repository) == false {
+	if rules.Repository.Match(d.Repository) == false {
 		return errors.Error{Code: InvalidRepositoryError, Message: "repository does not meet naming requirements"}
 	}
 
@@ -97,7 +94,7 @@ func (d *DataPoint) Validate() error {
 		return errors.Error{Code: NoEntityError, Message: "no entity was defined"}
 	}
 
-	if validEntityRegex.MatchString(d.Entity) == false {
+	if rules.Entity.Match(d.Entity) == false {
 		return errors.Error{Code: InvalidEntityError, Message: "entity does not meet naming requirements"}
 	}
 
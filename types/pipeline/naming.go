@@ -0,0 +1,296 @@
+package pipeline
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/naveego/api/types"
+	"github.com/naveego/errors"
+)
+
+const (
+	// MetaSourceEntity is the meta key used to store the original entity
+	// name of a data point whose entity name was normalized.
+	MetaSourceEntity = "source_entity"
+
+	// NamingConfigParam is the repository configuration parameter that
+	// holds the naming rules for the repository.
+	NamingConfigParam = "pipeline.naming"
+
+	// InvalidNamingRulesError is returned when the naming rules in a
+	// repository configuration cannot be used.
+	InvalidNamingRulesError = 4220017
+)
+
+var (
+	// DefaultNamingRules are the naming rules used by DataPoint.Validate
+	DefaultNamingRules NamingRules
+
+	// transliterations maps common accented latin characters to
+	// their ascii equivalents.
+	transliterations = map[rune]string{
+		'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae",
+		'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i",
+		'î': "i", 'ï': "i", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o",
+		'ö': "o", 'ø': "o", 'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y",
+		'ÿ': "y", 'ß': "ss", 'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "A",
+		'Å': "A", 'Æ': "AE", 'Ç': "C", 'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E",
+		'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I", 'Ñ': "N", 'Ò': "O", 'Ó': "O",
+		'Ô': "O", 'Õ': "O", 'Ö': "O", 'Ø': "O", 'Ù': "U", 'Ú': "U", 'Û': "U",
+		'Ü': "U", 'Ý': "Y",
+	}
+)
+
+func init() {
+	DefaultNamingRules = NamingRules{
+		Repository: MustCompileNameRule(NameRule{MinLength: 3, MaxLength: 15}),
+		Entity:     MustCompileNameRule(NameRule{MinLength: 3, MaxLength: 30}),
+	}
+}
+
+// NameRule describes the names that are allowed for a repository or entity.  Names
+// may always contain letters, digits and underscores.
+type NameRule struct {
+	MinLength  int    `json:"minLength"`            // The minimum length of the name
+	MaxLength  int    `json:"maxLength"`            // The maximum length of the name
+	ExtraChars string `json:"extraChars,omitempty"` // Characters allowed in addition to letters, digits and underscores
+	Pattern    string `json:"pattern,omitempty"`    // optional: A regular expression names must also match
+
+	regex   *regexp.Regexp
+	pattern *regexp.Regexp
+}
+
+// CompileNameRule validates the rule and prepares it for matching.
+func CompileNameRule(rule NameRule) (NameRule, error) {
+	if rule.MinLength < 1 || rule.MaxLength < rule.MinLength {
+		return rule, errors.Error{Code: InvalidNamingRulesError, Message: fmt.Sprintf("invalid name length range %d-%d", rule.MinLength, rule.MaxLength)}
+	}
+
+	var err error
+	expr := fmt.Sprintf("^[a-zA-Z0-9_%s]{%d,%d}$", escapeClassChars(rule.ExtraChars), rule.MinLength, rule.MaxLength)
+	if rule.regex, err = regexp.Compile(expr); err != nil {
+		return rule, errors.Error{Code: InvalidNamingRulesError, Message: "invalid extra characters: " + err.Error()}
+	}
+
+	if rule.Pattern != "" {
+		if rule.pattern, err = regexp.Compile(rule.Pattern); err != nil {
+			return rule, errors.Error{Code: InvalidNamingRulesError, Message: "invalid name pattern: " + err.Error()}
+		}
+	}
+
+	return rule, nil
+}
+
+// MustCompileNameRule is like CompileNameRule but panics if the rule is invalid.
+func MustCompileNameRule(rule NameRule) NameRule {
+	r, err := CompileNameRule(rule)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Match returns true if the name is allowed by the rule.
+func (r NameRule) Match(name string) bool {
+	if r.regex == nil {
+		compiled, err := CompileNameRule(r)
+		if err != nil {
+			return false
+		}
+		r = compiled
+	}
+
+	if !r.regex.MatchString(name) {
+		return false
+	}
+
+	return r.pattern == nil || r.pattern.MatchString(name)
+}
+
+// Normalize deterministically maps an arbitrary source name to a name that
+// is allowed by the rule.  Separators such as dots, dashes and spaces become
+// underscores, accented characters are transliterated and other characters are
+// dropped.  Whenever the name has to change, a short hash of the original name is
+// appended so that distinct names stay distinct.  Lengths are counted in characters.
+func (r NameRule) Normalize(name string) (string, error) {
+	var b strings.Builder
+
+	for _, c := range name {
+		switch {
+		case c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'):
+			b.WriteRune(c)
+		case strings.ContainsRune(r.ExtraChars, c):
+			b.WriteRune(c)
+		case transliterations[c] != "":
+			b.WriteString(transliterations[c])
+		case unicode.IsSpace(c) || unicode.IsPunct(c) || unicode.IsSymbol(c):
+			b.WriteRune('_')
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	normalized := collapseUnderscores(b.String())
+	if normalized == "" {
+		normalized = "entity"
+	}
+
+	if length := utf8.RuneCountInString(normalized); normalized != name || length < r.MinLength || length > r.MaxLength {
+		suffix := "_" + shortHash(name)
+		maxLen := r.MaxLength - len(suffix)
+		if maxLen < 1 {
+			return "", errors.Error{Code: InvalidEntityError, Message: fmt.Sprintf("cannot normalize '%s' to at most %d characters", name, r.MaxLength)}
+		}
+		if runes := []rune(normalized); len(runes) > maxLen {
+			normalized = strings.TrimRight(string(runes[:maxLen]), "_")
+		}
+		normalized += suffix
+	}
+
+	for utf8.RuneCountInString(normalized) < r.MinLength {
+		normalized += "_"
+	}
+
+	if !r.Match(normalized) {
+		return "", errors.Error{Code: InvalidEntityError, Message: fmt.Sprintf("normalized name '%s' does not meet naming requirements", normalized)}
+	}
+
+	return normalized, nil
+}
+
+// NamingRules contains the naming rules for data points.
+type NamingRules struct {
+	Repository NameRule `json:"repository"`
+	Entity     NameRule `json:"entity"`
+}
+
+// NamingRulesFromRepository reads the naming rules from the repository configuration.
+// Rules are read from the "pipeline.naming.repository" and "pipeline.naming.entity"
+// parameters, any value that is not configured keeps its default.
+//
+// Example configuration:
+//
+//	{"pipeline": {"naming": {"entity": {"maxLength": 63, "extraChars": "-"}}}}
+func NamingRulesFromRepository(repo *types.Repository) (NamingRules, error) {
+	rules := DefaultNamingRules
+	if repo == nil || !repo.HasConfigParam(NamingConfigParam) {
+		return rules, nil
+	}
+
+	var err error
+	if rules.Repository, err = nameRuleFromConfig(repo, NamingConfigParam+".repository", rules.Repository); err != nil {
+		return rules, err
+	}
+
+	if rules.Entity, err = nameRuleFromConfig(repo, NamingConfigParam+".entity", rules.Entity); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+func nameRuleFromConfig(repo *types.Repository, path string, defaults NameRule) (NameRule, error) {
+	rule := defaults
+
+	if v, ok := configInt(repo, path+".minLength"); ok {
+		rule.MinLength = v
+	}
+
+	if v, ok := configInt(repo, path+".maxLength"); ok {
+		rule.MaxLength = v
+	}
+
+	if v, ok := repo.GetStringConfig(path + ".extraChars"); ok {
+		rule.ExtraChars = v
+	}
+
+	if v, ok := repo.GetStringConfig(path + ".pattern"); ok {
+		rule.Pattern = v
+	}
+
+	return CompileNameRule(rule)
+}
+
+func configInt(repo *types.Repository, path string) (int, bool) {
+	switch v := repo.GetConfig(path).(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		// JSON deserialization always uses float64
+		return int(v), true
+	}
+	return 0, false
+}
+
+// NormalizeEntity replaces the entity name of the data point with a name that is
+// allowed by the rules.  If the name changes the original name is kept in the
+// meta data under MetaSourceEntity so that it can be recovered with SourceEntity.
+func (d *DataPoint) NormalizeEntity(rules NamingRules) error {
+	if rules.Entity.Match(d.Entity) {
+		return nil
+	}
+
+	normalized, err := rules.Entity.Normalize(d.Entity)
+	if err != nil {
+		return err
+	}
+
+	if d.Meta == nil {
+		d.Meta = map[string]string{}
+	}
+
+	// Keep the very first name when normalizing more than once
+	if _, ok := d.Meta[MetaSourceEntity]; !ok {
+		d.Meta[MetaSourceEntity] = d.Entity
+	}
+
+	d.Entity = normalized
+	return nil
+}
+
+// SourceEntity returns the entity name as it was provided by the source,
+// before any normalization.
+func (d *DataPoint) SourceEntity() string {
+	if name, ok := d.Meta[MetaSourceEntity]; ok {
+		return name
+	}
+	return d.Entity
+}
+
+func collapseUnderscores(s string) string {
+	var b strings.Builder
+	last := rune(0)
+	for _, c := range s {
+		if c == '_' && last == '_' {
+			continue
+		}
+		b.WriteRune(c)
+		last = c
+	}
+	return strings.Trim(b.String(), "_")
+}
+
+// escapeClassChars escapes characters so they can be used
+// inside a regular expression character class.
+func escapeClassChars(chars string) string {
+	var b strings.Builder
+	for _, c := range chars {
+		if c < unicode.MaxASCII && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func shortHash(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%08x", h.Sum32())[:6]
+}
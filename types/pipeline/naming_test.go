@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/naveego/api/types"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNamingRulesFromRepository(t *testing.T) {
+
+	Convey("Given a repository without naming configuration", t, func() {
+		rules, err := NamingRulesFromRepository(&types.Repository{ID: "test"})
+
+		Convey("Should return the default rules", func() {
+			So(err, ShouldBeNil)
+			So(rules.Entity.MaxLength, ShouldEqual, 30)
+			So(rules.Repository.MaxLength, ShouldEqual, 15)
+		})
+	})
+
+	Convey("Given a repository that allows long entity names with dashes", t, func() {
+		repo := &types.Repository{
+			ID: "test",
+			Config: map[string]interface{}{
+				"pipeline": map[string]interface{}{
+					"naming": map[string]interface{}{
+						"entity": map[string]interface{}{
+							"maxLength":  float64(63),
+							"extraChars": "-",
+						},
+					},
+				},
+			},
+		}
+
+		rules, err := NamingRulesFromRepository(repo)
+		So(err, ShouldBeNil)
+
+		Convey("Should accept entity names that match the configured rules", func() {
+			msg := DataPoint{Repository: "test", Entity: "sales-order-line-items-for-the-current-fiscal-year", Action: "upsert", KeyNames: []string{"id"}, Data: testData{"id": 1}}
+			So(msg.ValidateWithRules(rules), ShouldBeNil)
+		})
+
+		Convey("Should still reject the entity names with the default rules", func() {
+			msg := DataPoint{Repository: "test", Entity: "sales-order-line-items-for-the-current-fiscal-year", Action: "upsert", KeyNames: []string{"id"}, Data: testData{"id": 1}}
+			err := msg.Validate().(errors.Error)
+			So(err.Code, ShouldEqual, InvalidEntityError)
+		})
+	})
+
+	Convey("Given a repository with an invalid pattern", t, func() {
+		repo := &types.Repository{
+			ID: "test",
+			Config: map[string]interface{}{
+				"pipeline": map[string]interface{}{
+					"naming": map[string]interface{}{
+						"repository": map[string]interface{}{"pattern": "^[a-z"},
+					},
+				},
+			},
+		}
+
+		_, err := NamingRulesFromRepository(repo)
+
+		Convey("Should return an invalid naming rules error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, InvalidNamingRulesError)
+		})
+	})
+}
+
+func TestNameRuleNormalize(t *testing.T) {
+
+	rule := DefaultNamingRules.Entity
+
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{"Given a valid name", "customers", "customers"},
+		{"Given a name with a schema", "dbo.Customers", "dbo_Customers_" + shortHash("dbo.Customers")},
+		{"Given a name with dashes and spaces", "sales - order lines", "sales_order_lines_" + shortHash("sales - order lines")},
+		{"Given a name with accented characters", "Café Señor", "Cafe_Senor_" + shortHash("Café Señor")},
+		{"Given a name that is too short", "id", "id_" + shortHash("id")},
+		{"Given a name with characters that cannot be transliterated", "客户", "entity_" + shortHash("客户")},
+		{"Given a name that is too long", "a_very_long_table_name_that_goes_on_and_on", "a_very_long_table_name_" + shortHash("a_very_long_table_name_that_goes_on_and_on")},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			actual, err := rule.Normalize(tc.source)
+
+			Convey("Should return a valid name", func() {
+				So(err, ShouldBeNil)
+				So(actual, ShouldEqual, tc.expected)
+				So(rule.Match(actual), ShouldBeTrue)
+			})
+
+			Convey("Should be deterministic", func() {
+				again, _ := rule.Normalize(tc.source)
+				So(again, ShouldEqual, actual)
+			})
+		})
+	}
+
+	Convey("Given names that only differ in dropped characters", t, func() {
+		a, _ := rule.Normalize("客户")
+		b, _ := rule.Normalize("订单")
+
+		Convey("Should return distinct names", func() {
+			So(a, ShouldNotEqual, b)
+		})
+	})
+
+	Convey("Given names that only differ in separators", t, func() {
+		dotted, _ := rule.Normalize("my.table")
+		dashed, _ := rule.Normalize("my-table")
+		plain, _ := rule.Normalize("my_table")
+
+		Convey("Should return distinct names", func() {
+			So(plain, ShouldEqual, "my_table")
+			So(dotted, ShouldNotEqual, plain)
+			So(dashed, ShouldNotEqual, plain)
+			So(dotted, ShouldNotEqual, dashed)
+		})
+	})
+
+	Convey("Given a rule that allows multi-byte characters", t, func() {
+		wide := MustCompileNameRule(NameRule{MinLength: 3, MaxLength: 12, ExtraChars: "é"})
+		actual, err := wide.Normalize("éééééééééééééé")
+
+		Convey("Should truncate by characters", func() {
+			So(err, ShouldBeNil)
+			So(actual, ShouldEqual, "ééééé_"+shortHash("éééééééééééééé"))
+			So(wide.Match(actual), ShouldBeTrue)
+		})
+	})
+}
+
+func TestDataPointNormalizeEntity(t *testing.T) {
+
+	Convey("Given a data point with an invalid entity name", t, func() {
+		msg := DataPoint{Repository: "test", Entity: "dbo.Order Lines", Action: "upsert", KeyNames: []string{"id"}, Data: testData{"id": 1}}
+		err := msg.NormalizeEntity(DefaultNamingRules)
+
+		Convey("Should normalize the entity name", func() {
+			So(err, ShouldBeNil)
+			So(msg.Entity, ShouldEqual, "dbo_Order_Lines_"+shortHash("dbo.Order Lines"))
+			So(msg.Validate(), ShouldBeNil)
+		})
+
+		Convey("Should keep the source name in the meta data", func() {
+			So(msg.Meta[MetaSourceEntity], ShouldEqual, "dbo.Order Lines")
+			So(msg.SourceEntity(), ShouldEqual, "dbo.Order Lines")
+		})
+	})
+
+	Convey("Given a data point with a valid entity name", t, func() {
+		msg := DataPoint{Repository: "test", Entity: "orders"}
+		err := msg.NormalizeEntity(DefaultNamingRules)
+
+		Convey("Should leave the data point unchanged", func() {
+			So(err, ShouldBeNil)
+			So(msg.Entity, ShouldEqual, "orders")
+			So(msg.Meta, ShouldBeNil)
+			So(msg.SourceEntity(), ShouldEqual, "orders")
+		})
+	})
+}
@@ -0,0 +1,263 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/naveego/errors"
+)
+
+var (
+	// Pipeline validation errors
+	PipelineMissingFieldError      = 4220019
+	PipelineInvalidStatusError     = 4220020
+	PipelineInvalidScheduleError   = 4220021
+	PipelineUnknownPublisherError  = 4220022
+	PipelineUnknownSubscriberError = 4220023
+	PipelineInstanceMismatchError  = 4220024
+	PipelineShapeNotFoundError     = 4220025
+	PipelineMappingPropertyError   = 4220026
+	PipelineMappingTypeError       = 4220027
+	PipelineMappingDuplicateError  = 4220028
+	PipelineValidationFailedError  = 4220029
+)
+
+// PipelineError describes a single problem found while validating a pipeline.
+type PipelineError struct {
+	Field   string `json:"field"`   // The JSON name of the field, such as "mappings[2].to"
+	Code    int    `json:"code"`    // The error code
+	Message string `json:"message"` // A human readable message
+}
+
+func (e PipelineError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// PipelineValidationResult contains the outcome of validating a pipeline.
+type PipelineValidationResult struct {
+	Pipeline string          `json:"pipeline"`         // The ID, or the name of a new pipeline
+	Errors   []PipelineError `json:"errors,omitempty"` // The problems found, in field order
+}
+
+// IsValid returns true if no problems were found.
+func (r PipelineValidationResult) IsValid() bool {
+	return len(r.Errors) == 0
+}
+
+// Err returns nil if the result is valid, otherwise it returns an
+// errors.Error summarizing all the problems.
+func (r PipelineValidationResult) Err() error {
+	if r.IsValid() {
+		return nil
+	}
+
+	msgs := make([]string, len(r.Errors))
+	for i, e := range r.Errors {
+		msgs[i] = e.Error()
+	}
+
+	return errors.Error{
+		Code:    PipelineValidationFailedError,
+		Message: fmt.Sprintf("pipeline '%s' is invalid: %s", r.Pipeline, strings.Join(msgs, "; ")),
+	}
+}
+
+// PipelineValidationContext is what a pipeline is validated against.  The pipeline
+// types cannot depend on the publisher and subscriber registries or the schedule
+// parser, so they are provided by the caller, typically using runner.ValidationContext.
+// Anything that is not provided is not checked.
+type PipelineValidationContext struct {
+	PublisherTypes   []string            // optional: The registered publisher types
+	SubscriberTypes  []string            // optional: The registered subscriber types
+	Publisher        *PublisherInstance  // optional: The publisher instance of the pipeline
+	Subscriber       *SubscriberInstance // optional: The subscriber instance of the pipeline
+	ValidateSchedule func(string) error  // optional: Returns an error if the schedule is invalid
+}
+
+// Validate checks the fields of the pipeline, its status and the fields of its
+// mappings.  Use ValidateWithContext to also check the schedule, the registered
+// types and the publisher and subscriber instances.
+func (p *Pipeline) Validate() error {
+	return p.ValidateWithContext(PipelineValidationContext{}).Err()
+}
+
+// ValidateWithContext checks the pipeline and returns every problem that was found.
+// The published and subscribed shapes must exist in the instances of the context,
+// and the mapped properties must exist in the shapes if the shapes declare properties.
+func (p *Pipeline) ValidateWithContext(ctx PipelineValidationContext) PipelineValidationResult {
+	result := PipelineValidationResult{Pipeline: p.ID}
+	if result.Pipeline == "" {
+		result.Pipeline = p.Name
+	}
+
+	addErr := func(field string, code int, format string, args ...interface{}) {
+		result.Errors = append(result.Errors, PipelineError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	required := []struct {
+		field string
+		value string
+	}{
+		{"name", p.Name},
+		{"status", string(p.Status)},
+		{"publisher", p.PublisherID},
+		{"publisher_type", p.PublisherType},
+		{"subscriber", p.SubscriberID},
+		{"subscriber_type", p.SubscriberType},
+		{"published_shape", p.PublishedShape},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			addErr(r.field, PipelineMissingFieldError, "is required")
+		}
+	}
+
+	switch p.Status {
+	case "", PipelineActive, PipelinePaused, PipelineDeleted:
+	default:
+		addErr("status", PipelineInvalidStatusError, "'%s' is not one of active, paused or deleted", p.Status)
+	}
+
+	if p.Schedule != "" && ctx.ValidateSchedule != nil {
+		if err := ctx.ValidateSchedule(p.Schedule); err != nil {
+			addErr("schedule", PipelineInvalidScheduleError, "%s", strings.TrimPrefix(err.Error(), "schedule: "))
+		}
+	}
+
+	if ctx.PublisherTypes != nil && p.PublisherType != "" && !contains(ctx.PublisherTypes, p.PublisherType) {
+		addErr("publisher_type", PipelineUnknownPublisherError, "publisher type '%s' is not registered", p.PublisherType)
+	}
+
+	if ctx.SubscriberTypes != nil && p.SubscriberType != "" && !contains(ctx.SubscriberTypes, p.SubscriberType) {
+		addErr("subscriber_type", PipelineUnknownSubscriberError, "subscriber type '%s' is not registered", p.SubscriberType)
+	}
+
+	var published, subscribed *ShapeDefinition
+
+	if pub := ctx.Publisher; pub != nil {
+		if pub.ID != "" && p.PublisherID != "" && pub.ID != p.PublisherID {
+			addErr("publisher", PipelineInstanceMismatchError, "the pipeline uses publisher '%s' not '%s'", p.PublisherID, pub.ID)
+		}
+		if pub.Type != "" && p.PublisherType != "" && pub.Type != p.PublisherType {
+			addErr("publisher_type", PipelineInstanceMismatchError, "publisher '%s' is of type '%s' not '%s'", pub.ID, pub.Type, p.PublisherType)
+		}
+		if p.PublishedShape != "" {
+			for i, sd := range pub.Shapes {
+				if sd.Name == p.PublishedShape || sd.ID == p.PublishedShape {
+					published = &pub.Shapes[i]
+					break
+				}
+			}
+			if published == nil {
+				addErr("published_shape", PipelineShapeNotFoundError, "publisher '%s' does not publish shape '%s'", pub.ID, p.PublishedShape)
+			}
+		}
+	}
+
+	if sub := ctx.Subscriber; sub != nil {
+		if sub.ID != "" && p.SubscriberID != "" && sub.ID != p.SubscriberID {
+			addErr("subscriber", PipelineInstanceMismatchError, "the pipeline uses subscriber '%s' not '%s'", p.SubscriberID, sub.ID)
+		}
+		if sub.Type != "" && p.SubscriberType != "" && sub.Type != p.SubscriberType {
+			addErr("subscriber_type", PipelineInstanceMismatchError, "subscriber '%s' is of type '%s' not '%s'", sub.ID, sub.Type, p.SubscriberType)
+		}
+		if p.SubscribedShape == "" || sub.Shape.Name == p.SubscribedShape || sub.Shape.ID == p.SubscribedShape {
+			subscribed = &sub.Shape
+		} else {
+			addErr("subscribed_shape", PipelineShapeNotFoundError, "subscriber '%s' does not accept shape '%s'", sub.ID, p.SubscribedShape)
+		}
+	}
+
+	p.validateMappings(published, subscribed, addErr)
+
+	return result
+}
+
+func (p *Pipeline) validateMappings(published, subscribed *ShapeDefinition, addErr func(string, int, string, ...interface{})) {
+	fromTypes := declaredTypes(published)
+	toTypes := declaredTypes(subscribed)
+	targets := map[string]int{}
+
+	for i, m := range p.Mappings {
+		field := fmt.Sprintf("mappings[%d]", i)
+
+		validateMappingSide(field+".from", m.From, m.FromType, published, fromTypes, addErr)
+		validateMappingSide(field+".to", m.To, m.ToType, subscribed, toTypes, addErr)
+
+		if m.To == "" {
+			continue
+		}
+		if j, dup := targets[m.To]; dup {
+			addErr(field+".to", PipelineMappingDuplicateError, "property '%s' is already the target of mappings[%d]", m.To, j)
+			continue
+		}
+		targets[m.To] = i
+	}
+}
+
+// validateMappingSide checks one side of a mapping.  The path is checked against
+// the properties of the shape if the shape declares properties.
+func validateMappingSide(field, path, typ string, shape *ShapeDefinition, declared map[string]string, addErr func(string, int, string, ...interface{})) {
+	if path == "" {
+		addErr(field, PipelineMissingFieldError, "is required")
+		return
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			addErr(field, PipelineMappingPropertyError, "'%s' is not a valid property path", path)
+			return
+		}
+	}
+
+	typ = strings.ToLower(typ)
+	if typ != "" && !isPropertyType(typ) {
+		addErr(field+"_type", PipelineMappingTypeError, "'%s' is not a valid property type", typ)
+		return
+	}
+
+	if declared == nil {
+		return
+	}
+
+	actual, ok := declared[path]
+	if !ok {
+		addErr(field, PipelineMappingPropertyError, "property '%s' does not exist in shape '%s'", path, shape.Name)
+		return
+	}
+
+	if typ != "" && actual != typ {
+		addErr(field+"_type", PipelineMappingTypeError, "property '%s' is of type '%s' not '%s'", path, actual, typ)
+	}
+}
+
+// declaredTypes returns the types of the properties of the shape, or nil if the
+// shape is unknown or does not declare properties.
+func declaredTypes(shape *ShapeDefinition) map[string]string {
+	if shape == nil || len(shape.Properties) == 0 {
+		return nil
+	}
+
+	types := make(map[string]string, len(shape.Properties))
+	for _, prop := range shape.Properties {
+		types[prop.Name] = strings.ToLower(prop.Type)
+	}
+	return types
+}
+
+func isPropertyType(typ string) bool {
+	switch typ {
+	case PropertyTypeString, PropertyTypeNumber, PropertyTypeBool, PropertyTypeDate, PropertyTypeObject:
+		return true
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/naveego/api/pipeline/schedule"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func validPipeline() Pipeline {
+	return Pipeline{
+		ID:              "p1",
+		Name:            "Customers",
+		Status:          PipelineActive,
+		Schedule:        "@every 1h",
+		PublisherID:     "pub1",
+		PublisherType:   "csv",
+		SubscriberID:    "sub1",
+		SubscriberType:  "sql",
+		PublishedShape:  "user",
+		SubscribedShape: "customer",
+		Mappings: []ShapeMapping{
+			{From: "id", To: "customerId"},
+			{From: "company.name", FromType: "string", To: "company", ToType: "string"},
+		},
+	}
+}
+
+func validContext() PipelineValidationContext {
+	return PipelineValidationContext{
+		PublisherTypes:  []string{"csv", "json"},
+		SubscriberTypes: []string{"sql"},
+		Publisher: &PublisherInstance{
+			ID:     "pub1",
+			Type:   "csv",
+			Shapes: ShapeDefinitions{testShapeDefinition},
+		},
+		Subscriber: &SubscriberInstance{
+			ID:   "sub1",
+			Type: "sql",
+			Shape: ShapeDefinition{
+				Name: "customer",
+				Properties: []PropertyDefinition{
+					{Name: "customerId", Type: "number"},
+					{Name: "company", Type: "string"},
+				},
+			},
+		},
+		ValidateSchedule: schedule.Validate,
+	}
+}
+
+func TestPipelineValidate(t *testing.T) {
+
+	Convey("Given a valid pipeline", t, func() {
+		p := validPipeline()
+
+		Convey("Should be valid without a context", func() {
+			So(p.Validate(), ShouldBeNil)
+		})
+
+		Convey("Should not check the schedule without a context", func() {
+			p.Schedule = "every hour"
+			So(p.Validate(), ShouldBeNil)
+		})
+
+		Convey("Should be valid with a context", func() {
+			result := p.ValidateWithContext(validContext())
+			So(result.IsValid(), ShouldBeTrue)
+			So(result.Err(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given an empty pipeline", t, func() {
+		p := Pipeline{}
+		err := p.Validate()
+
+		Convey("Should report every missing field", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, PipelineValidationFailedError)
+
+			result := p.ValidateWithContext(PipelineValidationContext{})
+			So(result.Errors, ShouldHaveLength, 7)
+			for _, e := range result.Errors {
+				So(e.Code, ShouldEqual, PipelineMissingFieldError)
+			}
+			So(result.Errors[0].Field, ShouldEqual, "name")
+			So(result.Errors[6].Field, ShouldEqual, "published_shape")
+		})
+	})
+
+	testCases := []struct {
+		name     string
+		modify   func(p *Pipeline, ctx *PipelineValidationContext)
+		expected []PipelineError
+	}{
+		{
+			"Given an invalid status",
+			func(p *Pipeline, ctx *PipelineValidationContext) { p.Status = "running" },
+			[]PipelineError{{Field: "status", Code: PipelineInvalidStatusError, Message: "'running' is not one of active, paused or deleted"}},
+		},
+		{
+			"Given an invalid schedule",
+			func(p *Pipeline, ctx *PipelineValidationContext) { p.Schedule = "every hour" },
+			[]PipelineError{{Field: "schedule", Code: PipelineInvalidScheduleError, Message: "invalid schedule 'every hour': expected 5 or 6 fields, found 2"}},
+		},
+		{
+			"Given types that are not registered",
+			func(p *Pipeline, ctx *PipelineValidationContext) {
+				ctx.PublisherTypes = []string{"json"}
+				ctx.SubscriberTypes = []string{}
+			},
+			[]PipelineError{
+				{Field: "publisher_type", Code: PipelineUnknownPublisherError, Message: "publisher type 'csv' is not registered"},
+				{Field: "subscriber_type", Code: PipelineUnknownSubscriberError, Message: "subscriber type 'sql' is not registered"},
+			},
+		},
+		{
+			"Given instances of other publishers and subscribers",
+			func(p *Pipeline, ctx *PipelineValidationContext) {
+				ctx.Publisher.ID = "pub2"
+				ctx.Subscriber.Type = "mongo"
+			},
+			[]PipelineError{
+				{Field: "publisher", Code: PipelineInstanceMismatchError, Message: "the pipeline uses publisher 'pub1' not 'pub2'"},
+				{Field: "subscriber_type", Code: PipelineInstanceMismatchError, Message: "subscriber 'sub1' is of type 'mongo' not 'sql'"},
+			},
+		},
+		{
+			"Given shapes that do not exist",
+			func(p *Pipeline, ctx *PipelineValidationContext) {
+				p.PublishedShape = "orders"
+				p.SubscribedShape = "order"
+			},
+			[]PipelineError{
+				{Field: "published_shape", Code: PipelineShapeNotFoundError, Message: "publisher 'pub1' does not publish shape 'orders'"},
+				{Field: "subscribed_shape", Code: PipelineShapeNotFoundError, Message: "subscriber 'sub1' does not accept shape 'order'"},
+			},
+		},
+		{
+			"Given mappings that do not resolve",
+			func(p *Pipeline, ctx *PipelineValidationContext) {
+				p.Mappings = []ShapeMapping{
+					{From: "missing", To: "customerId"},
+					{From: "name", FromType: "number", To: "customerId"},
+					{From: "company..name", To: "unknown", ToType: "text"},
+					{From: "", To: ""},
+				}
+			},
+			[]PipelineError{
+				{Field: "mappings[0].from", Code: PipelineMappingPropertyError, Message: "property 'missing' does not exist in shape 'user'"},
+				{Field: "mappings[1].from_type", Code: PipelineMappingTypeError, Message: "property 'name' is of type 'string' not 'number'"},
+				{Field: "mappings[1].to", Code: PipelineMappingDuplicateError, Message: "property 'customerId' is already the target of mappings[0]"},
+				{Field: "mappings[2].from", Code: PipelineMappingPropertyError, Message: "'company..name' is not a valid property path"},
+				{Field: "mappings[2].to_type", Code: PipelineMappingTypeError, Message: "'text' is not a valid property type"},
+				{Field: "mappings[3].from", Code: PipelineMissingFieldError, Message: "is required"},
+				{Field: "mappings[3].to", Code: PipelineMissingFieldError, Message: "is required"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		Convey(tc.name, t, func() {
+			p := validPipeline()
+			ctx := validContext()
+			tc.modify(&p, &ctx)
+
+			result := p.ValidateWithContext(ctx)
+
+			Convey("Should return all of the problems", func() {
+				So(result.Errors, ShouldResemble, tc.expected)
+				So(result.Err().Error(), ShouldStartWith, "pipeline 'p1' is invalid: "+tc.expected[0].Field)
+			})
+		})
+	}
+}
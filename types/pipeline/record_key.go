@@ -0,0 +1,304 @@
+package pipeline
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naveego/errors"
+)
+
+const (
+	// RecordKeyError is returned when a record key or content hash
+	// cannot be created for a data point.
+	RecordKeyError = 4220018
+
+	// DefaultMaxTrackedRecords is the number of records a change tracker created
+	// with NewChangeTracker remembers.
+	DefaultMaxTrackedRecords = 100000
+)
+
+// RecordKey returns a canonical key that identifies the record represented by the
+// data point.  The key is built from the values of the key names, which may be
+// dotted paths into nested objects.  Key names are sorted and values are encoded
+// in a type-stable way, so numbers that are equal encode the same regardless of
+// their Go type (1, int64(1) and 1.0 are all the same key) while the string "1"
+// does not.
+func (d *DataPoint) RecordKey() (string, error) {
+	if len(d.KeyNames) == 0 {
+		return "", errors.Error{Code: NoKeyNamesError, Message: "keyNames was either not provided or is empty"}
+	}
+
+	keyNames := make([]string, len(d.KeyNames))
+	copy(keyNames, d.KeyNames)
+	sort.Strings(keyNames)
+
+	var b strings.Builder
+	for i, name := range keyNames {
+		val, ok := getPathValue(d.Data, name)
+		if !ok {
+			return "", errors.Error{Code: DataMissingKeysError, Message: fmt.Sprintf("key '%s' was not provided in the data", name)}
+		}
+
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Quote(name))
+		b.WriteByte('=')
+		if err := writeCanonical(&b, val); err != nil {
+			return "", errors.Error{Code: RecordKeyError, Message: fmt.Sprintf("key '%s': %v", name, err)}
+		}
+	}
+
+	return b.String(), nil
+}
+
+// ContentHash returns a hash of the data of the data point that can be used to
+// detect changes.  The hash does not depend on the order of properties and uses
+// the same type-stable encoding as RecordKey.
+func (d *DataPoint) ContentHash() (uint64, error) {
+	var b strings.Builder
+	if err := writeCanonical(&b, d.Data); err != nil {
+		return 0, errors.Error{Code: RecordKeyError, Message: "could not hash data: " + err.Error()}
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return binary.BigEndian.Uint64(sum[:8]), nil
+}
+
+// ChangeTracker remembers the content hash of the last data point seen for
+// each record so that unchanged records can be skipped.  When the tracker is
+// full the least recently seen record is forgotten, and is reported as changed
+// the next time it is seen.  A ChangeTracker is safe for concurrent use.
+type ChangeTracker struct {
+	mu     sync.Mutex
+	max    int
+	hashes map[string]*list.Element
+	order  *list.List // The tracked records, most recently seen first
+}
+
+type trackedRecord struct {
+	key  string
+	hash uint64
+}
+
+// NewChangeTracker creates an empty change tracker that remembers up to
+// DefaultMaxTrackedRecords records.
+func NewChangeTracker() *ChangeTracker {
+	return NewChangeTrackerWithLimit(DefaultMaxTrackedRecords)
+}
+
+// NewChangeTrackerWithLimit creates an empty change tracker that remembers up to
+// max records, or any number of records if max is 0.
+func NewChangeTrackerWithLimit(max int) *ChangeTracker {
+	return &ChangeTracker{max: max, hashes: map[string]*list.Element{}, order: list.New()}
+}
+
+// Changed returns true if the data point is new or its data is different from
+// the last data point seen for the same entity and record key.  Deletes are always
+// reported as changed and remove the record from the tracker.
+func (t *ChangeTracker) Changed(d DataPoint) (bool, error) {
+	key, err := d.RecordKey()
+	if err != nil {
+		return false, err
+	}
+	key = strconv.Quote(d.Entity) + ":" + key
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elem, tracked := t.hashes[key]
+
+	if d.Action == DataPointDelete {
+		if tracked {
+			t.order.Remove(elem)
+			delete(t.hashes, key)
+		}
+		return true, nil
+	}
+
+	hash, err := d.ContentHash()
+	if err != nil {
+		return false, err
+	}
+
+	if tracked {
+		t.order.MoveToFront(elem)
+		record := elem.Value.(*trackedRecord)
+		if record.hash == hash {
+			return false, nil
+		}
+		record.hash = hash
+		return true, nil
+	}
+
+	t.hashes[key] = t.order.PushFront(&trackedRecord{key: key, hash: hash})
+	if t.max > 0 && t.order.Len() > t.max {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+		delete(t.hashes, oldest.Value.(*trackedRecord).key)
+	}
+	return true, nil
+}
+
+// Reset forgets every record seen by the tracker.
+func (t *ChangeTracker) Reset() {
+	t.mu.Lock()
+	t.hashes = map[string]*list.Element{}
+	t.order.Init()
+	t.mu.Unlock()
+}
+
+// Len returns the number of records being tracked.
+func (t *ChangeTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.hashes)
+}
+
+// writeCanonical writes a canonical encoding of the value.  Strings and names are
+// quoted, numbers are prefixed so they never collide with other types, and map
+// keys are sorted.
+func writeCanonical(b *strings.Builder, val interface{}) error {
+	switch x := val.(type) {
+	case nil:
+		b.WriteString("null")
+		return nil
+	case string:
+		b.WriteString(strconv.Quote(x))
+		return nil
+	case bool:
+		b.WriteString(strconv.FormatBool(x))
+		return nil
+	case time.Time:
+		b.WriteString("t:")
+		b.WriteString(x.UTC().Format(time.RFC3339Nano))
+		return nil
+	case map[string]interface{}:
+		b.WriteByte('{')
+		for i, k := range sortedKeys(x) {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.Quote(k))
+			b.WriteByte(':')
+			if err := writeCanonical(b, x[k]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+		return nil
+	case []interface{}:
+		b.WriteByte('[')
+		for i, item := range x {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeCanonical(b, item); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+		return nil
+	}
+
+	if n, ok, err := canonicalNumber(val); ok {
+		if err != nil {
+			return err
+		}
+		b.WriteString("n:")
+		b.WriteString(n)
+		return nil
+	}
+
+	// Fall back to reflection for typed slices and maps
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+		return writeCanonical(b, items)
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", rv.Type().Key())
+		}
+		m := make(map[string]interface{}, rv.Len())
+		for _, k := range rv.MapKeys() {
+			m[k.String()] = rv.MapIndex(k).Interface()
+		}
+		return writeCanonical(b, m)
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return writeCanonical(b, nil)
+		}
+		return writeCanonical(b, rv.Elem().Interface())
+	}
+
+	return fmt.Errorf("unsupported value type %T", val)
+}
+
+// canonicalNumber formats numbers so that equal values produce the same string.
+// Integral values are written without a fraction or exponent.
+func canonicalNumber(val interface{}) (string, bool, error) {
+	switch x := val.(type) {
+	case int:
+		return strconv.FormatInt(int64(x), 10), true, nil
+	case int8:
+		return strconv.FormatInt(int64(x), 10), true, nil
+	case int16:
+		return strconv.FormatInt(int64(x), 10), true, nil
+	case int32:
+		return strconv.FormatInt(int64(x), 10), true, nil
+	case int64:
+		return strconv.FormatInt(x, 10), true, nil
+	case uint:
+		return strconv.FormatUint(uint64(x), 10), true, nil
+	case uint8:
+		return strconv.FormatUint(uint64(x), 10), true, nil
+	case uint16:
+		return strconv.FormatUint(uint64(x), 10), true, nil
+	case uint32:
+		return strconv.FormatUint(uint64(x), 10), true, nil
+	case uint64:
+		return strconv.FormatUint(x, 10), true, nil
+	case float32:
+		return canonicalFloat(float64(x)), true, nil
+	case float64:
+		return canonicalFloat(x), true, nil
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return strconv.FormatInt(i, 10), true, nil
+		}
+		f, err := x.Float64()
+		if err != nil {
+			return "", true, fmt.Errorf("invalid number '%s'", x)
+		}
+		return canonicalFloat(f), true, nil
+	}
+	return "", false, nil
+}
+
+func canonicalFloat(f float64) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	// Integral values within the exact range of an int64 are written as
+	// integers so they match the encoding of the integer types.
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDataPointRecordKey(t *testing.T) {
+
+	Convey("Given data points with equal numeric keys of different types", t, func() {
+		values := []interface{}{1, int64(1), uint8(1), float64(1), float32(1), json.Number("1"), json.Number("1.0")}
+
+		Convey("Should return the same key", func() {
+			expected, err := (&DataPoint{KeyNames: []string{"id"}, Data: map[string]interface{}{"id": 1}}).RecordKey()
+			So(err, ShouldBeNil)
+
+			for _, v := range values {
+				key, err := (&DataPoint{KeyNames: []string{"id"}, Data: map[string]interface{}{"id": v}}).RecordKey()
+				So(err, ShouldBeNil)
+				So(key, ShouldEqual, expected)
+			}
+		})
+
+		Convey("Should not match a string key", func() {
+			a, _ := (&DataPoint{KeyNames: []string{"id"}, Data: map[string]interface{}{"id": 1}}).RecordKey()
+			b, _ := (&DataPoint{KeyNames: []string{"id"}, Data: map[string]interface{}{"id": "1"}}).RecordKey()
+			So(a, ShouldNotEqual, b)
+		})
+	})
+
+	Convey("Given a data point with multiple and nested keys", t, func() {
+		dp := DataPoint{
+			KeyNames: []string{"order.id", "line"},
+			Data: map[string]interface{}{
+				"line":  2,
+				"order": map[string]interface{}{"id": "SO-1", "total": 12.5},
+			},
+		}
+
+		key, err := dp.RecordKey()
+
+		Convey("Should encode the sorted keys", func() {
+			So(err, ShouldBeNil)
+			So(key, ShouldEqual, `"line"=n:2,"order.id"="SO-1"`)
+		})
+
+		Convey("Should not depend on the order of the key names", func() {
+			dp.KeyNames = []string{"line", "order.id"}
+			other, _ := dp.RecordKey()
+			So(other, ShouldEqual, key)
+		})
+	})
+
+	Convey("Given a data point missing a key", t, func() {
+		_, err := (&DataPoint{KeyNames: []string{"id"}, Data: map[string]interface{}{"name": "a"}}).RecordKey()
+
+		Convey("Should return a missing keys error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, DataMissingKeysError)
+		})
+	})
+}
+
+func TestDataPointContentHash(t *testing.T) {
+
+	Convey("Given data that only differs in property order and number types", t, func() {
+		var decoded map[string]interface{}
+		json.Unmarshal([]byte(`{"tags":["a","b"],"id":1,"address":{"zip":"16501","city":"Erie"}}`), &decoded)
+
+		a := DataPoint{Data: decoded}
+		b := DataPoint{Data: map[string]interface{}{
+			"id":      int64(1),
+			"address": map[string]string{"city": "Erie", "zip": "16501"},
+			"tags":    []string{"a", "b"},
+		}}
+
+		Convey("Should return the same hash", func() {
+			hashA, err := a.ContentHash()
+			So(err, ShouldBeNil)
+			hashB, err := b.ContentHash()
+			So(err, ShouldBeNil)
+			So(hashA, ShouldEqual, hashB)
+		})
+	})
+
+	Convey("Given data with different values", t, func() {
+		a, _ := (&DataPoint{Data: map[string]interface{}{"id": 1, "name": "a"}}).ContentHash()
+		b, _ := (&DataPoint{Data: map[string]interface{}{"id": 1, "name": "b"}}).ContentHash()
+		c, _ := (&DataPoint{Data: map[string]interface{}{"id": 1, "name": nil}}).ContentHash()
+
+		Convey("Should return different hashes", func() {
+			So(a, ShouldNotEqual, b)
+			So(a, ShouldNotEqual, c)
+		})
+	})
+
+	Convey("Given data with an unsupported value", t, func() {
+		_, err := (&DataPoint{Data: map[string]interface{}{"fn": func() {}}}).ContentHash()
+
+		Convey("Should return a record key error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(errors.Error).Code, ShouldEqual, RecordKeyError)
+		})
+	})
+}
+
+func TestChangeTracker(t *testing.T) {
+
+	Convey("Given a change tracker", t, func() {
+		tracker := NewChangeTracker()
+		dp := DataPoint{Entity: "customers", Action: DataPointUpsert, KeyNames: []string{"id"}, Data: map[string]interface{}{"id": 1, "name": "a"}}
+
+		changed, err := tracker.Changed(dp)
+		So(err, ShouldBeNil)
+
+		Convey("Should report new records as changed", func() {
+			So(changed, ShouldBeTrue)
+			So(tracker.Len(), ShouldEqual, 1)
+		})
+
+		Convey("Should report the same record as unchanged", func() {
+			changed, _ := tracker.Changed(dp)
+			So(changed, ShouldBeFalse)
+		})
+
+		Convey("Should report modified records as changed", func() {
+			modified := dp
+			modified.Data = map[string]interface{}{"id": 1, "name": "b"}
+			changed, _ := tracker.Changed(modified)
+			So(changed, ShouldBeTrue)
+		})
+
+		Convey("Should track entities separately", func() {
+			other := dp
+			other.Entity = "vendors"
+			changed, _ := tracker.Changed(other)
+			So(changed, ShouldBeTrue)
+			So(tracker.Len(), ShouldEqual, 2)
+		})
+
+		Convey("Should forget deleted records", func() {
+			deleted := dp
+			deleted.Action = DataPointDelete
+			changed, _ := tracker.Changed(deleted)
+			So(changed, ShouldBeTrue)
+			So(tracker.Len(), ShouldEqual, 0)
+
+			changed, _ = tracker.Changed(dp)
+			So(changed, ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a change tracker with a limit", t, func() {
+		tracker := NewChangeTrackerWithLimit(2)
+		record := func(id int) DataPoint {
+			return DataPoint{Entity: "customers", Action: DataPointUpsert, KeyNames: []string{"id"}, Data: map[string]interface{}{"id": id}}
+		}
+
+		tracker.Changed(record(1))
+		tracker.Changed(record(2))
+		tracker.Changed(record(1))
+		tracker.Changed(record(3))
+
+		Convey("Should evict the least recently seen record", func() {
+			So(tracker.Len(), ShouldEqual, 2)
+
+			changed, _ := tracker.Changed(record(1))
+			So(changed, ShouldBeFalse)
+			changed, _ = tracker.Changed(record(2))
+			So(changed, ShouldBeTrue)
+		})
+	})
+}
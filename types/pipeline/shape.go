@@ -1,8 +1,11 @@
 package pipeline
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"hash/crc32"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -12,53 +15,118 @@ import (
 
 var castagnoliTable = crc32.MakeTable(crc32.Castagnoli) // see http://golang.org/pkg/hash/crc32/#pkg-constants
 
+const (
+	// ShapeHashCRC32 is the original hash scheme.  It is a CRC32 checksum over the
+	// comma separated property list.  Shapes without a hash version use this scheme.
+	ShapeHashCRC32 = 1
+
+	// ShapeHashSHA256 is a SHA-256 hash, truncated to 64 bits, over the length
+	// prefixed property list.
+	ShapeHashSHA256 = 2
+
+	// CurrentShapeHashVersion is the hash scheme used for new shapes.
+	CurrentShapeHashVersion = ShapeHashSHA256
+)
+
 // Shape is used to maintain type information about the data contained in the dataPoint.  Shape information
 // may be provided from the producer, but it is not required.  The pipeline will generate type information
 // automatically based on the data itself.
+//
+// The hashes are 64 bit values, shapes hashed with ShapeHashCRC32 only use the lower
+// 32 bits.  Consumers that decode shapes into 32 bit hashes must widen them.
 type Shape struct {
 	KeyNames     []string `json:"keyNames,omitempty"`     // An array of key property names
-	KeyNamesHash uint32   `json:"keyNamesHash,omitempty"` // A hash used to determine if keys have changed
+	KeyNamesHash uint64   `json:"keyNamesHash,omitempty"` // A hash used to determine if keys have changed
 	Properties   []string `json:"properties,omitempty"`   // An array of properties including type, the form of [name]:[type]
-	PropertyHash uint32   `json:"propertyHash,omitempty"` // A hash used to determine if the properties have changed
+	PropertyHash uint64   `json:"propertyHash,omitempty"` // A hash used to determine if the properties have changed
+	HashVersion  int      `json:"hashVersion,omitempty"`  // The scheme used to create the hashes, 0 means ShapeHashCRC32
 }
 
+// NewShape creates a shape using the current hash version.  The properties of
+// the shape are a sorted copy of the provided properties, the provided slices
+// are not modified.
 func NewShape(keyNames, properties []string) (Shape, error) {
+	return NewShapeWithHashVersion(CurrentShapeHashVersion, keyNames, properties)
+}
+
+// NewShapeWithHashVersion creates a shape using the provided hash version.
+func NewShapeWithHashVersion(version int, keyNames, properties []string) (Shape, error) {
 
 	shape := Shape{}
-	keyHash := uint32(0)
+	keyHash := uint64(0)
 
 	if keyNames != nil {
 		var err error
-		keyHash, err = hashArray(keyNames)
+		keyHash, err = hashArray(version, keyNames)
 		if err != nil {
 			return shape, err
 		}
 	}
 
-	propHash, err := hashArray(properties)
+	propHash, err := hashArray(version, properties)
 	if err != nil {
 		return shape, err
 	}
 
 	shape.KeyNames = keyNames
 	shape.KeyNamesHash = keyHash
-	shape.Properties = properties
+	shape.Properties = sortedProperties(properties)
 	shape.PropertyHash = propHash
+	shape.HashVersion = version
 	return shape, nil
 
 }
 
-// EnsureHashes sets the hash values on the shape if they are unset.
+// EnsureHashes sets the hash values on the shape if they are unset.  Shapes that
+// have no hashes at all are hashed with the current hash version, otherwise the
+// version already used by the shape is kept.
 func EnsureHashes(shape *Shape) {
 
+	if shape.HashVersion == 0 && shape.KeyNamesHash == 0 && shape.PropertyHash == 0 {
+		shape.HashVersion = CurrentShapeHashVersion
+	}
+
+	version := shape.hashVersion()
+
 	if shape.KeyNamesHash == 0 {
-		shape.KeyNamesHash, _ = hashArray(shape.KeyNames)
+		shape.KeyNamesHash, _ = hashArray(version, shape.KeyNames)
 	}
 
 	if shape.PropertyHash == 0 {
-		shape.PropertyHash, _ = hashArray(shape.Properties)
+		shape.PropertyHash, _ = hashArray(version, shape.Properties)
+	}
+
+}
+
+// HasSameProperties determines if two shapes have the same properties.  When the
+// hashes were created with the same scheme and are different the shapes are known
+// to differ.  When they match, the property lists are compared as well so that a
+// hash collision is never mistaken for an unchanged shape.
+func (s Shape) HasSameProperties(other Shape) bool {
+	if s.hashVersion() == other.hashVersion() && s.PropertyHash != 0 && other.PropertyHash != 0 &&
+		s.PropertyHash != other.PropertyHash {
+		return false
 	}
 
+	return sameNames(s.Properties, other.Properties)
+}
+
+// HasSameKeys determines if two shapes have the same key names.  Like
+// HasSameProperties the key names are verified when the hashes match.
+func (s Shape) HasSameKeys(other Shape) bool {
+	if s.hashVersion() == other.hashVersion() && s.KeyNamesHash != 0 && other.KeyNamesHash != 0 &&
+		s.KeyNamesHash != other.KeyNamesHash {
+		return false
+	}
+
+	return sameNames(s.KeyNames, other.KeyNames)
+}
+
+func (s Shape) hashVersion() int {
+	if s.HashVersion == 0 {
+		return ShapeHashCRC32
+	}
+	return s.HashVersion
 }
 
 // Shaper determines the schema of a given data point.  It will read through all the properties
@@ -180,33 +248,75 @@ func isDate(val string) bool {
 	return false
 }
 
-func hashArray(properties []string) (uint32, error) {
-	sort.Sort(sortByPropName(properties))
+// hashArray hashes a list of property names using the given hash version.  The
+// properties are hashed in sorted order and lower case, to allow for case
+// in-sensitivity.  The provided slice is not modified.
+func hashArray(version int, properties []string) (uint64, error) {
+	sorted := sortedProperties(properties)
+
+	switch version {
+	case ShapeHashCRC32:
+		// We are using a CRC check sum because it is very
+		// efficient, collisions are caught by HasSameProperties.
+		crc := crc32.New(castagnoliTable)
+		if _, err := crc.Write([]byte(strings.ToLower(strings.Join(sorted, ",")))); err != nil {
+			return 0, err
+		}
+		return uint64(crc.Sum32()), nil
+
+	case ShapeHashSHA256:
+		// Every property is prefixed with its length, so different
+		// lists can never produce the same input.
+		h := sha256.New()
+		var length [8]byte
+		for _, prop := range sorted {
+			lower := strings.ToLower(prop)
+			binary.BigEndian.PutUint64(length[:], uint64(len(lower)))
+			h.Write(length[:])
+			h.Write([]byte(lower))
+		}
+		return binary.BigEndian.Uint64(h.Sum(nil)[:8]), nil
+	}
 
-	// We are using a CRC check sum because it is very
-	// efficient.  We are simply looking for a change,
-	// we are not giving an identity.  Therefore, we don't
-	// have to be concered about collisions.
-	crcStr := ""
-	propLen := len(properties)
+	return 0, errors.New("unknown shape hash version " + strconv.Itoa(version))
+}
 
-	// We are using a lower case value for the properties
-	// in order to allow for case in-sensitivity.
-	for i, prop := range properties {
-		crcStr = crcStr + strings.ToLower(prop)
+// sortedProperties returns a sorted copy of the properties.
+func sortedProperties(properties []string) []string {
+	if properties == nil {
+		return nil
+	}
+
+	sorted := make([]string, len(properties))
+	copy(sorted, properties)
+	sort.Stable(sortByPropName(sorted))
+	return sorted
+}
 
-		if i < (propLen - 1) {
-			crcStr = crcStr + ","
+// sameNames determines if two lists contain the same names, ignoring case and order.
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	lowerA := lowerSorted(a)
+	lowerB := lowerSorted(b)
+	for i := range lowerA {
+		if lowerA[i] != lowerB[i] {
+			return false
 		}
 	}
 
-	crc := crc32.New(castagnoliTable)
+	return true
+}
 
-	if _, err := crc.Write([]byte(crcStr)); err != nil {
-		return 0, err
+func lowerSorted(names []string) []string {
+	lower := make([]string, len(names))
+	for i, n := range names {
+		lower[i] = strings.ToLower(n)
 	}
-
-	return crc.Sum32(), nil
+	sort.Strings(lower)
+	return lower
 }
 
 // ShapeDefinitions is a mapping of shape definition data
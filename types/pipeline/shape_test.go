@@ -1,7 +1,11 @@
 package pipeline
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
 	"hash/crc32"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -219,8 +223,8 @@ func TestShaper(t *testing.T) {
 				return
 			}
 
-			expectedKeyHash := doCrc([]byte(testCase.expectedKeyHashString))
-			expectedHash := doCrc([]byte(testCase.expectedHashString))
+			expectedKeyHash := doHash(testCase.expectedKeyHashString)
+			expectedHash := doHash(testCase.expectedHashString)
 
 			Convey("Should set the key names property", func() {
 				So(shape.KeyNames, ShouldResemble, testCase.keyNames)
@@ -238,12 +242,144 @@ func TestShaper(t *testing.T) {
 				So(shape.PropertyHash, ShouldEqual, expectedHash)
 			})
 
+			Convey("Should use the current hash version", func() {
+				So(shape.HashVersion, ShouldEqual, CurrentShapeHashVersion)
+			})
+
+		})
+	}
+}
+
+func TestNewShape(t *testing.T) {
+
+	Convey("Given unsorted key names and properties", t, func() {
+		keyNames := []string{"name", "id"}
+		properties := []string{"name:string", "id:number", "active:bool"}
+
+		shape, err := NewShape(keyNames, properties)
+		So(err, ShouldBeNil)
+
+		Convey("Should not modify the provided slices", func() {
+			So(keyNames, ShouldResemble, []string{"name", "id"})
+			So(properties, ShouldResemble, []string{"name:string", "id:number", "active:bool"})
+		})
+
+		Convey("Should store the properties sorted", func() {
+			So(shape.Properties, ShouldResemble, []string{"active:bool", "id:number", "name:string"})
+		})
+
+		Convey("Should hash independent of order", func() {
+			other, _ := NewShape([]string{"id", "name"}, []string{"active:bool", "id:number", "name:string"})
+			So(other.KeyNamesHash, ShouldEqual, shape.KeyNamesHash)
+			So(other.PropertyHash, ShouldEqual, shape.PropertyHash)
+		})
+	})
+
+	Convey("Given a legacy shape", t, func() {
+		shape, err := NewShapeWithHashVersion(ShapeHashCRC32, []string{"id"}, []string{"id:number", "name:string"})
+		So(err, ShouldBeNil)
+
+		Convey("Should generate the original CRC32 hashes", func() {
+			So(shape.KeyNamesHash, ShouldEqual, doCrc([]byte("id")))
+			So(shape.PropertyHash, ShouldEqual, doCrc([]byte("id:number,name:string")))
+		})
+
+		Convey("Should read shapes serialized without a hash version", func() {
+			var legacy Shape
+			err := json.Unmarshal([]byte(`{"keyNames":["id"],"keyNamesHash":1,"properties":["id:number","name:string"],"propertyHash":2}`), &legacy)
+			So(err, ShouldBeNil)
+			legacy.KeyNamesHash = shape.KeyNamesHash
+			legacy.PropertyHash = shape.PropertyHash
+			So(legacy.HasSameProperties(shape), ShouldBeTrue)
+			So(legacy.HasSameKeys(shape), ShouldBeTrue)
+		})
+
+		Convey("Should keep the version when ensuring hashes", func() {
+			legacy := Shape{KeyNames: []string{"id"}, KeyNamesHash: shape.KeyNamesHash, Properties: shape.Properties}
+			EnsureHashes(&legacy)
+			So(legacy.HashVersion, ShouldEqual, 0)
+			So(legacy.PropertyHash, ShouldEqual, shape.PropertyHash)
+		})
+	})
+
+	Convey("Given property names that contain the former separator", t, func() {
+		joined, _ := NewShape(nil, []string{"a\x00b:string"})
+		split, _ := NewShape(nil, []string{"a", "b:string"})
+
+		Convey("Should hash differently", func() {
+			So(joined.PropertyHash, ShouldNotEqual, split.PropertyHash)
+		})
+	})
+
+	Convey("Given an unknown hash version", t, func() {
+		_, err := NewShapeWithHashVersion(99, nil, []string{"id:number"})
+
+		Convey("Should return an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestShapeComparison(t *testing.T) {
+
+	Convey("Given two shapes with the same properties", t, func() {
+		a, _ := NewShape([]string{"id"}, []string{"id:number", "name:string"})
+		b, _ := NewShape([]string{"ID"}, []string{"Name:string", "id:number"})
+
+		Convey("Should have the same properties and keys", func() {
+			So(a.HasSameProperties(b), ShouldBeTrue)
+			So(a.HasSameKeys(b), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given two shapes with different properties", t, func() {
+		a, _ := NewShape([]string{"id"}, []string{"id:number", "name:string"})
+		b, _ := NewShape([]string{"id"}, []string{"id:number", "name:number"})
+
+		Convey("Should not have the same properties", func() {
+			So(a.HasSameProperties(b), ShouldBeFalse)
+			So(a.HasSameKeys(b), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given two shapes whose hashes collide", t, func() {
+		a := Shape{Properties: []string{"id:number"}, PropertyHash: 42, HashVersion: CurrentShapeHashVersion}
+		b := Shape{Properties: []string{"id:string"}, PropertyHash: 42, HashVersion: CurrentShapeHashVersion}
+
+		Convey("Should not have the same properties", func() {
+			So(a.HasSameProperties(b), ShouldBeFalse)
 		})
+	})
+
+	Convey("Given two shapes hashed with different versions", t, func() {
+		a, _ := NewShapeWithHashVersion(ShapeHashCRC32, nil, []string{"id:number"})
+		b, _ := NewShape(nil, []string{"id:number"})
+
+		Convey("Should compare the properties", func() {
+			So(a.PropertyHash, ShouldNotEqual, b.PropertyHash)
+			So(a.HasSameProperties(b), ShouldBeTrue)
+		})
+	})
+}
+
+// doHash computes the expected hash for a comma separated property list.
+func doHash(list string) uint64 {
+	if list == "" {
+		return 0
+	}
+
+	h := sha256.New()
+	var length [8]byte
+	for _, prop := range strings.Split(list, ",") {
+		binary.BigEndian.PutUint64(length[:], uint64(len(prop)))
+		h.Write(length[:])
+		h.Write([]byte(prop))
 	}
+	return binary.BigEndian.Uint64(h.Sum(nil)[:8])
 }
 
-func doCrc(data []byte) uint32 {
+func doCrc(data []byte) uint64 {
 	crc := crc32.New(castagnoliTable)
 	crc.Write(data)
-	return crc.Sum32()
+	return uint64(crc.Sum32())
 }
@@ -0,0 +1,317 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/naveego/api/types/dataflow"
+	"github.com/naveego/errors"
+)
+
+const (
+	// PropertyTypeString represents a string property
+	PropertyTypeString = "string"
+	// PropertyTypeNumber represents a numeric property
+	PropertyTypeNumber = "number"
+	// PropertyTypeBool represents a boolean property
+	PropertyTypeBool = "bool"
+	// PropertyTypeDate represents a date property.  Dates are sent as strings
+	// in one of the formats recognized by the shaper.
+	PropertyTypeDate = "date"
+	// PropertyTypeObject represents a nested object property
+	PropertyTypeObject = "object"
+)
+
+var (
+	// Shape validation errors
+	ShapeMissingKeyError       = 4220011
+	ShapeUnknownPropertyError  = 4220012
+	ShapeTypeMismatchError     = 4220013
+	ShapeInvalidDateError      = 4220014
+	ShapeUnknownTypeError      = 4220015
+	ShapeValidationFailedError = 4220016
+)
+
+// PropertyError describes a single problem found while validating
+// a data point against a shape definition.
+type PropertyError struct {
+	Property string `json:"property"` // The full (dotted) path of the property
+	Code     int    `json:"code"`     // The error code
+	Message  string `json:"message"`  // A human readable message
+}
+
+func (e PropertyError) Error() string {
+	return e.Property + ": " + e.Message
+}
+
+// ShapeValidationResult contains the outcome of validating the data
+// of a data point against a shape definition.
+type ShapeValidationResult struct {
+	Shape  string          `json:"shape"`            // The name of the shape definition used
+	Errors []PropertyError `json:"errors,omitempty"` // The problems found, in property order
+}
+
+// IsValid returns true if no problems were found.
+func (r ShapeValidationResult) IsValid() bool {
+	return len(r.Errors) == 0
+}
+
+// Err returns nil if the result is valid, otherwise it returns an
+// errors.Error summarizing all the property errors.
+func (r ShapeValidationResult) Err() error {
+	if r.IsValid() {
+		return nil
+	}
+
+	msgs := make([]string, len(r.Errors))
+	for i, e := range r.Errors {
+		msgs[i] = e.Error()
+	}
+
+	return errors.Error{
+		Code:    ShapeValidationFailedError,
+		Message: fmt.Sprintf("data does not conform to shape '%s': %s", r.Shape, strings.Join(msgs, "; ")),
+	}
+}
+
+// Logs creates one data flow log entry per property error.  The entries
+// are copies of the provided template with the property, level, message
+// and error information filled in.
+func (r ShapeValidationResult) Logs(template dataflow.Log) []dataflow.Log {
+	logs := make([]dataflow.Log, 0, len(r.Errors))
+
+	for _, e := range r.Errors {
+		l := template
+		l.Property = e.Property
+		l.Level = "error"
+		l.Message = e.Message
+		l.Error = &dataflow.Error{
+			Code:    int64(e.Code),
+			Message: e.Message,
+			Details: r.Shape,
+		}
+		logs = append(logs, l)
+	}
+
+	return logs
+}
+
+// ShapeValidator validates the data of data points against a
+// shape definition.
+type ShapeValidator struct {
+	definition ShapeDefinition
+	properties map[string]string
+	strict     bool
+}
+
+// NewShapeValidator creates a validator for the given shape definition.  When
+// strict is true, properties in the data that are not declared in the shape
+// definition are reported as errors.
+func NewShapeValidator(definition ShapeDefinition, strict bool) *ShapeValidator {
+	props := make(map[string]string, len(definition.Properties))
+	for _, p := range definition.Properties {
+		props[p.Name] = strings.ToLower(p.Type)
+	}
+
+	return &ShapeValidator{
+		definition: definition,
+		properties: props,
+		strict:     strict,
+	}
+}
+
+// Validate checks the data point against the shape definition. Only upsert and
+// delete data points carry data, all other actions are always valid.
+func (v *ShapeValidator) Validate(dataPoint DataPoint) ShapeValidationResult {
+	result := ShapeValidationResult{Shape: v.definition.Name}
+
+	if dataPoint.Action != DataPointUpsert && dataPoint.Action != DataPointDelete {
+		return result
+	}
+
+	keys := v.definition.Keys
+	if len(keys) == 0 {
+		keys = dataPoint.KeyNames
+	}
+
+	for _, key := range keys {
+		if val, ok := getPathValue(dataPoint.Data, key); !ok || val == nil {
+			result.Errors = append(result.Errors, PropertyError{
+				Property: key,
+				Code:     ShapeMissingKeyError,
+				Message:  "key property was not provided",
+			})
+		}
+	}
+
+	v.validateRecursive(&result, "", dataPoint.Data)
+
+	return result
+}
+
+func (v *ShapeValidator) validateRecursive(result *ShapeValidationResult, prefix string, data map[string]interface{}) {
+
+	for _, key := range sortedKeys(data) {
+		val := data[key]
+		propName := getPropertyName(key, prefix)
+
+		propType, declared := v.properties[propName]
+		if !declared {
+			if v.strict {
+				result.Errors = append(result.Errors, PropertyError{
+					Property: propName,
+					Code:     ShapeUnknownPropertyError,
+					Message:  "property is not defined in the shape",
+				})
+			}
+
+			// An undeclared object may still contain declared properties
+			if nested, ok := val.(map[string]interface{}); ok {
+				v.validateRecursive(result, propName, nested)
+			}
+			continue
+		}
+
+		// Null values are allowed for any type
+		if val == nil {
+			continue
+		}
+
+		if err, ok := checkPropertyType(propName, propType, val); !ok {
+			result.Errors = append(result.Errors, err)
+			continue
+		}
+
+		if nested, ok := val.(map[string]interface{}); ok {
+			v.validateRecursive(result, propName, nested)
+		}
+	}
+}
+
+// ValidateShape is a convenience method for validating a data point against
+// a shape definition.
+func (d *DataPoint) ValidateShape(definition ShapeDefinition, strict bool) ShapeValidationResult {
+	return NewShapeValidator(definition, strict).Validate(*d)
+}
+
+func checkPropertyType(propName, propType string, val interface{}) (PropertyError, bool) {
+	mismatch := func() (PropertyError, bool) {
+		return PropertyError{
+			Property: propName,
+			Code:     ShapeTypeMismatchError,
+			Message:  fmt.Sprintf("expected value of type '%s' but got '%s'", propType, valueTypeName(val)),
+		}, false
+	}
+
+	switch propType {
+	case PropertyTypeString:
+		if _, ok := val.(string); !ok {
+			return mismatch()
+		}
+	case PropertyTypeNumber:
+		if !isNumber(val) {
+			return mismatch()
+		}
+	case PropertyTypeBool:
+		if _, ok := val.(bool); !ok {
+			return mismatch()
+		}
+	case PropertyTypeObject:
+		if _, ok := val.(map[string]interface{}); !ok {
+			return mismatch()
+		}
+	case PropertyTypeDate:
+		switch x := val.(type) {
+		case time.Time:
+		case string:
+			if !isDate(x) {
+				return PropertyError{
+					Property: propName,
+					Code:     ShapeInvalidDateError,
+					Message:  fmt.Sprintf("could not parse '%s' as a date", x),
+				}, false
+			}
+		default:
+			return mismatch()
+		}
+	default:
+		return PropertyError{
+			Property: propName,
+			Code:     ShapeUnknownTypeError,
+			Message:  fmt.Sprintf("shape declares unknown type '%s'", propType),
+		}, false
+	}
+
+	return PropertyError{}, true
+}
+
+func isNumber(val interface{}) bool {
+	switch val.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+		return true
+	}
+	return false
+}
+
+func valueTypeName(val interface{}) string {
+	switch x := val.(type) {
+	case string:
+		if len(x) > 0 && isDate(x) {
+			return PropertyTypeDate
+		}
+		return PropertyTypeString
+	case bool:
+		return PropertyTypeBool
+	case map[string]interface{}:
+		return PropertyTypeObject
+	case []interface{}:
+		return "array"
+	case time.Time:
+		return PropertyTypeDate
+	}
+
+	if isNumber(val) {
+		return PropertyTypeNumber
+	}
+
+	return fmt.Sprintf("%T", val)
+}
+
+// getPathValue reads a value out of nested data using a dotted path.
+func getPathValue(data map[string]interface{}, path string) (interface{}, bool) {
+	if val, ok := data[path]; ok {
+		return val, true
+	}
+
+	parts := strings.Split(path, ".")
+	current := data
+	for i, part := range parts {
+		val, ok := current[part]
+		if !ok {
+			return nil, false
+		}
+
+		if i == len(parts)-1 {
+			return val, true
+		}
+
+		current, ok = val.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/naveego/api/types/dataflow"
+	"github.com/naveego/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testShapeDefinition = ShapeDefinition{
+	Name: "user",
+	Keys: []string{"id"},
+	Properties: []PropertyDefinition{
+		{Name: "id", Type: "number"},
+		{Name: "name", Type: "string"},
+		{Name: "active", Type: "bool"},
+		{Name: "dateOfBirth", Type: "date"},
+		{Name: "company", Type: "object"},
+		{Name: "company.name", Type: "string"},
+		{Name: "company.employees", Type: "number"},
+	},
+}
+
+func TestShapeValidator(t *testing.T) {
+
+	testCases := []struct {
+		name           string
+		strict         bool
+		data           testData
+		expectedErrors []PropertyError
+	}{
+		{
+			"Given data that matches the shape",
+			true,
+			testData{"id": 1, "name": "Derek", "active": true, "dateOfBirth": "1981-01-01T12:30:00Z", "company": map[string]interface{}{"name": "Naveego", "employees": 20}},
+			nil,
+		},
+		{
+			"Given data with null values",
+			true,
+			testData{"id": 1, "name": nil, "company": nil},
+			nil,
+		},
+		{
+			"Given data that is missing the key",
+			false,
+			testData{"name": "Derek"},
+			[]PropertyError{{"id", ShapeMissingKeyError, "key property was not provided"}},
+		},
+		{
+			"Given data with an unknown property in strict mode",
+			true,
+			testData{"id": 1, "nickname": "D"},
+			[]PropertyError{{"nickname", ShapeUnknownPropertyError, "property is not defined in the shape"}},
+		},
+		{
+			"Given data with an unknown property in lenient mode",
+			false,
+			testData{"id": 1, "nickname": "D"},
+			nil,
+		},
+		{
+			"Given data with a type mismatch",
+			false,
+			testData{"id": "1", "active": "yes"},
+			[]PropertyError{
+				{"active", ShapeTypeMismatchError, "expected value of type 'bool' but got 'string'"},
+				{"id", ShapeTypeMismatchError, "expected value of type 'number' but got 'string'"},
+			},
+		},
+		{
+			"Given data with a type mismatch in a nested object",
+			false,
+			testData{"id": 1, "company": map[string]interface{}{"name": "Naveego", "employees": "twenty"}},
+			[]PropertyError{{"company.employees", ShapeTypeMismatchError, "expected value of type 'number' but got 'string'"}},
+		},
+		{
+			"Given data with an unknown nested property in strict mode",
+			true,
+			testData{"id": 1, "company": map[string]interface{}{"name": "Naveego", "city": "Erie"}},
+			[]PropertyError{{"company.city", ShapeUnknownPropertyError, "property is not defined in the shape"}},
+		},
+		{
+			"Given data with a date that cannot be parsed",
+			false,
+			testData{"id": 1, "dateOfBirth": "January 1st"},
+			[]PropertyError{{"dateOfBirth", ShapeInvalidDateError, "could not parse 'January 1st' as a date"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		Convey(testCase.name, t, func() {
+			dp := DataPoint{Repository: "test", Entity: "user", Action: "upsert", KeyNames: []string{"id"}, Data: testCase.data}
+			result := dp.ValidateShape(testShapeDefinition, testCase.strict)
+
+			Convey("Should return the expected property errors", func() {
+				So(result.Errors, ShouldResemble, testCase.expectedErrors)
+			})
+
+			Convey("Should report validity consistently", func() {
+				So(result.IsValid(), ShouldEqual, len(testCase.expectedErrors) == 0)
+				So(result.Err() == nil, ShouldEqual, len(testCase.expectedErrors) == 0)
+			})
+		})
+	}
+
+	Convey("Given a data point that does not carry data", t, func() {
+		dp := DataPoint{Repository: "test", Entity: "user", Action: DataPointStartPublish}
+		result := dp.ValidateShape(testShapeDefinition, true)
+
+		Convey("Should be valid", func() {
+			So(result.IsValid(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestShapeValidationResult(t *testing.T) {
+
+	Convey("Given an invalid result", t, func() {
+		result := ShapeValidationResult{
+			Shape: "user",
+			Errors: []PropertyError{
+				{"id", ShapeMissingKeyError, "key property was not provided"},
+				{"age", ShapeTypeMismatchError, "expected value of type 'number' but got 'string'"},
+			},
+		}
+
+		Convey("Err should return a validation failed error", func() {
+			err, ok := result.Err().(errors.Error)
+			So(ok, ShouldBeTrue)
+			So(err.Code, ShouldEqual, ShapeValidationFailedError)
+		})
+
+		Convey("Logs should return one entry per property error", func() {
+			logs := result.Logs(dataflow.Log{TenantID: "vandelay", Resource: "pipeline"})
+			So(len(logs), ShouldEqual, 2)
+			So(logs[0].TenantID, ShouldEqual, "vandelay")
+			So(logs[0].Property, ShouldEqual, "id")
+			So(logs[0].Level, ShouldEqual, "error")
+			So(logs[0].Error.Code, ShouldEqual, ShapeMissingKeyError)
+			So(logs[1].Property, ShouldEqual, "age")
+			So(logs[1].Validate(), ShouldBeNil)
+		})
+	})
+}